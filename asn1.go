@@ -0,0 +1,326 @@
+package dissect
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const asn1Decl = "asn1"
+
+const (
+	classUniversal = iota
+	classApplication
+	classContext
+	classPrivate
+)
+
+const (
+	tagBoolean         = 1
+	tagInteger         = 2
+	tagBitString       = 3
+	tagNull            = 5
+	tagOID             = 6
+	tagEnumerated      = 10
+	tagUTF8String      = 12
+	tagRelativeOID     = 13
+	tagNumericString   = 18
+	tagPrintableString = 19
+	tagT61String       = 20
+	tagIA5String       = 22
+	tagUTCTime         = 23
+	tagGeneralizedTime = 24
+	tagGraphicString   = 25
+	tagVisibleString   = 26
+	tagGeneralString   = 27
+)
+
+var universalTags = map[int]string{
+	tagBoolean:         "boolean",
+	tagInteger:         "integer",
+	tagBitString:       "bitstring",
+	4:                  "octetstring",
+	tagNull:            "null",
+	tagOID:             "oid",
+	7:                  "objectdescriptor",
+	8:                  "external",
+	9:                  "real",
+	tagEnumerated:      "enumerated",
+	11:                 "embeddedpdv",
+	tagUTF8String:      "utf8string",
+	tagRelativeOID:     "relativeoid",
+	16:                 "sequence",
+	17:                 "set",
+	tagNumericString:   "numericstring",
+	tagPrintableString: "printablestring",
+	tagT61String:       "t61string",
+	21:                 "videotexstring",
+	tagIA5String:       "ia5string",
+	tagUTCTime:         "utctime",
+	tagGeneralizedTime: "generalizedtime",
+	tagGraphicString:   "graphicstring",
+	tagVisibleString:   "visiblestring",
+	tagGeneralString:   "generalstring",
+	28:                 "universalstring",
+	30:                 "bmpstring",
+}
+
+var (
+	errIndefinite = errors.New("indefinite length")
+	errEOC        = errors.New("end-of-contents")
+	errTruncated  = errors.New("truncated value")
+)
+
+// tlv is the identifier and the length octets of a BER encoded value. length
+// is negative when the length is indefinite: the contents then end with an
+// end-of-contents value.
+type tlv struct {
+	class       int
+	constructed bool
+	number      int
+	length      int
+	header      int
+}
+
+func (t tlv) isEOC() bool {
+	return t.class == classUniversal && !t.constructed && t.number == 0 && t.length == 0
+}
+
+// label gives the name of the tag of t in the paths of the fields: the name of
+// the type for the universal tags and the class followed by the number of the
+// tag otherwise, eg ctx0.
+func (t tlv) label() string {
+	switch t.class {
+	case classUniversal:
+		if s, ok := universalTags[t.number]; ok {
+			return s
+		}
+		return "universal" + strconv.Itoa(t.number)
+	case classApplication:
+		return "app" + strconv.Itoa(t.number)
+	case classContext:
+		return "ctx" + strconv.Itoa(t.number)
+	default:
+		return "priv" + strconv.Itoa(t.number)
+	}
+}
+
+// readTLV reads the identifier and the length octets at the start of buf. Tag
+// numbers are given on several bytes when the low bits of the first one are
+// all set and lengths on several bytes when the high bit of the first one is
+// set.
+func readTLV(buf []byte) (tlv, error) {
+	var t tlv
+	if len(buf) < 2 {
+		return t, fmt.Errorf("%w: identifier and length octets", errTruncated)
+	}
+	t.class = int(buf[0] >> 6)
+	t.constructed = buf[0]&0x20 != 0
+	t.number = int(buf[0] & 0x1F)
+	t.header = 1
+	if t.number == 0x1F {
+		t.number = 0
+		for {
+			if t.header >= len(buf) {
+				return t, fmt.Errorf("%w: tag number", errTruncated)
+			}
+			if t.number > 1<<24 {
+				return t, fmt.Errorf("tag number too large")
+			}
+			b := buf[t.header]
+			t.header++
+			t.number = t.number<<7 | int(b&0x7F)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	if t.header >= len(buf) {
+		return t, fmt.Errorf("%w: length octets", errTruncated)
+	}
+	b := buf[t.header]
+	t.header++
+	switch {
+	case b < 0x80:
+		t.length = int(b)
+	case b == 0x80:
+		if !t.constructed {
+			return t, fmt.Errorf("%s: %w for a primitive value", t.label(), errIndefinite)
+		}
+		t.length = -1
+	case b == 0xFF:
+		return t, fmt.Errorf("%s: reserved length", t.label())
+	default:
+		n := int(b & 0x7F)
+		if n > 4 {
+			return t, fmt.Errorf("%s: length on %d bytes", t.label(), n)
+		}
+		if t.header+n > len(buf) {
+			return t, fmt.Errorf("%w: length octets", errTruncated)
+		}
+		for _, b := range buf[t.header : t.header+n] {
+			t.length = t.length<<8 | int(b)
+		}
+		t.header += n
+	}
+	return t, nil
+}
+
+// decodeASN1 walks the BER encoded values of the bytes following the current
+// position, their number given by the expression of n. A field is given for
+// each primitive value, named after the path of the tags leading to it from
+// the name of the statement, eg asn1.sequence.integer. Constructed values,
+// with a definite or an indefinite length, are walked through.
+func (root *state) decodeASN1(n ASN1) error {
+	buf, err := root.region(asn1Decl, n.size)
+	if err != nil {
+		return err
+	}
+	fs, _, err := walkTLV(buf, root.Pos, n.id.Literal, false, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", asn1Decl, err)
+	}
+	for i := range fs {
+		fs[i].Block, fs[i].Ix = root.currentBlock(), root.Iter
+	}
+	root.Fields = append(root.Fields, fs...)
+	root.Pos += len(buf) * numbit
+	return nil
+}
+
+// walkTLV appends to fs the primitive values of buf, pos being the position of
+// its first byte in the packet. With eoc, the values end with an
+// end-of-contents value. walkTLV returns the number of bytes read.
+func walkTLV(buf []byte, pos int, path string, eoc bool, fs []Field) ([]Field, int, error) {
+	var offset int
+	for offset < len(buf) {
+		t, err := readTLV(buf[offset:])
+		if err != nil {
+			return fs, offset, fmt.Errorf("%s: %w", path, err)
+		}
+		start := offset + t.header
+		if t.isEOC() {
+			if !eoc {
+				return fs, offset, fmt.Errorf("%s: unexpected %s", path, errEOC)
+			}
+			return fs, start, nil
+		}
+		name := path + "." + t.label()
+		if t.length < 0 {
+			var n int
+			if fs, n, err = walkTLV(buf[start:], pos+start*numbit, name, true, fs); err != nil {
+				return fs, offset, err
+			}
+			offset = start + n
+			continue
+		}
+		end := start + t.length
+		if end > len(buf) {
+			return fs, offset, fmt.Errorf("%s: %w: want %d bytes, only %d available", name, errTruncated, t.length, len(buf)-start)
+		}
+		if t.constructed {
+			if fs, _, err = walkTLV(buf[start:end], pos+start*numbit, name, false, fs); err != nil {
+				return fs, offset, err
+			}
+		} else {
+			f := Field{
+				Id:  name,
+				Pos: pos + start*numbit,
+				Len: t.length * numbit,
+			}
+			f.raw, f.kind = asn1Value(t, buf[start:end])
+			fs = append(fs, f)
+		}
+		offset = end
+	}
+	if eoc {
+		return fs, offset, fmt.Errorf("%s: %w: missing %s", path, errTruncated, errEOC)
+	}
+	return fs, offset, nil
+}
+
+// asn1Value gives the value of the contents of a primitive value. The contents
+// of the types without matching value, the ones not valid and the values of the
+// tags not universal are given as bytes.
+func asn1Value(t tlv, b []byte) (Value, Kind) {
+	if t.class != classUniversal {
+		return &Bytes{Raw: b}, kindBytes
+	}
+	switch t.number {
+	case tagBoolean:
+		if len(b) == 1 {
+			return &Boolean{Raw: b[0] != 0}, kindNull
+		}
+	case tagInteger, tagEnumerated:
+		if len(b) > 0 && len(b) <= 8 {
+			var x int64
+			if b[0]&0x80 != 0 {
+				x = -1
+			}
+			for _, c := range b {
+				x = x<<numbit | int64(c)
+			}
+			return &Int{Raw: x}, kindInt
+		}
+	case tagNull:
+		return &Null{}, kindNull
+	case tagOID, tagRelativeOID:
+		if s, ok := oidString(b, t.number == tagRelativeOID); ok {
+			return &String{Raw: s}, kindString
+		}
+	case tagBitString:
+		if len(b) > 0 {
+			return &Bytes{Raw: b[1:]}, kindBytes
+		}
+	case tagUTCTime, tagGeneralizedTime:
+		layouts := []string{"20060102150405Z0700", "200601021504Z0700", "20060102150405"}
+		if t.number == tagUTCTime {
+			layouts = []string{"060102150405Z0700", "0601021504Z0700"}
+		}
+		for _, layout := range layouts {
+			if w, err := time.Parse(layout, string(b)); err == nil {
+				return &Time{Raw: w}, kindTime
+			}
+		}
+		return &String{Raw: string(b)}, kindString
+	case tagUTF8String, tagNumericString, tagPrintableString, tagT61String,
+		tagIA5String, tagGraphicString, tagVisibleString, tagGeneralString:
+		return &String{Raw: string(b)}, kindString
+	}
+	return &Bytes{Raw: b}, kindBytes
+}
+
+// oidString gives the arcs of an object identifier separated by dots. The first
+// byte of an absolute identifier holds its first two arcs.
+func oidString(b []byte, relative bool) (string, bool) {
+	var (
+		arcs []string
+		arc  uint64
+		size int
+	)
+	for _, c := range b {
+		if size++; size > 9 {
+			return "", false
+		}
+		arc = arc<<7 | uint64(c&0x7F)
+		if c&0x80 != 0 {
+			continue
+		}
+		if len(arcs) == 0 && !relative {
+			first := arc / 40
+			if first > 2 {
+				first = 2
+			}
+			arcs = append(arcs, strconv.FormatUint(first, 10))
+			arc -= first * 40
+		}
+		arcs = append(arcs, strconv.FormatUint(arc, 10))
+		arc, size = 0, 0
+	}
+	if size != 0 || len(arcs) == 0 {
+		return "", false
+	}
+	return strings.Join(arcs, "."), true
+}
@@ -0,0 +1,235 @@
+package dissect
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// ColumnType is the type of the values of a Column. The types are named after
+// the Arrow types with the same memory layout.
+type ColumnType int
+
+const (
+	ColumnInt64 ColumnType = iota
+	ColumnUint64
+	ColumnFloat64
+	ColumnBool
+	ColumnBinary
+	ColumnUtf8
+	ColumnTimestamp
+)
+
+func (t ColumnType) String() string {
+	switch t {
+	case ColumnInt64:
+		return "int64"
+	case ColumnUint64:
+		return "uint64"
+	case ColumnFloat64:
+		return "float64"
+	case ColumnBool:
+		return "bool"
+	case ColumnBinary:
+		return "binary"
+	case ColumnUtf8:
+		return "utf8"
+	case ColumnTimestamp:
+		return "timestamp[ns]"
+	default:
+		return "<column:unknown>"
+	}
+}
+
+// Column holds the values of a field for all the records of a RecordBatch in
+// the memory layout of an Arrow array. Valid is the validity bitmap, least
+// significant bit first, with a bit set for each value not null. Values holds
+// the values as 64 bits little endian numbers, timestamps being nanoseconds
+// since the Unix epoch, or a bitmap for booleans. For binary and utf8 columns,
+// Values holds the bytes of all the values and Offsets where each one starts.
+type Column struct {
+	Name    string
+	Type    ColumnType
+	Nulls   int
+	Valid   []byte
+	Offsets []int32
+	Values  []byte
+}
+
+func (c *Column) append(v Value, row int) {
+	if row%numbit == 0 {
+		c.Valid = append(c.Valid, 0)
+		if c.Type == ColumnBool {
+			c.Values = append(c.Values, 0)
+		}
+	}
+	null := v == nil
+	if _, ok := v.(*Null); ok {
+		null = true
+	}
+	if null {
+		c.Nulls++
+	} else {
+		c.Valid[row/numbit] |= 1 << (row % numbit)
+	}
+	var x uint64
+	switch c.Type {
+	case ColumnInt64:
+		if !null {
+			x = uint64(asInt(v))
+		}
+	case ColumnUint64:
+		if !null {
+			x = asUint(v)
+		}
+	case ColumnFloat64:
+		if !null {
+			x = math.Float64bits(asReal(v))
+		}
+	case ColumnTimestamp:
+		if t, ok := v.(*Time); ok {
+			x = uint64(t.Raw.UnixNano())
+		} else if !null {
+			x = uint64(asInt(v))
+		}
+	case ColumnBool:
+		if !null && asBool(v) {
+			c.Values[row/numbit] |= 1 << (row % numbit)
+		}
+		return
+	case ColumnBinary, ColumnUtf8:
+		if len(c.Offsets) == 0 {
+			c.Offsets = append(c.Offsets, 0)
+		}
+		if b, ok := v.(*Bytes); ok && c.Type == ColumnBinary {
+			c.Values = append(c.Values, b.Raw...)
+		} else if !null {
+			c.Values = append(c.Values, asString(v)...)
+		}
+		c.Offsets = append(c.Offsets, int32(len(c.Values)))
+		return
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], x)
+	c.Values = append(c.Values, buf[:]...)
+}
+
+// RecordBatch holds, by column, records printed by a script having all the
+// same fields, with values of the same types. Its buffers can be wrapped in Arrow arrays without copying them.
+type RecordBatch struct {
+	Rows    int
+	Columns []Column
+}
+
+type batchValue struct {
+	name  string
+	kind  Kind
+	value Value
+}
+
+// batcher accumulates the records printed in batches of at most rows records.
+type batcher struct {
+	rows  int
+	emit  func(*RecordBatch) error
+	batch *RecordBatch
+}
+
+func (b *batcher) append(values []Field, method string, names columns) error {
+	var vs []batchValue
+	for _, v := range explodeArrays(values) {
+		if v.Skip() {
+			continue
+		}
+		switch method {
+		case methEng:
+			vs = append(vs, batchValue{name: v.Id, kind: v.Kind(), value: v.Eng()})
+		case methBoth, methDebug:
+			vs = append(vs, batchValue{name: v.Id + names.raw, kind: v.Kind(), value: v.Raw()})
+			vs = append(vs, batchValue{name: v.Id + names.eng, kind: v.Kind(), value: v.Eng()})
+		default:
+			vs = append(vs, batchValue{name: v.Id, kind: v.Kind(), value: v.Raw()})
+		}
+	}
+	if b.batch != nil && !sameColumns(b.batch.Columns, vs) {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	if b.batch == nil {
+		b.batch = &RecordBatch{Columns: make([]Column, len(vs))}
+		for i, v := range vs {
+			typ, _ := columnType(v)
+			b.batch.Columns[i] = Column{Name: v.name, Type: typ}
+		}
+	}
+	for i, v := range vs {
+		b.batch.Columns[i].append(v.value, b.batch.Rows)
+	}
+	if b.batch.Rows++; b.batch.Rows >= b.rows {
+		return b.flush()
+	}
+	return nil
+}
+
+func (b *batcher) flush() error {
+	if b.batch == nil {
+		return nil
+	}
+	batch := b.batch
+	b.batch = nil
+	return b.emit(batch)
+}
+
+func sameColumns(cs []Column, vs []batchValue) bool {
+	if len(cs) != len(vs) {
+		return false
+	}
+	for i := range cs {
+		if cs[i].Name != vs[i].name {
+			return false
+		}
+		if typ, ok := columnType(vs[i]); ok && typ != cs[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// columnType gives the type of the column of a value or, when it is null, of
+// the kind of its field. The null value of a field without kind, eg computed by
+// a let statement, fits in a column of any type: it gives a utf8 column and
+// false.
+func columnType(v batchValue) (ColumnType, bool) {
+	switch v.value.(type) {
+	case *Int:
+		return ColumnInt64, true
+	case *Uint:
+		return ColumnUint64, true
+	case *Real:
+		return ColumnFloat64, true
+	case *Boolean:
+		return ColumnBool, true
+	case *Bytes:
+		return ColumnBinary, true
+	case *Time:
+		return ColumnTimestamp, true
+	case *Null, nil:
+	default:
+		return ColumnUtf8, true
+	}
+	switch v.kind {
+	case kindInt:
+		return ColumnInt64, true
+	case kindUint:
+		return ColumnUint64, true
+	case kindFloat:
+		return ColumnFloat64, true
+	case kindString:
+		return ColumnUtf8, true
+	case kindBytes:
+		return ColumnBinary, true
+	case kindTime, kindGPS, kindUnix, kindCUC, kindCDS:
+		return ColumnTimestamp, true
+	default:
+		return ColumnUtf8, false
+	}
+}
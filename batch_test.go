@@ -0,0 +1,175 @@
+package dissect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestColumnAppend(t *testing.T) {
+	le := func(xs ...uint64) []byte {
+		var buf []byte
+		for _, x := range xs {
+			buf = binary.LittleEndian.AppendUint64(buf, x)
+		}
+		return buf
+	}
+	tests := []struct {
+		Name   string
+		Type   ColumnType
+		Values []Value
+		Want   Column
+	}{
+		{
+			Name:   "int64",
+			Type:   ColumnInt64,
+			Values: []Value{&Int{Raw: -1}, &Null{}, &Int{Raw: 2}},
+			Want:   Column{Nulls: 1, Valid: []byte{0b101}, Values: le(math.MaxUint64, 0, 2)},
+		},
+		{
+			Name:   "uint64",
+			Type:   ColumnUint64,
+			Values: []Value{nil, &Uint{Raw: 300}},
+			Want:   Column{Nulls: 1, Valid: []byte{0b10}, Values: le(0, 300)},
+		},
+		{
+			Name:   "float64",
+			Type:   ColumnFloat64,
+			Values: []Value{&Real{Raw: 1.5}},
+			Want:   Column{Valid: []byte{1}, Values: le(math.Float64bits(1.5))},
+		},
+		{
+			Name:   "timestamp",
+			Type:   ColumnTimestamp,
+			Values: []Value{&Time{Raw: time.Unix(1, 5)}, &Null{}},
+			Want:   Column{Nulls: 1, Valid: []byte{1}, Values: le(1000000005, 0)},
+		},
+		{
+			Name: "bool",
+			Type: ColumnBool,
+			Values: []Value{
+				&Boolean{Raw: true}, &Boolean{}, &Null{}, &Boolean{}, &Boolean{},
+				&Boolean{}, &Boolean{}, &Boolean{}, &Boolean{Raw: true},
+			},
+			Want: Column{Nulls: 1, Valid: []byte{0b11111011, 1}, Values: []byte{1, 1}},
+		},
+		{
+			Name:   "utf8",
+			Type:   ColumnUtf8,
+			Values: []Value{&String{Raw: "ab"}, &Null{}, &Uint{Raw: 7}},
+			Want:   Column{Nulls: 1, Valid: []byte{0b101}, Offsets: []int32{0, 2, 2, 3}, Values: []byte("ab7")},
+		},
+		{
+			Name:   "binary",
+			Type:   ColumnBinary,
+			Values: []Value{&Null{}, &Bytes{Raw: []byte{1, 2}}},
+			Want:   Column{Nulls: 1, Valid: []byte{0b10}, Offsets: []int32{0, 0, 2}, Values: []byte{1, 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			c := Column{Type: tt.Type}
+			for i, v := range tt.Values {
+				c.append(v, i)
+			}
+			tt.Want.Type = tt.Type
+			if !reflect.DeepEqual(c, tt.Want) {
+				t.Errorf("column mismatched:\nwant: %+v\ngot:  %+v", tt.Want, c)
+			}
+		})
+	}
+}
+
+func TestRecordBatches(t *testing.T) {
+	// odd gives an int for the odd values, a real for the even values and null
+	// for 0
+	odd := func(_ []Field, args []Value) (Value, error) {
+		switch x := asInt(args[0]); {
+		case x == 0:
+			return nil, nil
+		case x%2 == 1:
+			return &Int{Raw: x}, nil
+		default:
+			return &Real{Raw: float64(x)}, nil
+		}
+	}
+	tests := []struct {
+		Name   string
+		Script string
+		Rows   int
+		Data   []byte
+		Want   []string
+	}{
+		{
+			Name:   "rows",
+			Script: "data (\n  c: uint 8\n  print raw\n)\n",
+			Rows:   2,
+			Data:   []byte{1, 2, 3},
+			Want:   []string{"2: c uint64", "1: c uint64"},
+		},
+		{
+			Name:   "fields",
+			Script: "data (\n  c: uint 8\n  let e = c\n  if [c == 2] (\n    print raw with c\n  ) else (\n    print raw\n  )\n)\n",
+			Rows:   10,
+			Data:   []byte{1, 1, 2, 1},
+			Want:   []string{"2: c uint64, e uint64", "1: c uint64", "1: c uint64, e uint64"},
+		},
+		{
+			Name:   "null-first",
+			Script: "data (\n  c: uint 8\n  let d = $delta(c)\n  print raw\n)\n",
+			Rows:   10,
+			Data:   []byte{10, 7, 12},
+			Want:   []string{"1: c uint64, d utf8 (1 null)", "2: c uint64, d int64"},
+		},
+		{
+			Name:   "null-kind",
+			Script: "data (\n  c: uint 8\n  let d = $callback(\"odd\", c)\n  print raw\n)\n",
+			Rows:   10,
+			Data:   []byte{1, 0, 3},
+			Want:   []string{"3: c uint64, d int64 (1 null)"},
+		},
+		{
+			Name:   "types",
+			Script: "data (\n  c: uint 8\n  let d = $callback(\"odd\", c)\n  print raw\n)\n",
+			Rows:   10,
+			Data:   []byte{1, 3, 2, 4, 5},
+			Want:   []string{"2: c uint64, d int64", "2: c uint64, d float64", "1: c uint64, d int64"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			var got []string
+			collect := func(b *RecordBatch) error {
+				got = append(got, describeBatch(b))
+				return nil
+			}
+			i, err := New(strings.NewReader(tt.Script), WithDryRun(true), WithCallback("odd", odd), WithRecordBatches(tt.Rows, collect))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := i.Run(bytes.NewReader(tt.Data)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.Want) {
+				t.Errorf("batches mismatched:\nwant: %q\ngot:  %q", tt.Want, got)
+			}
+		})
+	}
+}
+
+func describeBatch(b *RecordBatch) string {
+	var cs []string
+	for _, c := range b.Columns {
+		str := fmt.Sprintf("%s %s", c.Name, c.Type)
+		if c.Nulls > 0 {
+			str += fmt.Sprintf(" (%d null)", c.Nulls)
+		}
+		cs = append(cs, str)
+	}
+	return fmt.Sprintf("%d: %s", b.Rows, strings.Join(cs, ", "))
+}
@@ -0,0 +1,124 @@
+package dissect
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	bitorderDecl = "bitorder"
+	bitMSB       = "msb"
+	bitLSB       = "lsb"
+)
+
+// BitOrder tells how the bits of a byte are numbered when a field is decoded.
+//
+// With MSBFirst, the first bit of a byte is its most significant one and the
+// first bit of a field is the most significant bit of its value. With
+// LSBFirst, as used by many link-layer protocols, the first bit of a byte is
+// its least significant one and the first bit of a field is the least
+// significant bit of its value: the bytes of a field are then read in little
+// endian order.
+type BitOrder int
+
+const (
+	MSBFirst BitOrder = iota
+	LSBFirst
+)
+
+func ParseBitOrder(str string) (BitOrder, error) {
+	switch strings.ToLower(str) {
+	case "", bitMSB:
+		return MSBFirst, nil
+	case bitLSB:
+		return LSBFirst, nil
+	default:
+		return MSBFirst, fmt.Errorf("%s: unknown bit order", str)
+	}
+}
+
+func (b BitOrder) String() string {
+	switch b {
+	case MSBFirst:
+		return bitMSB
+	case LSBFirst:
+		return bitLSB
+	default:
+		return "<unknown>"
+	}
+}
+
+// WithBitOrder sets the bit order of the fields declared in blocks and typedefs
+// without bitorder directive.
+func WithBitOrder(b BitOrder) Option {
+	return func(i *Interpreter) error {
+		i.bitorder = b
+		return nil
+	}
+}
+
+func isBitOrder(str string) bool {
+	return str == bitMSB || str == bitLSB
+}
+
+// parseBitOrder parses the bitorder directive of a block. It applies to the
+// fields following it in the block, its inline blocks included.
+func (p *Parser) parseBitOrder() error {
+	p.nextToken()
+	if p.curr.Type != Ident || !isBitOrder(p.curr.Literal) {
+		return p.expectedError("msb/lsb")
+	}
+	p.bitorder = p.curr
+	p.nextToken()
+	if p.curr.Type != Newline {
+		return p.expectedError("newline")
+	}
+	return nil
+}
+
+// bitOrderOf returns the bit order of p: the one of its typedef or its block,
+// and otherwise the one of the interpreter.
+func (root *state) bitOrderOf(p Parameter) BitOrder {
+	switch p.bitorder.Literal {
+	case bitLSB:
+		return LSBFirst
+	case bitMSB:
+		return MSBFirst
+	default:
+		return root.bitorder
+	}
+}
+
+// ltoi reads buf as a little endian number and returns its bits selected by
+// shift, counted from the least significant bit of the first byte, and mask.
+// As for btoi, buf is at most 9 bytes long.
+func ltoi(buf []byte, shift, mask int) uint64 {
+	var hi uint64
+	if len(buf) > 8 {
+		hi, buf = uint64(buf[8]), buf[:8]
+	}
+	var u uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		u = u<<numbit | uint64(buf[i])
+	}
+	u >>= uint64(shift)
+	if hi != 0 {
+		u |= hi << (64 - uint64(shift))
+	}
+	return u & uint64(mask)
+}
+
+// bigLtoi is ltoi for fields wider than 64 bits.
+func bigLtoi(buf []byte, shift, bits int) *big.Int {
+	rev := make([]byte, len(buf))
+	for i, b := range buf {
+		rev[len(buf)-1-i] = b
+	}
+	var (
+		val  = new(big.Int).SetBytes(rev)
+		mask = new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	)
+	mask.Sub(mask, big.NewInt(1))
+	return val.Rsh(val, uint(shift)).And(val, mask)
+}
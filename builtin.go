@@ -0,0 +1,335 @@
+package dissect
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+type builtin func(*state, []Value) (Value, error)
+
+// builtins are the functions available in expressions. Functions working on
+// the current packet take the offsets, in bits, of its first and last bytes.
+var builtins = map[string]builtin{
+	"crc16":    checksumFunc(crc16),
+	"crc32":    checksumFunc(crc32.ChecksumIEEE),
+	"sum":      checksumFunc(sum8),
+	"xor":      checksumFunc(xor8),
+	"slice":    sliceFunc,
+	"iter":     iterFunc,
+	"callback": callbackFunc,
+	"bytes":    bytesFunc,
+	"human":    humanFunc,
+	"len":      lenFunc,
+	"delta":    deltaFunc,
+	"accum":    accumFunc,
+}
+
+func evalCall(c Call, root *state) (Value, error) {
+	fn, ok := builtins[c.id.Literal]
+	if !ok {
+		f, ok := root.funcs[c.id.Literal]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown function", c.id.Literal)
+		}
+		fn = registeredFunc(f)
+	}
+	args := make([]Value, len(c.args))
+	for i, a := range c.args {
+		v, err := eval(a, root)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	root.call = nodeKey{file: c.file, pos: c.id.pos}
+	v, err := fn(root, args)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", c.id.Literal, err)
+	}
+	return v, err
+}
+
+// registeredFunc calls a function registered with RegisterFunc. A nil value
+// returned by the function is replaced by null.
+func registeredFunc(fn Func) builtin {
+	return func(_ *state, args []Value) (Value, error) {
+		v, err := fn(args...)
+		if err == nil && v == nil {
+			v = &Null{}
+		}
+		return v, err
+	}
+}
+
+// isFuncName reports whether name can be called as $name.
+func isFuncName(name string) bool {
+	for i, r := range name {
+		if i == 0 && !isLetter(r) {
+			return false
+		}
+		if !isIdent(r) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func checksumFunc(sum func([]byte) uint32) builtin {
+	return func(root *state, args []Value) (Value, error) {
+		buf, err := packetSlice(root, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Uint{Raw: uint64(sum(buf))}, nil
+	}
+}
+
+func sliceFunc(root *state, args []Value) (Value, error) {
+	buf, err := packetSlice(root, args)
+	if err != nil {
+		return nil, err
+	}
+	dat := make([]byte, len(buf))
+	copy(dat, buf)
+	return &Bytes{Raw: dat}, nil
+}
+
+// lenFunc returns the number of elements of an array, of bytes of a bytes
+// value or of characters of a string.
+func lenFunc(_ *state, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("want 1 argument, got %d", len(args))
+	}
+	var n int
+	switch v := args[0].(type) {
+	case *Array:
+		n = len(v.Raw)
+	case *Bytes:
+		n = len(v.Raw)
+	case *String:
+		n = utf8.RuneCountInString(v.Raw)
+	default:
+		return nil, fmt.Errorf("%w: length of %s", ErrUnsupported, asString(v))
+	}
+	return &Int{Raw: int64(n)}, nil
+}
+
+// streamKey identifies the values kept from one packet to the next by a call
+// of delta or accum: the call and the key given to it, if any.
+type streamKey struct {
+	call nodeKey
+	key  string
+}
+
+// stream returns the value kept for the stream of the current call and the key
+// given as second argument, if any.
+func stream(root *state, args []Value) (streamKey, Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return streamKey{}, nil, fmt.Errorf("want 1 or 2 arguments, got %d", len(args))
+	}
+	k := streamKey{call: root.call}
+	if len(args) == 2 {
+		k.key = asString(args[1])
+	}
+	if root.streams == nil {
+		root.streams = make(map[streamKey]Value)
+	}
+	return k, root.streams[k], nil
+}
+
+// deltaFunc returns the difference between its first argument and its value
+// in the previous packet, for the same key if one is given. It returns null the
+// first time. The difference of unsigned values is signed: a counter can also
+// decrease.
+func deltaFunc(root *state, args []Value) (Value, error) {
+	k, prev, err := stream(root, args)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := args[0].(*Null); ok {
+		return args[0], nil
+	}
+	root.streams[k] = args[0]
+	if prev == nil {
+		return &Null{}, nil
+	}
+	if curr, ok := args[0].(*Uint); ok {
+		if prev, ok := prev.(*Uint); ok {
+			return &Int{Raw: int64(curr.Raw - prev.Raw)}, nil
+		}
+	}
+	return args[0].subtract(prev)
+}
+
+// accumFunc returns the sum of the values of its first argument since the first
+// packet, for the same key if one is given.
+func accumFunc(root *state, args []Value) (Value, error) {
+	k, prev, err := stream(root, args)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := args[0].(*Null); ok {
+		return args[0], nil
+	}
+	sum := args[0]
+	if prev != nil {
+		if sum, err = prev.add(args[0]); err != nil {
+			return nil, err
+		}
+	}
+	root.streams[k] = sum
+	return sum, nil
+}
+
+// iterFunc returns the number of iterations done by the repeat whose label is
+// given as argument.
+func iterFunc(root *state, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("want 1 argument, got %d", len(args))
+	}
+	n, err := root.iterOf(asString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &Int{Raw: int64(n)}, nil
+}
+
+// callbackFunc calls the Go function registered with WithCallback under the
+// name given as first argument. The other arguments are given to it with the
+// fields of the current packet.
+func callbackFunc(root *state, args []Value) (Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("want at least 1 argument, got 0")
+	}
+	name := asString(args[0])
+	fn, ok := root.callbacks[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown callback", name)
+	}
+	v, err := fn(root.Fields, args[1:])
+	if err == nil && v == nil {
+		v = &Null{}
+	}
+	return v, err
+}
+
+// bytesFunc renders a number of bits as a size in bytes with a binary prefix,
+// eg 1.5KiB.
+func bytesFunc(root *state, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("want 1 argument, got %d", len(args))
+	}
+	return &String{Raw: humanBytes(asReal(args[0]) / numbit)}, nil
+}
+
+// humanFunc renders a number in human units. Without unit, the number is
+// scaled with a SI prefix, eg 1.2M. The unit s, ms, us or ns renders the number
+// as a duration and bits or bytes renders it as a size.
+func humanFunc(root *state, args []Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("want 1 or 2 arguments, got %d", len(args))
+	}
+	var (
+		unit string
+		val  = asReal(args[0])
+		str  string
+	)
+	if len(args) == 2 {
+		unit = asString(args[1])
+	}
+	switch unit {
+	case "":
+		str = humanNumber(val)
+	case "bits":
+		str = humanBytes(val / numbit)
+	case "bytes":
+		str = humanBytes(val)
+	case "s", "ms", "us", "ns":
+		scale := map[string]time.Duration{
+			"s":  time.Second,
+			"ms": time.Millisecond,
+			"us": time.Microsecond,
+			"ns": time.Nanosecond,
+		}
+		str = time.Duration(val * float64(scale[unit])).String()
+	default:
+		return nil, fmt.Errorf("%s: unknown unit", unit)
+	}
+	return &String{Raw: str}, nil
+}
+
+func humanBytes(n float64) string {
+	const units = "KMGTPE"
+	if math.Abs(n) < 1024 {
+		return fmt.Sprintf("%gB", n)
+	}
+	i := -1
+	for math.Abs(n) >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", n, units[i])
+}
+
+func humanNumber(n float64) string {
+	const units = "kMGTPE"
+	if math.Abs(n) < 1000 {
+		return strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	i := -1
+	for math.Abs(n) >= 1000 && i < len(units)-1 {
+		n /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.1f%c", n, units[i])
+}
+
+func packetSlice(root *state, args []Value) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("want 2 arguments, got %d", len(args))
+	}
+	var (
+		from = asInt(args[0]) / numbit
+		to   = asInt(args[1]) / numbit
+	)
+	if from < 0 || from > to || to > int64(len(root.buffer)) {
+		return nil, fmt.Errorf("invalid range [%d:%d]", from, to)
+	}
+	return root.buffer[from:to], nil
+}
+
+// crc16 computes the CRC-16/CCITT-FALSE of buf.
+func crc16(buf []byte) uint32 {
+	crc := uint16(0xFFFF)
+	for _, b := range buf {
+		crc ^= uint16(b) << 8
+		for i := 0; i < numbit; i++ {
+			if crc&0x8000 != 0 {
+				crc = (crc << 1) ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return uint32(crc)
+}
+
+func sum8(buf []byte) uint32 {
+	var sum uint8
+	for _, b := range buf {
+		sum += b
+	}
+	return uint32(sum)
+}
+
+func xor8(buf []byte) uint32 {
+	var sum uint8
+	for _, b := range buf {
+		sum ^= b
+	}
+	return uint32(sum)
+}
@@ -0,0 +1,105 @@
+package dissect
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStreamFuncs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.lst": {Data: []byte("block a (\n  c: uint 8\n  let d = $delta(c)\n)\n")},
+		"b.lst": {Data: []byte("block b (\n  c: uint 8\n  let d = $delta(c)\n)\n")},
+	}
+	tests := []struct {
+		Name   string
+		Script string
+		Data   []byte
+		Want   string
+	}{
+		{
+			Name:   "delta",
+			Script: "data (\n  c: uint 8\n  let d = $delta(c)\n  print raw as csv with c d\n)\n",
+			Data:   []byte{10, 7, 12, 12},
+			Want:   csvRecords("c,d", "10,", "7,-3", "12,5", "12,0"),
+		},
+		{
+			Name:   "delta-int",
+			Script: "data (\n  c: int 8\n  let d = $delta(c)\n  print raw as csv with c d\n)\n",
+			Data:   []byte{5, 0xfe},
+			Want:   csvRecords("c,d", "5,", "-2,-7"),
+		},
+		{
+			Name:   "delta-key",
+			Script: "data (\n  k: uint 8\n  c: uint 8\n  let d = $delta(c, k)\n  print raw as csv with k c d\n)\n",
+			Data:   []byte{1, 10, 2, 50, 1, 15, 2, 40},
+			Want:   csvRecords("k,c,d", "1,10,", "2,50,", "1,15,5", "2,40,-10"),
+		},
+		{
+			Name:   "accum",
+			Script: "data (\n  c: uint 8\n  let s = $accum(c)\n  print raw as csv with c s\n)\n",
+			Data:   []byte{1, 2, 3},
+			Want:   csvRecords("c,s", "1,1", "2,3", "3,6"),
+		},
+		{
+			Name:   "files",
+			Script: "include (\n  \"a.lst\"\n  \"b.lst\"\n)\ndata (\n  include a\n  include b\n  print raw as csv\n)\n",
+			Data:   []byte{10, 100, 7, 150},
+			Want:   csvRecords("c,d,c,d", "10,,100,", "7,-3,150,50"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			got := dissectString(t, tt.Script, tt.Data, WithFS(fsys))
+			if got != tt.Want {
+				t.Errorf("output mismatched:\nwant: %q\ngot:  %q", tt.Want, got)
+			}
+		})
+	}
+}
+
+func TestCallback(t *testing.T) {
+	const script = `data (
+  id: uint 8
+  mode: uint 8
+  let d = $callback("double", mode)
+  let _t = $callback("double", d)
+  print raw as csv with id mode d _t
+)
+`
+	double := func(fields []Field, args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, nil
+		}
+		return &Uint{Raw: asUint(args[0]) * 2}, nil
+	}
+	got := dissectString(t, script, []byte{1, 2, 3, 4}, WithCallback("double", double))
+	want := csvRecords("id,mode,d", "1,2,4", "3,4,8")
+	if got != want {
+		t.Errorf("output mismatched:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func dissectString(t *testing.T, script string, data []byte, opts ...Option) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := DissectToWriter(strings.NewReader(script), bytes.NewReader(data), &buf, opts...); err != nil {
+		t.Fatalf("decoding: unexpected error: %s", err)
+	}
+	return buf.String()
+}
+
+// csvRecords quotes the values of each record like the csv printer does.
+func csvRecords(records ...string) string {
+	var str strings.Builder
+	for _, r := range records {
+		vs := strings.Split(r, ",")
+		for i := range vs {
+			vs[i] = `"` + vs[i] + `"`
+		}
+		str.WriteString(strings.Join(vs, ","))
+		str.WriteString("\r\n")
+	}
+	return str.String()
+}
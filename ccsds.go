@@ -0,0 +1,224 @@
+package dissect
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	timeCUC = "cuc"
+	timeCDS = "cds"
+
+	timeCoarse = "coarse"
+	timeFine   = "fine"
+	timeDays   = "days"
+	timeSubms  = "subms"
+	timeEpoch  = "epoch"
+
+	epochTAI = "tai"
+)
+
+// taiEpoch is the epoch recommended by CCSDS for the time codes: 1958-01-01 in
+// TAI.
+var taiEpoch = time.Date(1958, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ccsdsTime describes the segments of a CCSDS time code. A CUC (unsegmented)
+// time is made of coarse bytes of seconds and fine bytes of sub seconds. A CDS
+// (day segmented) time is made of days bytes of days, four bytes of
+// milliseconds of day and subms bytes of microseconds (2) or picoseconds (4).
+//
+// The epoch is tai (the default), unix, gps or a date in RFC 3339 format. The
+// leap seconds are removed from the TAI and GPS times to get UTC times.
+type ccsdsTime struct {
+	coarse int
+	fine   int
+	days   int
+	subms  int
+	epoch  string
+	base   time.Time
+}
+
+func defaultTime(kind string) ccsdsTime {
+	t := ccsdsTime{
+		epoch: epochTAI,
+		base:  taiEpoch,
+	}
+	if kind == timeCUC {
+		t.coarse = 4
+	} else {
+		t.days = 2
+	}
+	return t
+}
+
+func isCCSDSTime(kind string) bool {
+	return kind == timeCUC || kind == timeCDS
+}
+
+// bits returns the size of the time code.
+func (c ccsdsTime) bits() int {
+	if c.days > 0 {
+		return (c.days + 4 + c.subms) * numbit
+	}
+	return (c.coarse + c.fine) * numbit
+}
+
+func (c ccsdsTime) check() error {
+	if c.days > 0 {
+		if c.days != 2 && c.days != 3 {
+			return fmt.Errorf("%d: days segment should be 2 or 3 bytes", c.days)
+		}
+		if c.subms != 0 && c.subms != 2 && c.subms != 4 {
+			return fmt.Errorf("%d: sub-millisecond segment should be 0, 2 or 4 bytes", c.subms)
+		}
+		return nil
+	}
+	if c.coarse < 1 || c.coarse > 7 {
+		return fmt.Errorf("%d: coarse time should be 1 to 7 bytes", c.coarse)
+	}
+	if c.fine < 0 || c.fine > 4 {
+		return fmt.Errorf("%d: fine time should be 0 to 4 bytes", c.fine)
+	}
+	return nil
+}
+
+func (c *ccsdsTime) setEpoch(tok Token) error {
+	switch tok.Literal {
+	case epochTAI:
+		c.base = taiEpoch
+	case kwUnix:
+		c.base = time.Unix(0, 0).UTC()
+	case kwGPS:
+		c.base = gpsEpoch
+	default:
+		t, err := time.Parse(time.RFC3339, tok.Literal)
+		if err != nil {
+			return fmt.Errorf("%s: invalid epoch", tok.Literal)
+		}
+		c.base = t.UTC()
+	}
+	c.epoch = tok.Literal
+	return nil
+}
+
+// time returns the UTC time of the code found in buf.
+func (c ccsdsTime) time(buf []byte) time.Time {
+	var secs, nsec int64
+	if c.days > 0 {
+		var (
+			days = int64(beUint(buf[:c.days]))
+			ms   = int64(beUint(buf[c.days : c.days+4]))
+			sub  = int64(beUint(buf[c.days+4:]))
+		)
+		secs = days*86400 + ms/1000
+		nsec = (ms % 1000) * int64(time.Millisecond)
+		switch c.subms {
+		case 2:
+			nsec += sub * int64(time.Microsecond)
+		case 4:
+			nsec += sub / 1000
+		}
+	} else {
+		secs = int64(beUint(buf[:c.coarse]))
+		fine := beUint(buf[c.coarse:])
+		nsec = int64((fine * uint64(time.Second)) >> uint(c.fine*numbit))
+	}
+	when := time.Unix(c.base.Unix()+secs, nsec).UTC()
+	switch c.epoch {
+	case epochTAI:
+		when = taiToUTC(when)
+	case kwGPS:
+		when = removeLeaps(when, gpsEpoch)
+	}
+	return when
+}
+
+func beUint(buf []byte) uint64 {
+	var u uint64
+	for _, b := range buf {
+		u = u<<numbit | uint64(b)
+	}
+	return u
+}
+
+// parseTime parses the kind of a time field: time(unix), time(gps), or the
+// CCSDS time codes with their optional parameters, eg time(cuc, coarse=4,
+// fine=2) or time(cds, days=3, epoch="2000-01-01T00:00:00Z"). It stops on the
+// closing parenthesis.
+func (p *Parser) parseTime() (Token, ccsdsTime, error) {
+	p.nextToken()
+	p.nextToken()
+	kind := p.curr
+	switch lit := p.curr.Literal; {
+	case lit == kwUnix || lit == kwGPS:
+		p.nextToken()
+		if p.curr.Type != rparen {
+			return kind, ccsdsTime{}, p.unexpectedError()
+		}
+		return kind, ccsdsTime{}, nil
+	case p.curr.Type == Ident && isCCSDSTime(lit):
+	default:
+		return kind, ccsdsTime{}, p.unexpectedError()
+	}
+	spec := defaultTime(kind.Literal)
+	p.nextToken()
+	for p.curr.Type == comma {
+		p.nextToken()
+		name := p.curr
+		p.nextToken()
+		if p.curr.Type != Assign {
+			return kind, spec, p.expectedError("=")
+		}
+		p.nextToken()
+		if name.Literal == timeEpoch {
+			if err := spec.setEpoch(p.curr); err != nil {
+				return kind, spec, fmt.Errorf("time: %w (%s)", err, p.curr.Pos())
+			}
+			p.nextToken()
+			continue
+		}
+		if p.curr.Type != Integer {
+			return kind, spec, p.expectedError("integer")
+		}
+		n, _ := strconv.Atoi(p.curr.Literal)
+		switch {
+		case kind.Literal == timeCUC && name.Literal == timeCoarse:
+			spec.coarse = n
+		case kind.Literal == timeCUC && name.Literal == timeFine:
+			spec.fine = n
+		case kind.Literal == timeCDS && name.Literal == timeDays:
+			spec.days = n
+		case kind.Literal == timeCDS && name.Literal == timeSubms:
+			spec.subms = n
+		default:
+			return kind, spec, fmt.Errorf("time(%s): unknown parameter %s (%s)", kind.Literal, name.Literal, name.Pos())
+		}
+		p.nextToken()
+	}
+	if p.curr.Type != rparen {
+		return kind, spec, p.expectedError(")")
+	}
+	if err := spec.check(); err != nil {
+		return kind, spec, fmt.Errorf("time(%s): %w (%s)", kind.Literal, err, kind.Pos())
+	}
+	return kind, spec, nil
+}
+
+func (root *state) decodeTimeCode(p Parameter, bits, index int) (Field, error) {
+	size := bits / numbit
+	if n := root.Size() / numbit; n < index+size {
+		return Field{}, fmt.Errorf("%w: missing %d bytes", ErrShort, (index+size)-n)
+	}
+	raw := Field{
+		Id:     p.id.Literal,
+		Pos:    root.Pos,
+		Len:    bits,
+		kind:   p.is(),
+		endian: kwBig,
+		raw: &Time{
+			Raw: p.time.time(root.buffer[index : index+size]),
+		},
+	}
+	return raw, nil
+}
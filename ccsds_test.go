@@ -0,0 +1,103 @@
+package dissect
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeCode(t *testing.T) {
+	tests := []struct {
+		Kind string
+		Data []byte
+		Want string
+		Err  error
+	}{
+		{
+			Kind: "time(cuc, coarse=4, fine=2, epoch=unix)",
+			Data: []byte{0x5f, 0x5e, 0x10, 0x00, 0x80, 0x00},
+			Want: "2020-09-13T12:26:40.5Z",
+		},
+		{
+			// the leap seconds are removed from the TAI time
+			Kind: "time(cuc)",
+			Data: []byte{0x71, 0xa2, 0xeb, 0x25},
+			Want: "2018-06-01T00:00:00Z",
+		},
+		{
+			Kind: "time(cuc, coarse=1, epoch=gps)",
+			Data: []byte{0x00},
+			Want: "1980-01-06T00:00:00Z",
+		},
+		{
+			Kind: "time(cds, subms=2, epoch=unix)",
+			Data: []byte{0x4a, 0x38, 0x00, 0x36, 0xee, 0x81, 0x01, 0xf4},
+			Want: "2022-01-08T01:00:00.0015Z",
+		},
+		{
+			Kind: "time(cds, subms=4, epoch=unix)",
+			Data: []byte{0x4a, 0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x16, 0xe3, 0x60},
+			Want: "2022-01-08T00:00:00.0000015Z",
+		},
+		{
+			Kind: `time(cds, days=3, epoch="2000-01-01T00:00:00Z")`,
+			Data: []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x03, 0xe8},
+			Want: "2000-01-02T00:00:01Z",
+		},
+		{
+			Kind: "time(cds, subms=2)",
+			Data: []byte{0x4a, 0x38, 0x00, 0x36, 0xee},
+			Err:  ErrShort,
+		},
+	}
+	for _, tt := range tests {
+		script := fmt.Sprintf("data (\n  t: %s\n)\n", tt.Kind)
+		var got struct {
+			T time.Time
+		}
+		err := Unmarshal(strings.NewReader(script), tt.Data, &got)
+		if tt.Err != nil {
+			if !errors.Is(err, tt.Err) {
+				t.Errorf("%s: want error %v, got %v", tt.Kind, tt.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.Kind, err)
+			continue
+		}
+		if str := got.T.Format(time.RFC3339Nano); str != tt.Want {
+			t.Errorf("%s: want %s, got %s", tt.Kind, tt.Want, str)
+		}
+	}
+}
+
+func TestTimeCodeInvalid(t *testing.T) {
+	tests := []struct {
+		Kind string
+		Err  string
+	}{
+		{Kind: "time(cuc, coarse=0)", Err: "coarse time should be 1 to 7 bytes"},
+		{Kind: "time(cuc, coarse=8)", Err: "coarse time should be 1 to 7 bytes"},
+		{Kind: "time(cuc, fine=5)", Err: "fine time should be 0 to 4 bytes"},
+		{Kind: "time(cds, days=4)", Err: "days segment should be 2 or 3 bytes"},
+		{Kind: "time(cds, subms=3)", Err: "sub-millisecond segment should be 0, 2 or 4 bytes"},
+		{Kind: "time(cds, coarse=4)", Err: "unknown parameter coarse"},
+		{Kind: `time(cuc, epoch="yesterday")`, Err: "yesterday: invalid epoch"},
+		{Kind: "time(cuc, fine=two)", Err: "integer"},
+		{Kind: "time(cuc) 32", Err: "given by its segments"},
+	}
+	for _, tt := range tests {
+		script := fmt.Sprintf("data (\n  t: %s\n)\n", tt.Kind)
+		_, err := New(strings.NewReader(script))
+		if err == nil {
+			t.Errorf("%s: script accepted", tt.Kind)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.Err) {
+			t.Errorf("%s: error %q does not contain %q", tt.Kind, err, tt.Err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package dissect
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	checkCRC16 = "crc16"
+	checkCRC32 = "crc32"
+	checkSum   = "sum"
+	checkXor   = "xor"
+)
+
+// ErrChecksum is wrapped by the errors of the packets whose checksum does not
+// match the one computed by check.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// checksums are the methods of check computing the checksum of a range of the
+// packet. They are the same as the checksum builtins.
+var checksums = map[string]func([]byte) uint32{
+	checkCRC16: crc16,
+	checkCRC32: crc32.ChecksumIEEE,
+	checkSum:   sum8,
+	checkXor:   xor8,
+}
+
+// decodeChecksum computes the checksum of the current packet between the
+// offsets, in bits, of c and compares it with the value of its field. Without
+// offsets, the checksum covers the bytes preceding the field.
+func (root *state) decodeChecksum(c Check) error {
+	var (
+		field Field
+		found bool
+	)
+	for i := len(root.Fields) - 1; i >= 0; i-- {
+		if root.Fields[i].Id == c.field.Literal {
+			field, found = root.Fields[i], true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("check: %s: field not decoded (%s)", c.field.Literal, c.Pos())
+	}
+	if !isInteger(field.raw) {
+		return fmt.Errorf("check: %s: integer expected", field)
+	}
+	args := []Value{&Int{}, &Int{Raw: int64(field.Pos)}}
+	if c.from != nil {
+		for i, e := range []Expression{c.from, c.to} {
+			v, err := eval(e, root)
+			if err != nil {
+				return err
+			}
+			if !isInteger(v) {
+				return fmt.Errorf("check: %s: offset should be an integer", e)
+			}
+			args[i] = v
+		}
+	}
+	if end := int(asInt(args[1])); end > root.Pos {
+		if err := root.growBuffer(end - root.Pos); err != nil {
+			return err
+		}
+	}
+	buf, err := packetSlice(root, args)
+	if err != nil {
+		return fmt.Errorf("check: %s: %w", c.kind.Literal, err)
+	}
+	sum := checksums[c.kind.Literal](buf)
+	if got, want := uint64(sum), asUint(field.raw); got != want {
+		root.fail(field.Id, failChecksum)
+		return fmt.Errorf("%s %s %w: want %#x, got %#x", c.kind.Literal, field.Id, ErrChecksum, want, got)
+	}
+	return nil
+}
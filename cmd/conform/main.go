@@ -0,0 +1,102 @@
+// conform checks the parser against the corpus of scripts of a directory
+// (testdata/conformance by default). The scripts of the valid sub directory
+// should be accepted and the ones of the invalid sub directory rejected. An
+// invalid script can give the message of the expected error in its first
+// line, eg:
+//
+//	# error: keyword not allowed here
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/midbel/dissect"
+)
+
+const (
+	dirValid    = "valid"
+	dirInvalid  = "invalid"
+	errorPrefix = "# error:"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "print the result of each script")
+	flag.Parse()
+
+	dir := flag.Arg(0)
+	if dir == "" {
+		dir = filepath.Join("testdata", "conformance")
+	}
+	var total, failed int
+	for _, sub := range []string{dirValid, dirInvalid} {
+		files, err := filepath.Glob(filepath.Join(dir, sub, "*.lst"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			total++
+			err := check(f, sub == dirValid)
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL %s: %s\n", f, err)
+			} else if *verbose {
+				fmt.Printf("ok   %s\n", f)
+			}
+		}
+	}
+	fmt.Printf("%d scripts, %d failed\n", total, failed)
+	if total == 0 || failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func check(file string, valid bool) error {
+	want, err := expectedError(file)
+	if err != nil {
+		return err
+	}
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// scripts of the corpus never access the network
+	_, err = dissect.New(r, dissect.WithAllowNetwork())
+	switch {
+	case valid && err != nil:
+		return fmt.Errorf("unexpected error: %w", err)
+	case !valid && err == nil:
+		return errors.New("script accepted")
+	case !valid && !strings.Contains(err.Error(), want):
+		return fmt.Errorf("error %q does not contain %q", err, want)
+	}
+	return nil
+}
+
+func expectedError(file string) (string, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		return "", s.Err()
+	}
+	line := s.Text()
+	if !strings.HasPrefix(line, errorPrefix) {
+		return "", nil
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, errorPrefix)), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/midbel/dissect"
+)
+
+// admin serves the health, statistics and configuration of the running
+// interpreter over HTTP.
+type admin struct {
+	mu     sync.Mutex
+	source statsSource
+}
+
+// statsSource is an interpreter or a group of interpreters whose statistics are
+// summed.
+type statsSource interface {
+	Stats() dissect.Stats
+}
+
+func startAdmin(addr string) (*admin, error) {
+	a, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ctl admin
+		mux = http.NewServeMux()
+	)
+	mux.HandleFunc("/healthz", ctl.healthz)
+	mux.HandleFunc("/stats", ctl.stats)
+	mux.HandleFunc("/config", ctl.config)
+	go http.Serve(a, mux)
+	return &ctl, nil
+}
+
+func (a *admin) Attach(s statsSource) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.source = s
+}
+
+func (a *admin) current() statsSource {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.source
+}
+
+func (a *admin) healthz(w http.ResponseWriter, r *http.Request) {
+	if a.current() == nil {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func (a *admin) stats(w http.ResponseWriter, r *http.Request) {
+	var s dissect.Stats
+	if src := a.current(); src != nil {
+		s = src.Stats()
+	}
+	writeJSON(w, s)
+}
+
+func (a *admin) config(w http.ResponseWriter, r *http.Request) {
+	var (
+		flags = make(map[string]string)
+		cfg   = struct {
+			Args  []string          `json:"args"`
+			Flags map[string]string `json:"flags"`
+		}{
+			Args:  flag.Args(),
+			Flags: flags,
+		}
+	)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	writeJSON(w, cfg)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	if err := e.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/midbel/dissect"
+)
+
+const stampLayout = "20060102T150405"
+
+var stampPattern = regexp.MustCompile(`\d{8}T\d{6}`)
+
+type archive struct {
+	file  string
+	start time.Time
+}
+
+// runBackfill decodes again the archives produced by copy statements whose
+// data overlap the requested time range. Each archive is expected to have the
+// time of its first data in its name (as written by rotated sinks) and to last
+// until the time of the next archive. The files written are marked as
+// backfilled by a suffix before their extension, eg hk.backfill.csv.
+func runBackfill(args []string, opts []dissect.Option) error {
+	set := flag.NewFlagSet("backfill", flag.ExitOnError)
+	from := set.String("from", "", "start of time range")
+	to := set.String("to", "", "end of time range")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() < 2 {
+		return fmt.Errorf("usage: dissect backfill [-from time] [-to time] <schema> <archive...>")
+	}
+	var (
+		fd, td time.Time
+		err    error
+	)
+	if fd, err = parseTime(*from); err != nil {
+		return err
+	}
+	if td, err = parseTime(*to); err != nil {
+		return err
+	}
+	archives, err := listArchives(set.Args()[1:])
+	if err != nil {
+		return err
+	}
+	files := selectArchives(archives, fd, td)
+	if len(files) == 0 {
+		return fmt.Errorf("no archive found in time range")
+	}
+
+	opts = append(opts, dissect.WithBackfill(true))
+	i, err := loadSchema(set.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	ctl.Attach(i)
+	return i.RunFiles(files)
+}
+
+func parseTime(str string) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, stampLayout, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s: invalid time", str)
+}
+
+func listArchives(args []string) ([]archive, error) {
+	var files []string
+	for _, a := range args {
+		i, err := os.Stat(a)
+		if err != nil {
+			matches, err := filepath.Glob(a)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+			continue
+		}
+		if !i.IsDir() {
+			files = append(files, a)
+			continue
+		}
+		infos, err := ioutil.ReadDir(a)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range infos {
+			if i.Mode().IsRegular() {
+				files = append(files, filepath.Join(a, i.Name()))
+			}
+		}
+	}
+	var archives []archive
+	for _, f := range files {
+		stamp := stampPattern.FindString(filepath.Base(f))
+		if stamp == "" {
+			continue
+		}
+		when, err := time.Parse(stampLayout, stamp)
+		if err != nil {
+			continue
+		}
+		archives = append(archives, archive{file: f, start: when})
+	}
+	sort.SliceStable(archives, func(i, j int) bool {
+		if archives[i].start.Equal(archives[j].start) {
+			return archives[i].file < archives[j].file
+		}
+		return archives[i].start.Before(archives[j].start)
+	})
+	return archives, nil
+}
+
+func selectArchives(archives []archive, from, to time.Time) []string {
+	var files []string
+	for i, a := range archives {
+		if !to.IsZero() && a.start.After(to) {
+			break
+		}
+		if !from.IsZero() && i+1 < len(archives) {
+			next := archives[i+1].start
+			if !next.After(from) {
+				continue
+			}
+		}
+		files = append(files, a.file)
+	}
+	return files
+}
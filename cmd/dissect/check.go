@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/midbel/dissect"
+)
+
+// runCheck decodes a sample with a schema and compares the decoded fields with
+// the expected values of a csv file, reporting the first field that differs.
+func runCheck(args []string, opts []dissect.Option) error {
+	set := flag.NewFlagSet("check", flag.ExitOnError)
+	expect := set.String("expect", "", "csv file with the expected values")
+	eng := set.Bool("eng", false, "compare the engineering values")
+	files, err := parseInterspersed(set, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 2 || *expect == "" {
+		return fmt.Errorf("usage: dissect check [-eng] -expect file.csv schema sample")
+	}
+	script, err := os.Open(files[0])
+	if err != nil {
+		return err
+	}
+	defer script.Close()
+
+	sample, err := os.Open(files[1])
+	if err != nil {
+		return err
+	}
+	defer sample.Close()
+
+	want, err := os.Open(*expect)
+	if err != nil {
+		return err
+	}
+	defer want.Close()
+
+	d, err := dissect.CheckSample(script, sample, want, *eng, opts...)
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		return fmt.Errorf("%s: %s", files[1], d)
+	}
+	fmt.Fprintf(os.Stderr, "%s: all the values of %s match\n", files[1], *expect)
+	return nil
+}
+
+// parseInterspersed parses args accepting flags after the positional
+// arguments, eg dissect check schema sample -expect file.csv.
+func parseInterspersed(set *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := set.Parse(args); err != nil {
+			return nil, err
+		}
+		args = set.Args()
+		if len(args) == 0 {
+			break
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+	return rest, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/midbel/dissect"
+)
+
+const compiledExt = ".dc"
+
+func runCompile(args []string) error {
+	set := flag.NewFlagSet("compile", flag.ExitOnError)
+	out := set.String("o", "", "compiled output file")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	var schema string
+	if set.NArg() > 0 {
+		// flags are also accepted after the schema
+		schema = set.Arg(0)
+		if err := set.Parse(set.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if schema == "" || set.NArg() != 0 {
+		return fmt.Errorf("usage: dissect compile [-o file] <schema>")
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(schema, filepath.Ext(schema)) + compiledExt
+	}
+
+	r, err := os.Open(schema)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	prog, err := dissect.Compile(r)
+	if err != nil {
+		return err
+	}
+	w, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err = prog.Save(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// loadSchema creates an interpreter from schema. A compiled schema (.dc) is
+// loaded without being parsed and merged again.
+func loadSchema(schema string, opts []dissect.Option) (*dissect.Interpreter, error) {
+	r, err := os.Open(schema)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if filepath.Ext(schema) != compiledExt {
+		return dissect.New(r, opts...)
+	}
+	prog, err := dissect.LoadProgram(r)
+	if err != nil {
+		return nil, err
+	}
+	return dissect.NewFromProgram(prog, opts...)
+}
+
+// reloadSchema replaces the schema run by i with the content of schema. The
+// interpreter switches to it before the next packet.
+func reloadSchema(i *dissect.Interpreter, schema string) error {
+	r, err := os.Open(schema)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if filepath.Ext(schema) != compiledExt {
+		return i.Reload(r)
+	}
+	prog, err := dissect.LoadProgram(r)
+	if err == nil {
+		i.ReloadProgram(prog)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/midbel/dissect"
+)
+
+const dotExt = ".dot"
+
+// runGraph writes the graph of the blocks of a schema. The graph is written in
+// the DOT language to stdout or to a .dot file. For other extensions (svg,
+// png, pdf...), the graph is rendered with the dot command of graphviz.
+func runGraph(args []string) error {
+	set := flag.NewFlagSet("graph", flag.ExitOnError)
+	out := set.String("o", "", "output file")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	var schema string
+	if set.NArg() > 0 {
+		// flags are also accepted after the schema
+		schema = set.Arg(0)
+		if err := set.Parse(set.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if schema == "" || set.NArg() != 0 {
+		return fmt.Errorf("usage: dissect graph [-o file] <schema>")
+	}
+
+	r, err := os.Open(schema)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	if err := dissect.Graph(&buf, r); err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(*out)); {
+	case *out == "" || *out == "-":
+		_, err = buf.WriteTo(os.Stdout)
+	case ext == dotExt || ext == "":
+		err = ioutil.WriteFile(*out, buf.Bytes(), 0644)
+	default:
+		cmd := exec.Command("dot", "-T"+ext[1:], "-o", *out)
+		cmd.Stdin = &buf
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			err = fmt.Errorf("dot (graphviz is needed to render %s): %w", ext, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,742 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/midbel/dissect"
+)
+
+// runImport converts a packed C struct, and the structs and enums it uses,
+// into a schema.
+func runImport(args []string) error {
+	set := flag.NewFlagSet("import", flag.ExitOnError)
+	name := set.String("struct", "", "struct to convert (default: last struct of the header)")
+	endian := set.String("endian", "little", "byte order of the members (big, little)")
+	out := set.String("o", "-", "schema file to create (- for stdout)")
+	files, err := parseInterspersed(set, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("usage: dissect import [-struct name] [-endian little|big] [-o file] header.h")
+	}
+	if err := oneOf("big", "little")(*endian); err != nil {
+		return err
+	}
+	src, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		return err
+	}
+	schema, err := importHeader(src, *name, *endian, files[0])
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(schema)
+		return err
+	}
+	return ioutil.WriteFile(*out, schema, 0644)
+}
+
+// importHeader gives the schema of the struct name, or of the last struct when
+// name is empty, found in the C header src read from file.
+func importHeader(src []byte, name, endian, file string) ([]byte, error) {
+	h, err := parseHeader(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if name == "" {
+		if len(h.order) == 0 {
+			return nil, fmt.Errorf("%s: no struct found", file)
+		}
+		name = h.order[len(h.order)-1]
+	}
+	var buf bytes.Buffer
+	if err := h.write(&buf, name, endian, file); err != nil {
+		return nil, err
+	}
+	// the schema is parsed to never write one that dissect rejects
+	if _, err := dissect.New(bytes.NewReader(buf.Bytes())); err != nil {
+		return nil, fmt.Errorf("generated schema is invalid: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+type cType struct {
+	kind string // int, uint, float, string, bytes
+	bits int
+}
+
+// cTypes are the C types of fixed size, assuming an LP64 platform.
+var cTypes = map[string]cType{
+	"char":               {kind: "int", bits: 8},
+	"signed char":        {kind: "int", bits: 8},
+	"unsigned char":      {kind: "uint", bits: 8},
+	"bool":               {kind: "uint", bits: 8},
+	"_Bool":              {kind: "uint", bits: 8},
+	"int8_t":             {kind: "int", bits: 8},
+	"uint8_t":            {kind: "uint", bits: 8},
+	"short":              {kind: "int", bits: 16},
+	"signed short":       {kind: "int", bits: 16},
+	"unsigned short":     {kind: "uint", bits: 16},
+	"int16_t":            {kind: "int", bits: 16},
+	"uint16_t":           {kind: "uint", bits: 16},
+	"int":                {kind: "int", bits: 32},
+	"signed":             {kind: "int", bits: 32},
+	"signed int":         {kind: "int", bits: 32},
+	"unsigned":           {kind: "uint", bits: 32},
+	"unsigned int":       {kind: "uint", bits: 32},
+	"int32_t":            {kind: "int", bits: 32},
+	"uint32_t":           {kind: "uint", bits: 32},
+	"long":               {kind: "int", bits: 64},
+	"signed long":        {kind: "int", bits: 64},
+	"unsigned long":      {kind: "uint", bits: 64},
+	"long long":          {kind: "int", bits: 64},
+	"signed long long":   {kind: "int", bits: 64},
+	"unsigned long long": {kind: "uint", bits: 64},
+	"int64_t":            {kind: "int", bits: 64},
+	"uint64_t":           {kind: "uint", bits: 64},
+	"float":              {kind: "float", bits: 32},
+	"double":             {kind: "float", bits: 64},
+}
+
+type cMember struct {
+	name   string
+	typ    string // C type, struct or enum name
+	ref    string // struct or enum
+	bits   int    // width of a bitfield
+	dims   []int
+	line   int
+	isEnum bool
+}
+
+type cEnum struct {
+	name   string
+	values []cEnumValue
+}
+
+type cEnumValue struct {
+	name  string
+	value int64
+}
+
+type header struct {
+	structs map[string][]cMember
+	enums   map[string]cEnum
+	defines map[string]int64
+	order   []string
+}
+
+// parseHeader extracts the structs, the enums and the integer constants
+// defined with #define of a C header. Only the subset of C used to describe
+// binary formats is supported: no pointers, unions nor function declarations.
+func parseHeader(src []byte) (*header, error) {
+	h := header{
+		structs: make(map[string][]cMember),
+		enums:   make(map[string]cEnum),
+		defines: make(map[string]int64),
+	}
+	var (
+		code strings.Builder
+		scan = bufio.NewScanner(bytes.NewReader(stripComments(src)))
+	)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if strings.HasPrefix(line, "#") {
+			fs := strings.Fields(strings.TrimPrefix(line, "#"))
+			if len(fs) == 3 && fs[0] == "define" {
+				if n, err := parseCNumber(fs[2]); err == nil {
+					h.defines[fs[1]] = n
+				}
+			}
+			line = ""
+		}
+		code.WriteString(line)
+		code.WriteString("\n")
+	}
+	p := cParser{tokens: tokenizeC(code.String()), header: &h}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func stripComments(src []byte) []byte {
+	var out []byte
+	for i := 0; i < len(src); i++ {
+		switch {
+		case bytes.HasPrefix(src[i:], []byte("//")):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			if i < len(src) {
+				out = append(out, '\n')
+			}
+		case bytes.HasPrefix(src[i:], []byte("/*")):
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			// newlines are kept so that the lines of the members do not change
+			out = append(out, bytes.Repeat([]byte("\n"), bytes.Count(src[i:i+2+end], []byte("\n")))...)
+			out = append(out, ' ')
+			i += end + 3
+		default:
+			out = append(out, src[i])
+		}
+	}
+	return out
+}
+
+type cToken struct {
+	text string
+	line int
+}
+
+func tokenizeC(str string) []cToken {
+	var (
+		ts   []cToken
+		line = 1
+		rs   = []rune(str)
+	)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(rs) && (rs[j] == '_' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			ts = append(ts, cToken{text: string(rs[i:j]), line: line})
+			i = j
+		default:
+			ts = append(ts, cToken{text: string(r), line: line})
+			i++
+		}
+	}
+	return ts
+}
+
+func parseCNumber(str string) (int64, error) {
+	str = strings.TrimRight(str, "uUlL")
+	if len(str) > 1 && str[0] == '0' && str[1] != 'x' && str[1] != 'X' {
+		str = "0o" + str[1:]
+	}
+	return strconv.ParseInt(str, 0, 64)
+}
+
+type cParser struct {
+	tokens []cToken
+	pos    int
+	header *header
+}
+
+func (p *cParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+func (p *cParser) next() cToken {
+	if p.pos >= len(p.tokens) {
+		return cToken{}
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *cParser) line() int {
+	if p.pos >= len(p.tokens) {
+		if len(p.tokens) == 0 {
+			return 0
+		}
+		return p.tokens[len(p.tokens)-1].line
+	}
+	return p.tokens[p.pos].line
+}
+
+func (p *cParser) expect(str string) error {
+	if t := p.next(); t.text != str {
+		return fmt.Errorf("line %d: expected %q, got %q", t.line, str, t.text)
+	}
+	return nil
+}
+
+func (p *cParser) parse() error {
+	for p.pos < len(p.tokens) {
+		var err error
+		switch p.peek() {
+		case "typedef":
+			p.next()
+			err = p.parseTypedef()
+		case "struct":
+			p.next()
+			_, err = p.parseStruct("")
+			if err == nil {
+				p.skipDeclaration()
+			}
+		case "enum":
+			p.next()
+			_, err = p.parseEnum("")
+			if err == nil {
+				p.skipDeclaration()
+			}
+		default:
+			p.skipDeclaration()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipDeclaration skips everything up to the end of the current declaration,
+// eg a function prototype or a global variable.
+func (p *cParser) skipDeclaration() {
+	var depth int
+	for p.pos < len(p.tokens) {
+		switch p.next().text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		case ";":
+			if depth <= 0 {
+				return
+			}
+		}
+	}
+}
+
+func (p *cParser) parseTypedef() error {
+	switch p.peek() {
+	case "struct":
+		p.next()
+		tag, err := p.parseStruct("")
+		if err != nil {
+			return err
+		}
+		name := p.skipAttributes()
+		if name != "" && name != tag {
+			p.header.structs[name] = p.header.structs[tag]
+			p.header.order = append(p.header.order, name)
+		}
+	case "enum":
+		p.next()
+		tag, err := p.parseEnum("")
+		if err != nil {
+			return err
+		}
+		if name := p.skipAttributes(); name != "" && name != tag {
+			e := p.header.enums[tag]
+			e.name = name
+			p.header.enums[name] = e
+		}
+	}
+	p.skipDeclaration()
+	return nil
+}
+
+// skipAttributes skips the attributes, eg __attribute__((packed)), and
+// returns the first identifier found before the end of the declaration.
+func (p *cParser) skipAttributes() string {
+	for p.pos < len(p.tokens) {
+		switch t := p.peek(); {
+		case t == ";":
+			return ""
+		case strings.HasPrefix(t, "__"):
+			p.next()
+			if p.peek() == "(" {
+				p.skipParens()
+			}
+		case isCIdent(t):
+			p.next()
+			return t
+		default:
+			p.next()
+		}
+	}
+	return ""
+}
+
+func (p *cParser) skipParens() {
+	var depth int
+	for p.pos < len(p.tokens) {
+		switch p.next().text {
+		case "(":
+			depth++
+		case ")":
+			if depth--; depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+func (p *cParser) parseStruct(tag string) (string, error) {
+	for strings.HasPrefix(p.peek(), "__") {
+		p.next()
+		p.skipParens()
+	}
+	if isCIdent(p.peek()) {
+		tag = p.next().text
+	}
+	if p.peek() != "{" {
+		return tag, nil
+	}
+	p.next()
+	if tag == "" {
+		tag = fmt.Sprintf("struct_%d", p.line())
+	}
+	var ms []cMember
+	for p.peek() != "}" {
+		if p.pos >= len(p.tokens) {
+			return "", fmt.Errorf("struct %s: missing }", tag)
+		}
+		xs, err := p.parseMembers()
+		if err != nil {
+			return "", fmt.Errorf("struct %s: %w", tag, err)
+		}
+		ms = append(ms, xs...)
+	}
+	p.next()
+	p.header.structs[tag] = ms
+	p.header.order = append(p.header.order, tag)
+	return tag, nil
+}
+
+// parseMembers parses the declaration of one or more members of the same type,
+// eg uint8_t a, b[4], c:3;
+func (p *cParser) parseMembers() ([]cMember, error) {
+	var (
+		base   cMember
+		words  []string
+		line   = p.line()
+		qualif = map[string]bool{"const": true, "volatile": true}
+	)
+	switch p.peek() {
+	case "struct", "enum":
+		base.ref = p.next().text
+		if !isCIdent(p.peek()) {
+			return nil, fmt.Errorf("line %d: nested %s definitions not supported", line, base.ref)
+		}
+		base.typ = p.next().text
+		base.isEnum = base.ref == "enum"
+	case "union":
+		return nil, fmt.Errorf("line %d: unions not supported", line)
+	default:
+		for isCIdent(p.peek()) {
+			w := p.next().text
+			if qualif[w] {
+				continue
+			}
+			words = append(words, w)
+		}
+		if p.peek() == "*" {
+			return nil, fmt.Errorf("line %d: pointers not supported", line)
+		}
+		// the last word is the name of the first member
+		if len(words) < 2 {
+			return nil, fmt.Errorf("line %d: member without type", line)
+		}
+		p.pos--
+		// short int, long int,... are the same types as short, long,...
+		base.typ = strings.Join(words[:len(words)-1], " ")
+		if base.typ != "int" && base.typ != "signed int" && base.typ != "unsigned int" {
+			base.typ = strings.TrimSuffix(base.typ, " int")
+		}
+		if _, ok := cTypes[base.typ]; !ok {
+			if _, ok := p.header.structs[base.typ]; ok {
+				base.ref = "struct"
+			} else if _, ok := p.header.enums[base.typ]; ok {
+				base.ref, base.isEnum = "enum", true
+			} else {
+				return nil, fmt.Errorf("line %d: %s: unsupported type", line, base.typ)
+			}
+		}
+	}
+	var ms []cMember
+	for {
+		if p.peek() == "*" {
+			return nil, fmt.Errorf("line %d: pointers not supported", line)
+		}
+		m := base
+		m.line = line
+		if t := p.next(); isCIdent(t.text) {
+			m.name = t.text
+		} else {
+			return nil, fmt.Errorf("line %d: expected member name, got %q", t.line, t.text)
+		}
+		for p.peek() == "[" {
+			p.next()
+			n, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			if n <= 0 {
+				return nil, fmt.Errorf("line %d: %s: invalid array size", line, m.name)
+			}
+			m.dims = append(m.dims, int(n))
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		}
+		if p.peek() == ":" {
+			p.next()
+			n, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			if n <= 0 || n > 64 {
+				return nil, fmt.Errorf("line %d: %s: invalid bitfield width", line, m.name)
+			}
+			m.bits = int(n)
+		}
+		ms = append(ms, m)
+		switch t := p.next(); t.text {
+		case ",":
+		case ";":
+			return ms, nil
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+		}
+	}
+}
+
+// parseValue parses an integer constant: a number, a define or an enumerator,
+// optionally negated.
+func (p *cParser) parseValue() (int64, error) {
+	var neg bool
+	if p.peek() == "-" {
+		p.next()
+		neg = true
+	}
+	t := p.next()
+	n, err := parseCNumber(t.text)
+	if err != nil {
+		var ok bool
+		if n, ok = p.header.defines[t.text]; !ok {
+			n, ok = p.enumerator(t.text)
+		}
+		if !ok {
+			return 0, fmt.Errorf("line %d: %s: unsupported constant", t.line, t.text)
+		}
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+func (p *cParser) enumerator(name string) (int64, bool) {
+	for _, e := range p.header.enums {
+		for _, v := range e.values {
+			if v.name == name {
+				return v.value, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (p *cParser) parseEnum(tag string) (string, error) {
+	if isCIdent(p.peek()) {
+		tag = p.next().text
+	}
+	if p.peek() != "{" {
+		return tag, nil
+	}
+	p.next()
+	if tag == "" {
+		tag = fmt.Sprintf("enum_%d", p.line())
+	}
+	e := cEnum{name: tag}
+	p.header.enums[tag] = e
+	var next int64
+	for p.peek() != "}" {
+		t := p.next()
+		if !isCIdent(t.text) {
+			return "", fmt.Errorf("line %d: enum %s: unexpected %q", t.line, tag, t.text)
+		}
+		if p.peek() == "=" {
+			p.next()
+			n, err := p.parseValue()
+			if err != nil {
+				return "", err
+			}
+			next = n
+		}
+		e.values = append(e.values, cEnumValue{name: t.text, value: next})
+		p.header.enums[tag] = e
+		next++
+		if p.peek() == "," {
+			p.next()
+		}
+	}
+	p.next()
+	return tag, nil
+}
+
+func isCIdent(str string) bool {
+	if str == "" || unicode.IsDigit(rune(str[0])) {
+		return false
+	}
+	for _, r := range str {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// write writes the schema of the struct name: an enum per C enum, a block per
+// struct, nested structs first, and a data block decoding the struct.
+func (h *header) write(w io.Writer, name, endian, file string) error {
+	var (
+		blocks []string
+		enums  []string
+		seen   = make(map[string]bool)
+		visit  func(string, []string) error
+	)
+	visit = func(s string, stack []string) error {
+		if seen[s] {
+			return nil
+		}
+		for _, x := range stack {
+			if x == s {
+				return fmt.Errorf("struct %s: recursive definition", s)
+			}
+		}
+		ms, ok := h.structs[s]
+		if !ok {
+			return fmt.Errorf("struct %s: not found", s)
+		}
+		for _, m := range ms {
+			switch {
+			case m.isEnum && !seen["enum "+m.typ]:
+				if _, ok := h.enums[m.typ]; !ok {
+					return fmt.Errorf("enum %s: not found", m.typ)
+				}
+				seen["enum "+m.typ] = true
+				enums = append(enums, m.typ)
+			case m.ref == "struct":
+				if err := visit(m.typ, append(stack, s)); err != nil {
+					return err
+				}
+			}
+		}
+		seen[s] = true
+		blocks = append(blocks, s)
+		return nil
+	}
+	if err := visit(name, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# %s: imported from %s with dissect import\n", name, file)
+	fmt.Fprintf(w, "dissect %s\n\n", dissect.Version)
+
+	for _, e := range enums {
+		fmt.Fprintf(w, "enum %s (\n", quoteName(e))
+		for _, v := range h.enums[e].values {
+			fmt.Fprintf(w, "  %d = %q\n", v.value, v.name)
+		}
+		fmt.Fprint(w, ")\n\n")
+	}
+	for _, b := range blocks {
+		fmt.Fprintf(w, "block %s (\n", quoteName(b))
+		if endian == "little" {
+			// C compilers of little endian platforms fill bitfields from their
+			// least significant bit
+			fmt.Fprintln(w, "  bitorder lsb")
+		}
+		for _, m := range h.structs[b] {
+			h.writeMember(w, m)
+		}
+		fmt.Fprint(w, ")\n\n")
+	}
+	fmt.Fprintln(w, "data (")
+	fmt.Fprintf(w, "  include %s\n", quoteName(name))
+	fmt.Fprintln(w, "  print raw as csv")
+	fmt.Fprintln(w, ")")
+	return nil
+}
+
+func (h *header) writeMember(w io.Writer, m cMember) {
+	if m.ref == "struct" {
+		count := 1
+		for _, d := range m.dims {
+			count *= d
+		}
+		if count == 1 {
+			fmt.Fprintf(w, "  include %s as %s\n", quoteName(m.typ), quoteName(m.name))
+		} else {
+			fmt.Fprintf(w, "  repeat [%d] %s as %s\n", count, quoteName(m.typ), quoteName(m.name))
+		}
+		return
+	}
+	var (
+		t    = cType{kind: "int", bits: 32}
+		dims = m.dims
+	)
+	if m.isEnum {
+		// enums are unsigned unless one of their values is negative
+		t.kind = "uint"
+		for _, v := range h.enums[m.typ].values {
+			if v.value < 0 {
+				t.kind = "int"
+			}
+		}
+	} else {
+		t = cTypes[m.typ]
+	}
+	if m.bits > 0 {
+		t.bits = m.bits
+	}
+	// arrays of chars are strings and arrays of bytes are blobs
+	if len(dims) == 1 && t.bits == 8 && m.bits == 0 && !m.isEnum {
+		switch m.typ {
+		case "char":
+			t = cType{kind: "string", bits: dims[0]}
+			dims = nil
+		case "unsigned char", "uint8_t":
+			t = cType{kind: "bytes", bits: dims[0]}
+			dims = nil
+		}
+	}
+	fmt.Fprintf(w, "  %s: %s %d", quoteName(m.name), t.kind, t.bits)
+	for i, d := range dims {
+		if i == 0 {
+			fmt.Fprint(w, " ")
+		}
+		fmt.Fprintf(w, "[%d]", d)
+	}
+	if m.isEnum {
+		fmt.Fprintf(w, ", %s", quoteName(m.typ))
+	}
+	fmt.Fprintln(w)
+}
+
+// quoteName quotes the C identifiers that are keywords of the DSL.
+func quoteName(str string) string {
+	if isName(str) != nil {
+		return "`" + str + "`"
+	}
+	return str
+}
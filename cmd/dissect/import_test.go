@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/midbel/dissect"
+)
+
+func TestImportHeader(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Header string
+		Struct string
+		Endian string
+		Root   string // struct converted
+		Want   string // enums and blocks of the schema
+	}{
+		{
+			Name:   "types",
+			Header: "struct pkt {\n  uint16_t apid;\n  unsigned short int len;\n  signed char c;\n  long long n;\n  double v;\n  const float f;\n};\n",
+			Endian: "big",
+			Root:   "pkt",
+			Want:   "block pkt (\n  apid: uint 16\n  len: uint 16\n  c: int 8\n  n: int 64\n  v: float 64\n  f: float 32\n)\n\n",
+		},
+		{
+			Name:   "arrays",
+			Header: "#define N 3\nstruct pkt {\n  char name[4];\n  uint8_t raw[2];\n  int16_t t[2][N], u[N];\n};\n",
+			Endian: "big",
+			Root:   "pkt",
+			Want:   "block pkt (\n  name: string 4\n  raw: bytes 2\n  t: int 16 [2][3]\n  u: int 16 [3]\n)\n\n",
+		},
+		{
+			Name:   "bitfields",
+			Header: "struct flags {\n  unsigned int a:3, b:5;\n  uint8_t c : 0x8;\n};\n",
+			Endian: "little",
+			Root:   "flags",
+			Want:   "block flags (\n  bitorder lsb\n  a: uint 3\n  b: uint 5\n  c: uint 8\n)\n\n",
+		},
+		{
+			Name:   "enums",
+			Header: "typedef enum { ON = 1, OFF } mode_t;\nenum level { LOW = -1, HIGH };\nstruct pkt {\n  mode_t mode;\n  enum level lvl;\n  uint8_t x[OFF];\n};\n",
+			Endian: "big",
+			Root:   "pkt",
+			Want:   "enum mode_t (\n  1 = \"ON\"\n  2 = \"OFF\"\n)\n\nenum level (\n  -1 = \"LOW\"\n  0 = \"HIGH\"\n)\n\nblock pkt (\n  mode: uint 32, mode_t\n  lvl: int 32, level\n  x: bytes 2\n)\n\n",
+		},
+		{
+			Name:   "nested",
+			Header: "struct point { int16_t x; };\ntypedef struct __attribute__((packed)) {\n  struct point one;\n  struct point many[2];\n} pkt_t;\n",
+			Endian: "big",
+			Root:   "pkt_t",
+			Want:   "block point (\n  x: int 16\n)\n\nblock pkt_t (\n  include point as one\n  repeat [2] point as many\n)\n\n",
+		},
+		{
+			Name:   "select",
+			Header: "// first\nstruct a { uint8_t x; };\n/* second\n   struct */\nstruct b { uint8_t y; };\nint decode(struct a *p);\n",
+			Struct: "a",
+			Endian: "big",
+			Root:   "a",
+			Want:   "block a (\n  x: uint 8\n)\n\n",
+		},
+		{
+			Name:   "keywords",
+			Header: "struct block { uint8_t data; uint8_t repeat; };\n",
+			Endian: "big",
+			Root:   "block",
+			Want:   "block `block` (\n  `data`: uint 8\n  `repeat`: uint 8\n)\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			got, err := importHeader([]byte(tt.Header), tt.Struct, tt.Endian, "test.h")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			root := quoteName(tt.Root)
+			want := fmt.Sprintf("# %s: imported from test.h with dissect import\ndissect %s\n\n%sdata (\n  include %s\n  print raw as csv\n)\n", tt.Root, dissect.Version, tt.Want, root)
+			if string(got) != want {
+				t.Errorf("schema mismatched:\nwant: %q\ngot:  %q", want, got)
+			}
+		})
+	}
+}
+
+func TestImportHeaderErrors(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Header string
+		Struct string
+		Err    string
+	}{
+		{Name: "empty", Header: "int decode(void);\n", Err: "no struct found"},
+		{Name: "unknown", Header: "struct a { uint8_t x; };\n", Struct: "b", Err: "struct b: not found"},
+		{Name: "union", Header: "struct a {\n  union { int x; } u;\n};\n", Err: "line 2: unions not supported"},
+		{Name: "pointer", Header: "struct a {\n  uint8_t *x;\n};\n", Err: "line 2: pointers not supported"},
+		{Name: "type", Header: "struct a {\n  size_t x;\n};\n", Err: "line 2: size_t: unsupported type"},
+		{Name: "no-type", Header: "struct a {\n  x;\n};\n", Err: "line 2: member without type"},
+		{Name: "nested", Header: "struct a {\n  struct { int x; } b;\n};\n", Err: "nested struct definitions not supported"},
+		{Name: "size", Header: "struct a {\n  uint8_t x[0];\n};\n", Err: "x: invalid array size"},
+		{Name: "constant", Header: "struct a {\n  uint8_t x[LEN];\n};\n", Err: "LEN: unsupported constant"},
+		{Name: "width", Header: "struct a {\n  uint64_t x:65;\n};\n", Err: "x: invalid bitfield width"},
+		{Name: "separator", Header: "struct a {\n  uint8_t x y;\n};\n", Err: "unsupported type"},
+		{Name: "unclosed", Header: "struct a {\n  uint8_t x;\n", Err: "struct a: missing }"},
+		{Name: "enum", Header: "enum e { A = 1, 2 };\n", Err: "enum e: unexpected \"2\""},
+		{Name: "recursive", Header: "struct a { struct a x; };\n", Err: "struct a: recursive definition"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			got, err := importHeader([]byte(tt.Header), tt.Struct, "big", "test.h")
+			if err == nil {
+				t.Fatalf("want error %q, got schema %q", tt.Err, got)
+			}
+			if !strings.Contains(err.Error(), tt.Err) {
+				t.Errorf("error %q does not contain %q", err, tt.Err)
+			}
+		})
+	}
+}
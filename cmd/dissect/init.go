@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/midbel/dissect"
+)
+
+const defaultSchema = "schema.lst"
+
+// runInit asks a few questions about the packets to decode and writes a
+// starter schema from the answers.
+func runInit(args []string) error {
+	set := flag.NewFlagSet("init", flag.ExitOnError)
+	out := set.String("o", defaultSchema, "schema file to create (- for stdout)")
+	force := set.Bool("f", false, "overwrite the schema file if it exists")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() != 0 {
+		return fmt.Errorf("usage: dissect init [-f] [-o file]")
+	}
+	if *out != "-" && !*force {
+		if _, err := os.Stat(*out); err == nil {
+			return fmt.Errorf("%s: file exists (use -f to overwrite it)", *out)
+		}
+	}
+	w := wizard{
+		in:  bufio.NewReader(os.Stdin),
+		out: os.Stderr,
+	}
+	s, err := w.ask()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	s.write(&buf)
+	// the schema is parsed to never write one that dissect rejects
+	if _, err := dissect.New(bytes.NewReader(buf.Bytes())); err != nil {
+		return fmt.Errorf("generated schema is invalid: %w", err)
+	}
+	if *out == "-" {
+		_, err = buf.WriteTo(os.Stdout)
+		return err
+	}
+	if err := ioutil.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: schema created, run it with: dissect %s <files>\n", *out, *out)
+	return nil
+}
+
+type scaffoldField struct {
+	name string
+	kind string
+	bits int
+}
+
+type scaffold struct {
+	name   string
+	endian string
+	sync   string
+	size   int
+	fields []scaffoldField
+	format string
+}
+
+func (s scaffold) headerBits() int {
+	bits := len(s.sync) * 4
+	for _, f := range s.fields {
+		bits += f.bits
+	}
+	return bits
+}
+
+func (s scaffold) write(w io.Writer) {
+	fmt.Fprintf(w, "# %s: starter schema created with dissect init\n", s.name)
+	fmt.Fprintf(w, "dissect %s\n\n", dissect.Version)
+
+	fmt.Fprintln(w, "declare (")
+	if s.sync != "" {
+		fmt.Fprintf(w, "  sync: uint %d = [0x%s]\n", len(s.sync)*4, s.sync)
+	}
+	for _, f := range s.fields {
+		size := f.bits
+		if f.kind == "bytes" || f.kind == "string" {
+			size /= 8
+		}
+		fmt.Fprintf(w, "  %s: %s %d", f.name, f.kind, size)
+		if s.endian == "little" && f.bits > 8 && f.kind != "bytes" && f.kind != "string" {
+			fmt.Fprintf(w, " %s", s.endian)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprint(w, ")\n\n")
+
+	fmt.Fprintf(w, "block %s (\n", s.name)
+	if s.sync != "" {
+		fmt.Fprintln(w, "  sync")
+	}
+	for _, f := range s.fields {
+		fmt.Fprintf(w, "  %s\n", f.name)
+	}
+	if rest := s.size*8 - s.headerBits(); s.size > 0 && rest >= 8 {
+		fmt.Fprintln(w, "  # describe the body of the packet here")
+		fmt.Fprintf(w, "  payload: bytes %d\n", rest/8)
+	}
+	fmt.Fprint(w, ")\n\n")
+
+	fmt.Fprintln(w, "data (")
+	if s.size > 0 {
+		fmt.Fprintf(w, "  length [%d]\n", s.size)
+	}
+	fmt.Fprintf(w, "  include %s\n", s.name)
+	fmt.Fprintf(w, "  print raw as %s\n", s.format)
+	fmt.Fprintln(w, ")")
+}
+
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (w wizard) ask() (scaffold, error) {
+	var (
+		s   scaffold
+		err error
+	)
+	s.name, err = w.question("name of the packet", "packet", isName)
+	if err != nil {
+		return s, err
+	}
+	s.endian, err = w.question("endianness (big, little)", "big", oneOf("big", "little"))
+	if err != nil {
+		return s, err
+	}
+	size, err := w.question("packet size in bytes (0 if variable)", "0", isSize)
+	if err != nil {
+		return s, err
+	}
+	s.size, _ = strconv.Atoi(size)
+	s.sync, err = w.question("sync marker in hex, eg 1ACFFC1D (empty for none)", "", isMarker)
+	if err != nil {
+		return s, err
+	}
+	s.sync = strings.ToUpper(strings.TrimPrefix(strings.TrimPrefix(s.sync, "0x"), "0X"))
+
+	fmt.Fprintln(w.out, "header fields (empty name to stop)")
+	for {
+		var f scaffoldField
+		f.name, err = w.question("  field name", "", func(str string) error {
+			if str == "" {
+				return nil
+			}
+			if err := isName(str); err != nil {
+				return err
+			}
+			for _, f := range s.fields {
+				if f.name == str {
+					return fmt.Errorf("%s: field already defined", str)
+				}
+			}
+			return nil
+		})
+		if err != nil || f.name == "" {
+			break
+		}
+		f.kind, err = w.question("  type (int, uint, float, bytes, string)", "uint", oneOf("int", "uint", "float", "bytes", "string"))
+		if err != nil {
+			break
+		}
+		check := isBits
+		switch f.kind {
+		case "float":
+			check = oneOf("32", "64")
+		case "bytes", "string":
+			check = func(str string) error {
+				if n, err := strconv.Atoi(str); err != nil || n <= 0 || n%8 != 0 {
+					return fmt.Errorf("%s: size should be a multiple of 8", str)
+				}
+				return nil
+			}
+		}
+		var bits string
+		if bits, err = w.question("  size in bits", "8", check); err != nil {
+			break
+		}
+		f.bits, _ = strconv.Atoi(bits)
+		s.fields = append(s.fields, f)
+	}
+	if err != nil {
+		return s, err
+	}
+	if s.size > 0 && s.headerBits() > s.size*8 {
+		return s, fmt.Errorf("header (%d bits) larger than the packet (%d bytes)", s.headerBits(), s.size)
+	}
+	s.format, err = w.question("output format (csv, json, sexp, tuple)", "csv", oneOf("csv", "json", "sexp", "tuple"))
+	return s, err
+}
+
+// question asks q until the answer is accepted by check. An empty answer
+// selects the default value.
+func (w wizard) question(q, def string, check func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(w.out, "%s [%s]: ", q, def)
+		} else {
+			fmt.Fprintf(w.out, "%s: ", q)
+		}
+		line, err := w.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF {
+				fmt.Fprintln(w.out)
+				err = fmt.Errorf("init: no answer given")
+			}
+			return "", err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = def
+		}
+		if err := check(line); err != nil {
+			fmt.Fprintln(w.out, err)
+			continue
+		}
+		return line, nil
+	}
+}
+
+func isName(str string) error {
+	for i, r := range str {
+		letter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_'
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return fmt.Errorf("%s: invalid name (letters, digits and _ only)", str)
+		}
+	}
+	if str == "" {
+		return fmt.Errorf("name is required")
+	}
+	// keywords can not be used as names: the parser rejects them
+	if _, err := dissect.Parse(strings.NewReader(fmt.Sprintf("block %s ()", str))); err != nil {
+		return fmt.Errorf("%s: reserved word", str)
+	}
+	return nil
+}
+
+func isSize(str string) error {
+	if n, err := strconv.Atoi(str); err != nil || n < 0 {
+		return fmt.Errorf("%s: positive number expected", str)
+	}
+	return nil
+}
+
+func isBits(str string) error {
+	if n, err := strconv.Atoi(str); err != nil || n <= 0 || n > 64 {
+		return fmt.Errorf("%s: size should be between 1 and 64 bits", str)
+	}
+	return nil
+}
+
+func isMarker(str string) error {
+	str = strings.TrimPrefix(strings.TrimPrefix(str, "0x"), "0X")
+	if str == "" {
+		return nil
+	}
+	if _, err := hex.DecodeString(str); err != nil || len(str) > 16 {
+		return fmt.Errorf("%s: marker should be an even number of hex digits (at most 8 bytes)", str)
+	}
+	return nil
+}
+
+func oneOf(vs ...string) func(string) error {
+	return func(str string) error {
+		for _, v := range vs {
+			if v == str {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: expected one of %s", str, strings.Join(vs, ", "))
+	}
+}
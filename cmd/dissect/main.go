@@ -5,18 +5,47 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 
 	"github.com/midbel/dissect"
 	"github.com/pkg/profile"
 )
 
+var (
+	ctl        *admin
+	humanUnits bool
+)
+
 func main() {
 	var (
-		listen = flag.Bool("l", false, "listen")
-		mem    = flag.Bool("mem", false, "mem profile")
-		cpu    = flag.Bool("cpu", false, "cpu profile")
+		listen   = flag.Bool("l", false, "listen")
+		mem      = flag.Bool("mem", false, "mem profile")
+		cpu      = flag.Bool("cpu", false, "cpu profile")
+		dry      = flag.Bool("n", false, "dry run")
+		only     = flag.String("only", "", "only decode the given blocks")
+		skip     = flag.String("skip", "", "do not decode the given blocks")
+		files    = flag.Int("maxfiles", dissect.DefaultMaxFiles, "maximum number of output files open")
+		batch    = flag.Int("postbatch", dissect.DefaultPostBatch, "number of records sent in each request to HTTP outputs")
+		retry    = flag.Int("postretry", dissect.DefaultPostRetry, "number of retries of the failed requests to HTTP outputs")
+		wait     = flag.Duration("postwait", dissect.DefaultPostWait, "delay before the first retry of a request to HTTP outputs")
+		keep     = flag.Bool("c", false, "continue with next file on error")
+		skipbad  = flag.Bool("k", false, "skip the packets that can not be decoded")
+		order    = flag.String("sort", "", "order of input files (walk, name, mtime, numeric)")
+		trail    = flag.String("trailing", "", "partial packet at end of input (error, warn, ignore, emit)")
+		bitorder = flag.String("bitorder", "", "default bit order of the fields (msb, lsb)")
+		expect   = flag.String("expect", "", "default policy of the expectations of the fields (fail, warn, skip)")
+		addr     = flag.String("admin", "", "address of the admin HTTP listener")
+		timing   = flag.Bool("t", false, "report the time spent in blocks and printers")
+		human    = flag.Bool("human", false, "write sizes of the report in human units")
+		suffix   = flag.String("suffix", "", "suffixes of the raw and eng columns printed with both (raw,eng)")
+		offline  = flag.Bool("offline", false, "read schemas included from URLs only from the cache")
+		manifest = flag.String("manifest", "", "write the manifest of the output files")
+		outdir   = flag.String("outdir", "", "directory of the output files, files outside of it are rejected")
+		allownet = flag.String("allownet", "", "hosts the schemas can access, others are rejected")
+		sandbox  = flag.Bool("sandbox", false, "write only in outdir (default current directory) and access only allownet hosts")
 	)
 	flag.Parse()
+	humanUnits = *human
 	if *mem {
 		defer profile.Start(profile.MemProfile).Stop()
 	}
@@ -24,25 +53,104 @@ func main() {
 		defer profile.Start(profile.CPUProfile).Stop()
 	}
 
-	var err error
-	if *listen {
-		err = dissectFromConn()
-	} else {
-		err = dissectFromFiles()
+	sorting, err := dissect.ParseOrder(*order)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	trailing, err := dissect.ParseTrailing(*trail)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(3)
+		os.Exit(2)
 	}
-}
-
-func dissectFromConn() error {
-	r, err := os.Open(flag.Arg(1))
+	bits, err := dissect.ParseBitOrder(*bitorder)
 	if err != nil {
-		return err
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	policy, err := dissect.ParseExpectPolicy(*expect)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *addr != "" {
+		if ctl, err = startAdmin(*addr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	opts := []dissect.Option{
+		dissect.WithDryRun(*dry),
+		dissect.WithMaxFiles(*files),
+		dissect.WithPostBatch(*batch),
+		dissect.WithPostRetry(*retry, *wait),
+		dissect.WithOrder(sorting),
+		dissect.WithTrailing(trailing),
+		dissect.WithBitOrder(bits),
+		dissect.WithExpectPolicy(policy),
+		dissect.WithWarnings(func(w dissect.Warning) {
+			fmt.Fprintln(os.Stderr, "warning:", w)
+		}),
+		dissect.WithContinue(*keep),
+		dissect.WithSkipErrors(*skipbad),
+		dissect.WithTiming(*timing),
+		dissect.WithOffline(*offline),
+		dissect.WithManifest(*manifest),
+		dissect.WithOutputRoot(*outdir),
+	}
+	if *sandbox && *outdir == "" {
+		opts = append(opts, dissect.WithOutputRoot("."))
+	}
+	if *sandbox || *allownet != "" {
+		var hosts []string
+		if *allownet != "" {
+			hosts = strings.Split(*allownet, ",")
+		}
+		opts = append(opts, dissect.WithAllowNetwork(hosts...))
+	}
+	if *suffix != "" {
+		raw, eng, _ := strings.Cut(*suffix, ",")
+		opts = append(opts, dissect.WithColumnSuffixes(raw, eng))
+	}
+	if *only != "" {
+		opts = append(opts, dissect.WithOnly(strings.Split(*only, ",")...))
 	}
-	defer r.Close()
+	if *skip != "" {
+		opts = append(opts, dissect.WithSkip(strings.Split(*skip, ",")...))
+	}
+	switch {
+	case flag.Arg(0) == "watch":
+		err = runWatch(flag.Args()[1:], opts)
+	case flag.Arg(0) == "serve":
+		err = runServe(flag.Args()[1:], opts)
+	case flag.Arg(0) == "backfill":
+		err = runBackfill(flag.Args()[1:], opts)
+	case flag.Arg(0) == "compile":
+		err = runCompile(flag.Args()[1:])
+	case flag.Arg(0) == "graph":
+		err = runGraph(flag.Args()[1:])
+	case flag.Arg(0) == "check":
+		err = runCheck(flag.Args()[1:], opts)
+	case flag.Arg(0) == "init":
+		err = runInit(flag.Args()[1:])
+	case flag.Arg(0) == "import":
+		err = runImport(flag.Args()[1:])
+	case flag.Arg(0) == "syntax":
+		err = runSyntax(flag.Args()[1:])
+	case flag.Arg(0) == "grammar":
+		_, err = fmt.Print(dissect.Grammar())
+	case *listen:
+		err = dissectFromConn(opts, *dry || *timing)
+	default:
+		err = dissectFromFiles(opts, *dry || *timing)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(3)
+	}
+}
 
+func dissectFromConn(opts []dissect.Option, report bool) error {
 	a, err := net.ResolveUDPAddr("udp", flag.Arg(0))
 	if err != nil {
 		return err
@@ -58,19 +166,38 @@ func dissectFromConn() error {
 	}
 	defer c.Close()
 
-	return dissect.Dissect(r, c)
-}
-
-func dissectFromFiles() error {
-	r, err := os.Open(flag.Arg(0))
+	i, err := loadSchema(flag.Arg(1), opts)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
+	defer handleSignals(i, flag.Arg(1))()
+	ctl.Attach(i)
 
+	if err = i.Run(c); err == nil && report {
+		err = writeReport(i)
+	}
+	return err
+}
+
+func dissectFromFiles(opts []dissect.Option, report bool) error {
 	var files []string
 	for i := 1; i < flag.NArg(); i++ {
 		files = append(files, flag.Arg(i))
 	}
-	return dissect.DissectFiles(r, files)
+	i, err := loadSchema(flag.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	ctl.Attach(i)
+	if err = i.RunFiles(files); err == nil && report {
+		err = writeReport(i)
+	}
+	return err
+}
+
+func writeReport(i *dissect.Interpreter) error {
+	if humanUnits {
+		return i.Stats().HumanReport(os.Stderr)
+	}
+	return i.Stats().Report(os.Stderr)
 }
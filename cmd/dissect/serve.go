@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/midbel/dissect"
+)
+
+// grpcProto is the definition of the service of dissect serve without the
+// Record message, generated from the schema. Clients send packets and receive
+// the records printed while decoding each of them.
+const grpcProto = `syntax = "proto3";
+
+package dissect;
+
+service Dissect {
+  // Decode decodes each packet with the schema of the server. The records
+  // printed while decoding a packet are sent before the ones of the next
+  // packet.
+  rpc Decode(stream Packet) returns (stream Record);
+}
+
+message Packet {
+  bytes data = 1;
+}
+
+// Field is a field not found in the schema, eg the values of asn1, protobuf
+// and text statements.
+message Field {
+  string name = 1;
+  string block = 2;
+  Value raw = 3;
+  Value eng = 4;            // not set when the raw value is not converted
+  bool valid = 5;           // false when the value is not the expected one
+}
+
+message Value {
+  oneof kind {
+    sint64 int_value = 1;
+    uint64 uint_value = 2;
+    double float_value = 3;
+    string string_value = 4;  // also integers of more than 64 bits
+    bytes bytes_value = 5;
+    bool bool_value = 6;
+    int64 time_value = 7;     // nanoseconds since the unix epoch
+    Array array_value = 8;
+  }
+}
+
+message Array {
+  repeated Value values = 1;
+}
+`
+
+const (
+	grpcMethod  = "/dissect.Dissect/Decode"
+	grpcMaxSize = 4 << 20
+)
+
+// status codes of gRPC
+const (
+	codeOK                = 0
+	codeInvalidArgument   = 3
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+)
+
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+// fields of the Record message always present. The fields of the schema are
+// numbered from recordFirst.
+const (
+	recordPacket = iota + 1
+	recordError
+	recordOthers
+	recordInvalid
+	recordFirst
+)
+
+func runServe(args []string, opts []dissect.Option) error {
+	set := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := set.String("grpc", "", "address of the gRPC listener")
+	proto := set.Bool("proto", false, "print the protobuf definition of the service")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() != 1 || (*addr == "" && !*proto) {
+		return fmt.Errorf("usage: dissect serve [-proto] -grpc <address> <schema>")
+	}
+	i, err := loadSchema(set.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	d := newDecodeServer(i.Program(), opts)
+	if *proto {
+		_, err := fmt.Print(grpcProto, "\n", d.schema.proto())
+		return err
+	}
+	ctl.Attach(d)
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	s := http.Server{
+		Addr:      *addr,
+		Handler:   d,
+		Protocols: &protocols,
+	}
+	return s.ListenAndServe()
+}
+
+// decodeServer implements the Decode method of the service over HTTP/2 without
+// TLS. Each stream is decoded by its own interpreter. The statistics of the
+// server are the sum of the ones of all the streams.
+type decodeServer struct {
+	prog   *dissect.Program
+	opts   []dissect.Option
+	schema *recordSchema
+
+	mu     sync.Mutex
+	done   dissect.Stats
+	active map[*dissect.Interpreter]struct{}
+}
+
+func newDecodeServer(prog *dissect.Program, opts []dissect.Option) *decodeServer {
+	return &decodeServer{
+		prog:   prog,
+		opts:   opts,
+		schema: newRecordSchema(prog.Fields()),
+		active: make(map[*dissect.Interpreter]struct{}),
+	}
+}
+
+func (d *decodeServer) Stats() dissect.Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var s dissect.Stats
+	s.Add(d.done)
+	for i := range d.active {
+		s.Add(i.Stats())
+	}
+	return s
+}
+
+func (d *decodeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("content-type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("content-type", "application/grpc")
+	if r.URL.Path != grpcMethod {
+		writeStatus(w, codeUnimplemented, fmt.Errorf("%s: unknown method", r.URL.Path))
+		return
+	}
+	if enc := r.Header.Get("grpc-encoding"); enc != "" && enc != "identity" {
+		writeStatus(w, codeUnimplemented, fmt.Errorf("%s: compression not supported", enc))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	code, err := d.decode(r.Body, w, http.NewResponseController(w).Flush)
+	writeStatus(w, code, err)
+}
+
+func (d *decodeServer) decode(r io.Reader, w io.Writer, flush func() error) (int, error) {
+	var (
+		out    []byte
+		packet int
+	)
+	opts := append([]dissect.Option{}, d.opts...)
+	opts = append(opts, dissect.WithRecordHook(func(fs []dissect.Field) ([]dissect.Field, error) {
+		out = appendFrame(out, d.schema.appendRecord(nil, packet, fs, nil))
+		return nil, nil
+	}))
+	i, err := dissect.NewFromProgram(d.prog, opts...)
+	if err != nil {
+		return codeInternal, err
+	}
+	d.mu.Lock()
+	d.active[i] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.active, i)
+		d.done.Add(i.Stats())
+	}()
+
+	for packet = 1; ; packet++ {
+		msg, code, err := readFrame(r)
+		if errors.Is(err, io.EOF) {
+			return codeOK, nil
+		}
+		if err != nil {
+			return code, err
+		}
+		data, err := packetData(msg)
+		if err != nil {
+			return codeInvalidArgument, err
+		}
+		out = out[:0]
+		if err := i.Run(bytes.NewReader(data)); err != nil {
+			out = appendFrame(out, d.schema.appendRecord(nil, packet, nil, err))
+		}
+		if len(out) == 0 {
+			continue
+		}
+		if _, err := w.Write(out); err != nil {
+			return codeInternal, err
+		}
+		if err := flush(); err != nil {
+			return codeInternal, err
+		}
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, err error) {
+	w.Header().Set(http.TrailerPrefix+"grpc-status", strconv.Itoa(code))
+	if err != nil {
+		w.Header().Set(http.TrailerPrefix+"grpc-message", percentEncode(err.Error()))
+	}
+}
+
+// percentEncode escapes the message of a status as gRPC expects it: all the
+// bytes outside of the printable ASCII characters and the percent sign.
+func percentEncode(str string) string {
+	var buf strings.Builder
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&buf, "%%%02X", c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
+// readFrame reads a message prefixed by its compression flag and its length.
+// io.EOF is only returned at the end of the stream before a new message.
+func readFrame(r io.Reader) ([]byte, int, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, codeInvalidArgument, fmt.Errorf("message prefix truncated")
+		}
+		return nil, codeInternal, err
+	}
+	if prefix[0] != 0 {
+		return nil, codeUnimplemented, fmt.Errorf("compressed messages not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxSize {
+		return nil, codeResourceExhausted, fmt.Errorf("message too large (%d > %d bytes)", size, grpcMaxSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, codeInvalidArgument, fmt.Errorf("message truncated: %w", err)
+	}
+	return msg, codeOK, nil
+}
+
+func appendFrame(buf, msg []byte) []byte {
+	buf = append(buf, 0)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(msg)))
+	return append(buf, msg...)
+}
+
+// packetData gives the data of a Packet message. The unknown fields are
+// skipped, the last data field wins.
+func packetData(msg []byte) ([]byte, error) {
+	var data []byte
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, fmt.Errorf("packet: invalid tag")
+		}
+		msg = msg[n:]
+		var size uint64
+		switch tag & 0x7 {
+		case wireVarint:
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return nil, fmt.Errorf("packet: invalid varint")
+			}
+			size = uint64(n)
+		case wire64:
+			size = 8
+		case wire32:
+			size = 4
+		case wireBytes:
+			if size, n = binary.Uvarint(msg); n <= 0 {
+				return nil, fmt.Errorf("packet: invalid length")
+			}
+			msg = msg[n:]
+		default:
+			return nil, fmt.Errorf("packet: wire type %d not supported", tag&0x7)
+		}
+		if size > uint64(len(msg)) {
+			return nil, fmt.Errorf("packet: field truncated")
+		}
+		if tag == 1<<3|wireBytes {
+			data = msg[:size]
+		}
+		msg = msg[size:]
+	}
+	return data, nil
+}
+
+// recordSchema gives a field of the Record message to each field of the schema.
+// The converted fields have a second field holding their eng value.
+type recordSchema struct {
+	fields []recordField
+	index  map[[2]string]int
+}
+
+type recordField struct {
+	dissect.FieldInfo
+	name   string
+	number int
+	typ    string // protobuf type of the raw value
+	eng    int    // number of the field of the eng value, 0 if not converted
+	engId  string
+}
+
+func newRecordSchema(infos []dissect.FieldInfo) *recordSchema {
+	var (
+		s = recordSchema{
+			index: make(map[[2]string]int),
+		}
+		used = map[string]bool{
+			"packet":  true,
+			"error":   true,
+			"others":  true,
+			"invalid": true,
+		}
+		number = recordFirst
+	)
+	unique := func(name string) string {
+		str := name
+		for i := 2; used[str]; i++ {
+			str = fmt.Sprintf("%s_%d", name, i)
+		}
+		used[str] = true
+		return str
+	}
+	for _, fi := range infos {
+		name := protoName(fi.Id)
+		if used[name] && fi.Block != "" {
+			name = protoName(fi.Block + "_" + fi.Id)
+		}
+		f := recordField{
+			FieldInfo: fi,
+			name:      unique(name),
+			number:    number,
+			typ:       protoType(fi),
+		}
+		number++
+		if fi.Converted {
+			f.engId = unique(f.name + "_eng")
+			f.eng = number
+			number++
+		}
+		s.index[[2]string{fi.Block, fi.Id}] = len(s.fields)
+		s.fields = append(s.fields, f)
+	}
+	return &s
+}
+
+// proto gives the definition of the Record message.
+func (s *recordSchema) proto() string {
+	var str strings.Builder
+	str.WriteString("message Record {\n")
+	fmt.Fprintf(&str, "  uint64 packet = %d;  // number of the packet in the stream, from 1\n", recordPacket)
+	fmt.Fprintf(&str, "  string error = %d;  // packet not decoded, no field\n", recordError)
+	fmt.Fprintf(&str, "  repeated Field others = %d;  // fields not found in the schema\n", recordOthers)
+	fmt.Fprintf(&str, "  repeated string invalid = %d;  // fields whose value is not the expected one\n", recordInvalid)
+	for _, f := range s.fields {
+		var label string
+		if f.Repeated {
+			label = "repeated "
+		}
+		id := f.Id
+		if f.Block != "" {
+			id = f.Block + "." + id
+		}
+		fmt.Fprintf(&str, "  %s%s %s = %d;  // %s\n", label, f.typ, f.name, f.number, id)
+		if f.eng > 0 {
+			fmt.Fprintf(&str, "  %sValue %s = %d;\n", label, f.engId, f.eng)
+		}
+	}
+	str.WriteString("}\n")
+	return str.String()
+}
+
+// appendRecord encodes the fields of a record. The fields not found in the
+// schema or whose value has not the type of their field in the Record message
+// are given as Field messages.
+func (s *recordSchema) appendRecord(buf []byte, packet int, fs []dissect.Field, err error) []byte {
+	buf = appendVarint(buf, recordPacket, uint64(packet))
+	if err != nil {
+		buf = appendBytes(buf, recordError, []byte(err.Error()))
+	}
+	set := make([]bool, len(s.fields))
+	for _, f := range fs {
+		if f.Skip() {
+			continue
+		}
+		if !f.Valid() {
+			buf = appendBytes(buf, recordInvalid, []byte(f.String()))
+		}
+		if i, ok := s.index[[2]string{f.Block, f.Id}]; ok && (!set[i] || s.fields[i].Repeated) {
+			if b, ok := s.fields[i].appendField(buf, f); ok {
+				buf, set[i] = b, true
+				continue
+			}
+		}
+		buf = appendBytes(buf, recordOthers, appendField(nil, f))
+	}
+	return buf
+}
+
+// appendField encodes the value of f, and its eng value if any. It returns false
+// when a value has not the type of the field.
+func (r recordField) appendField(buf []byte, f dissect.Field) ([]byte, bool) {
+	var (
+		vs  = []dissect.Value{f.Raw()}
+		ok  bool
+		res = buf
+	)
+	if arr, isArray := f.Raw().(*dissect.Array); isArray && r.Repeated {
+		vs = arr.Raw
+	}
+	for _, v := range vs {
+		if res, ok = appendTyped(res, r.number, r.typ, v); !ok {
+			return buf, false
+		}
+	}
+	if eng := f.Eng(); r.eng > 0 && eng != f.Raw() {
+		res = appendBytes(res, r.eng, appendValue(nil, eng))
+	}
+	return res, true
+}
+
+// appendTyped encodes v as a field of type typ. A null value is not encoded.
+func appendTyped(buf []byte, num int, typ string, v dissect.Value) ([]byte, bool) {
+	if _, ok := v.(*dissect.Null); ok || v == nil {
+		return buf, true
+	}
+	switch v := v.(type) {
+	case *dissect.Int:
+		if typ == "sint64" {
+			return appendVarint(buf, num, zigzag(v.Raw)), true
+		}
+	case *dissect.Uint:
+		if typ == "uint64" {
+			return appendVarint(buf, num, v.Raw), true
+		}
+	case *dissect.Real:
+		if typ == "double" {
+			return appendDouble(buf, num, v.Raw), true
+		}
+	case *dissect.String:
+		if typ == "string" {
+			return appendBytes(buf, num, []byte(v.Raw)), true
+		}
+	case *dissect.BigInt:
+		if typ == "string" {
+			return appendBytes(buf, num, []byte(v.Raw.String())), true
+		}
+	case *dissect.Bytes:
+		if typ == "bytes" {
+			return appendBytes(buf, num, v.Raw), true
+		}
+	case *dissect.Time:
+		if typ == "int64" {
+			return appendVarint(buf, num, uint64(v.Raw.UnixNano())), true
+		}
+	}
+	if typ == "Value" {
+		return appendBytes(buf, num, appendValue(nil, v)), true
+	}
+	return buf, false
+}
+
+// protoType gives the protobuf type of the raw values of a field. The values
+// of the fields of let have no known type: they are given as Value messages.
+func protoType(fi dissect.FieldInfo) string {
+	switch fi.Type {
+	case "int", "uint":
+		if fi.Bits > 64 {
+			return "string"
+		}
+		if fi.Type == "int" {
+			return "sint64"
+		}
+		return "uint64"
+	case "float":
+		return "double"
+	case "string", "bytes":
+		return fi.Type
+	case "time":
+		return "int64"
+	default:
+		return "Value"
+	}
+}
+
+// protoName makes an identifier valid in a .proto file from a field id.
+func protoName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || (!unicode.IsLetter(r) && !unicode.IsDigit(r)) {
+			return '_'
+		}
+		return r
+	}, id)
+	if name == "" || !unicode.IsLetter(rune(name[0])) {
+		name = "f_" + name
+	}
+	return name
+}
+
+// appendField encodes f as a Field message.
+func appendField(buf []byte, f dissect.Field) []byte {
+	buf = appendBytes(buf, 1, []byte(f.Id))
+	if f.Block != "" {
+		buf = appendBytes(buf, 2, []byte(f.Block))
+	}
+	buf = appendBytes(buf, 3, appendValue(nil, f.Raw()))
+	if eng := f.Eng(); eng != f.Raw() {
+		buf = appendBytes(buf, 4, appendValue(nil, eng))
+	}
+	if f.Valid() {
+		buf = appendVarint(buf, 5, 1)
+	}
+	return buf
+}
+
+// appendValue encodes v as a Value message. A null value gives an empty
+// message.
+func appendValue(buf []byte, v dissect.Value) []byte {
+	switch v := v.(type) {
+	case *dissect.Int:
+		buf = appendVarint(buf, 1, zigzag(v.Raw))
+	case *dissect.Uint:
+		buf = appendVarint(buf, 2, v.Raw)
+	case *dissect.Real:
+		buf = appendDouble(buf, 3, v.Raw)
+	case *dissect.BigInt:
+		buf = appendBytes(buf, 4, []byte(v.Raw.String()))
+	case *dissect.String:
+		buf = appendBytes(buf, 4, []byte(v.Raw))
+	case *dissect.Bytes:
+		buf = appendBytes(buf, 5, v.Raw)
+	case *dissect.Boolean:
+		var b uint64
+		if v.Raw {
+			b = 1
+		}
+		buf = appendVarint(buf, 6, b)
+	case *dissect.Time:
+		buf = appendVarint(buf, 7, uint64(v.Raw.UnixNano()))
+	case *dissect.Array:
+		var arr []byte
+		for _, v := range v.Raw {
+			arr = appendBytes(arr, 1, appendValue(nil, v))
+		}
+		buf = appendBytes(buf, 8, arr)
+	}
+	return buf
+}
+
+func zigzag(x int64) uint64 {
+	return uint64(x<<1 ^ x>>63)
+}
+
+func appendTag(buf []byte, num, wire int) []byte {
+	return binary.AppendUvarint(buf, uint64(num<<3|wire))
+}
+
+func appendVarint(buf []byte, num int, x uint64) []byte {
+	buf = appendTag(buf, num, wireVarint)
+	return binary.AppendUvarint(buf, x)
+}
+
+func appendDouble(buf []byte, num int, x float64) []byte {
+	buf = appendTag(buf, num, wire64)
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(x))
+}
+
+func appendBytes(buf []byte, num int, b []byte) []byte {
+	buf = appendTag(buf, num, wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
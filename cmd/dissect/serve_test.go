@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/midbel/dissect"
+)
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Input []byte
+		Want  []byte
+		Code  int
+		Err   error
+	}{
+		{Name: "message", Input: appendFrame(nil, []byte("abc")), Want: []byte("abc")},
+		{Name: "empty", Input: appendFrame(nil, nil), Want: []byte{}},
+		{Name: "end", Input: nil, Code: codeInternal, Err: io.EOF},
+		{Name: "prefix", Input: []byte{0, 0, 0}, Code: codeInvalidArgument},
+		{Name: "truncated", Input: []byte{0, 0, 0, 0, 4, 'a'}, Code: codeInvalidArgument},
+		{Name: "compressed", Input: []byte{1, 0, 0, 0, 1, 'a'}, Code: codeUnimplemented},
+		{Name: "large", Input: []byte{0, 0xff, 0, 0, 0}, Code: codeResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg, code, err := readFrame(bytes.NewReader(tt.Input))
+			if code != tt.Code {
+				t.Fatalf("want code %d, got %d (%v)", tt.Code, code, err)
+			}
+			if tt.Code != codeOK {
+				if err == nil || (tt.Err != nil && !errors.Is(err, tt.Err)) {
+					t.Fatalf("want error %v, got %v", tt.Err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(msg, tt.Want) {
+				t.Errorf("want message %q, got %q", tt.Want, msg)
+			}
+		})
+	}
+}
+
+func TestPacketData(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Input []byte
+		Want  []byte
+		Err   bool
+	}{
+		{Name: "empty", Input: nil},
+		{Name: "data", Input: appendBytes(nil, 1, []byte{1, 2}), Want: []byte{1, 2}},
+		{Name: "last", Input: appendBytes(appendBytes(nil, 1, []byte{1}), 1, []byte{2}), Want: []byte{2}},
+		{
+			Name:  "unknown",
+			Input: appendBytes(appendDouble(appendVarint(nil, 2, 300), 3, 1.5), 1, []byte{3}),
+			Want:  []byte{3},
+		},
+		{Name: "fixed32", Input: []byte{4<<3 | wire32, 1, 2, 3, 4, 0x0a, 1, 5}, Want: []byte{5}},
+		{Name: "tag", Input: []byte{0x80}, Err: true},
+		{Name: "varint", Input: []byte{2 << 3, 0x80}, Err: true},
+		{Name: "truncated", Input: []byte{0x0a, 3, 1}, Err: true},
+		{Name: "group", Input: []byte{1<<3 | 3}, Err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			got, err := packetData(tt.Input)
+			if tt.Err {
+				if err == nil {
+					t.Fatalf("want error, got data %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(got, tt.Want) {
+				t.Errorf("want data %v, got %v", tt.Want, got)
+			}
+		})
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	double := binary.LittleEndian.AppendUint64(nil, math.Float64bits(1.5))
+	tests := []struct {
+		Value dissect.Value
+		Want  []byte
+	}{
+		{Value: &dissect.Null{}, Want: nil},
+		{Value: &dissect.Int{Raw: -2}, Want: []byte{1 << 3, 3}},
+		{Value: &dissect.Int{Raw: 64}, Want: []byte{1 << 3, 0x80, 1}},
+		{Value: &dissect.Uint{Raw: 300}, Want: []byte{2 << 3, 0xac, 2}},
+		{Value: &dissect.Real{Raw: 1.5}, Want: append([]byte{3<<3 | wire64}, double...)},
+		{Value: &dissect.String{Raw: "ok"}, Want: []byte{4<<3 | wireBytes, 2, 'o', 'k'}},
+		{Value: &dissect.BigInt{Raw: big.NewInt(12)}, Want: []byte{4<<3 | wireBytes, 2, '1', '2'}},
+		{Value: &dissect.Bytes{Raw: []byte{0xff}}, Want: []byte{5<<3 | wireBytes, 1, 0xff}},
+		{Value: &dissect.Boolean{Raw: true}, Want: []byte{6 << 3, 1}},
+		{Value: &dissect.Time{Raw: time.Unix(0, 5)}, Want: []byte{7 << 3, 5}},
+		{
+			Value: &dissect.Array{Raw: []dissect.Value{&dissect.Uint{Raw: 1}, &dissect.Null{}}},
+			Want:  []byte{8<<3 | wireBytes, 6, 1<<3 | wireBytes, 2, 2 << 3, 1, 1<<3 | wireBytes, 0},
+		},
+	}
+	for _, tt := range tests {
+		if got := appendValue(nil, tt.Value); !bytes.Equal(got, tt.Want) {
+			t.Errorf("%#v: want %v, got %v", tt.Value, tt.Want, got)
+		}
+	}
+}
+
+func TestRecordSchema(t *testing.T) {
+	const script = `enum kinds (
+  1 = "one"
+)
+block header (
+  apid: uint 8
+  kind: uint 8, kinds
+)
+data (
+  include header
+  repeat [2] (
+    temp: int 8
+  )
+  apid: uint 8
+  let ratio = temp / 2
+  print raw
+)
+`
+	prog, err := dissect.Compile(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDecodeServer(prog, nil)
+
+	want := []string{
+		"uint64 apid = 5;  // header.apid",
+		"uint64 kind = 6;  // header.kind",
+		"Value kind_eng = 7;",
+		"repeated sint64 temp = 8;",
+		"uint64 data_apid = 9;  // data.apid",
+		"Value ratio = 10;  // ratio",
+	}
+	proto := d.schema.proto()
+	for _, w := range want {
+		if !strings.Contains(proto, w) {
+			t.Errorf("%q not found in Record:\n%s", w, proto)
+		}
+	}
+
+	var input []byte
+	for _, p := range [][]byte{{7, 1, 0xfe, 4, 9}, {7, 1}} {
+		input = appendFrame(input, appendBytes(nil, 1, p))
+	}
+	var out bytes.Buffer
+	code, err := d.decode(bytes.NewReader(input), &out, func() error { return nil })
+	if code != codeOK || err != nil {
+		t.Fatalf("unexpected status %d (%v)", code, err)
+	}
+
+	var records []map[int][]string
+	for {
+		msg, _, err := readFrame(&out)
+		if err != nil {
+			break
+		}
+		records = append(records, readMessage(t, msg))
+	}
+	if len(records) != 2 {
+		t.Fatalf("want 2 records, got %d", len(records))
+	}
+	first := map[int][]string{
+		recordPacket: {"1"},
+		5:            {"7"},
+		6:            {"1"},
+		7:            {string(appendBytes(nil, 4, []byte("one")))},
+		8:            {"3", "8"},
+		9:            {"9"},
+		10:           {string(appendVarint(nil, 1, 4))},
+	}
+	if !reflect.DeepEqual(records[0], first) {
+		t.Errorf("first record mismatched:\nwant: %q\ngot:  %q", first, records[0])
+	}
+	if errs := records[1][recordError]; len(errs) != 1 || !strings.Contains(errs[0], "short buffer") {
+		t.Errorf("second record: want short buffer error, got %q", records[1])
+	}
+	if s := d.Stats(); s.Packets != 1 {
+		t.Errorf("want 1 packet in statistics, got %d", s.Packets)
+	}
+}
+
+// readMessage gives the varints as decimal numbers and the length delimited
+// fields as is, keyed by field number.
+func readMessage(t *testing.T, msg []byte) map[int][]string {
+	t.Helper()
+	fields := make(map[int][]string)
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		msg = msg[n:]
+		num := int(tag >> 3)
+		switch tag & 0x7 {
+		case wireVarint:
+			x, n := binary.Uvarint(msg)
+			msg = msg[n:]
+			fields[num] = append(fields[num], strconv.FormatUint(x, 10))
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			msg = msg[n:]
+			fields[num] = append(fields[num], string(msg[:size]))
+			msg = msg[size:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&0x7)
+		}
+	}
+	return fields
+}
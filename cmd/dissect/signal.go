@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/midbel/dissect"
+)
+
+// handleSignals lets operators control a running interpreter: SIGUSR1 prints
+// the current statistics, SIGUSR2 closes the output files (they are reopened
+// when written again) and SIGHUP reloads the schema from its file.
+func handleSignals(i *dissect.Interpreter, schema string) func() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+	go func() {
+		for s := range sig {
+			var err error
+			switch s {
+			case syscall.SIGUSR1:
+				err = writeReport(i)
+			case syscall.SIGUSR2:
+				i.Rotate()
+			case syscall.SIGHUP:
+				err = reloadSchema(i, schema)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", s, err)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(sig)
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/midbel/dissect"
+)
+
+func handleSignals(i *dissect.Interpreter, schema string) func() {
+	return func() {}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/midbel/dissect"
+)
+
+// runSyntax writes the syntax definition of the language for an editor.
+func runSyntax(args []string) error {
+	set := flag.NewFlagSet("syntax", flag.ExitOnError)
+	format := set.String("f", dissect.SyntaxTextMate, "format of the definition (textmate, vim)")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() != 0 {
+		return fmt.Errorf("usage: dissect syntax [-f textmate|vim]")
+	}
+	return dissect.Syntax(os.Stdout, *format)
+}
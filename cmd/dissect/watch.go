@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/midbel/dissect"
+)
+
+type watcher struct {
+	schema string
+	dir    string
+	opts   []dissect.Option
+
+	interp  *dissect.Interpreter
+	mod     time.Time
+	queue   chan string
+	stopped chan struct{}
+	err     error
+
+	seen    map[string]struct{}
+	pending map[string]int64
+}
+
+func runWatch(args []string, opts []dissect.Option) error {
+	set := flag.NewFlagSet("watch", flag.ExitOnError)
+	every := set.Duration("e", time.Second, "polling interval")
+	all := set.Bool("a", false, "decode files already present in directory")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() != 2 {
+		return fmt.Errorf("usage: dissect watch [-e interval] [-a] <schema> <directory>")
+	}
+	w := watcher{
+		schema: set.Arg(0),
+		dir:    set.Arg(1),
+		// a file that can not be decoded does not stop the watch
+		opts:    append(opts[:len(opts):len(opts)], dissect.WithContinue(true)),
+		queue:   make(chan string),
+		stopped: make(chan struct{}),
+		seen:    make(map[string]struct{}),
+		pending: make(map[string]int64),
+	}
+	if !*all {
+		infos, err := ioutil.ReadDir(w.dir)
+		if err != nil {
+			return err
+		}
+		for _, i := range infos {
+			w.seen[filepath.Join(w.dir, i.Name())] = struct{}{}
+		}
+	}
+	for {
+		if err := w.reload(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err := w.poll(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		select {
+		case <-w.stopped:
+			return w.err
+		case <-time.After(*every):
+		}
+	}
+}
+
+// reload loads the schema when it or one of the files it includes has been
+// modified. All the files of the directory are decoded by the same run of the
+// interpreter: the outputs stay open and the new schema is used from the next
+// packet.
+func (w *watcher) reload() error {
+	mod, err := w.modTime()
+	if err != nil {
+		return err
+	}
+	if !mod.After(w.mod) {
+		return nil
+	}
+	// a schema that can not be loaded is only tried again once modified
+	w.mod = mod
+	if w.interp != nil {
+		if err := reloadSchema(w.interp, w.schema); err != nil {
+			return fmt.Errorf("%s: %w", w.schema, err)
+		}
+	} else {
+		interp, err := loadSchema(w.schema, w.opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", w.schema, err)
+		}
+		w.interp = interp
+		ctl.Attach(interp)
+		go func() {
+			defer close(w.stopped)
+			w.err = interp.RunQueue(w.queue, func(res dissect.FileResult) {
+				if res.Err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %s\n", res.File, res.Err)
+				}
+			})
+		}()
+	}
+	w.mod, err = w.modTime()
+	return err
+}
+
+// modTime gives the latest modification of the schema and of the files and
+// the directories it includes.
+func (w *watcher) modTime() (time.Time, error) {
+	i, err := os.Stat(w.schema)
+	if err != nil {
+		return time.Time{}, err
+	}
+	mod := i.ModTime()
+	if w.interp == nil {
+		return mod, nil
+	}
+	for _, f := range w.interp.Sources() {
+		i, err := os.Stat(f)
+		if err == nil && i.ModTime().After(mod) {
+			mod = i.ModTime()
+		}
+	}
+	return mod, nil
+}
+
+func (w *watcher) poll() error {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	for _, i := range infos {
+		if !i.Mode().IsRegular() {
+			continue
+		}
+		file := filepath.Join(w.dir, i.Name())
+		if _, ok := w.seen[file]; ok {
+			continue
+		}
+		// a file is only decoded once its size did not change between two polls
+		// to avoid reading it while it is still being written.
+		if size, ok := w.pending[file]; !ok || size != i.Size() {
+			w.pending[file] = i.Size()
+			continue
+		}
+		delete(w.pending, file)
+		w.seen[file] = struct{}{}
+		if w.interp == nil {
+			continue
+		}
+		select {
+		case w.queue <- file:
+		case <-w.stopped:
+			return w.err
+		}
+	}
+	return nil
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/midbel/dissect"
 )
 
 func main() {
-	merge := flag.Bool("m", false, "merge")
+	var (
+		merge  = flag.Bool("m", true, "merge included files and resolve references")
+		layout = flag.Bool("l", false, "layout")
+	)
 	flag.Parse()
 
 	r, err := os.Open(flag.Arg(0))
@@ -22,15 +27,25 @@ func main() {
 	var n dissect.Node
 	if *merge {
 		n, err = dissect.Merge(r)
-	} else {
-		n, err = dissect.Parse(r)
+	}
+	if !*merge || errors.Is(err, dissect.ErrNoData) {
+		// files without data block, like the ones defining the blocks included by
+		// other scripts, can only be dumped as they are parsed
+		if _, err = r.Seek(0, io.SeekStart); err == nil {
+			n, err = dissect.Parse(r)
+		}
 	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(25)
 	}
 
-	if err = dissect.Dump(n); err != nil {
+	if *layout {
+		err = dissect.DumpLayout(n)
+	} else {
+		err = dissect.Dump(n)
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(23)
 	}
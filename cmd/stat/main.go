@@ -4,24 +4,49 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/midbel/dissect"
 )
 
+// paths are the directories given with -I, in order.
+type paths []string
+
+func (p *paths) String() string {
+	return strings.Join(*p, string(os.PathListSeparator))
+}
+
+func (p *paths) Set(dir string) error {
+	*p = append(*p, dir)
+	return nil
+}
+
 func main() {
+	var include paths
+	flag.Var(&include, "I", "directory where included files are looked for (repeatable)")
 	flag.Parse()
+
+	opts := []dissect.Option{
+		dissect.WithIncludePath(include...),
+	}
+	var code int
 	for _, a := range flag.Args() {
-		if err := stat(a); err != nil {
+		if flag.NArg() > 1 {
+			fmt.Printf("%s:\n", a)
+		}
+		if err := stat(a, opts); err != nil {
 			fmt.Fprintln(os.Stderr, err)
+			code = 1
 		}
 	}
+	os.Exit(code)
 }
 
-func stat(file string) error {
+func stat(file string, opts []dissect.Option) error {
 	r, err := os.Open(file)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
-	return dissect.Stat(r)
+	return dissect.Stat(r, opts...)
 }
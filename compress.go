@@ -0,0 +1,69 @@
+package dissect
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+var compressExt = map[string]string{
+	compressGzip: ".gz",
+	compressZstd: ".zst",
+}
+
+func isCompression(str string) bool {
+	_, ok := compressExt[str]
+	return ok
+}
+
+// compressionOf returns the compression given by the extension of file, if
+// any.
+func compressionOf(file string) string {
+	ext := filepath.Ext(file)
+	for c, e := range compressExt {
+		if e == ext {
+			return c
+		}
+	}
+	return ""
+}
+
+// splitExt splits file into its base and its extension. The extension of a
+// compressed file includes the extension of the file before compression, eg
+// .csv.gz.
+func splitExt(file string) (string, string) {
+	ext := filepath.Ext(file)
+	if compressionOf(file) != "" {
+		ext = filepath.Ext(strings.TrimSuffix(file, ext)) + ext
+	}
+	return strings.TrimSuffix(file, ext), ext
+}
+
+// compressor is a writer compressing the data written to the underlying
+// writer. Flush writes the pending data as a complete block so that it can be
+// read before the compressor is closed. Close does not close the underlying
+// writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newCompressor(kind string, w io.Writer) (compressor, error) {
+	switch kind {
+	case compressGzip:
+		return gzip.NewWriter(w), nil
+	case compressZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("%s: unsupported compression", kind)
+	}
+}
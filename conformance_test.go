@@ -0,0 +1,297 @@
+package dissect
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestConformance(t *testing.T) {
+	for _, sub := range []string{"valid", "invalid"} {
+		files, err := filepath.Glob(filepath.Join("testdata", "conformance", sub, "*.lst"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			t.Fatalf("no scripts found in %s", sub)
+		}
+		for _, file := range files {
+			valid := sub == "valid"
+			t.Run(file, func(t *testing.T) {
+				want, err := expectedError(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r, err := os.Open(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer r.Close()
+
+				// scripts of the corpus never access the network
+				_, err = New(r, WithAllowNetwork())
+				switch {
+				case valid && err != nil:
+					t.Errorf("unexpected error: %s", err)
+				case !valid && err == nil:
+					t.Errorf("script accepted")
+				case !valid && !strings.Contains(err.Error(), want):
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			})
+		}
+	}
+}
+
+func expectedError(file string) (string, error) {
+	const prefix = "# error:"
+
+	r, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		return "", s.Err()
+	}
+	line := s.Text()
+	if !strings.HasPrefix(line, prefix) {
+		return "", nil
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, prefix)), nil
+}
+
+func TestGrammar(t *testing.T) {
+	prods, err := parseGrammar(Grammar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lexical productions (prefixed by an underscore) can be described in
+	// comments only, all the others should be defined
+	for name, p := range prods {
+		for _, ref := range p.refs {
+			if _, ok := prods[ref]; !ok && !strings.HasPrefix(ref, "_") {
+				t.Errorf("%s (line %d): %s not defined", name, p.line, ref)
+			}
+		}
+	}
+	reached := make(map[string]bool)
+	var walk func(string)
+	walk = func(name string) {
+		p, ok := prods[name]
+		if !ok || reached[name] {
+			return
+		}
+		reached[name] = true
+		for _, ref := range p.refs {
+			walk(ref)
+		}
+	}
+	walk("Script")
+	for name, p := range prods {
+		if !reached[name] && !strings.HasPrefix(name, "_") {
+			t.Errorf("%s (line %d): not reachable from Script", name, p.line)
+		}
+	}
+
+	kw, ok := prods["keyword"]
+	if !ok {
+		t.Fatalf("keyword not defined")
+	}
+	got := append([]string{}, kw.terms...)
+	want := append([]string{}, keywords...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("keywords mismatched:\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+// production gives the names and the terminals used by a production of the
+// grammar.
+type production struct {
+	line  int
+	refs  []string
+	terms []string
+}
+
+// parseGrammar parses the EBNF of the grammar: productions, terminals quoted
+// with double quotes or backquotes, ranges of characters, groups, options,
+// repetitions and alternations.
+func parseGrammar(str string) (map[string]*production, error) {
+	g := grammarParser{str: str, line: 1}
+	g.next()
+
+	prods := make(map[string]*production)
+	for g.tok != "" {
+		if !g.isName() {
+			return nil, g.errorf("production name expected, got %q", g.tok)
+		}
+		p := production{line: g.line}
+		name := g.tok
+		if _, ok := prods[name]; ok {
+			return nil, g.errorf("%s already defined", name)
+		}
+		g.next()
+		if err := g.expect("="); err != nil {
+			return nil, err
+		}
+		if g.tok != "." {
+			if err := g.parseExpr(&p); err != nil {
+				return nil, err
+			}
+		}
+		if err := g.expect("."); err != nil {
+			return nil, err
+		}
+		prods[name] = &p
+	}
+	return prods, g.err
+}
+
+type grammarParser struct {
+	str  string
+	tok  string
+	line int
+	err  error
+}
+
+func (g *grammarParser) parseExpr(p *production) error {
+	for {
+		if err := g.parseSeq(p); err != nil {
+			return err
+		}
+		if g.tok != "|" {
+			return nil
+		}
+		g.next()
+	}
+}
+
+func (g *grammarParser) parseSeq(p *production) error {
+	var n int
+	for ; ; n++ {
+		switch {
+		case g.isName():
+			p.refs = append(p.refs, g.tok)
+			g.next()
+		case g.isTerm():
+			lit, err := g.unquote()
+			if err != nil {
+				return err
+			}
+			p.terms = append(p.terms, lit)
+			if g.next(); g.tok == "…" {
+				g.next()
+				if !g.isTerm() {
+					return g.errorf("terminal expected after …, got %q", g.tok)
+				}
+				g.next()
+			}
+		case g.tok == "(" || g.tok == "[" || g.tok == "{":
+			end := map[string]string{"(": ")", "[": "]", "{": "}"}[g.tok]
+			g.next()
+			if err := g.parseExpr(p); err != nil {
+				return err
+			}
+			if err := g.expect(end); err != nil {
+				return err
+			}
+		default:
+			if n == 0 {
+				return g.errorf("unexpected %q", g.tok)
+			}
+			return nil
+		}
+	}
+}
+
+func (g *grammarParser) expect(tok string) error {
+	if g.tok != tok {
+		return g.errorf("%s expected, got %q", tok, g.tok)
+	}
+	g.next()
+	return nil
+}
+
+func (g *grammarParser) isName() bool {
+	r, _ := utf8.DecodeRuneInString(g.tok)
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func (g *grammarParser) isTerm() bool {
+	return strings.HasPrefix(g.tok, `"`) || strings.HasPrefix(g.tok, "`")
+}
+
+func (g *grammarParser) unquote() (string, error) {
+	lit, err := strconv.Unquote(g.tok)
+	if err != nil {
+		return "", g.errorf("%s: invalid terminal", g.tok)
+	}
+	return lit, nil
+}
+
+func (g *grammarParser) errorf(format string, args ...interface{}) error {
+	if g.err != nil {
+		return g.err
+	}
+	return fmt.Errorf("line %d: %s", g.line, fmt.Sprintf(format, args...))
+}
+
+// next reads the following token, skipping blanks and comments. At the end of
+// the input or after an error, the token is empty.
+func (g *grammarParser) next() {
+	g.tok = ""
+	for g.err == nil && g.str != "" {
+		switch r, z := utf8.DecodeRuneInString(g.str); {
+		case r == '\n':
+			g.line++
+			g.str = g.str[z:]
+		case unicode.IsSpace(r):
+			g.str = g.str[z:]
+		case strings.HasPrefix(g.str, "(*"):
+			end := strings.Index(g.str, "*)")
+			if end < 0 {
+				g.err = g.errorf("comment not terminated")
+				return
+			}
+			g.line += strings.Count(g.str[:end], "\n")
+			g.str = g.str[end+2:]
+		case r == '"' || r == '`':
+			end := 1
+			for end < len(g.str) && g.str[end] != byte(r) && g.str[end] != '\n' {
+				if r == '"' && g.str[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(g.str) || g.str[end] != byte(r) {
+				g.err = g.errorf("terminal not terminated")
+				return
+			}
+			g.tok, g.str = g.str[:end+1], g.str[end+1:]
+			return
+		case r == '_' || unicode.IsLetter(r):
+			end := strings.IndexFunc(g.str, func(r rune) bool {
+				return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})
+			if end < 0 {
+				end = len(g.str)
+			}
+			g.tok, g.str = g.str[:end], g.str[end:]
+			return
+		default:
+			g.tok, g.str = g.str[:z], g.str[z:]
+			return
+		}
+	}
+}
@@ -9,11 +9,11 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
-	"os"
-	"path/filepath"
+	"math/big"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,50 +22,42 @@ var (
 	ErrDone     = errors.New("done")
 	errBreak    = errors.New("break")
 	errContinue = errors.New("continue")
-	errShort    = errors.New("short buffer")
 )
 
-const numbit = 8
+// ErrShort and ErrExpect are wrapped by the errors of the packets cut by the
+// end of their input and of the fields whose value is not the expected one.
+var (
+	ErrShort  = errors.New("short buffer")
+	ErrExpect = errors.New("expectation failed")
+)
+
+// DecodeError gives the context in which a packet failed to be decoded: the
+// input file, the index of the packet, the offset of the failure in the file,
+// in bytes and in bits, the path of the block being decoded and, when the
+// error comes from a field, its name and its position in the schema.
+type DecodeError struct {
+	File      string
+	Packet    int
+	Offset    int64
+	BitOffset int64
+	Block     string
+	Field     string
+	Pos       Position
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s:%d: packet %d: %s: %s", e.File, e.Offset, e.Packet, e.Block, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: packet %d: %s.%s (%s): %s", e.File, e.Offset, e.Packet, e.Block, e.Field, e.Pos, e.Err)
+}
 
-// type Option func(*Interpreter) error
-//
-// func WithStdout(std io.Writer) Option {
-// 	return func(i *Interpreter) error {
-// 		i.stdout = std
-// 	}
-// }
-//
-// func WithStderr(std io.Writer) Option {
-// 	return func(i *Interpreter) error {
-// 		i.stdout = std
-// 	}
-// }
-//
-// func WithWordLen(n uint8) Option {
-// 	return func(i *Interpreter) error {
-// 		i.wordlen = int(n)
-// 	}
-// }
-//
-// func WithInclude(files []string) Option {
-// 	return func(i *Interpreter) error {
-// 		return nil
-// 	}
-// }
-//
-// type Interpreter struct {
-// 	stdout  io.Writer
-// 	stderr  io.Writer
-//  wordlen int
-// }
-//
-// func New(r io.Reader, opts ...Option) (*Interpreter, error) {
-// 	return nil, nil
-// }
-//
-// func (i Interpreter) Run(r io.Reader) error {
-// 	return nil
-// }
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+const numbit = 8
 
 type Field struct {
 	Block string
@@ -76,6 +68,43 @@ type Field struct {
 
 	raw Value
 	eng Value
+
+	kind    Kind
+	endian  string
+	invalid bool
+	// derived fields are computed by let statements: they are printed like
+	// the decoded fields even though they do not take any bit
+	derived bool
+
+	// encoding of the signed integers and conversion of the raw value, used
+	// again when the raw value is corrected by a check statement
+	encoding string
+	apply    Node
+}
+
+// NewField creates a field holding v. It is meant to add fields to the
+// records given to a record hook.
+func NewField(id string, v Value) Field {
+	f := Field{
+		Id:  id,
+		Len: 64,
+		raw: v,
+	}
+	switch v := v.(type) {
+	case *Int:
+		f.kind = kindInt
+	case *Uint:
+		f.kind = kindUint
+	case *Real:
+		f.kind = kindFloat
+	case *String:
+		f.kind, f.Len = kindString, len(v.Raw)*numbit
+	case *Bytes:
+		f.kind, f.Len = kindBytes, len(v.Raw)*numbit
+	case *Time:
+		f.kind = kindTime
+	}
+	return f
 }
 
 func (f Field) String() string {
@@ -91,13 +120,23 @@ func (f Field) Offset() int {
 }
 
 func (f Field) Skip() bool {
-	return len(f.Id) == 0 || f.Id[0] == underscore || f.Len == 0
+	return len(f.Id) == 0 || f.Id[0] == underscore || (f.Len == 0 && !f.derived)
+}
+
+// Valid is false when the value of the field is not the one given by its
+// expectation and its policy let the decoding go on.
+func (f Field) Valid() bool {
+	return !f.invalid
 }
 
 func (f Field) Raw() Value {
 	return f.raw
 }
 
+func (f Field) Kind() Kind {
+	return f.kind
+}
+
 func (f Field) Eng() Value {
 	if f.eng == nil {
 		return f.raw
@@ -109,54 +148,263 @@ type state struct {
 	Block
 	data Block
 
-	Fields []Field
-	files  map[string]*os.File
+	Fields  []Field
+	files   *fileCache
+	targets map[string]string // file written by each output statement
+	hosts   hostPolicy
+	sinks   map[string]*sink
+	windows map[nodeKey]*window
+	alarms  []Limits
+	streams map[streamKey]Value
+	events  map[nodeKey]Value
+	call    nodeKey // builtin being called
+	drop    bool
+
+	parity      int
+	corrected   int
+	uncorrected int
+	yellows     int
+	reds        int
+	failures    []Failure
+	timings     []Timing
 
 	reader *bufio.Reader
 	buffer []byte
+	offset int64
+	eof    bool
+	length int
+	limits []int
+	marker int // position of the sync marker of the packet, -1 without
+	marks  map[string]int
 	Pos    int
 	Loop   int
 	Iter   int
+	loops  []loop
+
+	partial bool
+	// once stops the decoding after the first packet decoded
+	once bool
 
 	blocks      []string
 	currentFile string
 
 	stdout io.Writer
 	stderr io.Writer
+
+	capture  io.Writer
+	captured map[string]struct{}
+
+	record    []byte
+	columns   columns
+	renderers []renderer
+	hook      func([]Field) ([]Field, error)
+	onPacket  func(PacketInfo)
+	onError   func(error) Action
+	batches   *batcher
+	callbacks map[string]Callback
+	funcs     map[string]Func
+
+	dry        bool
+	backfill   bool
+	skipErrors bool
+	trailing   Trailing
+	bitorder   BitOrder
+	expect     ExpectPolicy
+	outroot    string
+	peak       int
+	timing     bool
+	depth      int
+	stats      *Stats
+	mu         *sync.Mutex
+	sync       func(*state)
+}
+
+func (root *state) updateStats(err error) {
+	if root.stats == nil {
+		return
+	}
+	if root.mu != nil {
+		root.mu.Lock()
+		defer root.mu.Unlock()
+	}
+	if err != nil {
+		root.stats.record(root, err)
+	} else {
+		root.stats.update(root)
+	}
+	root.stats.account(root)
 }
 
 func (root *state) Close() error {
-	var err error
-	for _, f := range root.files {
-		if e := f.Close(); e != nil {
-			err = e
+	err := root.files.Close()
+	if root.stats != nil && len(root.files.outputs) > 0 {
+		if root.mu != nil {
+			root.mu.Lock()
+			defer root.mu.Unlock()
 		}
+		root.stats.addOutputs(root.files.outputs)
+		root.files.outputs = make(map[string]*OutputStat)
 	}
 	return err
 }
 
+// rotate closes all the files opened by the print, echo and copy statements.
+// They are reopened in append mode when written again.
+func (root *state) rotate() error {
+	err := root.files.Close()
+	for _, s := range root.sinks {
+		for f := range s.files {
+			delete(s.files, f)
+		}
+	}
+	return err
+}
+
+// Run decodes packets from r until its end. ErrDone is used internally to
+// signal that no more packets are available: either all the input has been
+// consumed or the last packet is incomplete and should not be reported as an
+// error.
 func (root *state) Run(r io.Reader) error {
 	root.Reset(r)
 
 	for {
-		if err := root.growBuffer(4096); err != nil {
-			return err
+		err := root.runPacket()
+		if err == nil {
+			continue
 		}
-		if root.Size() == 0 {
+		if errors.Is(err, ErrDone) {
 			break
 		}
-		if err := root.decodeBlock(root.data); err != nil {
-			if errors.Is(err, ErrDone) {
-				break
+		if root.onError == nil {
+			// a dry run validates the whole input: the failures are reported
+			// in the anomalies of the statistics
+			if root.marker < 0 && !root.skipErrors && !root.dry {
+				return err
+			}
+			if root.marker < 0 && errors.Is(err, ErrShort) && root.eof {
+				return nil
+			}
+			what := "packet skipped"
+			if root.marker >= 0 {
+				what = "resync on next marker"
+			}
+			if !root.dry {
+				fmt.Fprintf(root.stderr, "warning: %s (%s at byte %d)\n", err, what, root.offset)
+			}
+			root.skipPacket()
+			continue
+		}
+		switch root.onError(err) {
+		case ActionSkip:
+			if errors.Is(err, ErrShort) && root.eof {
+				return nil
 			}
-			return fmt.Errorf("%s: %w", root.path(), err)
+			root.skipPacket()
+		case ActionRetry:
+			root.Pos = 0
+			root.reset()
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// skipPacket drops the packet that failed to be decoded. At least one byte is
+// dropped so that the decoding always goes forward. When a sync marker was
+// found, only the bytes up to the marker are dropped so that the search of the
+// next marker starts right after it.
+func (root *state) skipPacket() {
+	bits := root.Pos
+	if root.length > bits {
+		bits = root.length
+	}
+	if root.marker >= 0 {
+		bits = root.marker + 1
+	}
+	n := (bits + numbit - 1) / numbit
+	if n == 0 {
+		n = 1
+	}
+	if n > len(root.buffer) {
+		n = len(root.buffer)
+	}
+	root.Pos = n * numbit
+	root.Loop++
+	root.reset()
+}
+
+// notify gives the packet just decoded to the packet hook.
+func (root *state) notify(err error) {
+	if root.onPacket == nil {
+		return
+	}
+	root.onPacket(PacketInfo{
+		File:   root.currentFile,
+		Packet: root.Loop,
+		Offset: root.offset,
+		Size:   (root.Pos + numbit - 1) / numbit,
+		Fields: root.Fields,
+		Err:    err,
+	})
+}
+
+func (root *state) runPacket() error {
+	if len(root.buffer) == 0 && !root.eof {
+		if err := root.readBuffer(0); err != nil {
+			return err
+		}
+	}
+	if root.Size() == 0 {
+		return ErrDone
+	}
+	if root.sync != nil {
+		root.sync(root)
+	}
+	if err := root.decodeBlock(root.data); err != nil {
+		if errors.Is(err, ErrDone) {
+			return err
 		}
-		root.Loop++
-		root.reset()
+		if errors.Is(err, ErrShort) && root.eof && root.trailing != TrailingError {
+			return root.trailingPacket(err)
+		}
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			err = fmt.Errorf("%s: %w", root.path(), err)
+		}
+		root.updateStats(err)
+		root.notify(err)
+		return err
+	}
+	err := root.endPacket()
+	if err == nil {
+		err = root.checkLimits()
+	}
+	if err != nil {
+		err = root.blockError(err)
+		root.updateStats(err)
+		root.notify(err)
+		return err
 	}
+	root.updateStats(nil)
+	root.notify(nil)
+	root.Loop++
+	if root.partial || root.once {
+		return ErrDone
+	}
+	root.reset()
 	return nil
 }
 
+// trailingPacket drops a packet cut by the end of the input, writing a
+// warning if requested.
+func (root *state) trailingPacket(err error) error {
+	if root.trailing == TrailingWarn {
+		fmt.Fprintf(root.stderr, "warning: partial packet at end of input: %s\n", err)
+	}
+	return ErrDone
+}
+
 func (root *state) Reset(r io.Reader) {
 	if n, ok := r.(interface{ Name() string }); ok {
 		root.currentFile = n.Name()
@@ -165,36 +413,86 @@ func (root *state) Reset(r io.Reader) {
 	}
 	root.reader = bufio.NewReader(r)
 	root.buffer = root.buffer[:0]
+	root.offset = 0
+	root.eof = false
 	root.Pos = 0
 	root.Loop = 0
+	root.reset()
 }
 
 func (root *state) reset() {
 	if offset := root.Pos / numbit; offset < len(root.buffer) {
 		root.buffer = root.buffer[offset:]
+		root.offset += int64(offset)
 	} else {
+		root.offset += int64(len(root.buffer))
 		root.buffer = root.buffer[:0]
 	}
 	root.Fields = root.Fields[:0]
 	root.blocks = root.blocks[:0]
+	root.loops = root.loops[:0]
 	root.Pos = 0
+	root.drop = false
+	root.partial = false
+	root.length = 0
+	root.limits = root.limits[:0]
+	root.marker = -1
+	for k := range root.marks {
+		delete(root.marks, k)
+	}
+	root.parity, root.corrected, root.uncorrected = 0, 0, 0
+	root.yellows, root.reds = 0, 0
+	root.failures = root.failures[:0]
+	root.timings = root.timings[:0]
 }
 
+// fail records that the validation of kind failed for field in the current
+// packet.
+func (root *state) fail(field, kind string) {
+	root.addFailure(fmt.Sprintf("%s.%s", root.currentBlock(), field), kind)
+}
+
+func (root *state) addFailure(field, kind string) {
+	for i, c := range root.failures {
+		if c.Field == field && c.Kind == kind {
+			root.failures[i].Count++
+			return
+		}
+	}
+	root.failures = append(root.failures, Failure{Field: field, Kind: kind, Count: 1})
+}
+
+// growBuffer reads from the input until bits are available after the current
+// position or until the end of the input is reached.
 func (root *state) growBuffer(bits int) error {
-	pos := (root.Pos + bits) / numbit
-	if n := len(root.buffer); bits > 0 && pos < n {
-		return nil
+	want := (root.Pos + bits + numbit - 1) / numbit
+	for len(root.buffer) < want && !root.eof {
+		if err := root.readBuffer(want - len(root.buffer)); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	xs := make([]byte, 4096+(bits/numbit))
+func (root *state) readBuffer(size int) error {
+	// everything written for the data already read is flushed before waiting
+	// for more input.
+	if err := root.files.Flush(); err != nil {
+		return err
+	}
+	xs := make([]byte, 4096+size)
 	n, err := root.reader.Read(xs)
 	if n > 0 {
 		root.buffer = append(root.buffer, xs[:n]...)
+		if c := cap(root.buffer); c > root.peak {
+			root.peak = c
+		}
 	}
-	if err != nil && err != io.EOF {
-		return err
+	if errors.Is(err, io.EOF) {
+		root.eof = true
+		err = nil
 	}
-	return nil
+	return err
 }
 
 func (root *state) Size() int {
@@ -247,8 +545,39 @@ func (root *state) ResolveInternal(str string) (Field, error) {
 		field.raw = &String{
 			Raw: root.path(),
 		}
+	case "Partial":
+		field.raw = &Boolean{
+			Raw: root.partial,
+		}
+	case "Backfill":
+		field.raw = &Boolean{
+			Raw: root.backfill,
+		}
+	case "Parity":
+		field.raw = &Int{
+			Raw: int64(root.parity),
+		}
+	case "Corrected":
+		field.raw = &Int{
+			Raw: int64(root.corrected),
+		}
+	case "Uncorrected":
+		field.raw = &Int{
+			Raw: int64(root.uncorrected),
+		}
 	default:
-		err = fmt.Errorf("%s: unknown internal value", str)
+		if !strings.HasPrefix(str, "Iter") {
+			err = fmt.Errorf("%s: unknown internal value", str)
+			break
+		}
+		n, e := strconv.Atoi(strings.TrimPrefix(str, "Iter"))
+		if e != nil || n < 0 || n >= len(root.loops) {
+			err = fmt.Errorf("%s: unknown internal value", str)
+			break
+		}
+		field.raw = &Int{
+			Raw: int64(root.loops[n].iter),
+		}
 	}
 	return field, err
 }
@@ -282,6 +611,50 @@ func (root *state) currentBlock() string {
 	return strings.TrimRight(root.blocks[n-1], "$")
 }
 
+func (root *state) decodeError(p Parameter, pos int, err error) error {
+	var de *DecodeError
+	if errors.As(err, &de) {
+		return err
+	}
+	return &DecodeError{
+		File:      root.currentFile,
+		Packet:    root.Loop,
+		Offset:    root.offset + int64(pos/numbit),
+		BitOffset: root.offset*numbit + int64(pos),
+		Block:     root.path(),
+		Field:     p.id.Literal,
+		Pos:       p.Pos(),
+		Err:       err,
+	}
+}
+
+// blockError gives the context of an error raised outside of a field, eg by a
+// statement of the block being decoded. The errors used to control the
+// decoding are returned as is.
+func (root *state) blockError(err error) error {
+	if err == nil || errors.Is(err, ErrDone) || errors.Is(err, ErrSkip) {
+		return err
+	}
+	if errors.Is(err, errBreak) || errors.Is(err, errContinue) {
+		return err
+	}
+	var (
+		de   *DecodeError
+		exit *ExitError
+	)
+	if errors.As(err, &de) || errors.As(err, &exit) {
+		return err
+	}
+	return &DecodeError{
+		File:      root.currentFile,
+		Packet:    root.Loop,
+		Offset:    root.offset + int64(root.Pos/numbit),
+		BitOffset: root.offset*numbit + int64(root.Pos),
+		Block:     root.path(),
+		Err:       err,
+	}
+}
+
 func (root *state) path() string {
 	return "/" + strings.Join(root.blocks, "/")
 }
@@ -303,40 +676,39 @@ func (root *state) popBlock() {
 }
 
 func (root *state) decodeBlock(data Block) error {
+	if data.limit != nil {
+		return root.decodeLimit(data)
+	}
+	defer root.timed(timeBlock, data.id.Literal)()
 	root.pushBlock(data.id.Literal)
 	defer root.popBlock()
 
-	var err error
-	switch n := data.pre.(type) {
-	case Block:
-		err = root.decodeNodes(n.nodes)
-	case Reference:
-		p, err := root.ResolveBlock(n.id.Literal)
-		if err != nil {
-			return err
-		}
-		err = root.decodeNodes(p.nodes)
-	}
-	if err != nil {
+	// the context of the error is taken before the block is popped
+	return root.blockError(root.decodeDiamond(data))
+}
+
+// decodeDiamond decodes the nodes of data between the ones of its pre and post
+// blocks.
+func (root *state) decodeDiamond(data Block) error {
+	if err := root.decodeSection(data.pre); err != nil {
 		return err
 	}
-
 	if err := root.decodeNodes(data.nodes); err != nil {
 		return err
 	}
+	return root.decodeSection(data.post)
+}
 
-	switch n := data.post.(type) {
+func (root *state) decodeSection(n Node) error {
+	switch n := n.(type) {
 	case Block:
-		err = root.decodeNodes(n.nodes)
+		return root.decodeNodes(n.nodes)
 	case Reference:
 		p, err := root.ResolveBlock(n.id.Literal)
 		if err != nil {
 			return err
 		}
-		err = root.decodeNodes(p.nodes)
-	}
-	if err != nil {
-		return err
+		return root.decodeNodes(p.nodes)
 	}
 	return nil
 }
@@ -348,9 +720,13 @@ func (root *state) decodeNodes(nodes []Node) error {
 		}
 		switch n := n.(type) {
 		case Break:
-			return root.decodeBreak(n)
+			if err := root.decodeBreak(n); err != nil {
+				return err
+			}
 		case Continue:
-			return root.decodeContinue(n)
+			if err := root.decodeContinue(n); err != nil {
+				return err
+			}
 		case Copy:
 			if err := root.decodeCopy(n); err != nil {
 				return err
@@ -381,6 +757,40 @@ func (root *state) decodeNodes(nodes []Node) error {
 			}
 		case Push:
 			root.decodePush(n)
+		case Dedupe:
+			if err := root.decodeDedupe(n); err != nil {
+				return err
+			}
+		case Length:
+			if err := root.decodeLength(n); err != nil {
+				return err
+			}
+		case Sync:
+			if err := root.decodeSync(n); err != nil {
+				return err
+			}
+		case Summary:
+			val, err := root.decodeSummary(n)
+			if err != nil {
+				return err
+			}
+			root.Fields = append(root.Fields, val)
+		case ASN1:
+			if err := root.decodeASN1(n); err != nil {
+				return err
+			}
+		case Protobuf:
+			if err := root.decodeProtobuf(n); err != nil {
+				return err
+			}
+		case Extract:
+			if err := root.decodeText(n); err != nil {
+				return err
+			}
+		case Check:
+			if err := root.decodeCheck(n); err != nil {
+				return err
+			}
 		case Peek:
 			if err := root.decodePeek(n); err != nil {
 				return err
@@ -389,6 +799,14 @@ func (root *state) decodeNodes(nodes []Node) error {
 			if err := root.decodeSeek(n); err != nil {
 				return err
 			}
+		case Mark:
+			if err := root.decodeMark(n); err != nil {
+				return err
+			}
+		case Transition:
+			if err := root.decodeTransition(n); err != nil {
+				return err
+			}
 		case If:
 			if err := root.decodeIf(n); err != nil {
 				return err
@@ -433,35 +851,81 @@ func (root *state) decodeNodes(nodes []Node) error {
 	return nil
 }
 
-func (root *state) openFile(file string, echo bool) (io.Writer, bool, error) {
+// openFile opens file for the output statement identified by key. When the
+// name of the file of a statement changes, eg because of its placeholders, the
+// file it wrote to until then is closed. It is reopened in append mode if it is
+// written again.
+func (root *state) openFile(key, file string, echo bool) (io.Writer, bool, error) {
+	if root.dry {
+		return ioutil.Discard, false, nil
+	}
 	if file == "" || file == "-" {
 		if echo {
 			return root.stderr, false, nil
 		}
 		return root.stdout, false, nil
 	}
-	path := root.path()
 	if file == "/dev/null" {
 		return ioutil.Discard, false, nil
 	}
-
-	w, ok := root.files[path]
-	if ok && w.Name() == file {
-		return w, false, nil
-	}
-	if ok {
-		w.Close()
-		delete(root.files, path)
+	if isURL(file) {
+		return root.openEndpoint(file)
 	}
-	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil && !errors.Is(err, os.ErrExist) {
-		return nil, false, err
-	}
-	w, err := os.Create(file)
+	file, err := root.outputFile(file)
 	if err != nil {
 		return nil, false, err
 	}
-	root.files[path] = w
-	return w, true, nil
+	if prev, ok := root.targets[key]; ok && prev != file {
+		if err := root.files.Release(prev); err != nil {
+			return nil, false, err
+		}
+	}
+	if root.targets == nil {
+		root.targets = make(map[string]string)
+	}
+	root.targets[key] = file
+	return root.files.Open(file, false, compressionOf(file))
+}
+
+// outputKey identifies an output of the statement at pos: the path of its block
+// followed by its position and, for the outputs after the first one of a print
+// statement, the index of the output.
+func (root *state) outputKey(pos Position, index int) string {
+	key := root.path() + "@" + pos.String()
+	if index > 0 {
+		key += "#" + strconv.Itoa(index)
+	}
+	return key
+}
+
+func (root *state) openSink(key string, file Token, echo bool) (io.Writer, *sink, bool, error) {
+	name := file.Literal
+	if root.capture != nil {
+		_, seen := root.captured[name]
+		root.captured[name] = struct{}{}
+		return root.capture, root.sinks[name], !seen, nil
+	}
+	if file.Type == Ident {
+		if s, ok := root.sinks[name]; ok {
+			if root.dry {
+				return ioutil.Discard, s, false, nil
+			}
+			w, created, err := s.Open(root)
+			return w, s, created, err
+		}
+		v, err := root.ResolveValue(name)
+		if err == nil {
+			name = asString(v.Raw())
+		}
+	} else {
+		str, err := expandPath(root, name)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		name = str
+	}
+	w, created, err := root.openFile(key, name, echo)
+	return w, nil, created, err
 }
 
 func (root *state) decodePush(p Push) error {
@@ -486,27 +950,35 @@ func (root *state) decodePush(p Push) error {
 }
 
 func (root *state) decodeEcho(e Echo) error {
-	w, _, err := root.openFile(e.file.Literal, true)
+	if root.drop {
+		return nil
+	}
+	w, _, _, err := root.openSink(root.outputKey(e.pos, 0), e.file, true)
 	if err != nil {
 		return err
 	}
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 64)
-	)
+	buf := root.record[:0]
 	for _, e := range e.expr {
+		if i, ok := e.(Literal); ok && i.id.Type == Text {
+			buf = append(buf, i.id.Literal...)
+			continue
+		}
 		v, err := eval(e, root)
 		if err != nil {
 			return err
 		}
-		buf.Write(appendRaw(dat, v, false))
+		buf = appendRaw(buf, v, encText)
 	}
-	buf.WriteString("\r\n")
-	_, err = io.Copy(w, &buf)
+	buf = append(buf, "\r\n"...)
+	root.record = buf
+	_, err = w.Write(buf)
 	return err
 }
 
 func (root *state) decodeCopy(c Copy) error {
+	if root.drop {
+		return nil
+	}
 	if c.predicate != nil {
 		v, err := eval(c.predicate, root)
 		if err != nil {
@@ -522,14 +994,7 @@ func (root *state) decodeCopy(c Copy) error {
 		return err
 	}
 
-	file := c.file.Literal
-	if c.file.Type == Ident {
-		v, err := root.ResolveValue(file)
-		if err == nil {
-			file = asString(v.Raw())
-		}
-	}
-	w, _, err := root.openFile(file, false)
+	w, _, _, err := root.openSink(root.outputKey(c.pos, 0), c.file, false)
 	if err != nil {
 		return err
 	}
@@ -552,6 +1017,9 @@ func (root *state) decodeCopy(c Copy) error {
 }
 
 func (root *state) decodePrint(p Print) error {
+	if root.drop {
+		return nil
+	}
 	if p.predicate != nil {
 		v, err := eval(p.predicate, root)
 		if err != nil {
@@ -561,39 +1029,108 @@ func (root *state) decodePrint(p Print) error {
 			return nil
 		}
 	}
-	file := p.file.Literal
-	if p.file.Type == Ident {
-		v, err := root.ResolveValue(file)
-		if err == nil {
-			file = asString(v.Raw())
+	values := resolveValues(root, p.values)
+	if root.hook != nil {
+		var err error
+		if values, err = root.hook(append([]Field(nil), values...)); err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return nil
+		}
+	}
+	values = root.render(values)
+	if root.batches != nil {
+		if err := root.batches.append(values, p.method.Literal, root.columns); err != nil {
+			return err
 		}
 	}
-	w, created, err := root.openFile(file, false)
+	key := root.outputKey(p.pos, 0)
+	if len(p.outputs) == 0 {
+		return root.printTo(key, p.file, p.format, p.method, p.influx, values, nil)
+	}
+	// records printed to multiple outputs are only serialized once per format
+	cache := make(map[string][]byte)
+	if err := root.printTo(key, p.file, p.format, p.method, p.influx, values, cache); err != nil {
+		return err
+	}
+	for i, o := range p.outputs {
+		key = root.outputKey(p.pos, i+1)
+		if err := root.printTo(key, o.file, o.format, p.method, o.influx, values, cache); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (root *state) printTo(key string, file, format, method Token, spec *influxSpec, values []Field, cache map[string][]byte) error {
+	w, s, created, err := root.openSink(key, file, false)
 	if err != nil {
 		return err
 	}
+	return root.writeRecord(w, s, created, format, method, spec, values, cache)
+}
+
+// writeRecord serializes values in the given format, or the one of the sink s,
+// and writes the record to w. The csv headers are written first when w has
+// just been created.
+func (root *state) writeRecord(w io.Writer, s *sink, created bool, format, method Token, spec *influxSpec, values []Field, cache map[string][]byte) error {
+	if s != nil && s.format.Literal != "" {
+		format = s.format
+	}
 	k := struct {
 		Format string
 		Method string
 	}{
-		Format: p.format.Literal,
-		Method: p.method.Literal,
+		Format: format.Literal,
+		Method: method.Literal,
 	}
 	print, ok := printers[k]
+	if k.Format == fmtInflux {
+		print, ok = root.influxPrinter(spec, k.Method)
+	}
 	if !ok {
-		return fmt.Errorf("print: unsupported method %s for format %s", p.method, p.format)
+		return fmt.Errorf("print: unsupported method %s for format %s", method, format)
 	}
+	defer root.timed(timePrint, k.Format+"/"+k.Method)()
 
-	values := resolveValues(root, p.values)
+	if k.Format == fmtCSV || k.Format == fmtInflux {
+		values = explodeArrays(values)
+	}
+	buf := root.record[:0]
 	if created && k.Format == fmtCSV {
-		if err := csvPrintHeaders(w, k.Method, values); err != nil {
-			return err
+		buf = csvPrintHeaders(buf, k.Method, values, root.columns)
+	}
+	// the lines of influx depend on the options of each output
+	if cache == nil || k.Format == fmtInflux {
+		buf = print(buf, values)
+	} else {
+		rec, ok := cache[k.Format]
+		if !ok {
+			rec = print(nil, values)
+			cache[k.Format] = rec
 		}
+		buf = append(buf, rec...)
 	}
-	return print(w, values)
+	root.record = buf
+	_, err := w.Write(buf)
+	return err
 }
 
 func (root *state) decodeParameter(p Parameter) (Field, error) {
+	defer root.timed(timeStage, stageExtract)()
+	pos := root.Pos
+	f, err := root.decodeField(p)
+	if err != nil {
+		err = root.decodeError(p, pos, err)
+	}
+	return f, err
+}
+
+func (root *state) decodeField(p Parameter) (Field, error) {
+	if len(p.dims) > 0 {
+		return root.decodeArray(p)
+	}
 	var (
 		bits   int
 		offset = root.Pos % numbit
@@ -615,9 +1152,25 @@ func (root *state) decodeParameter(p Parameter) (Field, error) {
 	}
 
 	var (
-		err error
-		raw Field
+		err  error
+		raw  Field
+		need = bits
 	)
+	if k := p.is(); k == kindBytes || k == kindString {
+		need *= numbit
+	}
+	if err := root.checkFrame(need); err != nil {
+		return Field{}, err
+	}
+	if err := root.growBuffer(need); err != nil {
+		return Field{}, err
+	}
+	if avail := root.Size() - root.Pos; avail < need {
+		if root.eof && root.trailing == TrailingEmit {
+			return root.partialField(p, need), nil
+		}
+		return Field{}, fmt.Errorf("%w: want %d bits, only %d available", ErrShort, need, avail)
+	}
 
 	switch p.is() {
 	case kindBytes, kindString:
@@ -625,17 +1178,18 @@ func (root *state) decodeParameter(p Parameter) (Field, error) {
 			err = fmt.Errorf("bytes/string should start at offset 0")
 			break
 		}
-		if err := root.growBuffer(bits * numbit); err != nil {
-			return Field{}, err
-		}
 		raw, err = root.decodeBytes(p, bits, index)
 		bits *= numbit
-	default:
-		if err := root.growBuffer(bits * numbit); err != nil {
-			return Field{}, err
+	case kindCUC, kindCDS:
+		if offset != 0 {
+			err = fmt.Errorf("time codes should start at offset 0")
+			break
 		}
+		raw, err = root.decodeTimeCode(p, bits, index)
+	default:
 		raw, err = root.decodeNumber(p, bits, index, offset)
 		if err == nil {
+			raw.encoding, raw.apply = p.encoding.Literal, p.apply
 			raw, err = root.evalApply(raw, p.apply)
 		}
 	}
@@ -647,8 +1201,16 @@ func (root *state) decodeParameter(p Parameter) (Field, error) {
 		if err != nil {
 			return Field{}, err
 		}
-		if cmp := raw.Raw().Cmp(expect); cmp != 0 {
-			return Field{}, fmt.Errorf("%s expectation failed: want %s, got %s", p, expect, raw)
+		if got, want := promote(raw.Raw(), expect); got.Cmp(want) != 0 {
+			root.fail(p.id.Literal, failExpect)
+			err := fmt.Errorf("%s %w: want %s, got %s", p, ErrExpect, appendRaw(nil, expect, encText), appendRaw(nil, raw.Raw(), encText))
+			switch root.expectPolicyOf(p) {
+			case ExpectFail:
+				return Field{}, err
+			case ExpectWarn:
+				fmt.Fprintf(root.stderr, "warning: %s\n", root.decodeError(p, root.Pos, err))
+			}
+			raw.invalid = true
 		}
 	}
 	root.Pos += bits
@@ -656,14 +1218,70 @@ func (root *state) decodeParameter(p Parameter) (Field, error) {
 	return raw, nil
 }
 
+// decodeArray decodes the elements of an array field one after the other into
+// a single field. The elements of an array with more than one dimension are
+// arrays themselves. Each element is checked against the expected value of the
+// field, if any.
+func (root *state) decodeArray(p Parameter) (Field, error) {
+	var (
+		count, _ = strconv.Atoi(p.dims[0].Literal)
+		elem     = p
+		pos      = root.Pos
+		raw      = make([]Value, 0, count)
+		eng      = make([]Value, 0, count)
+		arr      Field
+		apply    bool
+	)
+	elem.dims = p.dims[1:]
+	for i := 0; i < count; i++ {
+		at := root.Pos
+		f, err := root.decodeField(elem)
+		if err != nil {
+			return Field{}, root.decodeError(p, at, fmt.Errorf("element %d: %w", i, err))
+		}
+		if i == 0 {
+			arr = f
+		}
+		apply = apply || f.eng != nil
+		arr.invalid = arr.invalid || f.invalid
+		raw = append(raw, f.Raw())
+		eng = append(eng, f.Eng())
+	}
+	arr.Pos, arr.Len = pos, root.Pos-pos
+	arr.raw, arr.eng = &Array{Raw: raw}, nil
+	if apply {
+		arr.eng = &Array{Raw: eng}
+	}
+	return arr, nil
+}
+
+// partialField gives a null value to a field cut by the end of the input. The
+// position is moved to the end of the input so that the next fields of the
+// packet are null too.
+func (root *state) partialField(p Parameter, bits int) Field {
+	root.partial = true
+	f := Field{
+		Id:    p.id.Literal,
+		Pos:   root.Pos,
+		Len:   bits,
+		Block: root.currentBlock(),
+		Ix:    root.Iter,
+		raw:   &Null{},
+		kind:  p.is(),
+	}
+	root.Pos = root.Size()
+	return f
+}
+
 func (root *state) decodeBytes(p Parameter, bits, index int) (Field, error) {
 	raw := Field{
-		Id:  p.id.Literal,
-		Pos: root.Pos,
-		Len: bits * numbit,
+		Id:   p.id.Literal,
+		Pos:  root.Pos,
+		Len:  bits * numbit,
+		kind: p.is(),
 	}
 	if n := root.Size() / numbit; n < index+bits {
-		return Field{}, fmt.Errorf("%w: missing %d bytes (decoding %s.%s)", errShort, (index+bits)-n, root.currentBlock(), p)
+		return Field{}, fmt.Errorf("%w: missing %d bytes", ErrShort, (index+bits)-n)
 	}
 	switch kind := p.is(); kind {
 	case kindBytes:
@@ -683,7 +1301,7 @@ func (root *state) decodeBytes(p Parameter, bits, index int) (Field, error) {
 
 func (root *state) decodeNumber(p Parameter, bits, index, offset int) (Field, error) {
 	var (
-		need  = numbytes(bits)
+		need  = numbytes(offset + bits)
 		shift = (numbit * need) - (offset + bits)
 		mask  = 1
 	)
@@ -691,34 +1309,64 @@ func (root *state) decodeNumber(p Parameter, bits, index, offset int) (Field, er
 		mask = (1 << bits) - 1
 	}
 	if n := root.Size() / numbit; n < index+need {
-		return Field{}, fmt.Errorf("%w: missing %d bytes (decoding %s.%s)", errShort, (index+need)-n, root.currentBlock(), p)
+		return Field{}, fmt.Errorf("%w: missing %d bytes", ErrShort, (index+need)-n)
 	}
 	raw := Field{
-		Id:  p.id.Literal,
-		Pos: root.Pos,
-		Len: bits,
+		Id:     p.id.Literal,
+		Pos:    root.Pos,
+		Len:    bits,
+		kind:   p.is(),
+		endian: kwBig,
+	}
+	if p.endian.Literal != "" {
+		raw.endian = p.endian.Literal
+	} else if root.bitOrderOf(p) == LSBFirst {
+		raw.endian = kwLittle
+	}
+	if bits > 64 {
+		return root.decodeBigNumber(p, raw, index, need, offset, shift)
+	}
+	var dat uint64
+	if root.bitOrderOf(p) == LSBFirst {
+		// the bytes of the field are already in little endian order
+		dat = ltoi(root.buffer[index:index+need], offset, mask)
+		if p.endian.Literal == kwBig {
+			dat = swapBits(dat, bits)
+		}
+	} else {
+		dat = btoi(root.buffer[index:index+need], shift, mask)
+		if p.endian.Literal == kwLittle {
+			dat = swapBits(dat, bits)
+		}
+	}
+	if p.parity.Literal != "" && !checkParity(p.parity.Literal, dat, bits) {
+		root.parity++
+		root.fail(p.id.Literal, failParity)
+	}
+	for _, t := range p.transform {
+		dat = transformBits(t.Literal, dat, bits)
 	}
-	var (
-		buf = swapBytes(root.buffer[index:index+need], p.endian.Literal)
-		dat = btoi(buf, shift, mask)
-	)
 	switch kind := p.is(); kind {
 	case kindInt: // signed integer
 		raw.raw = &Int{
-			Raw: int64(dat),
+			Raw: signExtend(p.encoding.Literal, dat, bits),
 		}
 	case kindUint: // unsigned integer
 		raw.raw = &Uint{
 			Raw: dat,
 		}
 	case kindFloat: // float
+		f, err := decodeFloat(dat, bits)
+		if err != nil {
+			return Field{}, err
+		}
 		raw.raw = &Real{
-			Raw: math.Float64frombits(dat),
+			Raw: f,
 		}
 	case kindUnix, kindGPS:
 		when := time.Unix(int64(dat), 0).UTC()
 		if kind == kindGPS {
-			when = convertTimeGPS(when)
+			when = convertTimeGPS(int64(dat))
 		}
 		raw.raw = &Time{
 			Raw: when,
@@ -729,15 +1377,85 @@ func (root *state) decodeNumber(p Parameter, bits, index, offset int) (Field, er
 	return raw, nil
 }
 
+// decodeFloat converts the bits of an IEEE 754 binary16, binary32 or binary64
+// number.
+func decodeFloat(dat uint64, bits int) (float64, error) {
+	switch bits {
+	case 16:
+		return float16frombits(uint16(dat)), nil
+	case 32:
+		return float64(math.Float32frombits(uint32(dat))), nil
+	case 64:
+		return math.Float64frombits(dat), nil
+	default:
+		return 0, fmt.Errorf("float of %d bits not supported (16, 32 or 64)", bits)
+	}
+}
+
+func float16frombits(b uint16) float64 {
+	var (
+		sign = 1.0
+		exp  = int(b>>10) & 0x1F
+		frac = float64(b & 0x3FF)
+	)
+	if b&0x8000 != 0 {
+		sign = -1
+	}
+	switch exp {
+	case 0: // zero and subnormal numbers
+		return sign * math.Ldexp(frac, -24)
+	case 0x1F:
+		if frac != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	default:
+		return sign * math.Ldexp(1024+frac, exp-25)
+	}
+}
+
+func (root *state) decodeBigNumber(p Parameter, raw Field, index, need, offset, shift int) (Field, error) {
+	switch kind := p.is(); kind {
+	case kindInt, kindUint:
+	default:
+		return Field{}, fmt.Errorf("%s: %s can not be wider than 64 bits", p, kind)
+	}
+	if len(p.transform) > 0 {
+		return Field{}, fmt.Errorf("%s: bit transforms not supported on fields wider than 64 bits", p)
+	}
+	var val *big.Int
+	if root.bitOrderOf(p) == LSBFirst {
+		val = bigLtoi(root.buffer[index:index+need], offset, raw.Len)
+		if p.endian.Literal == kwBig {
+			val = swapBigBits(val, raw.Len)
+		}
+	} else {
+		mask := new(big.Int).Lsh(big.NewInt(1), uint(raw.Len))
+		mask.Sub(mask, big.NewInt(1))
+		val = new(big.Int).SetBytes(root.buffer[index : index+need])
+		val.Rsh(val, uint(shift)).And(val, mask)
+		if p.endian.Literal == kwLittle {
+			val = swapBigBits(val, raw.Len)
+		}
+	}
+	if p.is() == kindInt && val.Bit(raw.Len-1) == 1 {
+		signExtendBig(p.encoding.Literal, val, raw.Len)
+	}
+	raw.raw = &BigInt{Raw: val}
+	return raw, nil
+}
+
 func (root *state) decodeLet(e Let) (Field, error) {
 	v, err := eval(e.expr, root)
 	if err != nil {
 		return Field{}, err
 	}
 	f := Field{
-		Id:  e.id.Literal,
-		raw: v,
-		eng: v,
+		Id:      e.id.Literal,
+		Pos:     root.Pos,
+		raw:     v,
+		eng:     v,
+		derived: true,
 	}
 	return f, nil
 }
@@ -795,27 +1513,32 @@ func (root *state) decodeIf(i If) error {
 
 func (root *state) decodeMatch(n Match) error {
 	var (
-		node Node
-		err  error
+		subject Value
+		mc      MatchCase
+		ok      bool
+		err     error
 	)
 	if n.expr == nil {
-		node, err = root.matchExpr(n)
+		mc, ok, err = root.matchExpr(n)
 	} else {
-		node, err = root.matchIdent(n)
+		if subject, err = eval(n.expr, root); err != nil {
+			return err
+		}
+		mc, ok, err = root.matchIdent(n, subject)
 	}
 	if err != nil {
 		return err
 	}
 
-	if node == nil {
-		if pos := n.alt.Pos(); !pos.IsValid() {
+	if !ok {
+		if n.alt.node == nil {
 			return nil
 		}
-		node = n.alt.node
+		mc = n.alt
 	}
 
 	var dat Block
-	switch n := node.(type) {
+	switch n := mc.node.(type) {
 	case Reference:
 		dat, err = root.ResolveBlock(n.id.Literal)
 	case Block:
@@ -823,40 +1546,120 @@ func (root *state) decodeMatch(n Match) error {
 	default:
 		return fmt.Errorf("decoding match: unexpected node type %T", n)
 	}
-	if err == nil {
-		err = root.decodeBlock(dat)
+	if err != nil {
+		return err
+	}
+	if mc.bind.Literal == "" {
+		return root.decodeBlock(dat)
+	}
+	// the bound value is only visible in the block of the case
+	ix := len(root.Fields)
+	root.Fields = append(root.Fields, Field{
+		Id:  mc.bind.Literal,
+		raw: subject,
+		eng: subject,
+	})
+	err = root.decodeBlock(dat)
+	if ix < len(root.Fields) && root.Fields[ix].Id == mc.bind.Literal {
+		root.Fields = append(root.Fields[:ix], root.Fields[ix+1:]...)
 	}
 	return err
 }
 
-func (root *state) matchIdent(n Match) (Node, error) {
-	e, err := eval(n.expr, root)
-	if err != nil {
-		return nil, err
-	}
+func (root *state) matchIdent(n Match, subject Value) (MatchCase, bool, error) {
 	for _, c := range n.nodes {
+		ok, err := root.matchCase(subject, c)
+		if err != nil {
+			return c, false, err
+		}
+		if ok {
+			return c, true, nil
+		}
+	}
+	return MatchCase{}, false, nil
+}
+
+// matchCase compares the subject of a match with the value of a case. String
+// and bytes subjects are compared byte per byte with string, bytes and
+// hexadecimal literals. A prefix case matches subjects starting with its
+// value. A range case matches subjects between its bounds (inclusive).
+func (root *state) matchCase(subject Value, c MatchCase) (bool, error) {
+	switch subject.(type) {
+	case *String, *Bytes:
+		if c.upto != nil {
+			return false, fmt.Errorf("match: range case on non numeric value")
+		}
+		want, err := root.caseBytes(c.cond)
+		if err != nil {
+			return false, err
+		}
+		got := valueBytes(subject)
+		if c.prefix {
+			return bytes.HasPrefix(got, want), nil
+		}
+		return bytes.Equal(got, want), nil
+	default:
+		if c.prefix {
+			return false, fmt.Errorf("match: prefix case on non string value")
+		}
 		r, err := eval(c.cond, root)
 		if err != nil {
-			return nil, err
+			return false, err
+		}
+		if c.upto == nil {
+			subject, r = promote(subject, r)
+			return subject.Cmp(r) == 0, nil
 		}
-		if e.Cmp(r) == 0 {
-			return c.node, nil
+		u, err := eval(c.upto, root)
+		if err != nil {
+			return false, err
 		}
+		lo, r := promote(subject, r)
+		hi, u := promote(subject, u)
+		return lo.Cmp(r) >= 0 && hi.Cmp(u) <= 0, nil
 	}
-	return nil, nil
 }
 
-func (root *state) matchExpr(n Match) (Node, error) {
+func (root *state) caseBytes(e Expression) ([]byte, error) {
+	if i, ok := e.(Literal); ok && i.id.Type == Integer {
+		str := strings.ToLower(i.id.Literal)
+		if strings.HasPrefix(str, "0x") {
+			str = str[2:]
+			if len(str)%2 == 1 {
+				str = "0" + str
+			}
+			return hex.DecodeString(str)
+		}
+	}
+	v, err := eval(e, root)
+	if err != nil {
+		return nil, err
+	}
+	return valueBytes(v), nil
+}
+
+func valueBytes(v Value) []byte {
+	switch v := v.(type) {
+	case *String:
+		return []byte(v.Raw)
+	case *Bytes:
+		return v.Raw
+	default:
+		return []byte(asString(v))
+	}
+}
+
+func (root *state) matchExpr(n Match) (MatchCase, bool, error) {
 	for _, c := range n.nodes {
 		e, err := eval(c.cond, root)
 		if err != nil {
-			return nil, err
+			return c, false, err
 		}
 		if isTrue(e) {
-			return c.node, nil
+			return c, true, nil
 		}
 	}
-	return nil, nil
+	return MatchCase{}, false, nil
 }
 
 func (root *state) decodeContinue(n Continue) error {
@@ -865,7 +1668,7 @@ func (root *state) decodeContinue(n Continue) error {
 		return err
 	}
 	if isTrue(v) {
-		err = errContinue
+		err = jump(errContinue, n.label)
 	}
 	return err
 }
@@ -876,11 +1679,46 @@ func (root *state) decodeBreak(n Break) error {
 		return err
 	}
 	if isTrue(v) {
-		err = errBreak
+		err = jump(errBreak, n.label)
 	}
 	return err
 }
 
+// jumpError is a break or continue targeting the repeat with the given label.
+type jumpError struct {
+	err   error
+	label string
+}
+
+func jump(err error, label Token) error {
+	if label.Literal == "" {
+		return err
+	}
+	return &jumpError{err: err, label: label.Literal}
+}
+
+func (j *jumpError) Error() string {
+	return fmt.Sprintf("%s %s", j.err, j.label)
+}
+
+func (j *jumpError) Unwrap() error {
+	return j.err
+}
+
+// isJump reports whether err is the target control flow error and whether it
+// should be handled by the repeat with the given label. Errors without label
+// are handled by the innermost repeat.
+func isJump(err, target error, label string) bool {
+	if !errors.Is(err, target) {
+		return false
+	}
+	var j *jumpError
+	if errors.As(err, &j) {
+		return j.label == label
+	}
+	return true
+}
+
 func (root *state) decodePeek(n Peek) error {
 	v, err := eval(n.count, root)
 	if err != nil {
@@ -895,6 +1733,13 @@ func (root *state) decodeSeek(n Seek) error {
 		return err
 	}
 	seek := int(asInt(v))
+	if n.end {
+		end, err := root.frameEnd()
+		if err != nil {
+			return err
+		}
+		seek += end - root.Pos
+	}
 	if err := root.growBuffer(seek); err != nil {
 		return err
 	}
@@ -909,6 +1754,25 @@ func (root *state) decodeSeek(n Seek) error {
 	return nil
 }
 
+// decodeMark saves the current position under the name of n or, with restore,
+// goes back to the position saved. The positions are forgotten at the end of
+// each packet.
+func (root *state) decodeMark(n Mark) error {
+	if !n.restore {
+		if root.marks == nil {
+			root.marks = make(map[string]int)
+		}
+		root.marks[n.id.Literal] = root.Pos
+		return nil
+	}
+	pos, ok := root.marks[n.id.Literal]
+	if !ok {
+		return fmt.Errorf("%s: %s: position not marked", restoreDecl, n.id.Literal)
+	}
+	root.Pos = pos
+	return nil
+}
+
 func (root *state) decodeRepeat(n Repeat) error {
 	var (
 		dat Block
@@ -923,37 +1787,180 @@ func (root *state) decodeRepeat(n Repeat) error {
 	if err != nil {
 		return err
 	}
-	var eval func(Expression, Block) error
-	if n.repeat.isBoolean() {
+	var step int
+	if n.step != nil {
+		v, err := eval(n.step, root)
+		if err != nil {
+			return err
+		}
+		if step = int(asInt(v)); step <= 0 {
+			return fmt.Errorf("repeat: step should be greater than 0 (%d)", step)
+		}
+	}
+	var eval func(Expression, Block, string) error
+	if n.within {
+		eval = root.evalRepeatWithin
+	} else if n.repeat.isBoolean() {
 		eval = root.evalRepeatBool
 	} else {
 		eval = root.evalRepeatUint
 	}
+	root.loops = append(root.loops, loop{label: n.label.Literal, step: step, every: n.every})
 	root.Iter = 0
-	return eval(n.repeat, dat)
+
+	err = eval(n.repeat, dat, n.label.Literal)
+
+	root.loops = root.loops[:len(root.loops)-1]
+	if n := len(root.loops); n > 0 {
+		root.Iter = root.loops[n-1].iter
+	}
+	return err
+}
+
+// loop keeps the number of iterations done by a repeat. Nested repeats are
+// stacked so that their counters are available with $Iter0, $Iter1,...
+type loop struct {
+	label string
+	iter  int
+	step  int
+	every int
 }
 
-func (root *state) evalRepeatBool(expr Expression, dat Block) error {
+// decodeIteration decodes one iteration of the current repeat. With a step, the
+// bits of the slot not consumed by the block are skipped, even after a
+// continue or a break of the repeat.
+func (root *state) decodeIteration(dat Block) error {
+	var (
+		pos = root.Pos
+		l   = root.loops[len(root.loops)-1]
+		err error
+	)
+	if l.every > 1 && l.iter%l.every != 0 {
+		err = root.skipIteration(dat, l.step)
+	} else {
+		err = root.decodeBlock(dat)
+	}
+	if l.step == 0 {
+		return err
+	}
+	if err != nil && !isJump(err, errContinue, l.label) && !isJump(err, errBreak, l.label) {
+		return err
+	}
+	if n := root.Pos - pos; n > l.step {
+		return fmt.Errorf("repeat: %d bits consumed by %s but step is %d", n, dat.id.Literal, l.step)
+	}
+	if e := root.growBuffer(pos + l.step - root.Pos); e != nil {
+		return e
+	}
+	if root.Pos = pos + l.step; root.Pos > root.Size() {
+		return fmt.Errorf("%w: step of %d bits goes past the end of the packet", ErrShort, l.step)
+	}
+	return err
+}
+
+// skipIteration moves past an iteration dropped by decimate. Its size is given
+// by the step of the repeat or by the fields of the block when they all have a
+// fixed size. Otherwise, the iteration is decoded and its fields are dropped.
+func (root *state) skipIteration(dat Block, step int) error {
+	if step > 0 {
+		return nil
+	}
+	segs, ok := layoutSegments(dat, root.Block)
+	if !ok {
+		n := len(root.Fields)
+		err := root.decodeBlock(dat)
+		root.Fields = root.Fields[:n]
+		return err
+	}
+	var size int
+	for _, s := range segs {
+		size += s.bits
+	}
+	if err := root.checkFrame(size); err != nil {
+		return err
+	}
+	if err := root.growBuffer(size); err != nil {
+		return err
+	}
+	if avail := root.Size() - root.Pos; avail < size {
+		return fmt.Errorf("%w: want %d bits, only %d available", ErrShort, size, avail)
+	}
+	root.Pos += size
+	return nil
+}
+
+func (root *state) iterate() {
+	root.Iter++
+	if n := len(root.loops); n > 0 {
+		root.loops[n-1].iter = root.Iter
+	}
+}
+
+// iterOf returns the counter of the repeat with the given label.
+func (root *state) iterOf(label string) (int, error) {
+	for i := len(root.loops) - 1; i >= 0; i-- {
+		if root.loops[i].label == label {
+			return root.loops[i].iter, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: no repeat with this label", label)
+}
+
+func (root *state) evalRepeatBool(expr Expression, dat Block, label string) error {
 	var (
 		val Value
 		err error
 	)
 	for val, err = eval(expr, root); err == nil && isTrue(val); val, err = eval(expr, root) {
-		if err = root.decodeBlock(dat); err != nil {
-			if errors.Is(err, errContinue) {
+		if err = root.decodeIteration(dat); err != nil {
+			if isJump(err, errContinue, label) {
+				err = nil
 				continue
 			}
-			if errors.Is(err, errBreak) {
+			if isJump(err, errBreak, label) {
 				err = nil
 			}
 			break
 		}
-		root.Iter++
+		root.iterate()
 	}
 	return err
 }
 
-func (root *state) evalRepeatUint(expr Expression, dat Block) error {
+// evalRepeatWithin decodes the block until the number of bits given by expr
+// has been consumed. It is an error if the last iteration goes past the limit.
+func (root *state) evalRepeatWithin(expr Expression, dat Block, label string) error {
+	v, err := eval(expr, root)
+	if err != nil {
+		return err
+	}
+	var (
+		limit  = int(asUint(v))
+		offset = root.Pos
+	)
+	for root.Pos-offset < limit {
+		pos := root.Pos
+		if err = root.decodeIteration(dat); err != nil {
+			if isJump(err, errContinue, label) {
+				err = nil
+			} else if isJump(err, errBreak, label) {
+				return nil
+			} else {
+				return err
+			}
+		}
+		if root.Pos == pos {
+			return fmt.Errorf("repeat: no bits consumed by %s", dat.id.Literal)
+		}
+		root.iterate()
+	}
+	if n := root.Pos - offset; n > limit {
+		return fmt.Errorf("repeat: %d bits consumed but only %d available", n, limit)
+	}
+	return nil
+}
+
+func (root *state) evalRepeatUint(expr Expression, dat Block, label string) error {
 	v, err := eval(expr, root)
 	if err != nil {
 		return err
@@ -963,16 +1970,17 @@ func (root *state) evalRepeatUint(expr Expression, dat Block) error {
 		repeat++
 	}
 	for i := uint64(0); i < repeat; i++ {
-		if err = root.decodeBlock(dat); err != nil {
-			if errors.Is(err, errContinue) {
+		if err = root.decodeIteration(dat); err != nil {
+			if isJump(err, errContinue, label) {
+				err = nil
 				continue
 			}
-			if errors.Is(err, errBreak) {
+			if isJump(err, errBreak, label) {
 				err = nil
 			}
 			break
 		}
-		root.Iter++
+		root.iterate()
 	}
 	return err
 }
@@ -1032,6 +2040,9 @@ func (root *state) evalApply(v Field, n Node) (Field, error) {
 	if err == nil {
 		v.eng = x
 	}
+	if _, ok := x.(*String); err == nil && !ok && pair.kind.Literal == kwEnum {
+		root.fail(v.Id, failEnum)
+	}
 	return v, err
 }
 
@@ -1039,7 +2050,7 @@ func (root *state) evalPoint(cs []Constant, v Value) (Value, error) {
 	raw := asInt(v)
 	for i := 0; i < len(cs); i++ {
 		c := cs[i]
-		id, _ := strconv.ParseInt(c.id.Literal, 0, 64)
+		id := pairKey(c.id)
 		if raw == id {
 			val, err := eval(c.value, root)
 			if err != nil {
@@ -1050,7 +2061,7 @@ func (root *state) evalPoint(cs []Constant, v Value) (Value, error) {
 			}, nil
 		}
 		if j := i + 1; j < len(cs) {
-			next, _ := strconv.ParseInt(cs[j].id.Literal, 0, 64)
+			next := pairKey(cs[j].id)
 			if id < raw && raw < next {
 				// linear interpolation
 				break
@@ -1063,7 +2074,7 @@ func (root *state) evalPoint(cs []Constant, v Value) (Value, error) {
 func (root *state) evalEnum(cs []Constant, v Value) (Value, error) {
 	raw := asInt(v)
 	for _, c := range cs {
-		id, _ := strconv.ParseInt(c.id.Literal, 0, 64)
+		id := pairKey(c.id)
 		if raw == id {
 			str, err := eval(c.value, root)
 			if err != nil {
@@ -1078,6 +2089,17 @@ func (root *state) evalEnum(cs []Constant, v Value) (Value, error) {
 	return v, nil
 }
 
+// pairKey returns the value of the key of an enum or a pointpair. Keys too
+// large for an int64, eg the bits of a float64, wrap like asInt does.
+func pairKey(tok Token) int64 {
+	id, err := strconv.ParseInt(tok.Literal, 0, 64)
+	if err != nil {
+		u, _ := strconv.ParseUint(tok.Literal, 0, 64)
+		id = int64(u)
+	}
+	return id
+}
+
 func (root *state) evalPoly(cs []Constant, v Value) (Value, error) {
 	var (
 		raw = asReal(v)
@@ -1116,22 +2138,52 @@ func resolveValues(root *state, vs []Token) []Field {
 	return xs
 }
 
-func swapBytes(buf []byte, e string) []byte {
-	if e == kwLittle {
-		dat := make([]byte, len(buf))
-		if n := len(buf); n <= 8 && n%2 == 0 {
-			for i := 0; i < n; i++ {
-				dat[n-1-i] = buf[i]
-			}
-		} else {
-			copy(dat, buf)
+// swapBits reverses the order of the bytes of the little endian number made
+// of the bits lowest bits of v. The bytes are the groups of 8 bits starting at
+// the first bit of the field: the first one is the least significant. When bits
+// is not a multiple of 8, the last group is shorter and holds the most
+// significant bits.
+func swapBits(v uint64, bits int) uint64 {
+	var r uint64
+	for shift := 0; bits > 0; shift += numbit {
+		n := numbit
+		if bits < n {
+			n = bits
 		}
-		buf = dat
+		bits -= n
+		r |= ((v >> uint(bits)) & (1<<uint(n) - 1)) << uint(shift)
 	}
-	return buf
+	return r
 }
 
+// swapBigBits is swapBits for numbers wider than 64 bits.
+func swapBigBits(v *big.Int, bits int) *big.Int {
+	var (
+		r    = new(big.Int)
+		grp  = new(big.Int)
+		mask = big.NewInt(1<<numbit - 1)
+	)
+	for shift := 0; bits > 0; shift += numbit {
+		n := numbit
+		if bits < n {
+			n = bits
+			mask.SetInt64(1<<uint(n) - 1)
+		}
+		bits -= n
+		grp.Rsh(v, uint(bits)).And(grp, mask)
+		r.Or(r, grp.Lsh(grp, uint(shift)))
+	}
+	return r
+}
+
+// btoi reads buf as a big endian number and returns its bits selected by shift
+// and mask. buf is at most 9 bytes long: a field of 64 bits not aligned on a
+// byte spans 9 bytes.
 func btoi(buf []byte, shift, mask int) uint64 {
+	var hi uint64
+	if len(buf) > 8 {
+		hi, buf = uint64(buf[0]), buf[1:]
+	}
 	var (
 		u uint64
 		n = len(buf)
@@ -1140,7 +2192,128 @@ func btoi(buf []byte, shift, mask int) uint64 {
 		n := uint64(buf[i]) << (numbit * (n - (i + 1)))
 		u += n
 	}
-	return (u >> uint64(shift)) & uint64(mask)
+	u >>= uint64(shift)
+	if hi != 0 {
+		u |= hi << (64 - uint64(shift))
+	}
+	return u & uint64(mask)
+}
+
+const (
+	transGray    = "gray"
+	transBitRev  = "bitrev"
+	transNibSwap = "nibswap"
+)
+
+func isTransform(str string) bool {
+	switch str {
+	case transGray, transBitRev, transNibSwap:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	signTwos = "twos"
+	signOnes = "ones"
+	signMag  = "signmag"
+)
+
+func isSignEncoding(str string) bool {
+	switch str {
+	case signTwos, signOnes, signMag:
+		return true
+	default:
+		return false
+	}
+}
+
+// signExtend returns the signed integer encoded in the bits lowest bits of v.
+// The encoding is two's complement unless enc is ones' complement or
+// sign-magnitude.
+func signExtend(enc string, v uint64, bits int) int64 {
+	if bits <= 0 || bits > 64 {
+		return int64(v)
+	}
+	var (
+		width = uint(bits)
+		mask  = uint64(1)<<width - 1
+		sign  = v >> (width - 1) & 1
+	)
+	switch enc {
+	case signMag:
+		mag := int64(v & (mask >> 1))
+		if sign == 1 {
+			return -mag
+		}
+		return mag
+	case signOnes:
+		if sign == 1 {
+			return -int64(^v & mask)
+		}
+		return int64(v)
+	default:
+		shift := 64 - width
+		return int64(v<<shift) >> shift
+	}
+}
+
+// signBits is the reverse of signExtend: it returns the bits lowest bits
+// encoding v. The negative zero of the ones' complement and sign-magnitude
+// encodings is given as zero.
+func signBits(enc string, v int64, bits int) uint64 {
+	if bits <= 0 || bits > 64 {
+		return uint64(v)
+	}
+	mask := lowBits(bits)
+	if v >= 0 {
+		return uint64(v) & mask
+	}
+	switch enc {
+	case signMag:
+		return (uint64(1)<<uint(bits-1) | uint64(-v)) & mask
+	case signOnes:
+		return ^uint64(-v) & mask
+	default:
+		return uint64(v) & mask
+	}
+}
+
+// signExtendBig is signExtend for a negative integer wider than 64 bits.
+func signExtendBig(enc string, v *big.Int, bits int) {
+	switch enc {
+	case signMag:
+		v.SetBit(v, bits-1, 0).Neg(v)
+	case signOnes:
+		max := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+		v.Sub(v, max.Sub(max, big.NewInt(1)))
+	default:
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+	}
+}
+
+// transformBits applies the transform t to the bits lowest bits of v.
+func transformBits(t string, v uint64, bits int) uint64 {
+	switch t {
+	case transGray:
+		for shift := uint(1); shift < uint(bits); shift <<= 1 {
+			v ^= v >> shift
+		}
+	case transBitRev:
+		var r uint64
+		for i := 0; i < bits; i++ {
+			r = (r << 1) | (v & 1)
+			v >>= 1
+		}
+		v = r
+	case transNibSwap:
+		v = ((v & 0x0F0F0F0F0F0F0F0F) << 4) | ((v >> 4) & 0x0F0F0F0F0F0F0F0F)
+		if bits < 64 {
+			v &= (1 << uint(bits)) - 1
+		}
+	}
+	return v
 }
 
 func numbytes(bits int) int {
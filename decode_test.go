@@ -0,0 +1,98 @@
+package dissect
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecodeLittleEndian(t *testing.T) {
+	tests := []struct {
+		Pad   int // bits before the field
+		Tail  int // bits after the field
+		Field string
+		Data  []byte
+		Want  int64
+	}{
+		{Field: "uint 24 little", Data: []byte{0x01, 0x02, 0x03}, Want: 0x030201},
+		{Field: "uint 24 big", Data: []byte{0x01, 0x02, 0x03}, Want: 0x010203},
+		{Field: "int 24 little", Data: []byte{0xff, 0xff, 0xff}, Want: -1},
+		{Field: "int 24 little", Data: []byte{0x00, 0x00, 0x80}, Want: -0x800000},
+		{Field: "uint 40 little", Data: []byte{0x01, 0x02, 0x03, 0x04, 0x05}, Want: 0x0504030201},
+		{Field: "int 40 little", Data: []byte{0x00, 0x00, 0x00, 0x00, 0x80}, Want: -1 << 39},
+		{Field: "uint 48 little", Data: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, Want: 0x060504030201},
+		{Field: "int 48 little", Data: []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff}, Want: -2},
+		{Tail: 4, Field: "uint 20 little", Data: []byte{0x12, 0x34, 0x50}, Want: 0x53412},
+		{Pad: 4, Tail: 4, Field: "uint 24 little", Data: []byte{0xa1, 0x23, 0x45, 0x60}, Want: 0x563412},
+		{Pad: 4, Tail: 4, Field: "uint 40 little", Data: []byte{0xa0, 0x10, 0x20, 0x30, 0x40, 0x50}, Want: 0x0504030201},
+		{Pad: 4, Tail: 4, Field: "int 40 little", Data: []byte{0xa0, 0x00, 0x00, 0x00, 0x08, 0x00}, Want: -1 << 39},
+		{Pad: 3, Tail: 5, Field: "uint 48 little", Data: []byte{0xa1, 0x41, 0x61, 0x81, 0xa1, 0xc1, 0xe0}, Want: 0x0f0e0d0c0b0a},
+	}
+	for _, tt := range tests {
+		var str strings.Builder
+		str.WriteString("data (\n")
+		if tt.Pad > 0 {
+			fmt.Fprintf(&str, "  pad: uint %d\n", tt.Pad)
+		}
+		fmt.Fprintf(&str, "  value: %s\n", tt.Field)
+		if tt.Tail > 0 {
+			fmt.Fprintf(&str, "  tail: uint %d\n", tt.Tail)
+		}
+		str.WriteString(")\n")
+
+		var got struct {
+			Value int64 `dissect:"value"`
+		}
+		if err := Unmarshal(strings.NewReader(str.String()), tt.Data, &got); err != nil {
+			t.Errorf("%s (offset %d): unexpected error: %s", tt.Field, tt.Pad, err)
+			continue
+		}
+		if got.Value != tt.Want {
+			t.Errorf("%s (offset %d): want %#x, got %#x", tt.Field, tt.Pad, tt.Want, got.Value)
+		}
+	}
+}
+
+func TestSwapBits(t *testing.T) {
+	tests := []struct {
+		Value uint64
+		Bits  int
+		Want  uint64
+	}{
+		{Value: 0x12, Bits: 8, Want: 0x12},
+		{Value: 0x010203, Bits: 24, Want: 0x030201},
+		{Value: 0x0102030405, Bits: 40, Want: 0x0504030201},
+		{Value: 0x010203040506, Bits: 48, Want: 0x060504030201},
+		{Value: 0x0102030405060708, Bits: 64, Want: 0x0807060504030201},
+		{Value: 0x12345, Bits: 20, Want: 0x53412},
+	}
+	for _, tt := range tests {
+		if got := swapBits(tt.Value, tt.Bits); got != tt.Want {
+			t.Errorf("%#x (%d bits): want %#x, got %#x", tt.Value, tt.Bits, tt.Want, got)
+		}
+	}
+}
+
+func TestEcho(t *testing.T) {
+	tests := []struct {
+		Name string
+		Echo string
+		Want string
+	}{
+		{Name: "text", Echo: `"id"`, Want: "id\r\n"},
+		{Name: "expression", Echo: `"id = %[id + 1]"`, Want: "id = 3\r\n"},
+		{
+			Name: "heredoc",
+			Echo: "<<END\n    id = %[id]\n    next = %[id + 1]\n    END",
+			Want: "id = 2\r\nnext = 3\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			script := fmt.Sprintf("data (\n  id: uint 8\n  echo %s\n)\n", tt.Echo)
+			if got := dissectString(t, script, []byte{2}); got != tt.Want {
+				t.Errorf("output mismatched:\nwant: %q\ngot:  %q", tt.Want, got)
+			}
+		})
+	}
+}
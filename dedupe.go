@@ -0,0 +1,74 @@
+package dissect
+
+import (
+	"strings"
+)
+
+const (
+	dedupeBy      = "by"
+	dedupeWindow  = "window"
+	defaultWindow = 1000
+)
+
+// nodeKey identifies a statement of a script: the same position can be found
+// in each of the files included.
+type nodeKey struct {
+	file string
+	pos  Position
+}
+
+// window remembers the keys of the last records seen by a dedupe statement.
+type window struct {
+	keys  []string
+	next  int
+	count map[string]int
+}
+
+func newWindow(size int) *window {
+	return &window{
+		keys:  make([]string, 0, size),
+		count: make(map[string]int),
+	}
+}
+
+// Seen reports whether key is already in the window before adding it, evicting
+// the oldest key when the window is full.
+func (w *window) Seen(key string) bool {
+	seen := w.count[key] > 0
+	if len(w.keys) < cap(w.keys) {
+		w.keys = append(w.keys, key)
+	} else {
+		old := w.keys[w.next]
+		if w.count[old]--; w.count[old] <= 0 {
+			delete(w.count, old)
+		}
+		w.keys[w.next] = key
+		w.next = (w.next + 1) % len(w.keys)
+	}
+	w.count[key]++
+	return seen
+}
+
+func (root *state) decodeDedupe(d Dedupe) error {
+	parts := make([]string, len(d.fields))
+	for i, f := range d.fields {
+		v, err := root.ResolveValue(f.Literal)
+		if err != nil {
+			return err
+		}
+		parts[i] = asString(v.Raw())
+	}
+	if root.windows == nil {
+		root.windows = make(map[nodeKey]*window)
+	}
+	key := nodeKey{file: d.file, pos: d.pos}
+	w, ok := root.windows[key]
+	if !ok {
+		w = newWindow(d.window)
+		root.windows[key] = w
+	}
+	if w.Seen(strings.Join(parts, "\x00")) {
+		root.drop = true
+	}
+	return nil
+}
@@ -36,6 +36,7 @@ const (
 	ShiftRight
 	BitAnd
 	BitOr
+	Range
 	Newline
 	Illegal
 )
@@ -59,6 +60,7 @@ const (
 	langle     = '<'
 	rangle     = '>'
 	quote      = '"'
+	backquote  = '`'
 	ampersand  = '&'
 	pipe       = '|'
 	minus      = '-'
@@ -103,9 +105,46 @@ func (k Kind) String() string {
 		return fmt.Sprintf("time(%s)", kwGPS)
 	case kindUnix:
 		return fmt.Sprintf("time(%s)", kwUnix)
+	case kindCUC:
+		return fmt.Sprintf("time(%s)", timeCUC)
+	case kindCDS:
+		return fmt.Sprintf("time(%s)", timeCDS)
 	}
 }
 
+// Version is the version of the language supported by the package. Scripts
+// can give the version they need with a "dissect" pragma.
+const Version = "1.0"
+
+const pragmaVersion = "dissect"
+
+const matchPrefix = "prefix"
+
+// seekEnd makes the offset of seek relative to the end of the frame; mark and
+// restore save and go back to a position of the packet.
+const (
+	seekEnd     = "end"
+	markDecl    = "mark"
+	restoreDecl = "restore"
+)
+
+const (
+	repeatWithin   = "within"
+	repeatStep     = "step"
+	repeatDecimate = "decimate"
+)
+
+// words introducing the explicit forms of the destinations of print, echo, copy
+// and sink.
+const (
+	destField = "field"
+	destConst = "const"
+)
+
+// constRef is the type of the destination tokens naming a defined constant.
+// They are replaced by the value of the constant when the script is merged.
+const constRef = Illegal - 1
+
 const (
 	methRaw   = "raw"
 	methEng   = "eng"
@@ -116,9 +155,11 @@ const (
 )
 
 const (
-	fmtCSV   = "csv"
-	fmtTuple = "tuple"
-	fmtSexp  = "sexp"
+	fmtCSV    = "csv"
+	fmtTuple  = "tuple"
+	fmtSexp   = "sexp"
+	fmtJSON   = "json"
+	fmtInflux = "influx"
 )
 
 const (
@@ -131,6 +172,8 @@ const (
 	kindTime
 	kindGPS
 	kindUnix
+	kindCUC
+	kindCDS
 )
 
 const (
@@ -177,6 +220,10 @@ const (
 	kwElse     = "else"
 	kwCopy     = "copy"
 	kwPush     = "push"
+	kwAnd      = "and"
+	kwSink     = "sink"
+	kwDedupe   = "dedupe"
+	kwCheck    = "check"
 )
 
 var keywords = []string{
@@ -219,6 +266,10 @@ var keywords = []string{
 	kwElse,
 	kwCopy,
 	kwPush,
+	kwAnd,
+	kwSink,
+	kwDedupe,
+	kwCheck,
 }
 
 type Expression interface {
@@ -356,6 +407,8 @@ func TokenString(t Token) string {
 		return "<shift right>"
 	case Cond:
 		return "<conditional>"
+	case Range:
+		return "<range>"
 	case Add:
 		return "<add>"
 	case Min:
@@ -406,6 +459,8 @@ func TokenString(t Token) string {
 		return "<newline>"
 	case Illegal:
 		str = "illegal"
+	case constRef:
+		str = "const"
 	default:
 		str = "punct"
 		lit = string(t.Type)
@@ -8,52 +8,104 @@ import (
 	"strings"
 )
 
-func Stat(r io.Reader) error {
-	n, err := Parse(r)
+// Stat prints the size and the number of parameters of the data block and of
+// each block it includes. The script is merged first so that the blocks and
+// parameters coming from included files are resolved. The size of a block with
+// conditional or repeated parts, or with fields whose size depends on the data,
+// is the size of its fixed part. The options are the ones of the interpreter,
+// only those changing how the script is read are used.
+func Stat(r io.Reader, opts ...Option) error {
+	i, err := newInterpreter(opts...)
+	if err != nil {
+		return err
+	}
+	dat, _, err := i.load(r)
 	if err != nil {
 		return err
 	}
-	block, ok := n.(Block)
-	if !ok {
-		return nil
+	var stats []blockStat
+	statBlock(dat.Block, &stats, make(map[string]struct{}))
+	for _, s := range stats {
+		var variable string
+		if !s.fixed {
+			variable = ", variable size"
+		}
+		fmt.Printf("%16s: %5d bits, %5d bytes, %3d parameters%s\n", s.name, s.size, s.size/numbit, s.count, variable)
+	}
+	return nil
+}
+
+type blockStat struct {
+	name  string
+	size  int64
+	count int
+	fixed bool
+}
+
+// statBlock computes the stats of b, including the blocks it always includes,
+// and appends them to stats the first time a block is seen.
+func statBlock(b Block, stats *[]blockStat, seen map[string]struct{}) blockStat {
+	st := blockStat{
+		name:  b.id.Literal,
+		fixed: true,
+	}
+	_, dup := seen[st.name]
+	if !dup {
+		seen[st.name] = struct{}{}
+		*stats = append(*stats, st)
 	}
-	for _, n := range block.nodes {
-		var bck Block
+	ix := len(*stats) - 1
+	for _, n := range b.nodes {
 		switch n := n.(type) {
+		case Parameter:
+			st.count++
+			if s, ok := layoutParameter(n); ok {
+				st.size += int64(s.bits)
+			} else {
+				st.fixed = false
+			}
 		case Block:
-			bck = n
-		case Data:
-			bck = n.Block
+			x := statBlock(n, stats, seen)
+			st.size += x.size
+			st.count += x.count
+			st.fixed = st.fixed && x.fixed
+		case Include, If, Match, Repeat:
+			st.fixed = false
+			for _, b := range nestedBlocks(n) {
+				statBlock(b, stats, seen)
+			}
+		case Let, Del, Echo, Print, Push, Peek:
 		default:
-			continue
+			st.fixed = false
 		}
-		if bck.id.Literal == kwDeclare || bck.id.Literal == kwDefine {
-			continue
-		}
-		var (
-			size  int64
-			count int
-		)
-		for _, n := range bck.nodes {
-			count++
+	}
+	if b.limit != nil {
+		st.fixed = false
+	}
+	if !dup {
+		(*stats)[ix] = st
+	}
+	return st
+}
 
-			p, ok := n.(Parameter)
-			if !ok {
-				continue
-			}
-			z, _ := strconv.ParseInt(p.size.Literal, 0, 64)
-			switch p.is() {
-			case kindInt, kindUint, kindFloat:
-			case kindString, kindBytes:
-				z *= numbit
-			default:
-				continue
-			}
-			size += z
+func nestedBlocks(n Node) []Block {
+	var bs []Block
+	switch n := n.(type) {
+	case Block:
+		bs = append(bs, n)
+	case Include:
+		bs = nestedBlocks(n.node)
+	case Repeat:
+		bs = nestedBlocks(n.node)
+	case If:
+		bs = append(nestedBlocks(n.csq), nestedBlocks(n.alt)...)
+	case Match:
+		for _, c := range n.nodes {
+			bs = append(bs, nestedBlocks(c.node)...)
 		}
-		fmt.Printf("%16s: %5d bits, %5d bytes, %3d parameters\n", bck.id, size, size/numbit, count)
+		bs = append(bs, nestedBlocks(n.alt.node)...)
 	}
-	return nil
+	return bs
 }
 
 func Dump(n Node) error {
@@ -85,6 +137,15 @@ func dumpNode(n Node, level int) error {
 			expr = n.predicate.String()
 		}
 		fmt.Printf("%sprint(file=%s, format=%s, method=%s, expr=%s, pos=%s)", indent, n.file, n.format, n.method, expr, n.Pos())
+		if n.influx != nil {
+			fmt.Printf(" influx(%s)", n.influx)
+		}
+		for _, o := range n.outputs {
+			fmt.Printf(" and(file=%s, format=%s)", o.file, o.format)
+			if o.influx != nil {
+				fmt.Printf(" influx(%s)", o.influx)
+			}
+		}
 		if len(n.values) > 0 {
 			fmt.Println(" (")
 			for _, n := range n.values {
@@ -92,24 +153,87 @@ func dumpNode(n Node, level int) error {
 			}
 			fmt.Printf("%s)", indent)
 		}
+	case Sink:
+		ps := make([]string, len(n.partition))
+		for i := range n.partition {
+			ps[i] = n.partition[i].Literal
+		}
+		fmt.Printf("%ssink(name=%s, type=%s, file=%s, format=%s, append=%t, rotate=%s, partition=%s, compress=%s, pos=%s)", indent, n.id, n.kind, n.file, n.format, n.append, n.rotate, strings.Join(ps, ", "), n.compress, n.Pos())
 	case Push:
 		expr := "???"
 		if n.expr != nil {
 			expr = n.expr.String()
 		}
 		fmt.Printf("%spush(id=%s, expr=%s, pos=%s)", indent, n.id, expr, n.Pos())
+	case Check:
+		fs := make([]string, len(n.fields))
+		for i := range n.fields {
+			fs[i] = n.fields[i].Literal
+		}
+		fmt.Printf("%scheck(method=%s, fields=%s, pos=%s)", indent, n.kind.Literal, strings.Join(fs, ", "), n.Pos())
+	case Length:
+		fmt.Printf("%slength(expr=%s, pos=%s)", indent, n.expr, n.Pos())
+	case Sync:
+		fmt.Printf("%ssync(marker=%x, pos=%s)", indent, n.marker, n.Pos())
+	case Summary:
+		fmt.Printf("%s%s(name=%s, expr=%s, pos=%s)", indent, n.kind.Literal, n.id.Literal, n.count, n.Pos())
+	case ASN1:
+		fmt.Printf("%sasn1(name=%s, size=%s, pos=%s)", indent, n.id.Literal, n.size, n.Pos())
+	case Extract:
+		fmt.Printf("%stext(format=%s, size=%s, pos=%s)", indent, n.format.Literal, n.size, n.Pos())
+	case Protobuf:
+		var message string
+		if n.message != nil {
+			message = n.message.name
+		}
+		fmt.Printf("%sprotobuf(name=%s, size=%s, file=%s, message=%s, pos=%s)", indent, n.id.Literal, n.size, n.file.Literal, message, n.Pos())
+	case Dedupe:
+		fs := make([]string, len(n.fields))
+		for i := range n.fields {
+			fs[i] = n.fields[i].Literal
+		}
+		fmt.Printf("%sdedupe(fields=%s, window=%d, pos=%s)", indent, strings.Join(fs, ", "), n.window, n.Pos())
 	case Echo:
-		fmt.Printf("%secho(string=%s, pos=%s)", indent, n, n.Pos())
+		fmt.Printf("%secho(file=%s, string=%q, pos=%s)", indent, n.file.Literal, n, n.Pos())
+	case Pragma:
+		fmt.Printf("%spragma(version=%s, pos=%s)", indent, n.version.Literal, n.Pos())
+	case Limits:
+		fmt.Printf("%slimits(file=%s, pos=%s) (\n", indent, n.file.Literal, n.Pos())
+		for _, l := range n.limits {
+			fmt.Printf("%s  %s(", indent, l.id.Literal)
+			for i, t := range l.thresholds {
+				if i > 0 {
+					fmt.Print(", ")
+				}
+				var low, high string
+				if t.low != nil {
+					low = t.low.String()
+				}
+				if t.high != nil {
+					high = t.high.String()
+				}
+				fmt.Printf("%s=[%s .. %s]", t.level.Literal, low, high)
+			}
+			fmt.Println(")")
+		}
+		fmt.Printf("%s)", indent)
 	case Data:
 		fs := make([]string, len(n.files))
 		for i := 0; i < len(n.files); i++ {
 			fs[i] = n.files[i].Literal
 		}
-		fmt.Printf("%sdata(files=%s, pos=%s) (\n", indent, strings.Join(fs, ", "), n.Pos())
+		fmt.Printf("%sdata(files=%s, version=%s, pos=%s) (\n", indent, strings.Join(fs, ", "), n.version, n.Pos())
 		dumpNode(n.Block, level+1)
+		for _, l := range n.limits {
+			dumpNode(l, level+1)
+		}
 		fmt.Printf("%s)", indent)
 	case Block:
-		fmt.Printf("%sblock(name=%s, type=%s, pos=%s) (\n", indent, n.String(), n.blockName(), n.Pos())
+		var limit string
+		if n.limit != nil {
+			limit = fmt.Sprintf(", limit=%s", n.limit)
+		}
+		fmt.Printf("%sblock(name=%s, type=%s%s, pos=%s) (\n", indent, n.String(), n.blockName(), limit, n.Pos())
 		for _, n := range n.nodes {
 			dumpNode(n, level+1)
 		}
@@ -131,7 +255,23 @@ func dumpNode(n Node, level int) error {
 		}
 		fmt.Printf("%s)", indent)
 	case Seek:
-		fmt.Printf("%sseek(offset=%s, pos=%s)", indent, n.offset, n.Pos())
+		fmt.Printf("%sseek(offset=%s, absolute=%t, end=%t, pos=%s)", indent, n.offset, n.absolute, n.end, n.Pos())
+	case Transition:
+		var from, to string
+		if n.from != nil {
+			from = n.from.String()
+		}
+		if n.to != nil {
+			to = n.to.String()
+		}
+		fmt.Printf("%stransition(field=%s, as=%s, from=%s, to=%s, cross=%s, pos=%s)", indent, n.field.Literal, n.bind.Literal, from, to, n.cross.Literal, n.Pos())
+		if n.node != nil {
+			fmt.Print(" (\n")
+			dumpNode(n.node, level+1)
+			fmt.Printf("%s)", indent)
+		}
+	case Mark:
+		fmt.Printf("%s%s(name=%s, pos=%s)", indent, n, n.id.Literal, n.Pos())
 	case Peek:
 		fmt.Printf("%speek(count=%s, pos=%s)", indent, n.count, n.Pos())
 	case If:
@@ -155,7 +295,7 @@ func dumpNode(n Node, level int) error {
 		for _, n := range n.nodes {
 			dumpNode(n, level+1)
 		}
-		if n.alt.Pos().IsValid() {
+		if n.alt.node != nil {
 			dumpNode(n.alt, level+1)
 		}
 		fmt.Printf("%s)", indent)
@@ -164,11 +304,14 @@ func dumpNode(n Node, level int) error {
 		if n.cond != nil {
 			expr = n.cond.String()
 		}
-		fmt.Printf("%scase(cond=%s) (\n", indent, expr)
+		if n.upto != nil {
+			expr = fmt.Sprintf("%s..%s", expr, n.upto)
+		}
+		fmt.Printf("%scase(cond=%s, prefix=%t, bind=%s) (\n", indent, expr, n.prefix, n.bind.Literal)
 		dumpNode(n.node, level+1)
 		fmt.Printf("%s)", indent)
 	case Repeat:
-		fmt.Printf("%srepeat(repeat=%s, pos=%s) (\n", indent, n.repeat, n.Pos())
+		fmt.Printf("%srepeat(label=%s, repeat=%s, within=%t, pos=%s) (\n", indent, n.label.Literal, n.repeat, n.within, n.Pos())
 		dumpNode(n.node, level+1)
 		fmt.Printf("%s)", indent)
 	case Break:
@@ -176,13 +319,13 @@ func dumpNode(n Node, level int) error {
 		if n.expr != nil {
 			predicate = n.expr.String()
 		}
-		fmt.Printf("%sbreak(predicate=%s, pos=%s)", indent, predicate, n.Pos())
+		fmt.Printf("%sbreak(label=%s, predicate=%s, pos=%s)", indent, n.label.Literal, predicate, n.Pos())
 	case Continue:
 		predicate := kwTrue
 		if n.expr != nil {
 			predicate = n.expr.String()
 		}
-		fmt.Printf("%scontinue(predicate=%s, pos=%s)", indent, predicate, n.Pos())
+		fmt.Printf("%scontinue(label=%s, predicate=%s, pos=%s)", indent, n.label.Literal, predicate, n.Pos())
 	case Include:
 		predicate := kwTrue
 		if n.cond != nil {
@@ -194,7 +337,11 @@ func dumpNode(n Node, level int) error {
 	case Reference:
 		fmt.Printf("%sreference(name=%s, alias=%s, pos=%s)", indent, n.alias, n.id, n.Pos())
 	case Parameter:
-		fmt.Printf("%sparameter(name=%s, type=%s, size=%s, pos=%s)", indent, n.id.Literal, n.kind.Literal, n.size.Literal, n.Pos())
+		ts := make([]string, len(n.transform))
+		for i := range n.transform {
+			ts[i] = n.transform[i].Literal
+		}
+		fmt.Printf("%sparameter(name=%s, type=%s, size=%s, transform=%s, parity=%s, encoding=%s, bitorder=%s, expect=%s, pos=%s)", indent, n.id.Literal, n.kind.Literal, n.size.Literal, strings.Join(ts, ", "), n.parity.Literal, n.encoding.Literal, n.bitorder.Literal, n.policy.Literal, n.Pos())
 		if p, ok := n.apply.(Pair); ok {
 			fmt.Print(" (\n")
 			dumpNode(p, level+1)
@@ -220,3 +367,194 @@ func sortNodes(nodes []Node) []Node {
 
 	return ns
 }
+
+const layoutWidth = 32
+
+type segment struct {
+	label string
+	bits  int
+}
+
+// DumpLayout prints for each block of n a diagram of the layout of its fields
+// in the style of the diagrams found in RFCs. Only fields with a size known
+// before decoding can be drawn: the diagram of a block stops at the first
+// field or statement whose size depends on the decoded data.
+func DumpLayout(n Node) error {
+	var (
+		root   Block
+		blocks []Block
+	)
+	switch n := n.(type) {
+	case Data:
+		blocks = append(blocks, n.Block)
+	case Block:
+		root = n
+		for _, n := range n.nodes {
+			switch n := n.(type) {
+			case Block:
+				if n.id.Literal == kwDeclare || n.id.Literal == kwDefine {
+					continue
+				}
+				blocks = append(blocks, n)
+			case Data:
+				blocks = append(blocks, n.Block)
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected node type: %T", n)
+	}
+	for _, b := range blocks {
+		segs, full := layoutSegments(b, root)
+		fmt.Printf("%s (%s)\n", b.id.Literal, b.Pos())
+		printLayout(segs, full)
+		fmt.Println()
+	}
+	return nil
+}
+
+func layoutSegments(b, root Block) ([]segment, bool) {
+	var segs []segment
+	for _, n := range b.nodes {
+		switch n := n.(type) {
+		case Reference:
+			if p, err := root.ResolveParameter(n.id.Literal); err == nil {
+				s, ok := layoutParameter(p)
+				if !ok {
+					return segs, false
+				}
+				segs = append(segs, s)
+				continue
+			}
+			b, err := root.ResolveBlock(n.id.Literal)
+			if err != nil {
+				return segs, false
+			}
+			xs, ok := layoutSegments(b, root)
+			if segs = append(segs, xs...); !ok {
+				return segs, false
+			}
+		case Parameter:
+			s, ok := layoutParameter(n)
+			if !ok {
+				return segs, false
+			}
+			segs = append(segs, s)
+		case Block:
+			xs, ok := layoutSegments(n, root)
+			if segs = append(segs, xs...); !ok {
+				return segs, false
+			}
+		case Include:
+			if n.cond != nil {
+				return segs, false
+			}
+			var (
+				xs []segment
+				ok bool
+			)
+			switch n := n.node.(type) {
+			case Block:
+				xs, ok = layoutSegments(n, root)
+			case Reference:
+				b, err := root.ResolveBlock(n.id.Literal)
+				if err != nil {
+					return segs, false
+				}
+				xs, ok = layoutSegments(b, root)
+			}
+			if segs = append(segs, xs...); !ok {
+				return segs, false
+			}
+		case Let, Del, Echo, Print, Push, Peek:
+		default:
+			return segs, false
+		}
+	}
+	// the bytes left unused by a limited block are not known
+	return segs, b.limit == nil
+}
+
+func layoutParameter(p Parameter) (segment, bool) {
+	if p.size.Type != Integer {
+		return segment{}, false
+	}
+	z, err := strconv.ParseInt(p.size.Literal, 0, 64)
+	if err != nil {
+		return segment{}, false
+	}
+	switch p.is() {
+	case kindString, kindBytes:
+		z *= numbit
+	}
+	for _, d := range p.dims {
+		n, err := strconv.ParseInt(d.Literal, 0, 64)
+		if err != nil {
+			return segment{}, false
+		}
+		z *= n
+	}
+	return segment{label: p.id.Literal, bits: int(z)}, true
+}
+
+func printLayout(segs []segment, full bool) {
+	var (
+		row  strings.Builder
+		col  int
+		line = func(n int) string {
+			return "+" + strings.Repeat("-+", n)
+		}
+	)
+	for i := 0; i < layoutWidth; i++ {
+		if i%10 == 0 {
+			fmt.Printf(" %d", i/10)
+		} else {
+			fmt.Print("  ")
+		}
+	}
+	fmt.Println()
+	for i := 0; i < layoutWidth; i++ {
+		fmt.Printf(" %d", i%10)
+	}
+	fmt.Println()
+	fmt.Println(line(layoutWidth))
+
+	row.WriteRune(pipe)
+	for _, s := range segs {
+		for bits := s.bits; bits > 0; {
+			n := layoutWidth - col
+			if bits < n {
+				n = bits
+			}
+			row.WriteString(centerLabel(s.label, (2*n)-1))
+			row.WriteRune(pipe)
+
+			col += n
+			bits -= n
+			if col == layoutWidth {
+				fmt.Println(row.String())
+				fmt.Println(line(layoutWidth))
+				row.Reset()
+				row.WriteRune(pipe)
+				col = 0
+			}
+		}
+	}
+	if col > 0 {
+		fmt.Println(row.String())
+		fmt.Println(line(col))
+	}
+	if !full {
+		fmt.Println("|" + centerLabel("...", (2*layoutWidth)-1) + "|")
+	}
+}
+
+func centerLabel(str string, width int) string {
+	if len(str) > width {
+		str = str[:width]
+	}
+	var (
+		left  = (width - len(str)) / 2
+		right = width - len(str) - left
+	)
+	return strings.Repeat(" ", left) + str + strings.Repeat(" ", right)
+}
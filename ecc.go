@@ -0,0 +1,136 @@
+package dissect
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	attrParity = "parity"
+	parityOdd  = "odd"
+	parityEven = "even"
+)
+
+const (
+	checkHamming = "hamming"
+	checkSecDed  = "secded"
+)
+
+// checkParity reports whether the n bits of v have the expected parity.
+func checkParity(parity string, v uint64, n int) bool {
+	odd := bits.OnesCount64(v&lowBits(n))%2 == 1
+	if parity == parityOdd {
+		return odd
+	}
+	return !odd
+}
+
+// hammingSyndrome computes the syndrome of the n bits codeword v. Bits are
+// numbered from 1 starting with the most significant one.
+func hammingSyndrome(v uint64, n int) int {
+	var syn int
+	for i := 1; i <= n; i++ {
+		if (v>>uint(n-i))&1 == 1 {
+			syn ^= i
+		}
+	}
+	return syn
+}
+
+// correctHamming checks the n bits codeword v and corrects it when it has a
+// single bit error.
+func correctHamming(v uint64, n int) (uint64, bool, bool) {
+	syn := hammingSyndrome(v, n)
+	switch {
+	case syn == 0:
+		return v, false, true
+	case syn <= n:
+		return v ^ (1 << uint(n-syn)), true, true
+	default:
+		return v, false, false
+	}
+}
+
+// correctSecDed checks the n bits codeword v whose most significant bit is the
+// parity of the whole codeword. It corrects single bit errors and detects
+// double bit errors.
+func correctSecDed(v uint64, n int) (uint64, bool, bool) {
+	var (
+		low = v & lowBits(n-1)
+		syn = hammingSyndrome(low, n-1)
+		odd = bits.OnesCount64(v&lowBits(n))%2 == 1
+	)
+	switch {
+	case syn == 0 && !odd:
+		return v, false, true
+	case !odd:
+		return v, false, false
+	case syn == 0:
+		return v ^ (1 << uint(n-1)), true, true
+	case syn <= n-1:
+		return v ^ (1 << uint(n-1-syn)), true, true
+	default:
+		return v, false, false
+	}
+}
+
+func lowBits(n int) uint64 {
+	if n >= 64 {
+		return math.MaxUint64
+	}
+	return uint64(1)<<uint(n) - 1
+}
+
+func (root *state) decodeCheck(c Check) error {
+	var correct func(uint64, int) (uint64, bool, bool)
+	switch c.kind.Literal {
+	case checkHamming:
+		correct = correctHamming
+	case checkSecDed:
+		correct = correctSecDed
+	case checkCRC16, checkCRC32, checkSum, checkXor:
+		return root.decodeChecksum(c)
+	default:
+		return fmt.Errorf("check: unsupported method %s", c.kind.Literal)
+	}
+	for i, f := range root.Fields {
+		if !c.includes(f.Id) {
+			continue
+		}
+		if f.Len > 64 || f.Len < 3 {
+			return fmt.Errorf("check: %s: invalid codeword size %d", f, f.Len)
+		}
+		// the codeword is made of the bits of the field: the sign extension
+		// of the signed integers is left out
+		var v uint64
+		switch x := f.raw.(type) {
+		case *Uint:
+			v = x.Raw & lowBits(f.Len)
+		case *Int:
+			v = signBits(f.encoding, x.Raw, f.Len)
+		default:
+			return fmt.Errorf("check: %s: integer expected", f)
+		}
+		v, fixed, ok := correct(v, f.Len)
+		switch {
+		case !ok:
+			root.uncorrected++
+		case fixed:
+			root.corrected++
+			if _, signed := f.raw.(*Int); signed {
+				f.raw = &Int{Raw: signExtend(f.encoding, v, f.Len)}
+			} else {
+				f.raw = &Uint{Raw: v}
+			}
+			// the engineering value is computed again from the corrected value
+			f.eng = nil
+			x, err := root.evalApply(f, f.apply)
+			if err != nil {
+				return fmt.Errorf("check: %s: %w", f, err)
+			}
+			root.Fields[i] = x
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package dissect
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCorrectCodeword(t *testing.T) {
+	// 0x70 is a valid Hamming(7,4) codeword: the bits 1, 2 and 3 are set and
+	// 1^2^3 == 0. 0xf0 adds the parity of the whole codeword for SECDED.
+	tests := []struct {
+		Name  string
+		Fn    func(uint64, int) (uint64, bool, bool)
+		Bits  int
+		Input uint64
+		Want  uint64
+		Fixed bool
+		Ok    bool
+	}{
+		{Name: "hamming", Fn: correctHamming, Bits: 7, Input: 0x70, Want: 0x70, Ok: true},
+		{Name: "hamming-single", Fn: correctHamming, Bits: 7, Input: 0x74, Want: 0x70, Fixed: true, Ok: true},
+		// the syndrome of the bits 3 and 4 points outside of the codeword
+		{Name: "hamming-outside", Fn: correctHamming, Bits: 5, Input: 0x06, Want: 0x06},
+		{Name: "secded", Fn: correctSecDed, Bits: 8, Input: 0xf0, Want: 0xf0, Ok: true},
+		{Name: "secded-single", Fn: correctSecDed, Bits: 8, Input: 0xf4, Want: 0xf0, Fixed: true, Ok: true},
+		{Name: "secded-parity", Fn: correctSecDed, Bits: 8, Input: 0x70, Want: 0xf0, Fixed: true, Ok: true},
+		{Name: "secded-double", Fn: correctSecDed, Bits: 8, Input: 0xf5, Want: 0xf5},
+	}
+	for _, tt := range tests {
+		got, fixed, ok := tt.Fn(tt.Input, tt.Bits)
+		if got != tt.Want || fixed != tt.Fixed || ok != tt.Ok {
+			t.Errorf("%s: want %#x (fixed: %t, ok: %t), got %#x (fixed: %t, ok: %t)", tt.Name, tt.Want, tt.Fixed, tt.Ok, got, fixed, ok)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Script string
+		Data   []byte
+		Want   string
+		Err    error
+	}{
+		{
+			Name:   "secded",
+			Script: "data (\n  c: uint 8\n  check secded c\n  let fixed = $Corrected\n  let bad = $Uncorrected\n  print raw as csv with c fixed bad\n)\n",
+			Data:   []byte{0xf0, 0xf4, 0x70, 0xf5},
+			Want:   csvRecords("c,fixed,bad", "240,0,0", "240,1,0", "240,1,0", "245,0,1"),
+		},
+		{
+			Name:   "signed",
+			Script: "data (\n  c: int 8\n  check secded c\n  print raw as csv with c\n)\n",
+			Data:   []byte{0xf4},
+			Want:   csvRecords("c", "-16"),
+		},
+		{
+			Name:   "eng",
+			Script: "enum codes (\n  112 = \"valid\"\n)\ndata (\n  c: uint 7, codes\n  pad: uint 1\n  check hamming c\n  print eng as csv with c\n)\n",
+			Data:   []byte{0x74 << 1},
+			Want:   csvRecords("c", "valid"),
+		},
+		{
+			Name:   "parity",
+			Script: "data (\n  c: uint 8 parity even\n  let errs = $Parity\n  print raw as csv with c errs\n)\n",
+			Data:   []byte{0x03, 0x07},
+			Want:   csvRecords("c,errs", "3,0", "7,1"),
+		},
+		{
+			Name:   "crc16",
+			Script: "data (\n  p: string 9\n  crc: uint 16\n  check crc16 = crc\n  print raw as csv with crc\n)\n",
+			Data:   []byte("123456789\x29\xb1"),
+			Want:   csvRecords("crc", "10673"),
+		},
+		{
+			Name:   "crc16-mismatch",
+			Script: "data (\n  p: string 9\n  crc: uint 16\n  check crc16 = crc\n  print raw\n)\n",
+			Data:   []byte("123456789\x29\xb2"),
+			Err:    ErrChecksum,
+		},
+		{
+			Name:   "size",
+			Script: "data (\n  c: uint 2\n  check hamming c\n  print raw\n)\n",
+			Data:   []byte{0x00},
+			Err:    errors.New("c: invalid codeword size"),
+		},
+		{
+			Name:   "integer",
+			Script: "data (\n  c: string 1\n  check secded c\n  print raw\n)\n",
+			Data:   []byte("a"),
+			Err:    errors.New("integer expected"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if tt.Err != nil {
+				var buf bytes.Buffer
+				err := DissectToWriter(strings.NewReader(tt.Script), bytes.NewReader(tt.Data), &buf)
+				if err == nil || (!errors.Is(err, tt.Err) && !strings.Contains(err.Error(), tt.Err.Error())) {
+					t.Fatalf("want error %v, got %v", tt.Err, err)
+				}
+				return
+			}
+			if got := dissectString(t, tt.Script, tt.Data); got != tt.Want {
+				t.Errorf("output mismatched:\nwant: %q\ngot:  %q", tt.Want, got)
+			}
+		})
+	}
+}
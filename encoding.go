@@ -0,0 +1,173 @@
+package dissect
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// encoding tells how values are written by the printers and by echo.
+//
+// encText is the plain text of echo: non printable characters are replaced by
+// a star. encCSV follows RFC 4180: the printers put each field between double
+// quotes and the double quotes of strings are doubled while tabs and line
+// breaks are kept. encSexp writes strings as double quoted literals with
+// backslash escapes, times between double quotes and null values as ().
+// encJSON writes strings, bytes and times as JSON strings and null values as
+// null. Times are written in RFC 3339 format by all the encodings.
+//
+// Whatever the encoding, the leading and trailing spaces of strings are
+// removed.
+type encoding int
+
+const (
+	encText encoding = iota
+	encCSV
+	encSexp
+	encJSON
+)
+
+func appendRaw(buf []byte, v Value, enc encoding) []byte {
+	switch v := v.(type) {
+	case *Int:
+		buf = strconv.AppendInt(buf, v.Raw, 10)
+	case *Uint:
+		buf = strconv.AppendUint(buf, v.Raw, 10)
+	case *BigInt:
+		buf = v.Raw.Append(buf, 10)
+	case *Real:
+		if enc == encJSON && (math.IsNaN(v.Raw) || math.IsInf(v.Raw, 0)) {
+			buf = appendNull(buf, enc)
+			break
+		}
+		buf = strconv.AppendFloat(buf, v.Raw, 'g', -1, 64)
+	case *Boolean:
+		buf = strconv.AppendBool(buf, v.Raw)
+	case *String:
+		buf = appendString(buf, v.Raw, enc)
+	case *Bytes:
+		if enc == encJSON {
+			buf = append(buf, '"')
+		}
+		buf = appendHex(buf, v.Raw)
+		if enc == encJSON {
+			buf = append(buf, '"')
+		}
+	case *Time:
+		if enc == encJSON || enc == encSexp {
+			buf = append(buf, '"')
+		}
+		buf = v.Raw.AppendFormat(buf, time.RFC3339Nano)
+		if enc == encJSON || enc == encSexp {
+			buf = append(buf, '"')
+		}
+	case *Array:
+		buf = appendArray(buf, v, enc)
+	default:
+		buf = appendNull(buf, enc)
+	}
+	return buf
+}
+
+// appendArray writes the elements of an array as a JSON array, as a list for
+// sexp and separated by spaces otherwise. The csv printers write the elements
+// in their own columns instead.
+func appendArray(buf []byte, arr *Array, enc encoding) []byte {
+	sep := byte(space)
+	switch enc {
+	case encJSON:
+		buf, sep = append(buf, '['), ','
+	case encSexp:
+		buf = append(buf, lparen)
+	}
+	for i, v := range arr.Raw {
+		if i > 0 {
+			buf = append(buf, sep)
+		}
+		buf = appendRaw(buf, v, enc)
+	}
+	switch enc {
+	case encJSON:
+		buf = append(buf, ']')
+	case encSexp:
+		buf = append(buf, rparen)
+	}
+	return buf
+}
+
+func appendEng(buf []byte, v Value, enc encoding) []byte {
+	return appendRaw(buf, v, enc)
+}
+
+func appendNull(buf []byte, enc encoding) []byte {
+	switch enc {
+	case encSexp:
+		buf = append(buf, lparen, rparen)
+	case encJSON:
+		buf = append(buf, "null"...)
+	default:
+	}
+	return buf
+}
+
+func appendString(buf []byte, str string, enc encoding) []byte {
+	str = strings.Trim(str, " ")
+	switch enc {
+	case encSexp:
+		return strconv.AppendQuote(buf, str)
+	case encJSON:
+		return appendJSONString(buf, str)
+	default:
+	}
+	for _, r := range str {
+		switch {
+		case enc == encCSV && r == '"':
+			buf = append(buf, '"')
+		case enc == encCSV && (r == '\t' || r == '\r' || r == '\n'):
+		case r == utf8.RuneError || !unicode.IsPrint(r):
+			r = '*'
+		}
+		buf = utf8.AppendRune(buf, r)
+	}
+	return buf
+}
+
+func appendJSONString(buf []byte, str string) []byte {
+	const digits = "0123456789abcdef"
+	buf = append(buf, '"')
+	for _, r := range str {
+		switch r {
+		case '"', '\\':
+			buf = append(buf, '\\', byte(r))
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\u2028', '\u2029':
+			buf = append(buf, `\u202`...)
+			buf = append(buf, digits[r&0xF])
+		default:
+			if r < 0x20 {
+				buf = append(buf, `\u00`...)
+				buf = append(buf, digits[r>>4], digits[r&0xF])
+				break
+			}
+			// invalid sequences are written as U+FFFD
+			buf = utf8.AppendRune(buf, r)
+		}
+	}
+	return append(buf, '"')
+}
+
+func appendHex(buf []byte, dat []byte) []byte {
+	const digits = "0123456789abcdef"
+	for _, b := range dat {
+		buf = append(buf, digits[b>>4], digits[b&0x0F])
+	}
+	return buf
+}
@@ -0,0 +1,122 @@
+package dissect
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestAppendString(t *testing.T) {
+	tests := []struct {
+		Input string
+		Text  string
+		CSV   string
+		Sexp  string
+		JSON  string
+	}{
+		{Input: "", Text: "", CSV: "", Sexp: `""`, JSON: `""`},
+		{Input: "  plain  ", Text: "plain", CSV: "plain", Sexp: `"plain"`, JSON: `"plain"`},
+		{Input: `say "hi"`, Text: `say "hi"`, CSV: `say ""hi""`, Sexp: `"say \"hi\""`, JSON: `"say \"hi\""`},
+		{Input: `a\b`, Text: `a\b`, CSV: `a\b`, Sexp: `"a\\b"`, JSON: `"a\\b"`},
+		{Input: "(a) (b)", Text: "(a) (b)", CSV: "(a) (b)", Sexp: `"(a) (b)"`, JSON: `"(a) (b)"`},
+		{Input: "a,b;c", Text: "a,b;c", CSV: "a,b;c", Sexp: `"a,b;c"`, JSON: `"a,b;c"`},
+		{Input: "a\tb", Text: "a*b", CSV: "a\tb", Sexp: `"a\tb"`, JSON: `"a\tb"`},
+		{Input: "a\nb", Text: "a*b", CSV: "a\nb", Sexp: `"a\nb"`, JSON: `"a\nb"`},
+		{Input: "a\r\nb", Text: "a**b", CSV: "a\r\nb", Sexp: `"a\r\nb"`, JSON: `"a\r\nb"`},
+		{Input: "a\x00b", Text: "a*b", CSV: "a*b", Sexp: `"a\x00b"`, JSON: `"a\u0000b"`},
+		{Input: "a\x1fb\x7f", Text: "a*b*", CSV: "a*b*", Sexp: `"a\x1fb\x7f"`, JSON: `"a\u001fb` + "\x7f" + `"`},
+		{Input: "a\xffb", Text: "a*b", CSV: "a*b", Sexp: `"a\xffb"`, JSON: "\"a\uFFFDb\""},
+		{Input: "côté 日本", Text: "côté 日本", CSV: "côté 日本", Sexp: `"côté 日本"`, JSON: `"côté 日本"`},
+		{Input: "a\u00a0b\u2028", Text: "a*b*", CSV: "a*b*", Sexp: `"a\u00a0b\u2028"`, JSON: "\"a\u00a0b\\u2028\""},
+		{Input: "<a&b>", Text: "<a&b>", CSV: "<a&b>", Sexp: `"<a&b>"`, JSON: `"<a&b>"`},
+	}
+	for _, tt := range tests {
+		for enc, want := range map[encoding]string{encText: tt.Text, encCSV: tt.CSV, encSexp: tt.Sexp, encJSON: tt.JSON} {
+			if got := string(appendString(nil, tt.Input, enc)); got != want {
+				t.Errorf("%q (encoding %d): want %q, got %q", tt.Input, enc, want, got)
+			}
+		}
+	}
+}
+
+func TestAppendStringRoundTrip(t *testing.T) {
+	// strings written in each format should be read back as they were by a
+	// reader of the format
+	ranges := [][2]rune{
+		{0, 0x3000},
+		{0xD7F0, 0xE010},
+		{0xFFF0, 0x10010},
+		{utf8.MaxRune - 0x10, utf8.MaxRune},
+	}
+	for _, rg := range ranges {
+		for r := rg[0]; r <= rg[1]; r++ {
+			str := "a" + string(r) + "b"
+
+			var got string
+			if err := json.Unmarshal(appendString(nil, str, encJSON), &got); err != nil || got != str {
+				t.Errorf("%U: json: want %q, got %q (%v)", r, str, got, err)
+			}
+			got, err := strconv.Unquote(string(appendString(nil, str, encSexp)))
+			if err != nil || got != str {
+				t.Errorf("%U: sexp: want %q, got %q (%v)", r, str, got, err)
+			}
+			if r == '\r' {
+				// RFC 4180 readers turn a CR in a quoted field into a LF
+				continue
+			}
+			want := str
+			if r != '\t' && r != '\n' && (r == utf8.RuneError || !utf8.ValidRune(r) || !unicode.IsPrint(r)) {
+				want = "a*b"
+			}
+			field := `"` + string(appendString(nil, str, encCSV)) + `"`
+			rs, err := csv.NewReader(strings.NewReader(field)).Read()
+			if err != nil || len(rs) != 1 || rs[0] != want {
+				t.Errorf("%U: csv: want %q, got %q (%v)", r, want, rs, err)
+			}
+		}
+	}
+}
+
+func TestAppendRaw(t *testing.T) {
+	when := time.Date(2021, 3, 4, 5, 6, 7, 800, time.UTC)
+	tests := []struct {
+		Value Value
+		Text  string
+		CSV   string
+		Sexp  string
+		JSON  string
+	}{
+		{Value: nil, Text: "", CSV: "", Sexp: "()", JSON: "null"},
+		{Value: &Int{Raw: -12}, Text: "-12", CSV: "-12", Sexp: "-12", JSON: "-12"},
+		{Value: &Uint{Raw: 12}, Text: "12", CSV: "12", Sexp: "12", JSON: "12"},
+		{Value: &Real{Raw: 1.5}, Text: "1.5", CSV: "1.5", Sexp: "1.5", JSON: "1.5"},
+		{Value: &Real{Raw: math.NaN()}, Text: "NaN", CSV: "NaN", Sexp: "NaN", JSON: "null"},
+		{Value: &Real{Raw: math.Inf(-1)}, Text: "-Inf", CSV: "-Inf", Sexp: "-Inf", JSON: "null"},
+		{Value: &Boolean{Raw: true}, Text: "true", CSV: "true", Sexp: "true", JSON: "true"},
+		{Value: &Bytes{Raw: []byte{0, 0xab, 0x10}}, Text: "00ab10", CSV: "00ab10", Sexp: "00ab10", JSON: `"00ab10"`},
+		{Value: &Time{Raw: when}, Text: "2021-03-04T05:06:07.0000008Z", CSV: "2021-03-04T05:06:07.0000008Z", Sexp: `"2021-03-04T05:06:07.0000008Z"`, JSON: `"2021-03-04T05:06:07.0000008Z"`},
+		{
+			Value: &Array{Raw: []Value{&Uint{Raw: 1}, &String{Raw: `"x"`}, nil}},
+			Text:  `1 "x" `,
+			CSV:   `1 ""x"" `,
+			Sexp:  `(1 "\"x\"" ())`,
+			JSON:  `[1,"\"x\"",null]`,
+		},
+	}
+	for _, tt := range tests {
+		for enc, want := range map[encoding]string{encText: tt.Text, encCSV: tt.CSV, encSexp: tt.Sexp, encJSON: tt.JSON} {
+			if got := string(appendRaw(nil, tt.Value, enc)); got != want {
+				t.Errorf("%v (encoding %d): want %q, got %q", tt.Value, enc, want, got)
+			}
+		}
+		if !json.Valid(appendRaw(nil, tt.Value, encJSON)) {
+			t.Errorf("%v: invalid json", tt.Value)
+		}
+	}
+}
@@ -6,7 +6,7 @@ import (
 )
 
 var leapDates = []time.Time{
-	time.Date(1972, 6, 30, 59, 59, 0, 0, time.UTC),
+	time.Date(1972, 6, 30, 23, 59, 59, 0, time.UTC),
 	time.Date(1972, 12, 31, 23, 59, 59, 0, time.UTC),
 	time.Date(1973, 12, 31, 23, 59, 59, 0, time.UTC),
 	time.Date(1974, 12, 31, 23, 59, 59, 0, time.UTC),
@@ -15,29 +15,29 @@ var leapDates = []time.Time{
 	time.Date(1977, 12, 31, 23, 59, 59, 0, time.UTC),
 	time.Date(1978, 12, 31, 23, 59, 59, 0, time.UTC),
 	time.Date(1979, 12, 31, 23, 59, 59, 0, time.UTC),
-	time.Date(1981, 6, 30, 59, 59, 0, 0, time.UTC),
-	time.Date(1982, 6, 30, 59, 59, 0, 0, time.UTC),
-	time.Date(1983, 6, 30, 59, 59, 0, 0, time.UTC),
-	time.Date(1985, 6, 30, 59, 59, 0, 0, time.UTC),
+	time.Date(1981, 6, 30, 23, 59, 59, 0, time.UTC),
+	time.Date(1982, 6, 30, 23, 59, 59, 0, time.UTC),
+	time.Date(1983, 6, 30, 23, 59, 59, 0, time.UTC),
+	time.Date(1985, 6, 30, 23, 59, 59, 0, time.UTC),
 	time.Date(1987, 12, 31, 23, 59, 59, 0, time.UTC),
 	time.Date(1989, 12, 31, 23, 59, 59, 0, time.UTC),
 	time.Date(1990, 12, 31, 23, 59, 59, 0, time.UTC),
-	time.Date(1992, 6, 30, 59, 59, 0, 0, time.UTC),
-	time.Date(1993, 6, 30, 59, 59, 0, 0, time.UTC),
-	time.Date(1994, 6, 30, 59, 59, 0, 0, time.UTC),
+	time.Date(1992, 6, 30, 23, 59, 59, 0, time.UTC),
+	time.Date(1993, 6, 30, 23, 59, 59, 0, time.UTC),
+	time.Date(1994, 6, 30, 23, 59, 59, 0, time.UTC),
 	time.Date(1995, 12, 31, 23, 59, 59, 0, time.UTC),
-	time.Date(1997, 6, 30, 59, 59, 0, 0, time.UTC),
-	time.Date(1998, 12, 31, 59, 59, 0, 0, time.UTC),
+	time.Date(1997, 6, 30, 23, 59, 59, 0, time.UTC),
+	time.Date(1998, 12, 31, 23, 59, 59, 0, time.UTC),
 	time.Date(2005, 12, 31, 23, 59, 59, 0, time.UTC),
 	time.Date(2008, 12, 31, 23, 59, 59, 0, time.UTC),
-	time.Date(2012, 6, 30, 59, 59, 0, 0, time.UTC),
-	time.Date(2015, 6, 30, 59, 59, 0, 0, time.UTC),
+	time.Date(2012, 6, 30, 23, 59, 59, 0, time.UTC),
+	time.Date(2015, 6, 30, 23, 59, 59, 0, time.UTC),
 	time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC),
 }
 
 var (
 	gpsEpoch  = time.Date(1980, 1, 6, 0, 0, 0, 0, time.UTC)
-	unixEpoch = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	leapStart = time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC)
 )
 
 func init() {
@@ -46,13 +46,34 @@ func init() {
 	})
 }
 
-func convertTimeGPS(t time.Time) time.Time {
-	delta := gpsEpoch.Sub(unixEpoch)
+// convertTimeGPS returns the UTC time of secs seconds since the GPS epoch. GPS
+// time does not have leap seconds: the ones inserted in UTC since the GPS epoch
+// are removed.
+func convertTimeGPS(secs int64) time.Time {
+	return removeLeaps(gpsEpoch.Add(time.Duration(secs)*time.Second), gpsEpoch)
+}
+
+// taiToUTC converts a TAI time to UTC. TAI was 10 seconds ahead of UTC in 1972
+// and one second is added for each leap second since. The offset before 1972 is
+// ignored.
+func taiToUTC(t time.Time) time.Time {
+	if t.Before(leapStart) {
+		return t
+	}
+	return removeLeaps(t.Add(-10*time.Second), time.Time{})
+}
+
+// removeLeaps removes from t, a time counted without leap seconds, the leap
+// seconds inserted in UTC after since.
+func removeLeaps(t, since time.Time) time.Time {
 	for _, d := range leapDates {
-		if d.After(t) {
+		if d.Before(since) {
+			continue
+		}
+		if !t.After(d) {
 			break
 		}
-		delta += time.Second
+		t = t.Add(-time.Second)
 	}
-	return t.Add(delta)
+	return t
 }
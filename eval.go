@@ -1,14 +1,25 @@
 package dissect
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 func eval(e Expression, root *state) (Value, error) {
 	if e == nil {
 		return &Null{}, nil
 	}
+	if root != nil && root.timing {
+		// only the outermost expression is measured
+		if root.depth == 0 {
+			defer root.timed(timeStage, stageEval)()
+		}
+		root.depth++
+		defer func() { root.depth-- }()
+	}
 	var (
 		v   Value
 		err error
@@ -25,10 +36,14 @@ func eval(e Expression, root *state) (Value, error) {
 		v, err = evalLiteral(e, root)
 	case Identifier:
 		v, err = evalIdentifier(e, root)
+	case Call:
+		v, err = evalCall(e, root)
 	case Assignment:
 		v, err = evalAssign(e, root)
 	case Member:
 		v, err = evalMember(e, root)
+	case Index:
+		v, err = evalIndex(e, root)
 	default:
 		err = fmt.Errorf("unsupported expression type %T", e)
 	}
@@ -69,12 +84,56 @@ func evalMember(m Member, root *state) (Value, error) {
 		val = v.Raw()
 	case "eng":
 		val = v.Eng()
+	case "size":
+		size := v.Len
+		if v.kind == kindBytes || v.kind == kindString {
+			size /= numbit
+		}
+		val = &Int{
+			Raw: int64(size),
+		}
+	case "kind":
+		val = &String{
+			Raw: fieldKind(v).String(),
+		}
+	case "endian":
+		val = &String{
+			Raw: v.endian,
+		}
+	case "block":
+		val = &String{
+			Raw: strings.TrimRight(v.Block, "$"),
+		}
 	default:
 		return nil, fmt.Errorf("unknown attribute %s", m.attr.Literal)
 	}
 	return val, nil
 }
 
+// fieldKind gives the declared kind of f or, for fields created with let, the
+// kind matching its value.
+func fieldKind(f Field) Kind {
+	if f.kind != kindNull {
+		return f.kind
+	}
+	switch f.Raw().(type) {
+	case *Int:
+		return kindInt
+	case *Uint, *BigInt:
+		return kindUint
+	case *Real:
+		return kindFloat
+	case *String:
+		return kindString
+	case *Bytes:
+		return kindBytes
+	case *Time:
+		return kindTime
+	default:
+		return kindNull
+	}
+}
+
 func evalAssign(a Assignment, root *state) (Value, error) {
 	v, err := eval(a.right, root)
 	if err != nil {
@@ -121,12 +180,20 @@ func evalLiteral(i Literal, _ *state) (Value, error) {
 	var val Value
 	switch i.id.Type {
 	case Integer:
-		i, err := strconv.ParseInt(i.id.Literal, 0, 64)
+		n, err := strconv.ParseInt(i.id.Literal, 0, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			if x, ok := new(big.Int).SetString(i.id.Literal, 0); ok {
+				val = &BigInt{
+					Raw: x,
+				}
+				break
+			}
+		}
 		if err != nil {
 			return nil, err
 		}
 		val = &Int{
-			Raw: i,
+			Raw: n,
 		}
 	case Float:
 		i, err := strconv.ParseFloat(i.id.Literal, 64)
@@ -167,6 +234,41 @@ func evalIdentifier(i Identifier, root *state) (Value, error) {
 	return f.Raw(), err
 }
 
+// evalIndex gives the element of an array or the byte of a bytes value at the
+// given index, starting at 0.
+func evalIndex(i Index, root *state) (Value, error) {
+	v, err := eval(i.arr, root)
+	if err != nil {
+		return nil, err
+	}
+	x, err := eval(i.index, root)
+	if err != nil {
+		return nil, err
+	}
+	if !isInteger(x) {
+		return nil, fmt.Errorf("%s: index should be an integer", i)
+	}
+	var (
+		index = asInt(x)
+		size  int
+	)
+	switch v := v.(type) {
+	case *Array:
+		size = len(v.Raw)
+		if index >= 0 && index < int64(size) {
+			return v.Raw[index], nil
+		}
+	case *Bytes:
+		size = len(v.Raw)
+		if index >= 0 && index < int64(size) {
+			return &Uint{Raw: uint64(v.Raw[index])}, nil
+		}
+	default:
+		return nil, fmt.Errorf("%s: %w: index on non array value", i, ErrUnsupported)
+	}
+	return nil, fmt.Errorf("%s: index out of range (%d elements)", i, size)
+}
+
 func evalArithmetic(b Binary, root *state) (Value, error) {
 	left, err := eval(b.Left, root)
 	if err != nil {
@@ -176,6 +278,7 @@ func evalArithmetic(b Binary, root *state) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	left, right = promote(left, right)
 	var val Value
 	switch b.operator {
 	case Add:
@@ -225,6 +328,7 @@ func evalRelational(b Binary, root *state) (Value, error) {
 		return nil, err
 	}
 
+	left, right = promote(left, right)
 	var (
 		cmp = left.Cmp(right)
 		ok  bool
@@ -258,6 +362,7 @@ func evalBitwise(b Binary, root *state) (Value, error) {
 		return nil, err
 	}
 
+	left, right = promote(left, right)
 	switch b.operator {
 	case BitAnd:
 		return left.and(right)
@@ -0,0 +1,176 @@
+package dissect
+
+import (
+	"fmt"
+)
+
+const (
+	onDecl       = "on"
+	onTransition = "transition"
+	onFrom       = "from"
+	onAbove      = "above"
+	onBelow      = "below"
+)
+
+// parseTransition parses a block executed when the value of a field changes
+// between two of its occurrences, eg
+//
+//	on transition mode as prev from 1 to 2 (
+//	  echo "mode: %[prev] -> %[mode]"
+//	)
+//	on transition temp above [limit + 5] ( print )
+//
+// from and to restrict the transitions to the ones leaving and reaching the
+// given values, above and below to the ones crossing the given threshold. The
+// values are literals or expressions between brackets.
+func (p *Parser) parseTransition() (Node, error) {
+	t := Transition{pos: p.curr.Pos(), file: p.currentFile()}
+	p.nextToken()
+	p.nextToken()
+	if !p.curr.isIdent() {
+		return nil, p.expectedError("ident")
+	}
+	t.field = p.curr
+	p.nextToken()
+	if p.curr.Type == Keyword && p.curr.Literal == kwAs {
+		p.nextToken()
+		if p.curr.Type != Ident {
+			return nil, p.expectedError("ident")
+		}
+		t.bind = p.curr
+		p.nextToken()
+	}
+	var err error
+	switch {
+	case p.curr.Type == Ident && (p.curr.Literal == onAbove || p.curr.Literal == onBelow):
+		t.cross = p.curr
+		p.nextToken()
+		if t.to, err = p.parseEventValue(); err != nil {
+			return nil, err
+		}
+	default:
+		if p.curr.Type == Ident && p.curr.Literal == onFrom {
+			p.nextToken()
+			if t.from, err = p.parseEventValue(); err != nil {
+				return nil, err
+			}
+		}
+		if p.curr.Type == Keyword && p.curr.Literal == kwTo {
+			p.nextToken()
+			if t.to, err = p.parseEventValue(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	t.node, err = p.parseBody()
+	return t, err
+}
+
+func (p *Parser) parseEventValue() (Expression, error) {
+	if p.curr.Type == lsquare {
+		p.nextToken()
+		return p.parsePredicate()
+	}
+	expr, err := p.parsePrefix()
+	if err == nil {
+		p.nextToken()
+	}
+	return expr, err
+}
+
+// decodeTransition compares the value of the field of t with the one it had the
+// previous time t was executed and decodes the body of t when the change
+// matches the transition. Null values are ignored. The previous value is kept
+// for the whole decoding, across packets and files.
+func (root *state) decodeTransition(t Transition) error {
+	f, err := root.ResolveValue(t.field.Literal)
+	if err != nil {
+		return err
+	}
+	curr := f.Raw()
+	if _, ok := curr.(*Null); ok {
+		return nil
+	}
+	if root.events == nil {
+		root.events = make(map[nodeKey]Value)
+	}
+	key := nodeKey{file: t.file, pos: t.pos}
+	prev, ok := root.events[key]
+	root.events[key] = curr
+	if !ok || t.node == nil {
+		return nil
+	}
+	if ok, err = root.isTransition(t, prev, curr); err != nil || !ok {
+		return err
+	}
+	var dat Block
+	switch n := t.node.(type) {
+	case Reference:
+		dat, err = root.ResolveBlock(n.id.Literal)
+	case Block:
+		dat = n
+	default:
+		return fmt.Errorf("decoding transition: unexpected node type %T", n)
+	}
+	if err != nil {
+		return err
+	}
+	if t.bind.Literal == "" {
+		return root.decodeBlock(dat)
+	}
+	// the previous value is only visible in the block of the transition
+	ix := len(root.Fields)
+	root.Fields = append(root.Fields, Field{
+		Id:  t.bind.Literal,
+		raw: prev,
+	})
+	err = root.decodeBlock(dat)
+	if ix < len(root.Fields) && root.Fields[ix].Id == t.bind.Literal {
+		root.Fields = append(root.Fields[:ix], root.Fields[ix+1:]...)
+	}
+	return err
+}
+
+func (root *state) isTransition(t Transition, prev, curr Value) (bool, error) {
+	if t.cross.Literal != "" {
+		v, err := eval(t.to, root)
+		if err != nil {
+			return false, err
+		}
+		if !isNumber(prev) || !isNumber(curr) || !isNumber(v) {
+			return false, fmt.Errorf("%s %s: %s: non numeric value", onTransition, t.cross.Literal, t.field.Literal)
+		}
+		x, before, after := asReal(v), asReal(prev), asReal(curr)
+		if t.cross.Literal == onAbove {
+			return before < x && after >= x, nil
+		}
+		return before > x && after <= x, nil
+	}
+	if equalValues(prev, curr) {
+		return false, nil
+	}
+	for _, c := range []struct {
+		expr  Expression
+		value Value
+	}{
+		{expr: t.from, value: prev},
+		{expr: t.to, value: curr},
+	} {
+		if c.expr == nil {
+			continue
+		}
+		v, err := eval(c.expr, root)
+		if err != nil {
+			return false, err
+		}
+		if !equalValues(c.value, v) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func equalValues(left, right Value) bool {
+	left, right = promote(left, right)
+	return left.Cmp(right) == 0
+}
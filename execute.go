@@ -5,84 +5,640 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/midbel/glob"
 )
 
-func Dissect(script io.Reader, r io.Reader) error {
-	node, err := Merge(script)
-	if err != nil {
-		return err
+type Option func(*Interpreter) error
+
+func WithStdout(w io.Writer) Option {
+	return func(i *Interpreter) error {
+		i.stdout = w
+		return nil
 	}
-	data, ok := node.(Data)
-	if !ok {
-		return fmt.Errorf("missing data block")
+}
+
+func WithStderr(w io.Writer) Option {
+	return func(i *Interpreter) error {
+		i.stderr = w
+		return nil
+	}
+}
+
+// WithDryRun makes the interpreter decode its inputs without writing anything
+// to the sinks referenced by print, echo and copy.
+func WithDryRun(dry bool) Option {
+	return func(i *Interpreter) error {
+		i.dry = dry
+		return nil
+	}
+}
+
+// WithOnly disables all the named blocks of the script except the given ones.
+// Statements including a disabled block become no-ops.
+func WithOnly(blocks ...string) Option {
+	return func(i *Interpreter) error {
+		i.only = append(i.only, blocks...)
+		return nil
+	}
+}
+
+// WithSkip disables the given named blocks of the script. Statements including
+// a disabled block become no-ops.
+func WithSkip(blocks ...string) Option {
+	return func(i *Interpreter) error {
+		i.skip = append(i.skip, blocks...)
+		return nil
+	}
+}
+
+// WithMaxFiles sets the maximum number of output files kept open at the same
+// time. A value lesser or equal to zero removes the limit.
+func WithMaxFiles(n int) Option {
+	return func(i *Interpreter) error {
+		i.maxFiles = n
+		return nil
+	}
+}
+
+// WithFS makes the interpreter read the files included by the script from
+// fsys instead of the file system of the host.
+func WithFS(fsys fs.FS) Option {
+	return func(i *Interpreter) error {
+		i.fsys = fsys
+		return nil
+	}
+}
+
+// WithIncludePath adds directories where the files included by the script are
+// looked for when they are found neither from the current directory nor next
+// to the file including them. The directories are tried in order.
+func WithIncludePath(dirs ...string) Option {
+	return func(i *Interpreter) error {
+		i.paths = append(i.paths, dirs...)
+		return nil
+	}
+}
+
+// WithContinue makes RunFiles go on with the next file when a file can not be
+// opened or decoded. The failures are returned at the end as a FilesError.
+func WithContinue(keep bool) Option {
+	return func(i *Interpreter) error {
+		i.keep = keep
+		return nil
+	}
+}
+
+// WithSkipErrors makes the decoding go on with the next packet when a packet
+// can not be decoded. The error is written on stderr with the offset of the
+// packet in its input and recorded in the anomalies of the stats. An error
+// hook, if any, takes precedence.
+func WithSkipErrors(skip bool) Option {
+	return func(i *Interpreter) error {
+		i.skipErrors = skip
+		return nil
+	}
+}
+
+// WithBackfill marks the decoding as the replay of archived data. The files
+// written get the backfill suffix before their extension, eg hk.backfill.csv,
+// so that they are told apart from, and do not replace, the files written when
+// the data was first decoded. The DSL can also check it with the $Backfill
+// internal value.
+func WithBackfill(backfill bool) Option {
+	return func(i *Interpreter) error {
+		i.backfill = backfill
+		return nil
+	}
+}
+
+const backfillSuffix = "backfill"
+
+func backfillFile(file string) string {
+	base, ext := splitExt(file)
+	return base + "." + backfillSuffix + ext
+}
+
+// WithWarnings sets the function called for each warning reported while the
+// script is parsed.
+func WithWarnings(warn func(Warning)) Option {
+	return func(i *Interpreter) error {
+		i.warn = warn
+		return nil
+	}
+}
+
+// WithTrailing sets how a partial packet found at the end of an input is
+// handled.
+func WithTrailing(t Trailing) Option {
+	return func(i *Interpreter) error {
+		i.trailing = t
+		return nil
+	}
+}
+
+// WithOrder sets the order in which RunFiles processes its input files.
+func WithOrder(o Order) Option {
+	return func(i *Interpreter) error {
+		i.order = o
+		return nil
+	}
+}
+
+// WithRecordHook sets the function called with the fields of each record
+// before it is written by a print statement. The hook can change, add or remove
+// fields. It drops the record by returning no field at all, eg after having
+// sent it somewhere else. An error returned by the hook stops the decoding.
+func WithRecordHook(hook func([]Field) ([]Field, error)) Option {
+	return func(i *Interpreter) error {
+		i.hook = hook
+		return nil
+	}
+}
+
+// WithPacketHook sets the function called after each packet, decoded or not.
+func WithPacketHook(hook func(PacketInfo)) Option {
+	return func(i *Interpreter) error {
+		i.onPacket = hook
+		return nil
+	}
+}
+
+// WithRecordBatches makes the interpreter accumulate the records written by the
+// print statements in batches of at most rows records stored by column in the
+// memory layout of Apache Arrow, eg to give them to data frames without
+// serializing them. fn is called with each batch when it is full, before a
+// record with other fields or with values of other types and at the end of the
+// run. An error returned by fn stops the decoding. With WithDryRun, the records
+// are only given to fn.
+func WithRecordBatches(rows int, fn func(*RecordBatch) error) Option {
+	return func(i *Interpreter) error {
+		if rows <= 0 {
+			return fmt.Errorf("record batches: number of rows should be greater than 0 (%d)", rows)
+		}
+		i.batchRows, i.onBatch = rows, fn
+		return nil
+	}
+}
+
+// WithErrorHook sets the function called when a packet can not be decoded. The
+// action it returns tells how the decoding goes on. Without hook, the decoding
+// stops on the first error.
+func WithErrorHook(hook func(error) Action) Option {
+	return func(i *Interpreter) error {
+		i.onError = hook
+		return nil
+	}
+}
+
+// Callback computes a value from the fields of the packet being decoded and the
+// other arguments given to $callback. The fields must not be modified nor kept
+// after the call.
+type Callback func(fields []Field, args []Value) (Value, error)
+
+// WithCallback registers fn under name. Expressions of the script call it with
+// $callback("name", args...).
+func WithCallback(name string, fn Callback) Option {
+	return func(i *Interpreter) error {
+		if i.callbacks == nil {
+			i.callbacks = make(map[string]Callback)
+		}
+		i.callbacks[name] = fn
+		return nil
+	}
+}
+
+// Func is a Go function called from the expressions of a script as
+// $name(args...), like a builtin.
+type Func func(args ...Value) (Value, error)
+
+// RegisterFunc makes fn callable from the expressions of the script as
+// $name(args...). name can not be the one of a builtin. Registering a function
+// does not affect the runs already started.
+func (i *Interpreter) RegisterFunc(name string, fn Func) error {
+	if !isFuncName(name) {
+		return fmt.Errorf("%s: invalid function name", name)
+	}
+	if _, ok := builtins[name]; ok {
+		return fmt.Errorf("%s: function name used by a builtin", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("%s: nil function", name)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// the functions are copied so that the states already created keep theirs
+	funcs := make(map[string]Func, len(i.funcs)+1)
+	for n, f := range i.funcs {
+		funcs[n] = f
+	}
+	funcs[name] = fn
+	i.funcs = funcs
+	return nil
+}
+
+func (i *Interpreter) functions() map[string]Func {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.funcs
+}
+
+// WithColumnSuffixes sets the suffixes added to the name of the fields for the
+// raw and the engineering columns of the csv records printed with both. By
+// default, the raw column has the name of the field and the name of the
+// engineering column ends with _eng.
+func WithColumnSuffixes(raw, eng string) Option {
+	return func(i *Interpreter) error {
+		if raw == eng {
+			return fmt.Errorf("raw and eng columns have the same suffix %q", raw)
+		}
+		i.columns = columns{raw: raw, eng: eng}
+		return nil
 	}
+}
+
+type Interpreter struct {
+	data  Data
+	fsys  fs.FS
+	paths []string
+
+	offline  bool
+	cache    string
+	manifest string
+	outroot  string
+	hosts    hostPolicy
+
+	maxFiles int
+	post     postPolicy
+	order    Order
+	trailing Trailing
+	bitorder BitOrder
+	expect   ExpectPolicy
+
+	only []string
+	skip []string
+
+	stdout  io.Writer
+	stderr  io.Writer
+	capture io.Writer
+	once    bool
+	warn    func(Warning)
+
+	columns   columns
+	renderers []renderer
+	hook      func([]Field) ([]Field, error)
+	onPacket  func(PacketInfo)
+	onError   func(error) Action
+	onBatch   func(*RecordBatch) error
+	batchRows int
+	callbacks map[string]Callback
+	funcs     map[string]Func
+
+	dry        bool
+	backfill   bool
+	keep       bool
+	skipErrors bool
+	timing     bool
+	stats      Stats
+	results    []FileResult
+	sources    *sourceSet
+
+	mu     sync.Mutex
+	rotate bool
+	reload bool
+}
+
+func New(script io.Reader, opts ...Option) (*Interpreter, error) {
+	i, err := newInterpreter(opts...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	s := state{
-		data:   data.Block,
-		files:  make(map[string]*os.File),
-		stdout: os.Stdout,
-		stderr: os.Stderr,
+	data, sources, err := i.load(script)
+	if err != nil {
+		return nil, err
 	}
-	defer s.Close()
-	if err = s.decodeNodes([]Node{data.pre}); err != nil {
-		return err
+	i.data, i.sources = data, sources
+	return i, nil
+}
+
+func newInterpreter(opts ...Option) (*Interpreter, error) {
+	i := Interpreter{
+		maxFiles: DefaultMaxFiles,
+		post:     defaultPost,
+		columns:  defaultColumns,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
 	}
-	err = s.Run(r)
-	if err == nil {
-		err = s.decodeNodes([]Node{data.post})
+	for _, o := range opts {
+		if err := o(&i); err != nil {
+			return nil, err
+		}
 	}
-	return err
+	return &i, nil
 }
 
-func DissectFiles(script io.Reader, fs []string) error {
-	node, err := Merge(script)
+func (i *Interpreter) load(script io.Reader) (Data, *sourceSet, error) {
+	p := newParser(i.fsys, i.warn)
+	p.offline = i.offline
+	p.cache = i.cache
+	p.hosts = i.hosts
+	p.paths = i.paths
+	p.sources = &sourceSet{}
+	node, err := mergeTree(p.parse(script))
 	if err != nil {
-		return err
+		return Data{}, nil, err
 	}
 	data, ok := node.(Data)
 	if !ok {
-		return fmt.Errorf("missing data block")
+		return data, nil, fmt.Errorf("missing data block")
+	}
+	return i.prepare(data), p.sources, nil
+}
+
+// prepare removes from data the blocks excluded by the only and skip options.
+func (i *Interpreter) prepare(data Data) Data {
+	if len(i.only) > 0 || len(i.skip) > 0 {
+		data = filterData(data, i.keepBlock)
+	}
+	return data
+}
+
+func (i *Interpreter) keepBlock(b Block) bool {
+	if b.id.Type == Keyword {
+		return true
+	}
+	for _, s := range i.skip {
+		if s == b.id.Literal {
+			return false
+		}
+	}
+	if len(i.only) == 0 {
+		return true
 	}
+	for _, s := range i.only {
+		if s == b.id.Literal {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Interpreter) Stats() Stats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stats.clone()
+}
+
+// Results gives the outcome of each file processed by RunFiles.
+func (i *Interpreter) Results() []FileResult {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]FileResult(nil), i.results...)
+}
+
+// Rotate closes all the output files opened by the interpreter before the
+// next packet is decoded. They are reopened in append mode when something is
+// written to them again.
+func (i *Interpreter) Rotate() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.rotate = true
+}
+
+// Reload replaces the script of the interpreter. A running decoding switches to
+// the new script before the next packet.
+func (i *Interpreter) Reload(script io.Reader) error {
+	data, sources, err := i.load(script)
 	if err != nil {
 		return err
 	}
+	i.swap(data, sources)
+	return nil
+}
+
+func (i *Interpreter) swap(data Data, sources *sourceSet) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.data, i.sources = data, sources
+	i.reload = true
+}
+
+// apply executes, from the goroutine decoding the packets, the rotate and
+// reload requests received since the previous packet.
+func (i *Interpreter) apply(s *state) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.rotate && !i.reload {
+		return
+	}
+	if err := s.rotate(); err != nil {
+		fmt.Fprintln(s.stderr, err)
+	}
+	if i.reload {
+		s.data = i.data.Block
+		s.sinks = openSinks(i.data.sinks)
+		s.alarms = i.data.limits
+	}
+	i.rotate, i.reload = false, false
+}
+
+func (i *Interpreter) Run(r io.Reader) (err error) {
+	s := i.newState()
+	defer func() { err = i.finish(s, err) }()
+	if err := s.decodeNodes([]Node{i.script().pre}); err != nil {
+		return err
+	}
+	i.begin()
+	err = s.Run(r)
+	if err != nil && i.dry {
+		err = nil
+	}
+	if err == nil {
+		err = s.decodeNodes([]Node{i.script().post})
+	}
+	return err
+}
+
+func (i *Interpreter) RunFiles(fs []string) error {
 	var files []string
-	if len(data.files) > 0 {
+	if data := i.script(); len(data.files) > 0 {
 		for _, f := range data.files {
 			files = append(files, f.Literal)
 		}
 	} else {
 		files = fs
 	}
-	s := state{
-		data:   data.Block,
-		files:  make(map[string]*os.File),
-		stdout: os.Stdout,
-		stderr: os.Stderr,
+	queue := walkFiles(files)
+	if i.order != OrderWalk {
+		queue = sortFiles(queue, i.order)
 	}
-	defer s.Close()
+	return i.RunQueue(queue, nil)
+}
+
+// RunQueue decodes the files received from queue like RunFiles until queue is
+// closed. All the files are decoded with the same state and write to the same
+// outputs, even those that arrive long after the first one. done, if not nil,
+// is called with the result of each file once it is decoded.
+func (i *Interpreter) RunQueue(queue <-chan string, done func(FileResult)) (err error) {
+	s := i.newState()
+	defer func() { err = i.finish(s, err) }()
 
-	if err = s.decodeNodes([]Node{data.pre}); err != nil {
+	if err := s.decodeNodes([]Node{i.script().pre}); err != nil {
 		return err
 	}
-	for f := range walkFiles(files) {
-		r, err := os.Open(f)
-		if err != nil {
+	var failed FilesError
+	for f := range queue {
+		res := i.runFile(s, f)
+		i.mu.Lock()
+		i.results = append(i.results, res)
+		i.mu.Unlock()
+		if done != nil {
+			done(res)
+		}
+		if res.Err == nil {
 			continue
 		}
-		err = s.Run(r)
-		r.Close()
-		if err != nil {
-			return err
+		if !i.keep && !i.dry {
+			return res.Err
+		}
+		failed = append(failed, res)
+	}
+	if err := s.decodeNodes([]Node{i.script().post}); err != nil {
+		return err
+	}
+	if len(failed) > 0 && !i.dry {
+		return failed
+	}
+	return nil
+}
+
+// finish closes the files of s and writes the manifest if one is requested.
+// err is the error of the decoding, returned in priority.
+func (i *Interpreter) finish(s *state, err error) error {
+	if s.batches != nil {
+		if e := s.batches.flush(); err == nil {
+			err = e
+		}
+	}
+	if e := s.Close(); err == nil {
+		err = e
+	}
+	if i.manifest == "" {
+		return err
+	}
+	if e := i.writeManifest(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (i *Interpreter) runFile(s *state, file string) FileResult {
+	res := FileResult{File: file}
+	r, err := os.Open(file)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer r.Close()
+
+	packets := i.begin()
+	res.Err = s.Run(r)
+	res.Packets = i.Stats().Packets - packets
+	return res
+}
+
+func (i *Interpreter) newState() *state {
+	data := i.script()
+	s := &state{
+		data:       data.Block,
+		files:      newFileCache(i.maxFiles, i.post),
+		hosts:      i.hosts,
+		sinks:      openSinks(data.sinks),
+		alarms:     data.limits,
+		stdout:     i.stdout,
+		stderr:     i.stderr,
+		dry:        i.dry,
+		backfill:   i.backfill,
+		timing:     i.timing,
+		trailing:   i.trailing,
+		bitorder:   i.bitorder,
+		expect:     i.expect,
+		outroot:    i.outroot,
+		capture:    i.capture,
+		once:       i.once,
+		captured:   make(map[string]struct{}),
+		columns:    i.columns,
+		renderers:  i.renderers,
+		hook:       i.hook,
+		onPacket:   i.onPacket,
+		onError:    i.onError,
+		skipErrors: i.skipErrors,
+		callbacks:  i.callbacks,
+		funcs:      i.functions(),
+		stats:      &i.stats,
+		mu:         &i.mu,
+		sync:       i.apply,
+	}
+	if i.onBatch != nil {
+		s.batches = &batcher{
+			rows: i.batchRows,
+			emit: i.onBatch,
 		}
 	}
-	return s.decodeNodes([]Node{data.post})
+	return s
+}
+
+func (i *Interpreter) script() Data {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.data
+}
+
+// begin counts a new input and returns the number of packets decoded so far.
+func (i *Interpreter) begin() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.stats.Files++
+	return i.stats.Packets
+}
+
+func Dissect(script io.Reader, r io.Reader, opts ...Option) error {
+	i, err := New(script, opts...)
+	if err != nil {
+		return err
+	}
+	return i.Run(r)
+}
+
+// DissectToWriter decodes data with script and writes everything produced by
+// the print, echo and copy statements of the script to w, whatever their
+// destination. It is useful to compare the output of a schema with a golden
+// file.
+func DissectToWriter(script, data io.Reader, w io.Writer, opts ...Option) error {
+	i, err := New(script, opts...)
+	if err != nil {
+		return err
+	}
+	i.capture = w
+	return i.Run(data)
+}
+
+func DissectFiles(script io.Reader, fs []string, opts ...Option) error {
+	i, err := New(script, opts...)
+	if err != nil {
+		return err
+	}
+	return i.RunFiles(fs)
 }
 
 func checkExit(err error) error {
@@ -112,10 +668,21 @@ func walkFiles(files []string) <-chan string {
 	queue := make(chan string)
 	go func() {
 		defer close(queue)
+
+		seen := make(map[string]struct{})
+		emit := func(f string) {
+			if _, ok := seen[f]; ok {
+				return
+			}
+			seen[f] = struct{}{}
+			queue <- f
+		}
 		for _, f := range files {
 			i, err := os.Stat(f)
 			if err != nil {
-				globFiles(f, queue)
+				for _, f := range globFiles(f) {
+					emit(f)
+				}
 				continue
 			}
 			if i.IsDir() {
@@ -124,27 +691,233 @@ func walkFiles(files []string) <-chan string {
 						return err
 					}
 					if i.Mode().IsRegular() {
-						queue <- p
+						emit(p)
 					}
 					return nil
 				})
 				continue
 			}
-			queue <- f
+			emit(f)
 		}
 	}()
 	return queue
 }
 
-func globFiles(f string, queue chan<- string) {
+// globFiles returns the regular files matching the pattern f sorted by name,
+// so that files are always processed in the same order.
+func globFiles(f string) []string {
 	g, err := glob.New("", f)
 	if err != nil {
-		return
+		return nil
 	}
+	var files []string
 	for n := g.Glob(); n != ""; n = g.Glob() {
 		i, err := os.Stat(n)
 		if err == nil && i.Mode().IsRegular() {
-			queue <- n
+			files = append(files, n)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Order defines how the files given to RunFiles are sorted before being
+// decoded. Whatever the order, files comparing equal are sorted by name.
+//
+// OrderWalk keeps the order of the arguments, each directory being walked in
+// lexical order and each glob pattern expanded in lexical order.
+//
+// OrderName sorts all the files by their full path.
+//
+// OrderTime sorts all the files by their modification time, oldest first.
+//
+// OrderNumeric sorts all the files by the numbers found in their base name,
+// compared one after the other: "file-2.bin" comes before "file-10.bin".
+type Order int
+
+const (
+	OrderWalk Order = iota
+	OrderName
+	OrderTime
+	OrderNumeric
+)
+
+func ParseOrder(str string) (Order, error) {
+	switch strings.ToLower(str) {
+	case "", "walk":
+		return OrderWalk, nil
+	case "name":
+		return OrderName, nil
+	case "mtime", "time":
+		return OrderTime, nil
+	case "numeric":
+		return OrderNumeric, nil
+	default:
+		return OrderWalk, fmt.Errorf("%s: unknown order", str)
+	}
+}
+
+func (o Order) String() string {
+	switch o {
+	case OrderWalk:
+		return "walk"
+	case OrderName:
+		return "name"
+	case OrderTime:
+		return "mtime"
+	case OrderNumeric:
+		return "numeric"
+	default:
+		return "unknown"
+	}
+}
+
+// PacketInfo describes a packet given to the packet hook. Fields is only valid
+// during the call of the hook.
+type PacketInfo struct {
+	File   string
+	Packet int
+	Offset int64
+	Size   int
+	Fields []Field
+	Err    error
+}
+
+// Action tells what to do after a packet failed to be decoded.
+//
+// ActionStop makes the decoding fail with the error. ActionSkip drops the
+// packet and goes on with the next one: it starts after the declared length of
+// the packet when there is one, after the bytes already read otherwise.
+// ActionRetry decodes the packet again from its start, eg after the schema has
+// been reloaded by the hook.
+type Action int
+
+const (
+	ActionStop Action = iota
+	ActionSkip
+	ActionRetry
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionStop:
+		return "stop"
+	case ActionSkip:
+		return "skip"
+	case ActionRetry:
+		return "retry"
+	default:
+		return "<unknown>"
+	}
+}
+
+// Trailing tells what to do when the input ends in the middle of a packet.
+//
+// TrailingError makes the decoding fail. TrailingWarn writes a warning on
+// stderr and TrailingIgnore silently drops the partial packet. TrailingEmit
+// decodes the partial packet: the fields missing are null and $Partial is set
+// to true.
+type Trailing int
+
+const (
+	TrailingError Trailing = iota
+	TrailingWarn
+	TrailingIgnore
+	TrailingEmit
+)
+
+func ParseTrailing(str string) (Trailing, error) {
+	switch strings.ToLower(str) {
+	case "", "error":
+		return TrailingError, nil
+	case "warn":
+		return TrailingWarn, nil
+	case "ignore":
+		return TrailingIgnore, nil
+	case "emit":
+		return TrailingEmit, nil
+	default:
+		return TrailingError, fmt.Errorf("%s: unknown trailing mode", str)
+	}
+}
+
+func (t Trailing) String() string {
+	switch t {
+	case TrailingError:
+		return "error"
+	case TrailingWarn:
+		return "warn"
+	case TrailingIgnore:
+		return "ignore"
+	case TrailingEmit:
+		return "emit"
+	default:
+		return "unknown"
+	}
+}
+
+func sortFiles(queue <-chan string, order Order) <-chan string {
+	type file struct {
+		name string
+		mod  int64
+		nums []int64
+	}
+	var files []file
+	for f := range queue {
+		x := file{name: f}
+		switch order {
+		case OrderTime:
+			if i, err := os.Stat(f); err == nil {
+				x.mod = i.ModTime().UnixNano()
+			}
+		case OrderNumeric:
+			x.nums = numbersIn(filepath.Base(f))
+		}
+		files = append(files, x)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		switch order {
+		case OrderTime:
+			if files[i].mod != files[j].mod {
+				return files[i].mod < files[j].mod
+			}
+		case OrderNumeric:
+			a, b := files[i].nums, files[j].nums
+			for k := 0; k < len(a) && k < len(b); k++ {
+				if a[k] != b[k] {
+					return a[k] < b[k]
+				}
+			}
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+		}
+		return files[i].name < files[j].name
+	})
+	sorted := make(chan string)
+	go func() {
+		defer close(sorted)
+		for _, f := range files {
+			sorted <- f.name
+		}
+	}()
+	return sorted
+}
+
+func numbersIn(str string) []int64 {
+	var nums []int64
+	for i := 0; i < len(str); {
+		if !isDigit(rune(str[i])) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(str) && isDigit(rune(str[j])) {
+			j++
 		}
+		n, _ := strconv.ParseInt(str[i:j], 10, 64)
+		nums = append(nums, n)
+		i = j
 	}
+	return nums
 }
@@ -0,0 +1,98 @@
+package dissect
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestDissectGolden decodes the packets of each name.bin file of testdata/golden
+// with the script name.dsl and compares the output with name.golden.
+func TestDissectGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "golden", "*.dsl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden script found")
+	}
+	for _, file := range files {
+		base := strings.TrimSuffix(file, filepath.Ext(file))
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			script, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer script.Close()
+			data, err := ioutil.ReadFile(base + ".bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := DissectToWriter(script, bytes.NewReader(data), &buf); err != nil {
+				t.Fatalf("decoding: unexpected error: %s", err)
+			}
+			golden := base + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("output mismatched:\nwant: %q\ngot:  %q", want, got)
+			}
+		})
+	}
+}
+
+func TestRunQueue(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.csv")
+	script := "data (\n  id: uint 8\n  print raw to \"" + filepath.ToSlash(out) + "\" as csv with id\n)\n"
+	for i, name := range []string{"a.bin", "b.bin"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte{byte(i + 1)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	i, err := New(strings.NewReader(script), WithStdout(ioutil.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := make(chan string)
+	results := make(chan FileResult)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- i.RunQueue(queue, func(res FileResult) { results <- res })
+	}()
+	for _, name := range []string{"a.bin", "b.bin"} {
+		queue <- filepath.Join(dir, name)
+		if res := <-results; res.Err != nil || res.Packets != 1 {
+			t.Fatalf("%s: unexpected result %+v", name, res)
+		}
+		// the records of a file are written before the next file arrives
+		if name == "a.bin" {
+			if got, _ := ioutil.ReadFile(out); string(got) != "\"id\"\r\n\"1\"\r\n" {
+				t.Fatalf("%s: unexpected output %q", name, got)
+			}
+		}
+	}
+	close(queue)
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "\"id\"\r\n\"1\"\r\n\"2\"\r\n"
+	if got, _ := ioutil.ReadFile(out); string(got) != want {
+		t.Errorf("output mismatched:\nwant: %q\ngot:  %q", want, got)
+	}
+}
@@ -0,0 +1,96 @@
+package dissect
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	expectDecl = "expect"
+	expectFail = "fail"
+	expectWarn = "warn"
+	expectSkip = "skip"
+)
+
+// ExpectPolicy tells what to do when the value of a field is not the one given
+// by its expectation.
+//
+// ExpectFail makes the decoding of the packet fail. ExpectWarn writes a
+// warning on stderr and ExpectSkip says nothing: in both cases, the field is
+// kept but marked as not valid and the decoding goes on.
+type ExpectPolicy int
+
+const (
+	ExpectFail ExpectPolicy = iota
+	ExpectWarn
+	ExpectSkip
+)
+
+func ParseExpectPolicy(str string) (ExpectPolicy, error) {
+	switch strings.ToLower(str) {
+	case "", expectFail:
+		return ExpectFail, nil
+	case expectWarn:
+		return ExpectWarn, nil
+	case expectSkip:
+		return ExpectSkip, nil
+	default:
+		return ExpectFail, fmt.Errorf("%s: unknown expect policy", str)
+	}
+}
+
+func (e ExpectPolicy) String() string {
+	switch e {
+	case ExpectFail:
+		return expectFail
+	case ExpectWarn:
+		return expectWarn
+	case ExpectSkip:
+		return expectSkip
+	default:
+		return "<unknown>"
+	}
+}
+
+// WithExpectPolicy sets the policy of the fields with an expectation declared
+// without policy and in blocks without expect directive.
+func WithExpectPolicy(e ExpectPolicy) Option {
+	return func(i *Interpreter) error {
+		i.expect = e
+		return nil
+	}
+}
+
+func isExpectPolicy(str string) bool {
+	return str == expectFail || str == expectWarn || str == expectSkip
+}
+
+// parseExpectPolicy parses the expect directive of a block. It applies to the
+// fields following it in the block, its inline blocks included.
+func (p *Parser) parseExpectPolicy() error {
+	p.nextToken()
+	if p.curr.Type != Ident || !isExpectPolicy(p.curr.Literal) {
+		return p.expectedError("fail/warn/skip")
+	}
+	p.policy = p.curr
+	p.nextToken()
+	if p.curr.Type != Newline {
+		return p.expectedError("newline")
+	}
+	return nil
+}
+
+// expectPolicyOf returns the policy of p: its own or the one of its block, and
+// otherwise the one of the interpreter.
+func (root *state) expectPolicyOf(p Parameter) ExpectPolicy {
+	switch p.policy.Literal {
+	case expectFail:
+		return ExpectFail
+	case expectWarn:
+		return ExpectWarn
+	case expectSkip:
+		return ExpectSkip
+	default:
+		return root.expect
+	}
+}
@@ -0,0 +1,89 @@
+package dissect
+
+import (
+	"strconv"
+)
+
+// FieldInfo describes a field that the records printed by a program can hold.
+type FieldInfo struct {
+	Block     string // block the field is decoded in, empty for the fields of let
+	Id        string
+	Type      string // int, uint, float, string, bytes or time, empty for the fields of let
+	Bits      int    // size of the field, 0 when it depends on the decoded data
+	Repeated  bool   // in a repeat or an array: a record can hold several values
+	Converted bool   // has an enum, a polynomial or a pointpair
+}
+
+// Fields returns the fields decoded and computed by the program in the order of
+// the script. A field found several times in a block is only given once. The
+// fields not printed, whose id starts with an underscore, are not given.
+func (p *Program) Fields() []FieldInfo {
+	var (
+		infos []FieldInfo
+		seen  = make(map[[2]string]int)
+	)
+	add := func(fi FieldInfo) {
+		if fi.Id == "" || fi.Id[0] == underscore {
+			return
+		}
+		key := [2]string{fi.Block, fi.Id}
+		if i, ok := seen[key]; ok {
+			infos[i].Repeated = infos[i].Repeated || fi.Repeated
+			return
+		}
+		seen[key] = len(infos)
+		infos = append(infos, fi)
+	}
+	var walk func(Node, string, bool)
+	walk = func(node Node, block string, repeated bool) {
+		switch n := node.(type) {
+		case Data:
+			walk(n.pre, block, repeated)
+			walk(n.Block, block, repeated)
+			walk(n.post, block, repeated)
+		case Block:
+			walk(n.pre, block, repeated)
+			for _, x := range n.nodes {
+				walk(x, n.id.Literal, repeated)
+			}
+			walk(n.post, block, repeated)
+		case Include:
+			walk(n.node, block, repeated)
+		case Repeat:
+			walk(n.node, block, true)
+		case If:
+			walk(n.csq, block, repeated)
+			walk(n.alt, block, repeated)
+		case Match:
+			for _, c := range n.nodes {
+				walk(c.node, block, repeated)
+			}
+			walk(n.alt.node, block, repeated)
+		case Transition:
+			walk(n.node, block, repeated)
+		case Let:
+			add(FieldInfo{Id: n.id.Literal, Repeated: repeated})
+		case Parameter:
+			bits, _ := strconv.Atoi(n.size.Literal)
+			add(FieldInfo{
+				Block:     block,
+				Id:        n.id.Literal,
+				Type:      kindType(n.is()),
+				Bits:      bits,
+				Repeated:  repeated || len(n.dims) > 0,
+				Converted: n.apply != nil,
+			})
+		}
+	}
+	walk(p.data, "", false)
+	return infos
+}
+
+func kindType(k Kind) string {
+	switch k {
+	case kindInt, kindUint, kindFloat, kindString, kindBytes:
+		return k.String()
+	default:
+		return kwTime
+	}
+}
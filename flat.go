@@ -0,0 +1,289 @@
+package dissect
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// flatValue is the form in which the merged tree of a compiled program is
+// saved. The nodes of the tree only have unexported fields and are held in
+// interfaces, so they can not be given to gob as they are: each value is
+// flattened into the fields of a flatValue, the values of a struct, a slice or
+// a map being kept in order in Values.
+type flatValue struct {
+	Type   string // concrete type of a value held in an interface
+	Nil    bool
+	Int    int64
+	Uint   uint64
+	Float  float64
+	Str    string
+	Bool   bool
+	Bytes  []byte
+	Values []flatValue
+}
+
+// flatTypes are the types that can be held by the interfaces of the tree.
+var flatTypes = make(map[string]reflect.Type)
+
+func init() {
+	nodes := []interface{}{
+		Literal{},
+		Identifier{},
+		Call{},
+		Unary{},
+		Assignment{},
+		Binary{},
+		Ternary{},
+		Member{},
+		Index{},
+		Echo{},
+		Copy{},
+		Print{},
+		Continue{},
+		Break{},
+		Exit{},
+		Peek{},
+		Seek{},
+		Mark{},
+		Del{},
+		Let{},
+		Push{},
+		Dedupe{},
+		Length{},
+		Sync{},
+		Summary{},
+		ASN1{},
+		Protobuf{},
+		Extract{},
+		Transition{},
+		Limits{},
+		Check{},
+		Parameter{},
+		Reference{},
+		MatchCase{},
+		Match{},
+		If{},
+		Repeat{},
+		Include{},
+		Constant{},
+		Pair{},
+		Data{},
+		Pragma{},
+		Block{},
+		Sink{},
+		Token{},
+		typedef{},
+	}
+	for _, n := range nodes {
+		t := reflect.TypeOf(n)
+		flatTypes[t.String()] = t
+	}
+}
+
+// flatten gives the flat form of v. v should be addressable so that its
+// unexported fields can be read.
+func flatten(v reflect.Value) (flatValue, error) {
+	var (
+		fv  flatValue
+		err error
+	)
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			fv.Nil = true
+			break
+		}
+		e := v.Elem()
+		if _, ok := flatTypes[e.Type().String()]; !ok {
+			return fv, fmt.Errorf("%s: type can not be compiled", e.Type())
+		}
+		c := reflect.New(e.Type()).Elem()
+		c.Set(e)
+		if fv, err = flatten(c); err == nil {
+			fv.Type = e.Type().String()
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			fv.Nil = true
+			break
+		}
+		fv, err = flatten(v.Elem())
+	case reflect.Struct:
+		if v.Type() == timeType {
+			fv.Bytes, err = v.Interface().(time.Time).MarshalBinary()
+			break
+		}
+		for i := 0; i < v.NumField(); i++ {
+			x, err := flatten(exposeField(v.Field(i)))
+			if err != nil {
+				return fv, err
+			}
+			fv.Values = append(fv.Values, x)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			fv.Nil = true
+			break
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			fv.Bytes = append([]byte{}, v.Bytes()...)
+			break
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			x, err := flatten(v.Index(i))
+			if err != nil {
+				return fv, err
+			}
+			fv.Values = append(fv.Values, x)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			fv.Nil = true
+			break
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, k := range keys {
+			for _, x := range []reflect.Value{k, v.MapIndex(k)} {
+				c := reflect.New(x.Type()).Elem()
+				c.Set(x)
+				x, err := flatten(c)
+				if err != nil {
+					return fv, err
+				}
+				fv.Values = append(fv.Values, x)
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fv.Int = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fv.Uint = v.Uint()
+	case reflect.Float32, reflect.Float64:
+		fv.Float = v.Float()
+	case reflect.String:
+		fv.Str = v.String()
+	case reflect.Bool:
+		fv.Bool = v.Bool()
+	default:
+		err = fmt.Errorf("%s: type can not be compiled", v.Type())
+	}
+	return fv, err
+}
+
+// unflatten sets v, that should be settable, from its flat form fv.
+func unflatten(fv flatValue, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		if fv.Nil {
+			return nil
+		}
+		t, ok := flatTypes[fv.Type]
+		if !ok {
+			return fmt.Errorf("%s: unknown type", fv.Type)
+		}
+		c := reflect.New(t).Elem()
+		if err := unflatten(fv, c); err != nil {
+			return err
+		}
+		if !t.Implements(v.Type()) {
+			return fmt.Errorf("%s: type does not implement %s", t, v.Type())
+		}
+		v.Set(c)
+	case reflect.Ptr:
+		if fv.Nil {
+			return nil
+		}
+		c := reflect.New(v.Type().Elem())
+		if err := unflatten(fv, c.Elem()); err != nil {
+			return err
+		}
+		v.Set(c)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			var t time.Time
+			if err := t.UnmarshalBinary(fv.Bytes); err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+		if len(fv.Values) != v.NumField() {
+			return fmt.Errorf("%s: want %d fields, got %d", v.Type(), v.NumField(), len(fv.Values))
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if err := unflatten(fv.Values[i], exposeField(v.Field(i))); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if fv.Nil {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte{}, fv.Bytes...))
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), len(fv.Values), len(fv.Values)))
+		fallthrough
+	case reflect.Array:
+		if len(fv.Values) != v.Len() {
+			return fmt.Errorf("%s: want %d values, got %d", v.Type(), v.Len(), len(fv.Values))
+		}
+		for i := range fv.Values {
+			if err := unflatten(fv.Values[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if fv.Nil {
+			return nil
+		}
+		if len(fv.Values)%2 != 0 {
+			return fmt.Errorf("%s: odd number of values", v.Type())
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(fv.Values)/2)
+		for i := 0; i < len(fv.Values); i += 2 {
+			var (
+				key = reflect.New(v.Type().Key()).Elem()
+				val = reflect.New(v.Type().Elem()).Elem()
+			)
+			if err := unflatten(fv.Values[i], key); err != nil {
+				return err
+			}
+			if err := unflatten(fv.Values[i+1], val); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(fv.Int)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(fv.Uint)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(fv.Float)
+	case reflect.String:
+		v.SetString(fv.Str)
+	case reflect.Bool:
+		v.SetBool(fv.Bool)
+	default:
+		return fmt.Errorf("%s: type can not be compiled", v.Type())
+	}
+	return nil
+}
+
+// exposeField gives a field of an addressable struct that can be read and set
+// even when the field is unexported.
+func exposeField(f reflect.Value) reflect.Value {
+	if f.CanSet() {
+		return f
+	}
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
@@ -0,0 +1,14 @@
+package dissect
+
+import _ "embed"
+
+//go:embed grammar.ebnf
+var grammar string
+
+// Grammar returns the grammar of the language supported by the parser in EBNF.
+// The tests check that every production used is defined and that the keywords
+// are the ones of the parser. The scripts of testdata/conformance give examples
+// of what the parser accepts and rejects.
+func Grammar() string {
+	return grammar
+}
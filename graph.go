@@ -0,0 +1,166 @@
+package dissect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Graph writes in the DOT language the graph of the blocks of the script read
+// from r. An edge goes from a block to each block it includes. The edges
+// created by a match, an if or a conditional include are labelled with the
+// condition leading to the included block. The blocks that can not be reached
+// from the data block are drawn dashed.
+func Graph(w io.Writer, r io.Reader) error {
+	n, err := Parse(r)
+	if err != nil {
+		return err
+	}
+	root, ok := n.(Block)
+	if !ok {
+		return fmt.Errorf("root node is not a block")
+	}
+	dat, err := root.ResolveData()
+	if err != nil {
+		return err
+	}
+	g := graph{
+		root: root,
+		seen: make(map[graphEdge]struct{}),
+	}
+	g.walkNode(kwData, dat.pre, "pre")
+	g.walkNode(kwData, dat.post, "post")
+	g.walkBlock(kwData, dat.Block, "")
+	for _, n := range root.nodes {
+		if b, ok := n.(Block); ok && b.id.Type != Keyword {
+			g.walkBlock(b.id.Literal, b, "")
+		}
+	}
+
+	reached := map[string]bool{kwData: true}
+	for changed := true; changed; {
+		changed = false
+		for _, e := range g.edges {
+			if reached[e.from] && !reached[e.to] {
+				reached[e.to], changed = true, true
+			}
+		}
+	}
+
+	ws := bufio.NewWriter(w)
+	fmt.Fprintln(ws, "digraph schema {")
+	fmt.Fprintln(ws, "  node [shape=box];")
+	fmt.Fprintf(ws, "  %s [style=bold];\n", strconv.Quote(kwData))
+	for _, n := range root.nodes {
+		b, ok := n.(Block)
+		if !ok || b.id.Type == Keyword {
+			continue
+		}
+		attr := ""
+		if !reached[b.id.Literal] {
+			attr = " [style=dashed, color=red]"
+		}
+		fmt.Fprintf(ws, "  %s%s;\n", strconv.Quote(b.id.Literal), attr)
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(ws, "  %s -> %s", strconv.Quote(e.from), strconv.Quote(e.to))
+		if e.label != "" {
+			fmt.Fprintf(ws, " [label=%s]", strconv.Quote(e.label))
+		}
+		fmt.Fprintln(ws, ";")
+	}
+	fmt.Fprintln(ws, "}")
+	return ws.Flush()
+}
+
+type graphEdge struct {
+	from  string
+	to    string
+	label string
+}
+
+type graph struct {
+	root  Block
+	edges []graphEdge
+	seen  map[graphEdge]struct{}
+}
+
+func (g *graph) link(from, to, label string) {
+	e := graphEdge{from: from, to: to, label: label}
+	if _, ok := g.seen[e]; ok {
+		return
+	}
+	g.seen[e] = struct{}{}
+	g.edges = append(g.edges, e)
+}
+
+// walkBlock follows the statements of b. Inline blocks are not drawn: their
+// includes are linked to from, the closest named block.
+func (g *graph) walkBlock(from string, b Block, label string) {
+	g.walkNode(from, b.pre, label)
+	for _, n := range b.nodes {
+		switch n := n.(type) {
+		case Reference:
+			g.walkNode(from, n, label)
+		case Block:
+			g.walkBlock(from, n, label)
+		case Include:
+			lbl := label
+			if n.cond != nil {
+				lbl = joinLabel(label, n.cond.String())
+			}
+			g.walkNode(from, n.node, lbl)
+		case Repeat:
+			g.walkNode(from, n.node, joinLabel(label, kwRepeat))
+		case If:
+			g.walkIf(from, n, label)
+		case Match:
+			for _, c := range n.nodes {
+				g.walkNode(from, c.node, joinLabel(label, matchLabel(n, c)))
+			}
+			g.walkNode(from, n.alt.node, joinLabel(label, "default"))
+		}
+	}
+	g.walkNode(from, b.post, label)
+}
+
+func (g *graph) walkIf(from string, i If, label string) {
+	g.walkNode(from, i.csq, joinLabel(label, i.expr.String()))
+	switch alt := i.alt.(type) {
+	case If:
+		g.walkIf(from, alt, joinLabel(label, kwElse))
+	case nil:
+	default:
+		g.walkNode(from, alt, joinLabel(label, kwElse))
+	}
+}
+
+func (g *graph) walkNode(from string, n Node, label string) {
+	switch n := n.(type) {
+	case Block:
+		g.walkBlock(from, n, label)
+	case Reference:
+		if _, err := g.root.ResolveBlock(n.id.Literal); err == nil {
+			g.link(from, n.id.Literal, label)
+		}
+	}
+}
+
+func matchLabel(m Match, c MatchCase) string {
+	str := c.cond.String()
+	if c.upto != nil {
+		str = fmt.Sprintf("%s..%s", str, c.upto)
+	}
+	if m.expr != nil {
+		str = fmt.Sprintf("%s = %s", m.expr, str)
+	}
+	return str
+}
+
+func joinLabel(outer, inner string) string {
+	if outer == "" {
+		return inner
+	}
+	return outer + ", " + inner
+}
@@ -0,0 +1,262 @@
+package dissect
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	influxTag  = "tag"
+	influxTime = kwTime
+)
+
+// influxSpec tells how the records are written as lines of the InfluxDB line
+// protocol: the measurement, the fields written as tags and the field giving
+// the timestamp of the lines.
+type influxSpec struct {
+	measurement string
+	tags        []string
+	time        string
+}
+
+func (s *influxSpec) String() string {
+	var str strings.Builder
+	str.WriteString("measurement=")
+	str.WriteString(s.measurement)
+	for _, t := range s.tags {
+		str.WriteString(", tag=")
+		str.WriteString(t)
+	}
+	if s.time != "" {
+		str.WriteString(", time=")
+		str.WriteString(s.time)
+	}
+	return str.String()
+}
+
+// parseInflux parses the options following the influx format, if any: the
+// measurement, by default the name of the block of the print statement, then
+// the tags and the time field, eg influx(hk, tag=apid, time=ts).
+func (p *Parser) parseInflux() (*influxSpec, error) {
+	var spec influxSpec
+	if p.peek.Type != lparen {
+		return &spec, nil
+	}
+	p.nextToken()
+	p.nextToken()
+	if p.curr.isIdent() && p.peek.Type != Assign {
+		spec.measurement = p.curr.Literal
+		p.nextToken()
+		if p.curr.Type == comma {
+			p.nextToken()
+		}
+	}
+	for p.curr.Type != rparen && !p.isDone() {
+		key := p.curr
+		p.nextToken()
+		if p.curr.Type != Assign {
+			return nil, p.expectedError("=")
+		}
+		p.nextToken()
+		if !p.curr.isIdent() {
+			return nil, p.expectedError("ident")
+		}
+		switch key.Literal {
+		case influxTag:
+			spec.tags = append(spec.tags, p.curr.Literal)
+		case influxTime:
+			spec.time = p.curr.Literal
+		default:
+			return nil, fmt.Errorf("print: unknown influx option %s (%s)", TokenString(key), key.Pos())
+		}
+		p.nextToken()
+		switch p.curr.Type {
+		case comma:
+			p.nextToken()
+		case rparen:
+		default:
+			return nil, p.expectedError(")")
+		}
+	}
+	if p.curr.Type != rparen {
+		return nil, p.expectedError(")")
+	}
+	return &spec, nil
+}
+
+// influxPrinter gives the printer of the records as lines of the InfluxDB line
+// protocol. The tags and the time field are looked up among all the fields of
+// the record, the fields whose name starts with an underscore included. The
+// other fields are written as fields of the line, null values being left out.
+// The timestamp is given by a time value or by a number of seconds since the
+// Unix epoch and is the current time without time field.
+func (root *state) influxPrinter(spec *influxSpec, method string) (printFunc, bool) {
+	if method == methDebug {
+		return nil, false
+	}
+	if spec == nil {
+		spec = &influxSpec{}
+	}
+	var (
+		measurement = spec.measurement
+		names       = root.columns
+	)
+	if measurement == "" {
+		measurement = root.namedBlock()
+	}
+	isKey := func(id string) bool {
+		if id == spec.time {
+			return true
+		}
+		for _, t := range spec.tags {
+			if t == id {
+				return true
+			}
+		}
+		return false
+	}
+	print := func(buf []byte, values []Field) []byte {
+		var (
+			line  = len(buf)
+			count int
+			stamp = time.Now().UnixNano()
+			last  = make(map[string]int)
+		)
+		// a key is given once per line: the last value of the fields sharing
+		// the same name, eg in a repeat, is kept
+		for i, v := range values {
+			last[v.Id] = i
+		}
+		buf = appendInfluxKey(buf, measurement, true)
+		for _, t := range spec.tags {
+			if i, ok := last[t]; ok {
+				v := values[i]
+				str := asString(v.Raw())
+				if method == methEng {
+					str = asString(v.Eng())
+				}
+				if str != "" {
+					buf = append(buf, comma)
+					buf = appendInfluxKey(buf, t, false)
+					buf = append(buf, '=')
+					buf = appendInfluxKey(buf, str, false)
+				}
+			}
+		}
+		if i, ok := last[spec.time]; ok && spec.time != "" {
+			stamp = influxStamp(values[i].Eng(), stamp)
+		}
+		for i, v := range values {
+			if last[v.Id] != i || v.Skip() || isKey(v.Id) {
+				continue
+			}
+			switch method {
+			case methEng:
+				buf, count = appendInfluxField(buf, v.Id, v.Eng(), count)
+			case methBoth:
+				buf, count = appendInfluxField(buf, v.Id+names.raw, v.Raw(), count)
+				buf, count = appendInfluxField(buf, v.Id+names.eng, v.Eng(), count)
+			default:
+				buf, count = appendInfluxField(buf, v.Id, v.Raw(), count)
+			}
+		}
+		if count == 0 {
+			// a line without field is rejected by InfluxDB
+			return buf[:line]
+		}
+		buf = append(buf, space)
+		buf = strconv.AppendInt(buf, stamp, 10)
+		return append(buf, '\n')
+	}
+	return print, true
+}
+
+func appendInfluxField(buf []byte, id string, v Value, count int) ([]byte, int) {
+	if _, ok := v.(*Null); ok || v == nil {
+		return buf, count
+	}
+	if count == 0 {
+		buf = append(buf, space)
+	} else {
+		buf = append(buf, comma)
+	}
+	buf = appendInfluxKey(buf, id, false)
+	buf = append(buf, '=')
+	switch v := v.(type) {
+	case *Int:
+		buf = strconv.AppendInt(buf, v.Raw, 10)
+		buf = append(buf, 'i')
+	case *Uint:
+		if v.Raw > math.MaxInt64 {
+			buf = strconv.AppendUint(buf, v.Raw, 10)
+			buf = append(buf, 'u')
+		} else {
+			buf = strconv.AppendUint(buf, v.Raw, 10)
+			buf = append(buf, 'i')
+		}
+	case *Real:
+		buf = strconv.AppendFloat(buf, v.Raw, 'g', -1, 64)
+	case *Boolean:
+		buf = strconv.AppendBool(buf, v.Raw)
+	default:
+		buf = append(buf, '"')
+		for _, c := range []byte(string(appendRaw(nil, v, encText))) {
+			if c == '"' || c == '\\' {
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, c)
+		}
+		buf = append(buf, '"')
+	}
+	return buf, count + 1
+}
+
+// appendInfluxKey appends a measurement, a tag or a field key with the
+// characters having a meaning in the line protocol escaped.
+func appendInfluxKey(buf []byte, str string, measurement bool) []byte {
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case ',', ' ':
+			buf = append(buf, '\\', c)
+		case '=':
+			if !measurement {
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, c)
+		case '\n':
+			buf = append(buf, ' ')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
+
+// influxStamp gives the timestamp, in nanoseconds, of v. def is returned when v
+// is not a time or a number.
+func influxStamp(v Value, def int64) int64 {
+	switch v := v.(type) {
+	case *Time:
+		return v.Raw.UnixNano()
+	case *Int, *Uint:
+		return asInt(v) * int64(time.Second)
+	case *Real:
+		return int64(v.Raw * float64(time.Second))
+	default:
+		return def
+	}
+}
+
+// namedBlock gives the name of the innermost block, the inline blocks not named
+// with as being skipped.
+func (root *state) namedBlock() string {
+	for i := len(root.blocks) - 1; i >= 0; i-- {
+		if b := strings.TrimRight(root.blocks[i], "$"); !strings.HasPrefix(b, kwInline+"-") {
+			return b
+		}
+	}
+	return root.currentBlock()
+}
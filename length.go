@@ -0,0 +1,115 @@
+package dissect
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	lengthDecl = "length"
+	blockLimit = "limit"
+)
+
+// decodeLength sets the size, in bytes, of the packet being decoded. It is
+// given from the start of the packet and must cover what has already been
+// decoded.
+func (root *state) decodeLength(n Length) error {
+	v, err := eval(n.expr, root)
+	if err != nil {
+		return err
+	}
+	size := int(asInt(v)) * numbit
+	if size < root.Pos {
+		return fmt.Errorf("length: %d bytes already decoded but packet length is %d bytes", root.Pos/numbit, size/numbit)
+	}
+	root.length = size
+	// the whole packet is read at once so that inputs like TCP connections are
+	// framed on the declared length and not on what the reads return.
+	return root.growBuffer(size - root.Pos)
+}
+
+// checkFrame reports an error when a field of bits would be decoded past the
+// declared length of the packet. Without it, the field would be decoded from
+// the bytes of the next packet.
+func (root *state) checkFrame(bits int) error {
+	if n := len(root.limits); n > 0 && root.Pos+bits > root.limits[n-1] {
+		return fmt.Errorf("limit: field needs %d bits but only %d left in block", bits, root.limits[n-1]-root.Pos)
+	}
+	if root.length == 0 || root.Pos+bits <= root.length {
+		return nil
+	}
+	return fmt.Errorf("length: field needs %d bits but only %d left in packet", bits, root.length-root.Pos)
+}
+
+// endPacket checks that the packet did not consume more than its declared
+// length and skips the bytes left after the last field (padding).
+func (root *state) endPacket() error {
+	if root.length == 0 || root.partial {
+		return nil
+	}
+	if root.Pos > root.length {
+		return fmt.Errorf("length: %d bytes decoded but packet length is %d bytes", root.Pos/numbit, root.length/numbit)
+	}
+	if err := root.growBuffer(root.length - root.Pos); err != nil {
+		return err
+	}
+	if avail := root.Size(); avail < root.length {
+		return fmt.Errorf("%w: packet length is %d bytes, only %d available", ErrShort, root.length/numbit, avail/numbit)
+	}
+	root.Pos = root.length
+	return nil
+}
+
+// frameEnd gives the position of the end of the frame being decoded: the limit
+// of the innermost limited block, the declared length of the packet or, without
+// them, the end of the input that is then read entirely.
+func (root *state) frameEnd() (int, error) {
+	if n := len(root.limits); n > 0 {
+		return root.limits[n-1], nil
+	}
+	if root.length > 0 {
+		return root.length, nil
+	}
+	for !root.eof {
+		if err := root.readBuffer(0); err != nil {
+			return 0, err
+		}
+	}
+	return root.Size(), nil
+}
+
+// decodeLimit decodes a block restricted to the number of bytes given by its
+// limit: decoding a field past the limit is an error and the bytes not used by
+// the block are skipped.
+func (root *state) decodeLimit(data Block) error {
+	v, err := eval(data.limit, root)
+	if err != nil {
+		return err
+	}
+	size := int(asInt(v)) * numbit
+	if size < 0 {
+		return fmt.Errorf("limit: negative size for %s (%d bytes)", data.id.Literal, size/numbit)
+	}
+	if err := root.checkFrame(size); err != nil {
+		return err
+	}
+	end := root.Pos + size
+	root.limits = append(root.limits, end)
+	data.limit = nil
+	err = root.decodeBlock(data)
+	root.limits = root.limits[:len(root.limits)-1]
+
+	if err != nil && !errors.Is(err, errBreak) && !errors.Is(err, errContinue) {
+		return err
+	}
+	if root.Pos > end {
+		return fmt.Errorf("limit: %d bits decoded by %s but limit is %d bytes", root.Pos-end+size, data.id.Literal, size/numbit)
+	}
+	if e := root.growBuffer(end - root.Pos); e != nil {
+		return e
+	}
+	if root.Pos = end; root.Pos > root.Size() {
+		return fmt.Errorf("%w: limit of %s goes past the end of the packet", ErrShort, data.id.Literal)
+	}
+	return err
+}
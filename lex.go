@@ -0,0 +1,119 @@
+package dissect
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Trivia is a part of a script ignored by the parser: a run of blanks or a
+// comment, including the newline ending it.
+type Trivia struct {
+	Comment bool
+	Text    string
+	Pos     Position
+}
+
+// Lexeme is a token of a script with its text as written in the script and
+// the trivia preceding it.
+type Lexeme struct {
+	Token
+	Text    string
+	Leading []Trivia
+}
+
+// Lex splits the script read from r in lexemes, the last one being EOF.
+// Concatenating the text of the leading trivia and of each lexeme gives back
+// the script, if valid UTF-8, byte for byte, including its line endings.
+// Illegal tokens are returned as lexemes: it is up to the caller to report
+// them.
+func Lex(r io.Reader) ([]Lexeme, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var s Scanner
+	if err := s.Reset(bytes.NewReader(buf)); err != nil {
+		return nil, err
+	}
+	var (
+		crlf    = crlfOffsets(buf)
+		ls      []Lexeme
+		leading []Trivia
+	)
+	// source converts offsets in the buffer of the scanner, where \r\n are
+	// replaced by \n, to offsets in buf.
+	source := func(offset int) int {
+		return offset + sort.SearchInts(crlf, offset)
+	}
+	for {
+		var (
+			at    = s.offset()
+			pos   = Position{Line: s.line, Column: s.column}
+			tok   = s.Scan()
+			end   = s.offset()
+			start = at + len(s.buffer[at:end]) - len(bytes.TrimLeft(s.buffer[at:end], " \t"))
+		)
+		if start > at {
+			leading = append(leading, Trivia{
+				Text: string(buf[source(at):source(start)]),
+				Pos:  pos,
+			})
+		}
+		text := string(buf[source(start):source(end)])
+		if tok.Type == Comment {
+			leading = append(leading, Trivia{
+				Comment: true,
+				Text:    text,
+				Pos:     tok.Pos(),
+			})
+			continue
+		}
+		ls = append(ls, Lexeme{
+			Token:   tok,
+			Text:    text,
+			Leading: leading,
+		})
+		leading = nil
+		if tok.Type == EOF {
+			break
+		}
+	}
+	return ls, nil
+}
+
+// String returns the text of the lexeme preceded by its trivia.
+func (l Lexeme) String() string {
+	var str strings.Builder
+	for _, t := range l.Leading {
+		str.WriteString(t.Text)
+	}
+	str.WriteString(l.Text)
+	return str.String()
+}
+
+// offset returns the offset of the current character in the buffer.
+func (s *Scanner) offset() int {
+	if s.char == EOF {
+		return len(s.buffer)
+	}
+	return s.pos
+}
+
+// crlfOffsets returns the offsets, once normalized, of the \r\n of buf.
+func crlfOffsets(buf []byte) []int {
+	var (
+		offsets []int
+		removed int
+	)
+	for i := 0; i < len(buf)-1; i++ {
+		if buf[i] == '\r' && buf[i+1] == '\n' {
+			offsets = append(offsets, i-removed)
+			removed++
+			i++
+		}
+	}
+	return offsets
+}
@@ -0,0 +1,214 @@
+package dissect
+
+import (
+	"fmt"
+)
+
+const (
+	limitsDecl  = "limits"
+	limitYellow = "yellow"
+	limitRed    = "red"
+)
+
+// parseLimits parses the thresholds checked on the fields of each record, eg
+//
+//	limits to alarms (
+//	  temp: yellow [-10 .. 50] red [-20 .. 70]
+//	  hk.volt: red [min_volt ..]
+//	)
+//
+// The bounds are expressions and one of them can be left out. The violations
+// are written to the destination following to, stderr by default.
+func (p *Parser) parseLimits() (Node, error) {
+	n := Limits{
+		pos:  p.curr.Pos(),
+		file: Token{Literal: "-", Type: Ident},
+	}
+	p.nextToken()
+	if p.curr.Type == Keyword && p.curr.Literal == kwTo {
+		p.nextToken()
+		file, err := p.parseDestination()
+		if err != nil {
+			return nil, err
+		}
+		n.file = file
+		p.nextToken()
+	}
+	if p.curr.Type != lparen {
+		return nil, p.expectedError("(")
+	}
+	p.nextToken()
+	for !p.isDone() {
+		p.skipComment()
+		if p.curr.Type == rparen {
+			break
+		}
+		// keywords are accepted as names of fields and of blocks, eg data
+		isKeyword := p.curr.Type == Keyword && (p.peek.Type == colon || p.peek.Type == dot)
+		if !p.curr.isIdent() && !isKeyword {
+			return nil, p.expectedError("ident")
+		}
+		l, err := p.parseLimit()
+		if err != nil {
+			return nil, err
+		}
+		n.limits = append(n.limits, l)
+	}
+	return n, p.isClosed()
+}
+
+func (p *Parser) parseLimit() (Limit, error) {
+	l := Limit{id: p.curr}
+	p.nextToken()
+	// the name of the field can be qualified by the name of its block
+	for p.curr.Type == dot && p.peek.isIdent() {
+		p.nextToken()
+		l.id.Literal += "." + p.curr.Literal
+		p.nextToken()
+	}
+	if p.curr.Type != colon {
+		return l, p.expectedError(":")
+	}
+	p.nextToken()
+	for p.curr.Type != Newline && p.curr.Type != Comment && !p.isDone() {
+		if p.curr.Type != Ident || (p.curr.Literal != limitYellow && p.curr.Literal != limitRed) {
+			return l, fmt.Errorf("limits: unknown level %s (%s)", TokenString(p.curr), p.curr.Pos())
+		}
+		t := Threshold{level: p.curr}
+		p.nextToken()
+		if p.curr.Type != lsquare {
+			return l, p.expectedError("[")
+		}
+		p.nextToken()
+		if p.curr.Type != Range {
+			low, err := p.parsePredicate()
+			if err != nil {
+				return l, err
+			}
+			t.low = low
+		}
+		if p.curr.Type != Range {
+			return l, p.expectedError("..")
+		}
+		p.nextToken()
+		if p.curr.Type != rsquare {
+			high, err := p.parsePredicate()
+			if err != nil {
+				return l, err
+			}
+			t.high = high
+		} else {
+			p.nextToken()
+		}
+		if t.low == nil && t.high == nil {
+			return l, fmt.Errorf("limits: %s: no bound given (%s)", l.id.Literal, t.level.Pos())
+		}
+		// red is checked first: a value out of both ranges is only reported once
+		if t.level.Literal == limitRed {
+			l.thresholds = append([]Threshold{t}, l.thresholds...)
+		} else {
+			l.thresholds = append(l.thresholds, t)
+		}
+	}
+	if len(l.thresholds) == 0 {
+		return l, p.expectedError(limitYellow + "/" + limitRed)
+	}
+	return l, nil
+}
+
+// checkLimits checks the values of the fields of the record just decoded
+// against the thresholds of the limits blocks. A field is checked each time it
+// appears in the record, the elements of arrays one by one, and only its
+// numeric values are checked, in engineering units.
+//
+// Each violation is counted in the statistics and written as a record made of
+// the packet, the field, the level, the value and the bounds of the threshold.
+func (root *state) checkLimits() error {
+	if len(root.alarms) == 0 || root.drop {
+		return nil
+	}
+	for _, set := range root.alarms {
+		for _, l := range set.limits {
+			var fields []Field
+			for _, f := range root.Fields {
+				if f.Id == l.id.Literal || f.String() == l.id.Literal {
+					fields = append(fields, f)
+				}
+			}
+			for _, f := range explodeArrays(fields) {
+				if err := root.checkLimit(set, l, f); err != nil {
+					return fmt.Errorf("%s: %s: %w", limitsDecl, l.id.Literal, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (root *state) checkLimit(set Limits, l Limit, f Field) error {
+	v := f.Eng()
+	if !isNumber(v) {
+		return nil
+	}
+	for _, t := range l.thresholds {
+		low, high, err := t.bounds(root)
+		if err != nil {
+			return err
+		}
+		x := asReal(v)
+		if (low == nil || x >= asReal(low)) && (high == nil || x <= asReal(high)) {
+			continue
+		}
+		if low == nil {
+			low = &Null{}
+		}
+		if high == nil {
+			high = &Null{}
+		}
+		name := f.String()
+		root.addFailure(name, t.level.Literal)
+		if t.level.Literal == limitRed {
+			root.reds++
+		} else {
+			root.yellows++
+		}
+		values := []Field{
+			NewField("packet", &Int{Raw: int64(root.Loop)}),
+			NewField("field", &String{Raw: name}),
+			NewField("level", &String{Raw: t.level.Literal}),
+			NewField("value", v),
+			NewField("low", low),
+			NewField("high", high),
+		}
+		key := limitsDecl + "@" + set.pos.String()
+		w, s, created, err := root.openSink(key, set.file, true)
+		if err != nil {
+			return err
+		}
+		var (
+			format = Token{Literal: fmtCSV, Type: Ident}
+			method = Token{Literal: methEng, Type: Ident}
+		)
+		return root.writeRecord(w, s, created, format, method, nil, values, nil)
+	}
+	return nil
+}
+
+func (t Threshold) bounds(root *state) (Value, Value, error) {
+	var low, high Value
+	if t.low != nil {
+		v, err := eval(t.low, root)
+		if err != nil {
+			return nil, nil, err
+		}
+		low = v
+	}
+	if t.high != nil {
+		v, err := eval(t.high, root)
+		if err != nil {
+			return nil, nil, err
+		}
+		high = v
+	}
+	return low, high, nil
+}
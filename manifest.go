@@ -0,0 +1,88 @@
+package dissect
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// WithManifest makes Run and RunFiles write, when they return, a JSON manifest
+// of the output files in file.
+func WithManifest(file string) Option {
+	return func(i *Interpreter) error {
+		i.manifest = file
+		return nil
+	}
+}
+
+// Manifest lists the files written by the print, echo and copy statements so
+// that the transfer of the decoded products can be verified.
+type Manifest struct {
+	Created time.Time      `json:"created"`
+	Files   []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	File    string `json:"file"`
+	Records int    `json:"records"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// Manifest returns the manifest of the files written so far. It should be
+// called once the decoding is done, when the files are closed.
+func (i *Interpreter) Manifest() (Manifest, error) {
+	m := Manifest{
+		Created: time.Now().UTC(),
+		Files:   []ManifestFile{},
+	}
+	for file, out := range i.Stats().Outputs {
+		if isURL(file) {
+			// the records sent to HTTP endpoints leave no file to check
+			continue
+		}
+		f, err := checksumFile(file)
+		if err != nil {
+			return m, err
+		}
+		f.Records = out.Records
+		m.Files = append(m.Files, f)
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].File < m.Files[j].File
+	})
+	return m, nil
+}
+
+func (i *Interpreter) writeManifest() error {
+	m, err := i.Manifest()
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(i.manifest, append(buf, '\n'), 0644)
+}
+
+func checksumFile(file string) (ManifestFile, error) {
+	mf := ManifestFile{File: file}
+	r, err := os.Open(file)
+	if err != nil {
+		return mf, err
+	}
+	defer r.Close()
+
+	sum := sha256.New()
+	if mf.Size, err = io.Copy(sum, r); err != nil {
+		return mf, err
+	}
+	mf.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return mf, nil
+}
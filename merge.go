@@ -1,12 +1,36 @@
 package dissect
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 )
 
+// ErrNoData is returned when merging a script without data block, like the
+// files only defining blocks to be included by other scripts.
+var ErrNoData = errors.New("data block not found")
+
 func Merge(r io.Reader) (Node, error) {
-	n, err := Parse(r)
+	return merge(r, nil, nil)
+}
+
+// MergeFS parses and merges the script file found in fsys. Files included by
+// the script are also read from fsys.
+func MergeFS(fsys fs.FS, file string) (Node, error) {
+	r, err := fsys.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return merge(r, fsys, nil)
+}
+
+func merge(r io.Reader, fsys fs.FS, warn func(Warning)) (Node, error) {
+	return mergeTree(parse(r, fsys, warn))
+}
+
+func mergeTree(n Node, err error) (Node, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -14,6 +38,7 @@ func Merge(r io.Reader) (Node, error) {
 	if !ok {
 		return nil, fmt.Errorf("root node is not a block")
 	}
+	root = mergeSymbols(root)
 	for _, r := range root.GetReferences() {
 		n, err := mergeAlias(r, root)
 		if err != nil {
@@ -21,6 +46,9 @@ func Merge(r io.Reader) (Node, error) {
 		}
 		root.nodes = append(root.nodes, n)
 	}
+	if err := checkBlockIds(root); err != nil {
+		return nil, err
+	}
 	dat, err := root.ResolveData()
 	if err != nil {
 		return nil, err
@@ -29,13 +57,151 @@ func Merge(r io.Reader) (Node, error) {
 		return nil, err
 	} else {
 	}
+	for _, n := range root.nodes {
+		if g, ok := n.(Pragma); ok {
+			dat.version = g.version.Literal
+			break
+		}
+	}
+	for _, n := range root.nodes {
+		if l, ok := n.(Limits); ok {
+			if l.file, err = mergeDestination(l.file, root); err != nil {
+				return nil, err
+			}
+			dat.limits = append(dat.limits, l)
+		}
+	}
 	bck, err := mergeBlock(dat.Block, root)
 	if err == nil {
 		dat.Block = bck.(Block)
+		dat = mergeSinks(dat)
 	}
 	return dat, err
 }
 
+// checkBlockIds rejects the scripts where the same id is given to several
+// blocks, including the inline blocks named with "as". Only the first of them
+// would be found by the references while the records of both would be
+// filtered and reported under the same name.
+func checkBlockIds(root Block) error {
+	seen := make(map[string]Position)
+	var check func(Node) error
+	check = func(node Node) error {
+		var nodes []Node
+		switch n := node.(type) {
+		case Block:
+			if n.id.Type != Keyword {
+				if pos, ok := seen[n.id.Literal]; ok {
+					return fmt.Errorf("%s: block already defined at %s (%s)", n.id.Literal, pos, n.id.Pos())
+				}
+				seen[n.id.Literal] = n.id.Pos()
+			}
+			nodes = append(nodes, n.pre, n.post)
+			nodes = append(nodes, n.nodes...)
+		case Data:
+			nodes = append(nodes, n.pre, n.post)
+			nodes = append(nodes, n.nodes...)
+		case Include:
+			nodes = append(nodes, n.node)
+		case Repeat:
+			nodes = append(nodes, n.node)
+		case Transition:
+			nodes = append(nodes, n.node)
+		case If:
+			nodes = append(nodes, n.csq, n.alt)
+		case Match:
+			for _, c := range n.nodes {
+				nodes = append(nodes, c.node)
+			}
+			nodes = append(nodes, n.alt.node)
+		}
+		for _, n := range nodes {
+			if n == nil {
+				continue
+			}
+			if err := check(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, n := range root.nodes {
+		if err := check(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mergeSinks(dat Data) Data {
+	nodes := make([]Node, 0, len(dat.nodes))
+	for _, n := range dat.nodes {
+		if s, ok := n.(Sink); ok {
+			dat.sinks = append(dat.sinks, s)
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	dat.nodes = nodes
+	return dat
+}
+
+// mergeSymbols gathers all the declare and define blocks of the script and of
+// the files it includes into one declare block and one define block.
+//
+// When the same name is declared multiple times, the last declaration read by
+// the parser wins. Since included files are read where the include appears, a
+// script shadows the declarations of the files it includes before them.
+func mergeSymbols(root Block) Block {
+	var (
+		nodes   = make([]Node, 0, len(root.nodes))
+		declare Block
+		define  Block
+		params  = make(map[string]int)
+		consts  = make(map[string]int)
+	)
+	unify := func(dst *Block, src Block, seen map[string]int) {
+		if !dst.id.Pos().IsValid() {
+			dst.id = src.id
+		}
+		for _, n := range src.nodes {
+			key := n.String()
+			if c, ok := n.(Constant); ok {
+				key = c.id.Literal
+			}
+			if i, ok := seen[key]; ok {
+				dst.nodes[i] = n
+				continue
+			}
+			seen[key] = len(dst.nodes)
+			dst.nodes = append(dst.nodes, n)
+		}
+	}
+	for _, n := range root.nodes {
+		b, ok := n.(Block)
+		if !ok || b.id.Type != Keyword {
+			nodes = append(nodes, n)
+			continue
+		}
+		switch b.id.Literal {
+		case kwDeclare:
+			unify(&declare, b, params)
+		case kwDefine:
+			unify(&define, b, consts)
+		default:
+			nodes = append(nodes, n)
+		}
+	}
+	if len(params) > 0 {
+		nodes = append(nodes, declare)
+	}
+	if len(consts) > 0 {
+		nodes = append(nodes, define)
+	}
+	root.nodes = nodes
+	return root
+}
+
 func mergeData(dat Data, root Block) (Data, error) {
 	var err error
 	if dat.pre != nil {
@@ -65,6 +231,17 @@ func mergeBlock(dat, root Block) (Node, error) {
 		switch x := n.(type) {
 		default:
 			nx = n
+		case Print:
+			nx, err = mergePrint(x, root)
+		case Echo:
+			x.file, err = mergeDestination(x.file, root)
+			nx = x
+		case Copy:
+			x.file, err = mergeDestination(x.file, root)
+			nx = x
+		case Sink:
+			x.file, err = mergeDestination(x.file, root)
+			nx = x
 		case Block:
 			nx, err = mergeBlock(x, root)
 		case Parameter:
@@ -77,6 +254,9 @@ func mergeBlock(dat, root Block) (Node, error) {
 			nx, err = mergeMatch(x, root)
 		case If:
 			nx, err = mergeIf(x, root)
+		case Transition:
+			x.node, err = mergeNode(x.node, root)
+			nx = x
 		case Reference:
 			p, e := root.ResolveParameter(x.id.Literal)
 			if e == nil {
@@ -109,6 +289,37 @@ func mergeParameter(p Parameter, root Block) (Node, error) {
 	return p, err
 }
 
+func mergePrint(p Print, root Block) (Node, error) {
+	var err error
+	if p.file, err = mergeDestination(p.file, root); err != nil {
+		return nil, err
+	}
+	for i := range p.outputs {
+		if p.outputs[i].file, err = mergeDestination(p.outputs[i].file, root); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// mergeDestination replaces a destination referencing a constant by the value
+// of the constant. The value is used like a quoted path.
+func mergeDestination(tok Token, root Block) (Token, error) {
+	if tok.Type != constRef {
+		return tok, nil
+	}
+	c, err := root.ResolveConstant(tok.Literal)
+	if err != nil {
+		return tok, fmt.Errorf("%s: %s: constant not defined", tok.Pos(), tok.Literal)
+	}
+	lit, ok := c.value.(Literal)
+	if !ok {
+		return tok, fmt.Errorf("%s: %s: constant can not be used as destination", tok.Pos(), tok.Literal)
+	}
+	tok.Type, tok.Literal = Text, lit.id.Literal
+	return tok, nil
+}
+
 func mergeAlias(r Reference, root Block) (Node, error) {
 	dat, err := root.ResolveBlock(r.alias.Literal)
 	if err != nil {
@@ -195,3 +406,71 @@ func mergeNode(node Node, root Block) (Node, error) {
 	}
 	return mergeBlock(dat, root)
 }
+
+func filterData(dat Data, keep func(Block) bool) Data {
+	dat.Block = filterBlock(dat.Block, keep)
+	dat.pre = filterNode(dat.pre, keep)
+	dat.post = filterNode(dat.post, keep)
+	return dat
+}
+
+func filterBlock(dat Block, keep func(Block) bool) Block {
+	nodes := make([]Node, 0, len(dat.nodes))
+	for _, n := range dat.nodes {
+		if n = filterNode(n, keep); n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+	dat.nodes = nodes
+	dat.pre = filterNode(dat.pre, keep)
+	dat.post = filterNode(dat.post, keep)
+	return dat
+}
+
+func filterNode(node Node, keep func(Block) bool) Node {
+	if node == nil {
+		return nil
+	}
+	switch n := node.(type) {
+	case Block:
+		if !keep(n) {
+			return nil
+		}
+		return filterBlock(n, keep)
+	case Include:
+		if n.node = filterNode(n.node, keep); n.node == nil {
+			return nil
+		}
+		return n
+	case Repeat:
+		if n.node = filterNode(n.node, keep); n.node == nil {
+			return nil
+		}
+		return n
+	case If:
+		n.csq = filterNode(n.csq, keep)
+		n.alt = filterNode(n.alt, keep)
+		return n
+	case Transition:
+		n.node = filterNode(n.node, keep)
+		return n
+	case Match:
+		cs := make([]MatchCase, len(n.nodes))
+		for i, c := range n.nodes {
+			if c.node = filterNode(c.node, keep); c.node == nil {
+				c.node = emptyBlock(n.nodes[i].node.(Block).id)
+			}
+			cs[i] = c
+		}
+		n.nodes = cs
+		if n.alt.node != nil {
+			alt := n.alt.node
+			if n.alt.node = filterNode(alt, keep); n.alt.node == nil {
+				n.alt.node = emptyBlock(alt.(Block).id)
+			}
+		}
+		return n
+	default:
+		return node
+	}
+}
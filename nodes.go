@@ -45,6 +45,32 @@ func (i Identifier) isBoolean() bool {
 	return false
 }
 
+type Call struct {
+	id   Token
+	args []Expression
+	file string
+}
+
+func (c Call) String() string {
+	args := make([]string, len(c.args))
+	for i := range c.args {
+		args[i] = c.args[i].String()
+	}
+	return fmt.Sprintf("$%s(%s)", c.id.Literal, strings.Join(args, ", "))
+}
+
+func (c Call) Pos() Position {
+	return c.id.Pos()
+}
+
+func (c Call) exprNode() Node {
+	return c
+}
+
+func (c Call) isBoolean() bool {
+	return false
+}
+
 type Unary struct {
 	operator rune
 	Right    Expression
@@ -247,6 +273,29 @@ func (m Member) isBoolean() bool {
 	return false
 }
 
+// Index is an element of an array, eg samples[3].
+type Index struct {
+	arr   Expression
+	index Expression
+}
+
+func (i Index) String() string {
+	return fmt.Sprintf("%s[%s]", i.arr, i.index)
+}
+
+func (i Index) Pos() Position {
+	n := i.arr.exprNode()
+	return n.Pos()
+}
+
+func (i Index) exprNode() Node {
+	return i
+}
+
+func (i Index) isBoolean() bool {
+	return false
+}
+
 type Echo struct {
 	pos  Position
 	file Token
@@ -296,6 +345,14 @@ type Print struct {
 	format    Token // csv,...
 	values    []Token
 	predicate Expression
+	outputs   []Output
+	influx    *influxSpec
+}
+
+type Output struct {
+	file   Token
+	format Token
+	influx *influxSpec
 }
 
 func (p Print) Pos() Position {
@@ -307,8 +364,9 @@ func (p Print) String() string {
 }
 
 type Continue struct {
-	pos  Position
-	expr Expression
+	pos   Position
+	label Token
+	expr  Expression
 }
 
 func (c Continue) Pos() Position {
@@ -323,8 +381,9 @@ func (c Continue) String() string {
 }
 
 type Break struct {
-	pos  Position
-	expr Expression
+	pos   Position
+	label Token
+	expr  Expression
 }
 
 func (b Break) Pos() Position {
@@ -368,6 +427,7 @@ type Seek struct {
 	pos      Position
 	offset   Expression
 	absolute bool
+	end      bool
 }
 
 func (s Seek) String() string {
@@ -378,6 +438,25 @@ func (s Seek) Pos() Position {
 	return s.pos
 }
 
+// Mark saves the current position of the packet under a name or, with
+// restore, goes back to the position saved.
+type Mark struct {
+	pos     Position
+	id      Token
+	restore bool
+}
+
+func (m Mark) String() string {
+	if m.restore {
+		return fmt.Sprintf("%s(%s)", restoreDecl, m.id.Literal)
+	}
+	return fmt.Sprintf("%s(%s)", markDecl, m.id.Literal)
+}
+
+func (m Mark) Pos() Position {
+	return m.pos
+}
+
 type Del struct {
 	pos   Position
 	nodes []Node
@@ -418,13 +497,213 @@ func (p Push) Pos() Position {
 	return p.pos
 }
 
-type Parameter struct {
-	id     Token
-	size   Token
+type Dedupe struct {
+	pos    Position
+	file   string // file of the statement, its window being kept per statement
+	fields []Token
+	window int
+}
+
+func (d Dedupe) String() string {
+	fs := make([]string, len(d.fields))
+	for i := range d.fields {
+		fs[i] = d.fields[i].Literal
+	}
+	return fmt.Sprintf("dedupe(%s)", strings.Join(fs, ", "))
+}
+
+func (d Dedupe) Pos() Position {
+	return d.pos
+}
+
+type Length struct {
+	pos  Position
+	expr Expression
+}
+
+func (n Length) String() string {
+	return fmt.Sprintf("length(%s)", n.expr)
+}
+
+func (n Length) Pos() Position {
+	return n.pos
+}
+
+// Sync searches the input for a marker before decoding the rest of a packet.
+type Sync struct {
+	pos    Position
+	marker []byte
+}
+
+func (s Sync) String() string {
+	return fmt.Sprintf("sync(%x)", s.marker)
+}
+
+func (s Sync) Pos() Position {
+	return s.pos
+}
+
+// Summary gives statistics on the bytes of a region of the packet, eg their
+// entropy, as a field.
+type Summary struct {
+	pos   Position
+	kind  Token // entropy, histogram
+	count Expression
+	id    Token
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%s(%s)", s.kind.Literal, s.count)
+}
+
+func (s Summary) Pos() Position {
+	return s.pos
+}
+
+// ASN1 walks the BER encoded values of a region of the packet, giving a field
+// for each primitive value.
+type ASN1 struct {
+	pos  Position
+	size Expression
+	id   Token
+}
+
+func (a ASN1) String() string {
+	return fmt.Sprintf("%s(%s)", asn1Decl, a.size)
+}
+
+func (a ASN1) Pos() Position {
+	return a.pos
+}
+
+// Protobuf walks a message in protobuf wire format, giving a field for each
+// scalar value. The fields are named after the message of a .proto file when
+// one is given.
+type Protobuf struct {
+	pos     Position
+	size    Expression
+	id      Token
+	file    Token
+	schema  *protoSchema
+	message *protoMessage
+}
+
+func (p Protobuf) String() string {
+	return fmt.Sprintf("%s(%s)", protobufDecl, p.size)
+}
+
+func (p Protobuf) Pos() Position {
+	return p.pos
+}
+
+// Extract parses a textual region of the packet, eg json, giving a field for
+// each of its values.
+type Extract struct {
+	pos    Position
+	size   Expression
+	format Token // json, nmea, kv
+}
+
+func (e Extract) String() string {
+	return fmt.Sprintf("%s(%s)", textDecl, e.size)
+}
+
+func (e Extract) Pos() Position {
+	return e.pos
+}
+
+// Transition executes its body when the value of a field changes: to a given
+// value, from a given value or across a threshold.
+type Transition struct {
+	pos   Position
+	file  string // file of the statement, its previous value being kept per statement
+	field Token
+	bind  Token
+	cross Token      // above, below
+	from  Expression // previous value
+	to    Expression // new value or threshold
+	node  Node
+}
+
+func (t Transition) String() string {
+	return fmt.Sprintf("%s(%s)", onTransition, t.field.Literal)
+}
+
+func (t Transition) Pos() Position {
+	return t.pos
+}
+
+// Limits holds the thresholds checked on the fields of each record and the
+// destination of the violations.
+type Limits struct {
+	pos    Position
+	file   Token
+	limits []Limit
+}
+
+func (l Limits) String() string {
+	return fmt.Sprintf("%s(%s)", limitsDecl, l.file.Literal)
+}
+
+func (l Limits) Pos() Position {
+	return l.pos
+}
+
+type Limit struct {
+	id         Token
+	thresholds []Threshold // red first
+}
+
+// Threshold is the range of the values of a field for a level. A nil bound is
+// not checked.
+type Threshold struct {
+	level Token // yellow, red
+	low   Expression
+	high  Expression
+}
+
+type Check struct {
+	pos    Position
 	kind   Token
-	endian Token
-	apply  Node
-	expect Expression
+	fields []Token
+
+	// range and field of a checksum
+	from  Expression
+	to    Expression
+	field Token
+}
+
+func (c Check) String() string {
+	return fmt.Sprintf("check(%s)", c.kind.Literal)
+}
+
+func (c Check) Pos() Position {
+	return c.pos
+}
+
+func (c Check) includes(id string) bool {
+	for _, f := range c.fields {
+		if f.Literal == id {
+			return true
+		}
+	}
+	return false
+}
+
+type Parameter struct {
+	id        Token
+	size      Token
+	dims      []Token // number of elements of each dimension of an array
+	kind      Token
+	endian    Token
+	transform []Token // gray, bitrev, nibswap
+	parity    Token   // odd, even
+	encoding  Token   // twos (default), ones, signmag
+	bitorder  Token   // msb, lsb
+	time      ccsdsTime
+	apply     Node
+	expect    Expression
+	policy    Token // fail, warn, skip
 }
 
 func (p Parameter) String() string {
@@ -451,6 +730,10 @@ func (p Parameter) is() Kind {
 		return kindUnix
 	case kwGPS:
 		return kindGPS
+	case timeCUC:
+		return kindCUC
+	case timeCDS:
+		return kindCDS
 	}
 }
 
@@ -469,8 +752,11 @@ func (r Reference) Pos() Position {
 
 type MatchCase struct {
 	// cond Token
-	cond Expression
-	node Node
+	cond   Expression
+	upto   Expression
+	node   Node
+	prefix bool
+	bind   Token
 }
 
 func (m MatchCase) isDefault() bool {
@@ -522,7 +808,11 @@ func (i If) String() string {
 type Repeat struct {
 	pos    Position
 	repeat Expression
+	step   Expression // bits from the start of an iteration to the next
+	every  int        // only one iteration out of every is decoded
 	node   Node
+	label  Token
+	within bool
 }
 
 func (r Repeat) Pos() Position {
@@ -576,9 +866,25 @@ func (p Pair) Pos() Position {
 
 type Data struct {
 	Block
-	pre   Node
-	post  Node
-	files []Token
+	pre     Node
+	post    Node
+	files   []Token
+	sinks   []Sink
+	limits  []Limits
+	version string
+}
+
+type Pragma struct {
+	pos     Position
+	version Token
+}
+
+func (p Pragma) Pos() Position {
+	return p.pos
+}
+
+func (p Pragma) String() string {
+	return fmt.Sprintf("%s %s", pragmaVersion, p.version.Literal)
 }
 
 type Block struct {
@@ -586,6 +892,7 @@ type Block struct {
 
 	id    Token
 	nodes []Node
+	limit Expression // size of the block in bytes, if any
 
 	pre  Node
 	post Node
@@ -621,7 +928,7 @@ func (b Block) ResolveData() (Data, error) {
 			return dat, nil
 		}
 	}
-	return Data{}, fmt.Errorf("data block not found")
+	return Data{}, ErrNoData
 }
 
 func (b Block) GetReferences() []Reference {
@@ -695,10 +1002,12 @@ func (b Block) ResolvePair(pair string) (Pair, error) {
 }
 
 type typedef struct {
-	label  Token
-	kind   Token
-	size   Token
-	endian Token
+	label    Token
+	kind     Token
+	size     Token
+	endian   Token
+	bitorder Token
+	time     ccsdsTime
 }
 
 func (t typedef) Pos() Position {
@@ -0,0 +1,87 @@
+package dissect
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutputRoot is returned when a print, echo or copy statement writes to a
+// file outside of the output root set with WithOutputRoot.
+var ErrOutputRoot = errors.New("path outside of output root")
+
+// WithOutputRoot confines the files written by the print, echo and copy
+// statements to dir. Relative paths are resolved from dir and the paths
+// leading outside of it are rejected.
+func WithOutputRoot(dir string) Option {
+	return func(i *Interpreter) error {
+		if dir == "" {
+			i.outroot = ""
+			return nil
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		i.outroot = abs
+		return nil
+	}
+}
+
+// outputFile returns the path of the file created for file, written with
+// slashes in the script, under the output root if any and with the backfill
+// suffix when replaying archives.
+func (root *state) outputFile(file string) (string, error) {
+	file = filepath.FromSlash(file)
+	if root.backfill {
+		file = backfillFile(file)
+	}
+	if root.outroot == "" {
+		return file, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(root.outroot, file)
+	}
+	rel, err := filepath.Rel(root.outroot, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w", file, ErrOutputRoot)
+	}
+	return file, nil
+}
+
+// sanitizeName makes str, the value of a field used in a placeholder, usable as
+// one element of a path on all platforms. Path separators, characters reserved
+// on Windows and control characters are replaced by underscores. The names
+// reserved by Windows for devices and the special names . and .. are prefixed
+// with an underscore so that a value can neither escape a directory nor open
+// a device.
+func sanitizeName(str string) string {
+	str = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7F || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, str)
+	if str == "" || str == "." || str == ".." || isReservedName(str) {
+		return "_" + str
+	}
+	if last := str[len(str)-1]; last == '.' || last == ' ' {
+		str = str[:len(str)-1] + "_"
+	}
+	return str
+}
+
+func isReservedName(str string) bool {
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		str = str[:i]
+	}
+	switch str = strings.ToUpper(strings.TrimRight(str, " ")); str {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(str) == 4 && (strings.HasPrefix(str, "COM") || strings.HasPrefix(str, "LPT")) {
+		return str[3] >= '1' && str[3] <= '9'
+	}
+	return false
+}
@@ -3,11 +3,16 @@ package dissect
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -65,17 +70,71 @@ type Parser struct {
 	curr Token
 	peek Token
 
-	typedef map[string]typedef
+	typedef  map[string]typedef
+	bitorder Token
+	policy   Token
 
 	stmts  map[string]func() (Node, error)
 	kwords map[string]func() (Node, error)
 	blocks []string
+	pairs  []map[string]Pair
+	labels []string
 
-	inline int
+	inline  map[string]int
+	fsys    fs.FS
+	paths   []string
+	warn    func(Warning)
+	sources *sourceSet
+
+	offline bool
+	cache   string
+	hosts   hostPolicy
+
+	// closed is set once the bracket closing the expression being parsed is
+	// consumed; index counts the brackets of array elements still open.
+	closed bool
+	index  int
+}
+
+// Warning is a non fatal diagnostic reported by the parser, typically the use
+// of a deprecated syntax.
+type Warning struct {
+	File    string
+	Pos     Position
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s:%s: %s", w.File, w.Pos, w.Message)
 }
 
 func Parse(r io.Reader) (Node, error) {
-	var p Parser
+	return parse(r, nil, nil)
+}
+
+// ParseWithWarnings parses r like Parse and calls warn for each warning found
+// in the script.
+func ParseWithWarnings(r io.Reader, warn func(Warning)) (Node, error) {
+	return parse(r, nil, warn)
+}
+
+// ParseFS parses the script file found in fsys. Files included by the script
+// are also read from fsys.
+func ParseFS(fsys fs.FS, file string) (Node, error) {
+	r, err := fsys.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return parse(r, fsys, nil)
+}
+
+func parse(r io.Reader, fsys fs.FS, warn func(Warning)) (Node, error) {
+	return newParser(fsys, warn).parse(r)
+}
+
+func newParser(fsys fs.FS, warn func(Warning)) *Parser {
+	p := Parser{fsys: fsys, warn: warn}
 	p.kwords = map[string]func() (Node, error){
 		kwInclude: p.parseImport,
 		kwData:    p.parseData,
@@ -104,8 +163,16 @@ func Parse(r io.Reader) (Node, error) {
 		kwIf:       p.parseIf,
 		kwCopy:     p.parseCopy,
 		kwPush:     p.parsePush,
+		kwSink:     p.parseSink,
+		kwDedupe:   p.parseDedupe,
+		kwCheck:    p.parseCheck,
 	}
 	p.typedef = make(map[string]typedef)
+	p.inline = make(map[string]int)
+	return &p
+}
+
+func (p *Parser) parse(r io.Reader) (Node, error) {
 	if err := p.pushFrame(r); err != nil {
 		return nil, err
 	}
@@ -123,6 +190,24 @@ func (p *Parser) Parse() (Node, error) {
 		if p.isDone() {
 			break
 		}
+		if p.curr.Type == Ident && p.curr.Literal == pragmaVersion {
+			n, err := p.parsePragma()
+			if err != nil {
+				return nil, err
+			}
+			root.nodes = append(root.nodes, n)
+			continue
+		}
+		if p.curr.Type == Ident && p.curr.Literal == limitsDecl {
+			p.pushBlock(limitsDecl)
+			n, err := p.parseLimits()
+			if err != nil {
+				return nil, err
+			}
+			p.popBlock()
+			root.nodes = append(root.nodes, n)
+			continue
+		}
 		if p.curr.Type != Keyword {
 			return nil, p.unexpectedError()
 		}
@@ -143,6 +228,57 @@ func (p *Parser) Parse() (Node, error) {
 	return root, nil
 }
 
+// parsePragma parses the version of the language required by the script. It
+// fails if the version is more recent than the one supported by the parser.
+func (p *Parser) parsePragma() (Node, error) {
+	g := Pragma{pos: p.curr.Pos()}
+	p.nextToken()
+	if p.curr.Type != Float && p.curr.Type != Integer {
+		return nil, p.expectedError("version")
+	}
+	g.version = p.curr
+	want, err := parseVersion(g.version.Literal)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid version (%s)", g.version.Literal, g.pos)
+	}
+	if have, _ := parseVersion(Version); compareVersion(want, have) > 0 {
+		return nil, fmt.Errorf("script requires version %s of the language but only %s is supported (%s)", g.version.Literal, Version, g.pos)
+	}
+	p.nextToken()
+	if p.curr.Type != Newline && p.curr.Type != EOF {
+		return nil, p.expectedError("newline")
+	}
+	return g, nil
+}
+
+func parseVersion(str string) ([2]int, error) {
+	var (
+		vs         [2]int
+		err        error
+		major, min = str, "0"
+	)
+	if i := strings.IndexByte(str, dot); i >= 0 {
+		major, min = str[:i], str[i+1:]
+	}
+	if vs[0], err = strconv.Atoi(major); err != nil {
+		return vs, err
+	}
+	vs[1], err = strconv.Atoi(min)
+	return vs, err
+}
+
+func compareVersion(left, right [2]int) int {
+	for i := range left {
+		if left[i] < right[i] {
+			return -1
+		}
+		if left[i] > right[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
 func (p *Parser) parsePush() (Node, error) {
 	h := Push{
 		pos: p.curr.Pos(),
@@ -167,6 +303,356 @@ func (p *Parser) parsePush() (Node, error) {
 	return h, nil
 }
 
+// parseLength parses the declaration of the length of the packet: either the
+// name of a field or an expression between brackets.
+func (p *Parser) parseLength() (Node, error) {
+	n := Length{pos: p.curr.Pos()}
+	p.nextToken()
+	if p.curr.Type == Ident {
+		n.expr = Identifier{id: p.curr}
+		p.nextToken()
+	} else {
+		p.nextToken()
+		expr, err := p.parsePredicate()
+		if err != nil {
+			return nil, err
+		}
+		n.expr = expr
+	}
+	if p.curr.Type != Newline {
+		return nil, p.expectedError("newline")
+	}
+	return n, nil
+}
+
+func (p *Parser) parseSync() (Node, error) {
+	n := Sync{pos: p.curr.Pos()}
+	p.nextToken()
+	marker, err := parseMarker(p.curr.Literal)
+	if err != nil {
+		return nil, fmt.Errorf("sync: %w (%s)", err, p.curr.Pos())
+	}
+	n.marker = marker
+	p.nextToken()
+	if p.curr.Type != Newline {
+		return nil, p.expectedError("newline")
+	}
+	return n, nil
+}
+
+func (p *Parser) parseSummary() (Node, error) {
+	s := Summary{
+		pos:  p.curr.Pos(),
+		kind: p.curr,
+		id:   p.curr,
+	}
+	p.nextToken()
+	p.nextToken()
+	expr, err := p.parsePredicate()
+	if err != nil {
+		return nil, err
+	}
+	s.count = expr
+	if p.curr.Type == Keyword && p.curr.Literal == kwAs {
+		p.nextToken()
+		if !p.curr.isIdent() {
+			return nil, p.expectedError("ident")
+		}
+		s.id = p.curr
+		p.nextToken()
+	}
+	if p.curr.Type != Newline {
+		return nil, p.expectedError("newline")
+	}
+	return s, nil
+}
+
+func (p *Parser) parseASN1() (Node, error) {
+	a := ASN1{
+		pos: p.curr.Pos(),
+		id:  p.curr,
+	}
+	p.nextToken()
+	p.nextToken()
+	expr, err := p.parsePredicate()
+	if err != nil {
+		return nil, err
+	}
+	a.size = expr
+	if p.curr.Type == Keyword && p.curr.Literal == kwAs {
+		p.nextToken()
+		if !p.curr.isIdent() {
+			return nil, p.expectedError("ident")
+		}
+		a.id = p.curr
+		p.nextToken()
+	}
+	if p.curr.Type != Newline {
+		return nil, p.expectedError("newline")
+	}
+	return a, nil
+}
+
+func (p *Parser) parseProtobuf() (Node, error) {
+	b := Protobuf{
+		pos: p.curr.Pos(),
+		id:  p.curr,
+	}
+	p.nextToken()
+	p.nextToken()
+	expr, err := p.parsePredicate()
+	if err != nil {
+		return nil, err
+	}
+	b.size = expr
+	if p.curr.Type == Keyword && p.curr.Literal == kwWith {
+		p.nextToken()
+		if p.curr.Type != Text {
+			return nil, p.expectedError("string")
+		}
+		b.file = p.curr
+		p.nextToken()
+		if !p.curr.isIdent() {
+			return nil, p.expectedError("ident")
+		}
+		if b.schema, err = p.loadProto(b.file.Literal); err != nil {
+			return nil, fmt.Errorf("%s: %w (%s)", protobufDecl, err, b.file.Pos())
+		}
+		if b.message, err = b.schema.message(p.curr.Literal); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w (%s)", protobufDecl, b.file.Literal, err, p.curr.Pos())
+		}
+		p.nextToken()
+	}
+	if p.curr.Type == Keyword && p.curr.Literal == kwAs {
+		p.nextToken()
+		if !p.curr.isIdent() {
+			return nil, p.expectedError("ident")
+		}
+		b.id = p.curr
+		p.nextToken()
+	}
+	if p.curr.Type != Newline {
+		return nil, p.expectedError("newline")
+	}
+	return b, nil
+}
+
+func (p *Parser) parseText() (Node, error) {
+	e := Extract{pos: p.curr.Pos()}
+	p.nextToken()
+	p.nextToken()
+	expr, err := p.parsePredicate()
+	if err != nil {
+		return nil, err
+	}
+	e.size = expr
+	if p.curr.Type != Keyword || p.curr.Literal != kwAs {
+		return nil, p.expectedError(kwAs)
+	}
+	p.nextToken()
+	if p.curr.Type != Ident || !isTextFormat(p.curr.Literal) {
+		return nil, p.expectedError("json/nmea/kv")
+	}
+	e.format = p.curr
+	p.nextToken()
+	if p.curr.Type != Newline {
+		return nil, p.expectedError("newline")
+	}
+	return e, nil
+}
+
+func (p *Parser) parseDedupe() (Node, error) {
+	d := Dedupe{
+		pos:    p.curr.Pos(),
+		file:   p.currentFile(),
+		window: defaultWindow,
+	}
+	p.nextToken()
+	if p.curr.Type != Ident || p.curr.Literal != dedupeBy {
+		return nil, p.expectedError(dedupeBy)
+	}
+	p.nextToken()
+	for p.curr.Type == Ident && p.curr.Literal != dedupeWindow {
+		d.fields = append(d.fields, p.curr)
+		p.nextToken()
+	}
+	if len(d.fields) == 0 {
+		return nil, p.expectedError("ident")
+	}
+	if p.curr.Type == Ident && p.curr.Literal == dedupeWindow {
+		p.nextToken()
+		if p.curr.Type != Integer {
+			return nil, p.expectedError("integer")
+		}
+		n, err := strconv.Atoi(p.curr.Literal)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("dedupe: invalid window %s (%s)", p.curr.Literal, p.curr.Pos())
+		}
+		d.window = n
+		p.nextToken()
+	}
+	if p.curr.Type != Newline {
+		return nil, p.unexpectedError()
+	}
+	return d, nil
+}
+
+func (p *Parser) parseCheck() (Node, error) {
+	c := Check{pos: p.curr.Pos()}
+	p.nextToken()
+	if p.curr.Type != Ident {
+		return nil, p.expectedError("ident")
+	}
+	switch p.curr.Literal {
+	case checkHamming, checkSecDed:
+		c.kind = p.curr
+	case checkCRC16, checkCRC32, checkSum, checkXor:
+		c.kind = p.curr
+		return c, p.parseChecksum(&c)
+	default:
+		return nil, fmt.Errorf("check: unknown method %s (%s)", TokenString(p.curr), p.curr.Pos())
+	}
+	p.nextToken()
+	for p.curr.isIdent() {
+		c.fields = append(c.fields, p.curr)
+		p.nextToken()
+	}
+	if len(c.fields) == 0 {
+		return nil, p.expectedError("ident")
+	}
+	if p.curr.Type != Newline {
+		return nil, p.unexpectedError()
+	}
+	return c, nil
+}
+
+// parseChecksum parses the optional byte range and the field holding the
+// checksum: check crc32 [from .. to] = field.
+func (p *Parser) parseChecksum(c *Check) error {
+	p.nextToken()
+	if p.curr.Type == lsquare {
+		p.nextToken()
+		from, err := p.parsePredicate()
+		if err != nil {
+			return err
+		}
+		if p.curr.Type != Range {
+			return p.expectedError("..")
+		}
+		p.nextToken()
+		to, err := p.parsePredicate()
+		if err != nil {
+			return err
+		}
+		c.from, c.to = from, to
+	}
+	if p.curr.Type != Assign {
+		return p.expectedError("=")
+	}
+	p.nextToken()
+	if !p.curr.isIdent() {
+		return p.expectedError("ident")
+	}
+	c.field = p.curr
+	p.nextToken()
+	if p.curr.Type != Newline {
+		return p.unexpectedError()
+	}
+	return nil
+}
+
+func (p *Parser) parseSink() (Node, error) {
+	if len(p.blocks) != 2 || p.blocks[0] != kwData {
+		return nil, fmt.Errorf("sink: unexpected outside of data block (%s)", p.curr.Pos())
+	}
+	s := Sink{pos: p.curr.Pos()}
+	p.nextToken()
+	if p.curr.Type != Ident {
+		return nil, p.expectedError("ident")
+	}
+	s.id = p.curr
+	p.nextToken()
+	if p.curr.Type != Assign {
+		return nil, p.expectedError("=")
+	}
+	p.nextToken()
+	if p.curr.Type != Ident {
+		return nil, p.expectedError("ident")
+	}
+	switch p.curr.Literal {
+	case sinkFile:
+		s.kind = p.curr
+	default:
+		return nil, fmt.Errorf("sink: unknown sink type %s (%s)", TokenString(p.curr), p.curr.Pos())
+	}
+	p.nextToken()
+	if p.curr.Type != lparen {
+		return nil, p.expectedError("(")
+	}
+	p.nextToken()
+	file, err := p.parseDestination()
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	p.nextToken()
+	for p.curr.Type == comma {
+		p.nextToken()
+		if p.curr.Type != Ident {
+			return nil, p.expectedError("ident")
+		}
+		switch p.curr.Literal {
+		case fmtCSV, fmtTuple, fmtSexp, fmtJSON, fmtInflux:
+			s.format = p.curr
+			p.nextToken()
+		case sinkAppend:
+			s.append = true
+			p.nextToken()
+		case sinkRotate:
+			p.nextToken()
+			var str strings.Builder
+			for p.curr.Type != comma && p.curr.Type != rparen && !p.isDone() {
+				str.WriteString(p.curr.Literal)
+				p.nextToken()
+			}
+			d, err := time.ParseDuration(str.String())
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("sink: invalid rotation interval %s (%s)", str.String(), p.curr.Pos())
+			}
+			s.rotate = d
+		case sinkCompress:
+			p.nextToken()
+			if p.curr.Type != Ident || !isCompression(p.curr.Literal) {
+				return nil, p.expectedError("gzip/zstd")
+			}
+			s.compress = p.curr.Literal
+			p.nextToken()
+		case sinkPartition:
+			p.nextToken()
+			if p.curr.Type != Ident || p.curr.Literal != partitionBy {
+				return nil, p.expectedError(partitionBy)
+			}
+			p.nextToken()
+			for p.curr.Type == Ident {
+				s.partition = append(s.partition, p.curr)
+				p.nextToken()
+			}
+			if len(s.partition) == 0 {
+				return nil, p.expectedError("ident")
+			}
+		default:
+			return nil, p.unexpectedError()
+		}
+	}
+	if err := p.isClosed(); err != nil {
+		return nil, err
+	}
+	if p.curr.Type != Newline {
+		return nil, p.expectedError("newline")
+	}
+	return s, nil
+}
+
 func (p *Parser) parseCopy() (Node, error) {
 	c := Copy{
 		pos:    p.curr.Pos(),
@@ -207,10 +693,11 @@ func (p *Parser) parseCopyTo(c *Copy) error {
 		return p.expectedError(kwTo)
 	}
 	p.nextToken()
-	if !p.curr.isIdent() {
-		return p.expectedError("ident")
+	file, err := p.parseDestination()
+	if err != nil {
+		return err
 	}
-	c.file = p.curr
+	c.file = file
 	p.nextToken()
 
 	switch p.curr.Type {
@@ -230,8 +717,8 @@ func (p *Parser) parseCopyTo(c *Copy) error {
 }
 
 func (p *Parser) parseCopyAs(c *Copy) error {
-	if p.curr.Literal != kwTo {
-		return p.expectedError(kwTo)
+	if p.curr.Literal != kwAs {
+		return p.expectedError(kwAs)
 	}
 	p.nextToken()
 	if p.curr.Type != Keyword {
@@ -306,12 +793,22 @@ func (p *Parser) parseEcho() (Node, error) {
 	e.expr = es
 
 	p.nextToken()
+	if p.curr.Type == Keyword && p.curr.Literal == kwTo {
+		p.nextToken()
+		file, err := p.parseDestination()
+		if err != nil {
+			return nil, err
+		}
+		e.file = file
+		p.nextToken()
+	}
 	return e, nil
 }
 
 func (p *Parser) parseEchoString() ([]Expression, error) {
 	var (
 		expr     []Expression
+		start    int
 		offset   int
 		template = p.curr.Literal
 	)
@@ -321,30 +818,34 @@ func (p *Parser) parseEchoString() ([]Expression, error) {
 			break
 		}
 		offset += i
-		if i > 0 && template[offset-1] != modulo {
+		if offset == 0 || template[offset-1] != modulo {
+			offset++
 			continue
 		}
 		tok := Token{
-			Literal: template[offset-i : offset-1],
+			Literal: echoLines(template[start : offset-1]),
 			Type:    Text,
 		}
-		j := strings.IndexByte(template[offset:], rsquare)
+		j := closingBracket(template[offset:])
 		if j < 0 {
 			return nil, fmt.Errorf("echo: expression not closed %s (%s)", template, p.curr.Pos())
 		}
 		if j <= 1 {
 			return nil, fmt.Errorf("echo: empty expression %s (%s)", template, p.curr.Pos())
 		}
-		e, err := parseString(template[offset+1 : offset+j])
+		at := p.curr.Pos()
+		at.Column += offset + 1
+		e, err := parseString(template[offset+1:offset+j], p.currentFile(), at)
 		if err != nil {
 			return nil, err
 		}
 		offset += j + 1
+		start = offset
 		expr = append(expr, Literal{id: tok}, e)
 	}
-	if str := template[offset:]; len(str) > 0 {
+	if str := template[start:]; len(str) > 0 {
 		tok := Token{
-			Literal: template[offset:],
+			Literal: echoLines(str),
 			Type:    Text,
 		}
 		expr = append(expr, Literal{id: tok})
@@ -352,6 +853,30 @@ func (p *Parser) parseEchoString() ([]Expression, error) {
 	return expr, nil
 }
 
+// echoLines ends the lines of the text of an echo, eg given by a heredoc, with
+// \r\n like the record written by echo.
+func echoLines(str string) string {
+	return strings.ReplaceAll(str, "\n", "\r\n")
+}
+
+// closingBracket returns the index of the bracket closing the one str starts
+// with, skipping the brackets of indices, or -1 if it is not closed.
+func closingBracket(str string) int {
+	var depth int
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case lsquare:
+			depth++
+		case rsquare:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 func (p *Parser) parsePrint() (Node, error) {
 	f := Print{
 		pos:    p.curr.Pos(),
@@ -398,15 +923,18 @@ func (p *Parser) parsePrintTo(f *Print) error {
 		return p.expectedError(kwTo)
 	}
 	p.nextToken()
-	if !p.curr.isIdent() {
-		return p.expectedError("ident")
+	file, err := p.parseDestination()
+	if err != nil {
+		return err
 	}
-	f.file = p.curr
+	f.file = file
 	p.nextToken()
 	switch p.curr.Type {
 	case Keyword:
 		if kw := p.curr.Literal; kw == kwAs {
 			return p.parsePrintAs(f)
+		} else if kw == kwAnd {
+			return p.parsePrintAnd(f)
 		} else if kw == kwWith {
 			return p.parsePrintWith(f)
 		} else if kw == kwIf {
@@ -414,28 +942,122 @@ func (p *Parser) parsePrintTo(f *Print) error {
 		} else {
 			return p.unexpectedError()
 		}
-	case Newline:
-	default:
-		return p.unexpectedError()
-	}
-	return nil
-}
-
-func (p *Parser) parsePrintAs(f *Print) error {
-	if p.curr.Literal != kwAs {
-		return p.expectedError(kwAs)
-	}
-	p.nextToken()
-	if p.curr.Type != Ident {
-		return p.expectedError("ident")
-	}
-	switch p.curr.Literal {
-	case fmtCSV, fmtTuple, fmtSexp:
-		f.format = p.curr
-	default:
-		return fmt.Errorf("print: unknown format %s (%s)", TokenString(p.curr), p.curr.Pos())
+	case Newline:
+	default:
+		return p.unexpectedError()
+	}
+	return nil
+}
+
+// parseDestination parses the destination of print, echo, copy and sink. The
+// destination is either:
+//
+// - a quoted path, with optional %(name) placeholders
+// - field NAME: the value of the decoded field NAME
+// - const NAME: the value of the constant NAME of the define block
+// - a bare identifier: a sink, the value of a field with this name or else
+// the name itself
+func (p *Parser) parseDestination() (Token, error) {
+	if !p.curr.isIdent() {
+		return p.curr, p.expectedError("ident")
+	}
+	if p.curr.Type != Ident || !p.peek.isIdent() {
+		return p.curr, nil
+	}
+	switch p.curr.Literal {
+	case destField:
+		p.nextToken()
+		tok := p.curr
+		tok.Type, tok.Literal = Text, fmt.Sprintf("%%(%s)", tok.Literal)
+		return tok, nil
+	case destConst:
+		p.nextToken()
+		tok := p.curr
+		tok.Type = constRef
+		return tok, nil
+	default:
+		return p.curr, nil
+	}
+}
+
+func (p *Parser) parsePrintAs(f *Print) error {
+	if p.curr.Literal != kwAs {
+		return p.expectedError(kwAs)
+	}
+	p.nextToken()
+	if p.curr.Type != Ident {
+		return p.expectedError("ident")
+	}
+	switch p.curr.Literal {
+	case fmtCSV, fmtTuple, fmtSexp, fmtJSON:
+		f.format = p.curr
+	case fmtInflux:
+		f.format = p.curr
+		spec, err := p.parseInflux()
+		if err != nil {
+			return err
+		}
+		f.influx = spec
+	default:
+		return fmt.Errorf("print: unknown format %s (%s)", TokenString(p.curr), p.curr.Pos())
+	}
+	p.nextToken()
+	switch p.curr.Type {
+	case Keyword:
+		if kw := p.curr.Literal; kw == kwAnd {
+			return p.parsePrintAnd(f)
+		} else if kw == kwWith {
+			return p.parsePrintWith(f)
+		} else if kw == kwIf {
+			return p.parsePrintIf(f)
+		} else {
+			return p.unexpectedError()
+		}
+	case Newline:
+	default:
+		return p.unexpectedError()
+	}
+	return nil
+}
+
+func (p *Parser) parsePrintAnd(f *Print) error {
+	for p.curr.Type == Keyword && p.curr.Literal == kwAnd {
+		p.nextToken()
+		if p.curr.Type != Keyword || p.curr.Literal != kwTo {
+			return p.expectedError(kwTo)
+		}
+		p.nextToken()
+		file, err := p.parseDestination()
+		if err != nil {
+			return err
+		}
+		o := Output{
+			file:   file,
+			format: Token{Literal: fmtCSV, Type: Ident},
+		}
+		p.nextToken()
+		if p.curr.Type == Keyword && p.curr.Literal == kwAs {
+			p.nextToken()
+			if p.curr.Type != Ident {
+				return p.expectedError("ident")
+			}
+			switch p.curr.Literal {
+			case fmtCSV, fmtTuple, fmtSexp, fmtJSON:
+				o.format = p.curr
+			case fmtInflux:
+				o.format = p.curr
+				spec, err := p.parseInflux()
+				if err != nil {
+					return err
+				}
+				o.influx = spec
+			default:
+				return fmt.Errorf("print: unknown format %s (%s)", TokenString(p.curr), p.curr.Pos())
+			}
+			p.nextToken()
+		}
+		f.outputs = append(f.outputs, o)
 	}
-	p.nextToken()
 	switch p.curr.Type {
 	case Keyword:
 		if kw := p.curr.Literal; kw == kwWith {
@@ -493,6 +1115,13 @@ func (p *Parser) parseContinue() (Node, error) {
 		pos: p.curr.Pos(),
 	}
 	p.nextToken()
+	if p.curr.Type == Ident {
+		if !p.inLabel(p.curr.Literal) {
+			return nil, fmt.Errorf("continue: %s: no enclosing repeat with this label (%s)", p.curr.Literal, p.curr.Pos())
+		}
+		c.label = p.curr
+		p.nextToken()
+	}
 	if p.curr.Type != lsquare {
 		return nil, p.expectedError("[")
 	}
@@ -517,6 +1146,13 @@ func (p *Parser) parseBreak() (Node, error) {
 		pos: p.curr.Pos(),
 	}
 	p.nextToken()
+	if p.curr.Type == Ident {
+		if !p.inLabel(p.curr.Literal) {
+			return nil, fmt.Errorf("break: %s: no enclosing repeat with this label (%s)", p.curr.Literal, p.curr.Pos())
+		}
+		b.label = p.curr
+		p.nextToken()
+	}
 	if p.curr.Type != lsquare {
 		return nil, p.expectedError("[")
 	}
@@ -539,6 +1175,10 @@ func (p *Parser) parseStatements() ([]Node, error) {
 	}
 	p.nextToken()
 
+	defer func(order, policy Token) {
+		p.bitorder, p.policy = order, policy
+	}(p.bitorder, p.policy)
+
 	var ns []Node
 	for !p.isDone() {
 		p.skipComment()
@@ -551,21 +1191,67 @@ func (p *Parser) parseStatements() ([]Node, error) {
 		)
 		switch pos := p.curr.Pos(); p.curr.Type {
 		case Keyword:
+			if p.peek.Type == colon {
+				// keywords followed by a colon are field names
+				p.curr.Type = Ident
+				node, err = p.parseField()
+				break
+			}
 			parse, ok := p.stmts[p.curr.Literal]
 			if !ok {
-				return nil, p.unexpectedError()
+				return nil, fmt.Errorf("%s: keyword not allowed here, quote it with ` to use it as an identifier (%s)", p.curr.Literal, p.curr.Pos())
 			}
 			p.pushBlock(p.curr.Literal)
 			node, err = parse()
 			p.popBlock()
 		case Ident, Text:
+			if p.curr.Literal == lengthDecl && (p.peek.Type == Ident || p.peek.Type == lsquare) {
+				node, err = p.parseLength()
+				break
+			}
+			if p.curr.Literal == syncDecl && p.peek.Type == Integer {
+				node, err = p.parseSync()
+				break
+			}
+			if isSummary(p.curr.Literal) && p.peek.Type == lsquare {
+				node, err = p.parseSummary()
+				break
+			}
+			if p.curr.Literal == asn1Decl && p.peek.Type == lsquare {
+				node, err = p.parseASN1()
+				break
+			}
+			if p.curr.Literal == protobufDecl && p.peek.Type == lsquare {
+				node, err = p.parseProtobuf()
+				break
+			}
+			if p.curr.Literal == textDecl && p.peek.Type == lsquare {
+				node, err = p.parseText()
+				break
+			}
+			if p.curr.Literal == onDecl && p.peek.Type == Ident && p.peek.Literal == onTransition {
+				node, err = p.parseTransition()
+				break
+			}
+			if (p.curr.Literal == markDecl || p.curr.Literal == restoreDecl) && p.peek.Type == Ident {
+				node, err = p.parseMark()
+				break
+			}
+			if p.curr.Literal == bitorderDecl && p.peek.Type == Ident {
+				err = p.parseBitOrder()
+				break
+			}
+			if p.curr.Literal == expectDecl && p.peek.Type == Ident {
+				err = p.parseExpectPolicy()
+				break
+			}
 			node, err = p.parseField()
 		case lparen:
 			xs, err := p.parseStatements()
 			if err != nil {
 				return nil, err
 			}
-			id, err := p.parseBlockId()
+			id, err := p.parseBlockId(xs)
 			if err != nil {
 				return nil, err
 			}
@@ -638,7 +1324,7 @@ func (p *Parser) parseBody() (Node, error) {
 		if err != nil {
 			return nil, err
 		}
-		id, err := p.parseBlockId()
+		id, err := p.parseBlockId(ns)
 		if err != nil {
 			return nil, err
 		}
@@ -661,9 +1347,24 @@ func (p *Parser) parseBody() (Node, error) {
 func (p *Parser) parseRepeat() (Node, error) {
 	r := Repeat{pos: p.curr.Pos()}
 	p.nextToken()
+	if p.curr.Type == Ident && (p.curr.Literal != repeatWithin || p.peek.Type == Ident) {
+		if p.inLabel(p.curr.Literal) {
+			return nil, fmt.Errorf("repeat: %s: label already used by enclosing repeat (%s)", p.curr.Literal, p.curr.Pos())
+		}
+		r.label = p.curr
+		p.nextToken()
+	}
+	if p.curr.Type == Ident && p.curr.Literal == repeatWithin {
+		r.within = true
+		p.nextToken()
+	}
 	if p.curr.Type != lsquare {
 		return nil, p.expectedError("[")
 	}
+	p.labels = append(p.labels, r.label.Literal)
+	defer func() {
+		p.labels = p.labels[:len(p.labels)-1]
+	}()
 	p.nextToken()
 	expr, err := p.parsePredicate()
 	if err != nil {
@@ -671,10 +1372,27 @@ func (p *Parser) parseRepeat() (Node, error) {
 	}
 	r.repeat = expr
 
+	if p.curr.Type == Ident && p.curr.Literal == repeatStep && p.peek.Type == lsquare {
+		p.nextToken()
+		p.nextToken()
+		if r.step, err = p.parsePredicate(); err != nil {
+			return nil, err
+		}
+	}
+	if p.curr.Type == Ident && p.curr.Literal == repeatDecimate && p.peek.Type == Integer {
+		p.nextToken()
+		n, err := strconv.ParseInt(p.curr.Literal, 0, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("repeat: decimate should be greater than 0 (%s)", p.curr.Pos())
+		}
+		r.every = int(n)
+		p.nextToken()
+	}
+
 	switch pos := p.curr.Pos(); p.curr.Type {
 	case lparen:
 		if ns, e := p.parseStatements(); e == nil {
-			id, err := p.parseBlockId()
+			id, err := p.parseBlockId(ns)
 			if err != nil {
 				return nil, err
 			}
@@ -721,6 +1439,9 @@ func (p *Parser) parseSeek() (Node, error) {
 		}
 		k.absolute = true
 		p.nextToken()
+	} else if p.curr.Type == Ident && p.curr.Literal == seekEnd {
+		k.end = true
+		p.nextToken()
 	}
 	if p.curr.Type != lsquare {
 		return nil, p.expectedError("[")
@@ -736,6 +1457,23 @@ func (p *Parser) parseSeek() (Node, error) {
 	return k, nil
 }
 
+func (p *Parser) parseMark() (Node, error) {
+	m := Mark{
+		pos:     p.curr.Pos(),
+		restore: p.curr.Literal == restoreDecl,
+	}
+	p.nextToken()
+	if !p.curr.isIdent() {
+		return nil, p.expectedError("ident")
+	}
+	m.id = p.curr
+	p.nextToken()
+	if p.curr.Type != Newline {
+		return nil, p.expectedError("newline")
+	}
+	return m, nil
+}
+
 func (p *Parser) parseLet() (Node, error) {
 	n := Let{id: p.peek}
 	p.nextToken()
@@ -799,6 +1537,7 @@ func (p *Parser) parseData() (Node, error) {
 }
 
 func (p *Parser) parsePredicate() (Expression, error) {
+	p.closed = false
 	expr, err := p.parseExpression(bindLowest)
 	if err == nil && p.peek.Type != colon {
 		p.nextToken()
@@ -814,7 +1553,8 @@ func (p *Parser) parseExpression(pow int) (Expression, error) {
 	checkPeek := func(peek rune) bool {
 		return peek == rsquare || peek == Newline || peek == Comment || peek == colon
 	}
-	for !checkPeek(p.peek.Type) && pow < bindPower(p.peek) {
+	// the closing bracket can already be consumed by the right operand
+	for !p.closed && !checkPeek(p.peek.Type) && pow < bindPower(p.peek) {
 		p.nextToken()
 		switch p.curr.Type {
 		case Cond:
@@ -828,8 +1568,32 @@ func (p *Parser) parseExpression(pow int) (Expression, error) {
 			return nil, err
 		}
 	}
-	if p.peek.Type == rsquare {
+	if p.peek.Type == rsquare && p.index == 0 {
+		p.nextToken()
+		p.closed = true
+	}
+	return expr, nil
+}
+
+// parseIndex parses the indices following expr, eg samples[3] or m[1][2].
+func (p *Parser) parseIndex(expr Expression) (Expression, error) {
+	for p.peek.Type == lsquare {
+		p.nextToken()
 		p.nextToken()
+		p.index++
+		index, err := p.parseExpression(bindLowest)
+		p.index--
+		if err != nil {
+			return nil, err
+		}
+		p.nextToken()
+		if p.curr.Type != rsquare {
+			return nil, p.expectedError("]")
+		}
+		expr = Index{
+			arr:   expr,
+			index: index,
+		}
 	}
 	return expr, nil
 }
@@ -903,12 +1667,13 @@ func (p *Parser) parsePrefix() (Expression, error) {
 		expr = n
 	case Integer, Float, Bool, Text:
 		expr = Literal{id: p.curr}
-	case Ident:
+	case Ident, Keyword:
 		id := p.curr
+		id.Type = Ident
 		if p.peek.Type == dot {
 			p.nextToken()
 			p.nextToken()
-			if p.curr.Type != Ident {
+			if p.curr.Type != Ident && p.curr.Type != Keyword {
 				return nil, p.expectedError("ident")
 			}
 			expr = Member{
@@ -918,7 +1683,11 @@ func (p *Parser) parsePrefix() (Expression, error) {
 		} else {
 			expr = Identifier{id: id}
 		}
+		return p.parseIndex(expr)
 	case Internal:
+		if p.peek.Type == lparen {
+			return p.parseCall()
+		}
 		expr = Identifier{id: p.curr}
 	default:
 		return nil, p.unexpectedError()
@@ -926,6 +1695,27 @@ func (p *Parser) parsePrefix() (Expression, error) {
 	return expr, nil
 }
 
+func (p *Parser) parseCall() (Expression, error) {
+	c := Call{id: p.curr, file: p.currentFile()}
+	p.nextToken()
+	for p.peek.Type != rparen {
+		p.nextToken()
+		arg, err := p.parseExpression(bindLowest)
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if p.peek.Type != comma && p.peek.Type != rparen {
+			return nil, p.expectedError(", or )")
+		}
+		if p.peek.Type == comma {
+			p.nextToken()
+		}
+	}
+	p.nextToken()
+	return c, nil
+}
+
 func (p *Parser) parseInfix(left Expression) (Expression, error) {
 	isComparison := func(op rune) bool {
 		return op == Lesser || op == Greater || op == LessEq || op == GreatEq
@@ -1003,8 +1793,8 @@ func (p *Parser) parseMatch() (Node, error) {
 			return nil, err
 		}
 		if alt {
-			if pos := match.alt.Pos(); pos.IsValid() {
-				return nil, fmt.Errorf("match: default case already set (%s)", pos)
+			if match.alt.node != nil {
+				return nil, fmt.Errorf("match: default case already set (%s)", match.Pos())
 			}
 			match.alt = mcs[0]
 		} else {
@@ -1020,7 +1810,7 @@ func (p *Parser) parseMatchCase(nocomma bool) ([]MatchCase, bool, error) {
 		alt bool
 	)
 	for !p.isDone() {
-		if p.curr.Type == colon {
+		if p.curr.Type == colon || (p.curr.Type == Keyword && p.curr.Literal == kwAs) {
 			break
 		}
 		if p.curr.Type == underscore {
@@ -1031,21 +1821,50 @@ func (p *Parser) parseMatchCase(nocomma bool) ([]MatchCase, bool, error) {
 			p.nextToken()
 			break
 		}
+		var prefix bool
+		if p.curr.Type == Ident && p.curr.Literal == matchPrefix && (p.peek.Type == Text || p.peek.Type == Integer) {
+			prefix = true
+			p.nextToken()
+		}
 		expr, err := p.parsePredicate()
 		if err != nil {
 			return nil, alt, err
 		}
 
-		mcs = append(mcs, MatchCase{cond: expr})
-		p.nextToken()
-		if p.curr.Type == comma {
+		mc := MatchCase{cond: expr, prefix: prefix}
+		if p.curr.Type == Range {
+			if nocomma || prefix {
+				return nil, alt, p.unexpectedError()
+			}
+			p.nextToken()
+			if mc.upto, err = p.parsePredicate(); err != nil {
+				return nil, alt, err
+			}
+		}
+		mcs = append(mcs, mc)
+		switch {
+		case p.curr.Type == comma:
 			if nocomma {
 				return nil, alt, p.unexpectedError()
 			}
 			p.nextToken()
+		case p.peek.Type == colon:
+			p.nextToken()
 		}
 	}
 
+	var bind Token
+	if p.curr.Type == Keyword && p.curr.Literal == kwAs {
+		if nocomma {
+			return nil, alt, fmt.Errorf("match: binding without value to match (%s)", p.curr.Pos())
+		}
+		p.nextToken()
+		if p.curr.Type != Ident {
+			return nil, alt, p.expectedError("ident")
+		}
+		bind = p.curr
+		p.nextToken()
+	}
 	if p.curr.Type != colon {
 		return nil, alt, p.expectedError(":")
 	}
@@ -1065,7 +1884,7 @@ func (p *Parser) parseMatchCase(nocomma bool) ([]MatchCase, bool, error) {
 		if err != nil {
 			return nil, alt, err
 		}
-		id, err := p.parseBlockId()
+		id, err := p.parseBlockId(ns)
 		if err != nil {
 			return nil, alt, err
 		}
@@ -1082,6 +1901,7 @@ func (p *Parser) parseMatchCase(nocomma bool) ([]MatchCase, bool, error) {
 
 	for i := range mcs {
 		mcs[i].node = node
+		mcs[i].bind = bind
 	}
 
 	return mcs, alt, nil
@@ -1105,7 +1925,7 @@ func (p *Parser) parseInclude() (Node, error) {
 		i.node, err = p.parseReference()
 	case lparen:
 		if ns, e := p.parseStatements(); e == nil {
-			id, err := p.parseBlockId()
+			id, err := p.parseBlockId(ns)
 			if err != nil {
 				return nil, err
 			}
@@ -1129,7 +1949,7 @@ func (p *Parser) parseFieldLong(id Token) (Node, error) {
 	var (
 		typok bool
 		lenok bool
-		a     = Parameter{id: id}
+		a     = Parameter{id: id, bitorder: p.bitorder, policy: p.policy}
 	)
 	if p.curr.Type == Keyword && p.curr.Literal == kwAs {
 		p.nextToken()
@@ -1188,11 +2008,27 @@ func (p *Parser) parseTypedef() (Node, error) {
 			case kwInt, kwUint, kwFloat, kwBytes, kwString:
 				td.kind, typok = p.curr, true
 				p.nextToken()
+			case kwTime:
+				td.kind, typok = p.curr, true
+				if p.peek.Type == lparen {
+					kind, spec, err := p.parseTime()
+					if err != nil {
+						return nil, err
+					}
+					td.kind, td.time = kind, spec
+					if isCCSDSTime(kind.Literal) {
+						td.size, lenok = Token{Type: Integer, Literal: strconv.Itoa(spec.bits()), pos: kind.pos}, true
+					}
+				}
+				p.nextToken()
 			default:
 				return nil, p.unexpectedError()
 			}
 		}
 		if p.curr.Type == Integer {
+			if isCCSDSTime(td.kind.Literal) {
+				return nil, fmt.Errorf("typedef: size of %s given by its segments (%s)", TokenString(td.label), p.curr.Pos())
+			}
 			td.size, lenok = p.curr, true
 			p.nextToken()
 		}
@@ -1204,6 +2040,14 @@ func (p *Parser) parseTypedef() (Node, error) {
 			}
 			p.nextToken()
 		}
+		if p.curr.Type == Ident && p.curr.Literal == bitorderDecl {
+			p.nextToken()
+			if p.curr.Type != Ident || !isBitOrder(p.curr.Literal) {
+				return nil, p.expectedError("msb/lsb")
+			}
+			td.bitorder = p.curr
+			p.nextToken()
+		}
 		if !typok && !lenok {
 			return nil, fmt.Errorf("typdef: type and length not set %s (%s)", TokenString(td.label), td.Pos())
 		}
@@ -1216,7 +2060,7 @@ func (p *Parser) parseFieldShort(id Token) (Node, error) {
 	var (
 		typok bool
 		lenok bool
-		a     = Parameter{id: id}
+		a     = Parameter{id: id, bitorder: p.bitorder, policy: p.policy}
 	)
 	p.nextToken()
 	if p.curr.Type == Keyword {
@@ -1224,17 +2068,13 @@ func (p *Parser) parseFieldShort(id Token) (Node, error) {
 		case kwInt, kwUint, kwFloat, kwBytes, kwString, kwTime:
 			a.kind, typok = p.curr, true
 			if lit == kwTime && p.peek.Type == lparen {
-				p.nextToken()
-				p.nextToken()
-				switch lit := p.curr.Literal; lit {
-				case kwUnix, kwGPS:
-					a.kind = p.curr
-				default:
-					return nil, p.unexpectedError()
+				kind, spec, err := p.parseTime()
+				if err != nil {
+					return nil, err
 				}
-				p.nextToken()
-				if p.curr.Type != rparen {
-					return nil, p.unexpectedError()
+				a.kind, a.time = kind, spec
+				if isCCSDSTime(kind.Literal) {
+					a.size = Token{Type: Integer, Literal: strconv.Itoa(spec.bits()), pos: kind.pos}
 				}
 			}
 			p.nextToken()
@@ -1246,16 +2086,30 @@ func (p *Parser) parseFieldShort(id Token) (Node, error) {
 			a.kind = td.kind
 			a.size = td.size
 			a.endian = td.endian
+			a.time = td.time
+			a.bitorder = td.bitorder
+			if a.bitorder.Literal == "" {
+				a.bitorder = p.bitorder
+			}
 		} else {
 			return nil, p.unexpectedError()
 		}
 		p.nextToken()
-		return a, nil
+		return a, p.parseDims(&a)
 	}
 	if p.curr.Type == Integer {
+		if isCCSDSTime(a.kind.Literal) {
+			return nil, fmt.Errorf("field: size of %s given by its segments (%s)", TokenString(a.id), p.curr.Pos())
+		}
 		a.size, lenok = p.curr, true
 		p.nextToken()
 	}
+	if isCCSDSTime(a.kind.Literal) {
+		lenok = true
+	}
+	if err := p.parseDims(&a); err != nil {
+		return nil, err
+	}
 	if p.curr.Type == Keyword {
 		if p.curr.Literal == kwBig || p.curr.Literal == kwLittle {
 			a.endian = p.curr
@@ -1264,12 +2118,65 @@ func (p *Parser) parseFieldShort(id Token) (Node, error) {
 		}
 		p.nextToken()
 	}
+	for p.curr.Type == Ident && isTransform(p.curr.Literal) {
+		a.transform = append(a.transform, p.curr)
+		p.nextToken()
+	}
+	if p.curr.Type == Ident && isSignEncoding(p.curr.Literal) {
+		if a.is() != kindInt {
+			return nil, fmt.Errorf("field: %s encoding only applies to int %s (%s)", p.curr.Literal, TokenString(a.id), p.curr.Pos())
+		}
+		a.encoding = p.curr
+		p.nextToken()
+	}
+	if p.curr.Type == Ident && p.curr.Literal == attrParity {
+		p.nextToken()
+		if p.curr.Literal != parityOdd && p.curr.Literal != parityEven {
+			return nil, p.expectedError("odd/even")
+		}
+		a.parity = p.curr
+		p.nextToken()
+	}
 	if !typok && !lenok {
 		return nil, fmt.Errorf("field: type and length not set %s (%s)", TokenString(a.id), a.Pos())
 	}
 	return a, nil
 }
 
+// parseDims parses the number of elements of an array field, either as a count
+// (samples: uint 12 * 64) or as the size of each of its dimensions (samples:
+// uint 12 [8][8]).
+func (p *Parser) parseDims(a *Parameter) error {
+	count := func() error {
+		if p.curr.Type != Integer {
+			return p.expectedError("integer")
+		}
+		if n, err := strconv.ParseInt(p.curr.Literal, 0, 64); err != nil || n <= 0 {
+			return fmt.Errorf("field: invalid number of elements for %s (%s)", TokenString(a.id), p.curr.Pos())
+		}
+		a.dims = append(a.dims, p.curr)
+		p.nextToken()
+		return nil
+	}
+	switch p.curr.Type {
+	case Mul:
+		p.nextToken()
+		return count()
+	case lsquare:
+		for p.curr.Type == lsquare {
+			p.nextToken()
+			if err := count(); err != nil {
+				return err
+			}
+			if p.curr.Type != rsquare {
+				return p.expectedError("]")
+			}
+			p.nextToken()
+		}
+	}
+	return nil
+}
+
 func (p *Parser) parseField() (node Node, err error) {
 	if !p.curr.isIdent() {
 		return nil, p.expectedError("ident")
@@ -1278,6 +2185,9 @@ func (p *Parser) parseField() (node Node, err error) {
 	id := p.curr
 	p.nextToken()
 
+	if id.Type == Text && p.curr.Type == colon {
+		p.warnf(id.Pos(), "double quoted field name %q is deprecated, use `%s` instead", id.Literal, id.Literal)
+	}
 	switch p.curr.Type {
 	case Newline:
 		node = Reference{id: id}
@@ -1297,13 +2207,25 @@ func (p *Parser) parseField() (node Node, err error) {
 			switch p.curr.Type {
 			case Text, Ident:
 				n.apply = p.curr
+				if a, ok := p.lookupPair(p.curr.Literal); ok {
+					n.apply = a
+				}
 				p.nextToken()
 			case Keyword:
 				apply, err := p.parsePairInline(true)
 				if err != nil {
 					return nil, err
 				}
-				n.apply = apply
+				a := apply.(Pair)
+				if a.id.Literal == "" {
+					a.id = Token{
+						Literal: id.Literal,
+						Type:    Ident,
+						pos:     a.kind.Pos(),
+					}
+				}
+				p.registerPair(a)
+				n.apply = a
 			default:
 				return nil, p.expectedError("ident")
 			}
@@ -1316,6 +2238,10 @@ func (p *Parser) parseField() (node Node, err error) {
 				return nil, err
 			}
 			n.expect = expr
+			if p.curr.Type == Ident && isExpectPolicy(p.curr.Literal) {
+				n.policy = p.curr
+				p.nextToken()
+			}
 		}
 		node = n
 	}
@@ -1407,10 +2333,18 @@ func (p *Parser) parseImport() (Node, error) {
 		if p.curr.Type == rparen {
 			break
 		}
-		if !p.curr.isIdent() {
+		file := p.curr.Literal
+		switch {
+		case p.curr.Type == Lesser:
+			std, err := p.parseStdName()
+			if err != nil {
+				return nil, err
+			}
+			file = std
+		case !p.curr.isIdent():
 			return nil, p.expectedError("ident")
 		}
-		files = append(files, p.curr.Literal)
+		files = append(files, file)
 
 		p.nextToken()
 		switch p.curr.Type {
@@ -1418,17 +2352,40 @@ func (p *Parser) parseImport() (Node, error) {
 			p.skipComment()
 		case Newline:
 			p.nextToken()
+		case rparen:
 		default:
 			return nil, p.unexpectedError()
 		}
 	}
+	for i := range files {
+		if isURL(files[i]) || isStd(files[i]) {
+			continue
+		}
+		if base := p.currentFile(); isURL(base) {
+			files[i] = resolveURL(base, files[i])
+			continue
+		}
+		files[i] = p.resolvePath(files[i])
+	}
 	for i := 0; i < len(files); i++ {
-		if infos, err := ioutil.ReadDir(files[i]); err == nil {
-			for _, j := range infos {
-				files = append(files, filepath.Join(files[i], j.Name()))
+		if isURL(files[i]) || isStd(files[i]) {
+			open := p.openURL
+			if isStd(files[i]) {
+				open = openStd
 			}
+			r, err := open(files[i])
+			if err != nil {
+				return nil, err
+			}
+			if err := p.pushFrame(r); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if names, err := p.readDir(files[i]); err == nil {
+			files = append(files, names...)
 		} else {
-			r, err := os.Open(files[i])
+			r, err := p.openFile(files[i])
 			if err != nil {
 				return nil, err
 			}
@@ -1442,14 +2399,93 @@ func (p *Parser) parseImport() (Node, error) {
 	return nil, p.isClosed()
 }
 
+// resolvePath expands the environment variables and the leading ~ found in
+// file and converts its separators to the ones expected by the file system
+// the script is read from. A relative path that does not exist from the
+// current directory is looked up relatively to the directory of the file that
+// includes it, then in the directories of the include path.
+func (p *Parser) resolvePath(file string) string {
+	file = os.ExpandEnv(file)
+	if p.fsys != nil {
+		return path.Clean(strings.ReplaceAll(file, "\\", "/"))
+	}
+	if file == "~" || strings.HasPrefix(file, "~/") || strings.HasPrefix(file, "~\\") {
+		if home, err := os.UserHomeDir(); err == nil {
+			file = home + file[1:]
+		}
+	}
+	file = filepath.Clean(filepath.FromSlash(file))
+	if filepath.IsAbs(file) {
+		return file
+	}
+	if _, err := os.Stat(file); err == nil {
+		return file
+	}
+	if f := p.currentFrame(); f != nil && f.file != "<input>" {
+		other := filepath.Join(filepath.Dir(f.file), file)
+		if _, err := os.Stat(other); err == nil {
+			return other
+		}
+	}
+	for _, dir := range p.paths {
+		other := filepath.Join(dir, file)
+		if _, err := os.Stat(other); err == nil {
+			return other
+		}
+	}
+	return file
+}
+
+func (p *Parser) readDir(dir string) ([]string, error) {
+	var names []string
+	if p.fsys == nil {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range infos {
+			names = append(names, filepath.Join(dir, i.Name()))
+		}
+	} else {
+		es, err := fs.ReadDir(p.fsys, path.Clean(dir))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range es {
+			names = append(names, path.Join(path.Clean(dir), e.Name()))
+		}
+	}
+	p.addSourceDir(dir, names)
+	return names, nil
+}
+
+func (p *Parser) openFile(file string) (io.ReadCloser, error) {
+	if p.fsys == nil {
+		return os.Open(file)
+	}
+	return p.fsys.Open(path.Clean(file))
+}
+
 func (p *Parser) parseBlock() (Node, error) {
 	p.nextToken()
 	if !p.curr.isIdent() {
 		return nil, p.unexpectedError()
 	}
+	if err := checkBlockId(p.curr); err != nil {
+		return nil, err
+	}
 	b := emptyBlock(p.curr)
 	p.nextToken()
 
+	if p.curr.Literal == blockLimit && p.peek.Type == lsquare {
+		p.nextToken()
+		p.nextToken()
+		expr, err := p.parsePredicate()
+		if err != nil {
+			return nil, err
+		}
+		b.limit = expr
+	}
 	if p.curr.Type == Lesser {
 		pre, post, err := p.parseDiamond()
 		if err != nil {
@@ -1536,6 +2572,10 @@ func (p *Parser) parsePairInline(inline bool) (Node, error) {
 		if p.curr.Type == rparen {
 			break
 		}
+		if p.curr.Type == Min && p.peek.Type == Integer {
+			p.nextToken()
+			p.curr.Literal = "-" + p.curr.Literal
+		}
 		n, err := p.parseAssignment()
 		if err != nil {
 			return nil, err
@@ -1548,24 +2588,21 @@ func (p *Parser) parsePairInline(inline bool) (Node, error) {
 	if !inline {
 		return a, nil
 	}
-	id, err := p.parseBlockId()
-	if err == nil {
-		if !id.pos.IsValid() {
-			id.Literal = fmt.Sprintf("%s-%s", a.kind.Literal, id.Literal)
-			id.pos = a.kind.Pos()
+	if p.curr.Type == Keyword && p.curr.Literal == kwAs {
+		p.nextToken()
+		if !p.curr.isIdent() {
+			return nil, p.expectedError("ident")
 		}
-		a.id = id
+		a.id = p.curr
+		p.nextToken()
 	}
-	return a, err
+	return a, nil
 }
 
 func (p *Parser) parseReference() (Node, error) {
 	ref := Reference{id: p.curr, alias: p.curr}
-	if p.peek.Type == Keyword {
+	if p.peek.Type == Keyword && p.peek.Literal == kwAs {
 		p.nextToken()
-		if p.curr.Literal != kwAs {
-			return nil, p.expectedError(kwAs)
-		}
 		p.nextToken()
 		ref.alias = p.curr
 	}
@@ -1573,25 +2610,50 @@ func (p *Parser) parseReference() (Node, error) {
 	return ref, nil
 }
 
-func (p *Parser) parseBlockId() (Token, error) {
-	var id Token
+// parseBlockId returns the id given to an inline block with "as". Otherwise,
+// the id is built from the content of the block so that it does not change
+// when unrelated parts of the schema are edited.
+func (p *Parser) parseBlockId(ns []Node) (Token, error) {
 	if p.curr.Type == Keyword && p.curr.Literal == kwAs {
 		p.nextToken()
 		if !p.curr.isIdent() {
 			return Token{}, p.expectedError("ident")
 		}
-		id = p.curr
+		if err := checkBlockId(p.curr); err != nil {
+			return Token{}, err
+		}
+		id := p.curr
 		p.nextToken()
+		return id, nil
+	}
+	sum := fnv.New32a()
+	io.WriteString(sum, p.currentBlock())
+	for _, n := range ns {
+		fmt.Fprintf(sum, "%T:%s;", n, n)
+	}
+	str := fmt.Sprintf("%s-%08x", kwInline, sum.Sum32())
+	if n := p.inline[str]; n > 0 {
+		p.inline[str]++
+		str = fmt.Sprintf("%s-%d", str, n)
 	} else {
-		id = Token{
-			Literal: fmt.Sprintf("%s-%d", kwInline, p.inline),
-			Type:    Keyword,
-		}
-		p.inline++
+		p.inline[str] = 1
+	}
+	id := Token{
+		Literal: str,
+		Type:    Keyword,
 	}
 	return id, nil
 }
 
+// checkBlockId rejects ids given by users that could collide with the ids
+// generated for inline blocks.
+func checkBlockId(id Token) error {
+	if id.Literal == kwInline || strings.HasPrefix(id.Literal, kwInline+"-") {
+		return fmt.Errorf("%s: reserved block id (%s)", id.Literal, id.Pos())
+	}
+	return nil
+}
+
 func (p *Parser) isDone() bool {
 	return len(p.frames) == 0 || p.curr.Type == EOF
 }
@@ -1617,6 +2679,15 @@ func (p *Parser) inBlock(id string) bool {
 	return false
 }
 
+func (p *Parser) inLabel(label string) bool {
+	for i := len(p.labels) - 1; i >= 0; i-- {
+		if p.labels[i] == label {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Parser) currentBlock() string {
 	n := len(p.blocks)
 	if n == 0 {
@@ -1627,6 +2698,7 @@ func (p *Parser) currentBlock() string {
 
 func (p *Parser) pushBlock(id string) {
 	p.blocks = append(p.blocks, id)
+	p.pairs = append(p.pairs, make(map[string]Pair))
 }
 
 func (p *Parser) popBlock() {
@@ -1635,6 +2707,24 @@ func (p *Parser) popBlock() {
 		return
 	}
 	p.blocks = p.blocks[:n-1]
+	p.pairs = p.pairs[:n-1]
+}
+
+// registerPair makes an inline pair available to the next fields of the
+// current block and of its nested blocks.
+func (p *Parser) registerPair(a Pair) {
+	if n := len(p.pairs); n > 0 {
+		p.pairs[n-1][a.id.Literal] = a
+	}
+}
+
+func (p *Parser) lookupPair(name string) (Pair, bool) {
+	for i := len(p.pairs) - 1; i >= 0; i-- {
+		if a, ok := p.pairs[i][name]; ok {
+			return a, true
+		}
+	}
+	return Pair{}, false
 }
 
 func (p *Parser) nextToken() {
@@ -1646,9 +2736,11 @@ func (p *Parser) nextToken() {
 
 	p.curr = p.peek
 	p.peek = p.frames[n].Scan()
-	if n -= 1; p.peek.Type == EOF && n >= 0 {
+	// an included file can end right after its own includes: the frames are
+	// popped until one still has tokens
+	for ; p.peek.Type == EOF && n > 0; n-- {
 		p.popFrame()
-		p.peek = p.frames[n].Scan()
+		p.peek = p.frames[n-1].Scan()
 	}
 }
 
@@ -1662,6 +2754,7 @@ func (p *Parser) pushFrame(r io.Reader) error {
 		if n, ok := r.(interface{ Name() string }); ok {
 			f.file = n.Name()
 		}
+		p.addSource(f.file, s.buffer)
 		f.Scan()
 		p.frames = append(p.frames, f)
 	}
@@ -1698,6 +2791,13 @@ func (p *Parser) peekToken() Token {
 	return tok
 }
 
+func (p *Parser) currentFile() string {
+	if f := p.currentFrame(); f != nil {
+		return f.file
+	}
+	return ""
+}
+
 func (p *Parser) currentFrame() *frame {
 	n := len(p.frames)
 	if n == 0 {
@@ -1720,6 +2820,21 @@ func (p *Parser) expectedError(want string) error {
 	return fmt.Errorf("(%s) %s(%s): expected %s, got %s", p.curr.Pos(), where, file, want, TokenString(p.curr))
 }
 
+func (p *Parser) warnf(pos Position, format string, args ...interface{}) {
+	if p.warn == nil {
+		return
+	}
+	w := Warning{
+		File:    "<input>",
+		Pos:     pos,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if f := p.currentFrame(); f != nil {
+		w.File = f.file
+	}
+	p.warn(w)
+}
+
 func (p *Parser) unexpectedError() error {
 	var (
 		file  = "<input>"
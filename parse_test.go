@@ -0,0 +1,214 @@
+package dissect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.dsl": {Data: []byte(`
+include (
+  "defs/header.lst"
+  "defs/types"
+)
+
+data (
+  include header
+  value: uint16
+  print raw as csv
+)
+`)},
+		"defs/header.lst": {Data: []byte(`
+block header (
+  apid: uint 8
+)
+`)},
+		"defs/types/uint.lst": {Data: []byte(`
+typedef (
+  uint16 = uint 16 big
+)
+`)},
+		"missing.dsl": {Data: []byte(`
+include ("defs/nothing.lst")
+data ()
+`)},
+	}
+	tests := []struct {
+		File string
+		Want string
+		Err  error
+	}{
+		{
+			File: "main.dsl",
+			Want: "\"apid\",\"value\"\r\n\"1\",\"515\"\r\n",
+		},
+		{
+			File: "missing.dsl",
+			Err:  fs.ErrNotExist,
+		},
+		{
+			File: "nothing.dsl",
+			Err:  fs.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.File, func(t *testing.T) {
+			if _, err := MergeFS(fsys, tt.File); !errors.Is(err, tt.Err) {
+				t.Fatalf("merging: want error %v, got %v", tt.Err, err)
+			}
+			if tt.Err != nil {
+				return
+			}
+			script, err := fsys.Open(tt.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer script.Close()
+
+			var buf bytes.Buffer
+			err = DissectToWriter(script, bytes.NewReader([]byte{1, 2, 3}), &buf, WithFS(fsys))
+			if err != nil {
+				t.Fatalf("decoding: unexpected error: %s", err)
+			}
+			if got := buf.String(); got != tt.Want {
+				t.Errorf("output mismatched: want %q, got %q", tt.Want, got)
+			}
+		})
+	}
+}
+
+func TestParseFSHostFiles(t *testing.T) {
+	// files only available on the host are not found once a fs.FS is given
+	fsys := fstest.MapFS{
+		"main.dsl": {Data: []byte(`include ("data/enums.lst")` + "\ndata ()\n")},
+	}
+	if _, err := ParseFS(fsys, "main.dsl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want %v, got %v", fs.ErrNotExist, err)
+	}
+	if _, err := Parse(strings.NewReader(`include ("data/enums.lst")` + "\ndata ()\n")); err != nil {
+		t.Fatalf("parsing from the host: unexpected error: %s", err)
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DEFS", "defs")
+
+	tests := []struct {
+		FS   bool
+		File string
+		Want string
+	}{
+		{FS: true, File: `defs\header.lst`, Want: "defs/header.lst"},
+		{FS: true, File: `.\defs\\types\..\header.lst`, Want: "defs/header.lst"},
+		{FS: true, File: "./defs//header.lst", Want: "defs/header.lst"},
+		{FS: true, File: "$DEFS/header.lst", Want: "defs/header.lst"},
+		{FS: true, File: "${DEFS}/types", Want: "defs/types"},
+		{File: "defs/../header.lst", Want: "header.lst"},
+		{File: "./defs//header.lst", Want: filepath.Join("defs", "header.lst")},
+		{File: "$DEFS/header.lst", Want: filepath.Join("defs", "header.lst")},
+		{File: "~", Want: home},
+		{File: "~/defs/header.lst", Want: filepath.Join(home, "defs", "header.lst")},
+		{File: "/etc/../defs/header.lst", Want: filepath.FromSlash("/defs/header.lst")},
+	}
+	for _, tt := range tests {
+		var fsys fs.FS
+		if tt.FS {
+			fsys = fstest.MapFS{}
+		}
+		p := newParser(fsys, nil)
+		if got := p.resolvePath(tt.File); got != tt.Want {
+			t.Errorf("%s (fs: %t): want %s, got %s", tt.File, tt.FS, tt.Want, got)
+		}
+	}
+}
+
+func TestIncludeRelative(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.dsl":       "include (\n  \"sub/types.lst\"\n)\ndata (\n  include header\n)\n",
+		"sub/types.lst":  "include (\n  \"header.lst\"\n)\n",
+		"sub/header.lst": "block header (\r\n  apid: uint 8\r\n)\r\n",
+	}
+	for name, body := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the included files are not found from the current directory but next
+	// to the file including them
+	r, err := os.Open(filepath.Join(dir, "main.dsl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := Merge(r); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	script := fmt.Sprintf("include (\n  %q\n)\ndata (\n  include header\n)\n", filepath.ToSlash(filepath.Join(dir, "sub", "types.lst")))
+	if _, err := Merge(strings.NewReader(script)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	// columns are counted in characters, not in bytes
+	tests := []struct {
+		Script string
+		Pos    string
+	}{
+		{Script: "data (\n  température: foo\n)", Pos: "(2:16)"},
+		{Script: "data (\n  日本: uint 8 \"été\"\n)", Pos: "(2:14)"},
+		{Script: "data (\n  e\u0301te\u0301: foo\n)", Pos: "(2:10)"},
+		{Script: "data (\n  a: uint 8\n  ñ: uint 8 1\n)", Pos: "(3:13)"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.Script))
+		if err == nil {
+			t.Errorf("%q: script accepted", tt.Script)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.Pos) {
+			t.Errorf("%q: want error at %s, got %s", tt.Script, tt.Pos, err)
+		}
+	}
+}
+
+func TestIncludePath(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		defs   = filepath.Join(dir, "defs")
+		script = "include (\n  \"header.lst\"\n)\ndata (\n  include header\n  print raw as csv\n)\n"
+	)
+	if err := os.MkdirAll(defs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(defs, "header.lst"), []byte("block header (\n  apid: uint 8\n)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Stat(strings.NewReader(script)); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("without include path: want %v, got %v", fs.ErrNotExist, err)
+	}
+	var buf bytes.Buffer
+	err := DissectToWriter(strings.NewReader(script), bytes.NewReader([]byte{1}), &buf, WithIncludePath(dir, defs))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, got := "\"apid\"\r\n\"1\"\r\n", buf.String(); got != want {
+		t.Errorf("output mismatched: want %q, got %q", want, got)
+	}
+}
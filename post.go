@@ -0,0 +1,193 @@
+package dissect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultPostBatch = 100
+	DefaultPostRetry = 3
+
+	DefaultPostWait = time.Second
+	postLinger      = time.Second
+)
+
+// WithPostBatch sets the number of records sent in each request to the HTTP
+// endpoints the records are printed to. The records not sent yet are sent at
+// the latest one second after the first of them when waiting for more input,
+// and when the output is closed. A value lesser or equal to one sends each
+// record on its own.
+func WithPostBatch(n int) Option {
+	return func(i *Interpreter) error {
+		i.post.batch = n
+		return nil
+	}
+}
+
+// WithPostRetry sets how many times a request to an HTTP endpoint is retried
+// when it fails because of the network, a server error or too many requests,
+// waiting wait before the first retry and twice as long before each next one.
+func WithPostRetry(n int, wait time.Duration) Option {
+	return func(i *Interpreter) error {
+		if n < 0 || wait < 0 {
+			return fmt.Errorf("post: invalid retry %d/%s", n, wait)
+		}
+		i.post.retry, i.post.wait = n, wait
+		return nil
+	}
+}
+
+type postPolicy struct {
+	batch int
+	retry int
+	wait  time.Duration
+}
+
+var defaultPost = postPolicy{
+	batch: DefaultPostBatch,
+	retry: DefaultPostRetry,
+	wait:  DefaultPostWait,
+}
+
+// endpoint buffers the records printed to an HTTP endpoint and sends them by
+// batches in the body of POST requests.
+type endpoint struct {
+	url    string
+	policy postPolicy
+	client *http.Client
+	out    *OutputStat
+
+	buf   bytes.Buffer
+	count int
+	first time.Time
+}
+
+// Write adds one record to the current batch and sends the batch once it is
+// full.
+func (e *endpoint) Write(b []byte) (int, error) {
+	if e.count == 0 {
+		e.first = time.Now()
+	}
+	e.buf.Write(b)
+	e.count++
+	e.out.Records++
+	e.out.Bytes += int64(len(b))
+	if e.count < e.policy.batch {
+		return len(b), nil
+	}
+	return len(b), e.Flush()
+}
+
+// Flush sends the records of the current batch, if any. The batch is dropped
+// when it can not be sent.
+func (e *endpoint) Flush() error {
+	if e.count == 0 {
+		return nil
+	}
+	defer func() {
+		e.buf.Reset()
+		e.count = 0
+	}()
+	var err error
+	for i, wait := 0, e.policy.wait; i <= e.policy.retry; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		var retry bool
+		if retry, err = e.send(e.buf.Bytes()); err == nil || !retry {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %d records not sent: %w", e.url, e.count, err)
+	}
+	return nil
+}
+
+// send posts body and reports whether the request should be retried when it
+// fails.
+func (e *endpoint) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType(body))
+	res, err := e.client.Do(req)
+	if err != nil {
+		return !errors.Is(err, ErrNetwork), err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	switch code := res.StatusCode; {
+	case code >= 200 && code < 300:
+		return false, nil
+	case code == http.StatusTooManyRequests || code >= 500:
+		return true, fmt.Errorf("%s", res.Status)
+	default:
+		return false, fmt.Errorf("%s", res.Status)
+	}
+}
+
+// isStale reports whether the first record of the current batch waits for
+// longer than the linger delay.
+func (e *endpoint) isStale() bool {
+	return e.count > 0 && time.Since(e.first) >= postLinger
+}
+
+// contentType gives the media type of a batch of records: json records are
+// sent as newline delimited json, the others as text.
+func contentType(body []byte) string {
+	if b := bytes.TrimLeft(body, " \t\r\n"); len(b) > 0 && b[0] == '{' {
+		return "application/x-ndjson"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// openEndpoint gives the endpoint of addr, the records being sent to it only if
+// the host of addr is allowed by the network policy.
+func (root *state) openEndpoint(addr string) (io.Writer, bool, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, false, err
+	}
+	if !root.hosts.allow(u) {
+		return nil, false, fmt.Errorf("%s: %w", addr, ErrNetwork)
+	}
+	return root.files.OpenURL(addr, root.hosts)
+}
+
+// OpenURL gives the endpoint of addr, created the first time. Like the files,
+// the endpoints count the records written to them in the outputs. The requests
+// are only redirected to the hosts allowed by hosts.
+func (c *fileCache) OpenURL(addr string, hosts hostPolicy) (io.Writer, bool, error) {
+	if e, ok := c.endpoints[addr]; ok {
+		return e, false, nil
+	}
+	_, seen := c.seen[addr]
+	out, ok := c.outputs[addr]
+	if !ok {
+		out = new(OutputStat)
+		c.outputs[addr] = out
+	}
+	e := &endpoint{
+		url:    addr,
+		policy: c.post,
+		client: hosts.client(),
+		out:    out,
+	}
+	if c.endpoints == nil {
+		c.endpoints = make(map[string]*endpoint)
+	}
+	c.endpoints[addr] = e
+	c.seen[addr] = struct{}{}
+	c.opens++
+	return e, !seen, nil
+}
@@ -13,14 +13,25 @@ type pratt struct {
 
 	peek Token
 	curr Token
+
+	// file and position of the string in the script: the positions of the
+	// tokens are shifted by at
+	file string
+	at   Position
+
+	index int
 }
 
-func parseString(str string) (Expression, error) {
+func parseString(str, file string, at Position) (Expression, error) {
 	s, err := Scan(strings.NewReader(str))
 	if err != nil {
 		return nil, err
 	}
-	p := pratt{scan: s}
+	p := pratt{
+		scan: s,
+		file: file,
+		at:   at,
+	}
 	p.prefix = map[rune]func() (Expression, error){}
 	p.infix = map[rune]func(Expression) (Expression, error){}
 
@@ -53,8 +64,30 @@ func (p *pratt) parseExpression(pow int) (Expression, error) {
 			return nil, err
 		}
 	}
-	if p.peek.Type == rsquare {
+	if p.peek.Type == rsquare && p.index == 0 {
+		p.nextToken()
+	}
+	return expr, nil
+}
+
+func (p *pratt) parseIndex(expr Expression) (Expression, error) {
+	for p.peek.Type == lsquare {
 		p.nextToken()
+		p.nextToken()
+		p.index++
+		index, err := p.parseExpression(bindLowest)
+		p.index--
+		if err != nil {
+			return nil, err
+		}
+		p.nextToken()
+		if p.curr.Type != rsquare {
+			return nil, fmt.Errorf("pratt: expected ], got %s (%s)", TokenString(p.curr), p.curr.Pos())
+		}
+		expr = Index{
+			arr:   expr,
+			index: index,
+		}
 	}
 	return expr, nil
 }
@@ -86,12 +119,13 @@ func (p *pratt) parsePrefix() (Expression, error) {
 		expr = n
 	case Integer, Float, Bool, Text:
 		expr = Literal{id: p.curr}
-	case Ident:
+	case Ident, Keyword:
 		id := p.curr
+		id.Type = Ident
 		if p.peek.Type == dot {
 			p.nextToken()
 			p.nextToken()
-			if p.curr.Type != Ident {
+			if p.curr.Type != Ident && p.curr.Type != Keyword {
 				return nil, fmt.Errorf("pratt: unpexected token %s (%s)", TokenString(p.curr), p.curr.Pos())
 			}
 			expr = Member{
@@ -101,7 +135,11 @@ func (p *pratt) parsePrefix() (Expression, error) {
 		} else {
 			expr = Identifier{id: id}
 		}
+		return p.parseIndex(expr)
 	case Internal:
+		if p.peek.Type == lparen {
+			return p.parseCall()
+		}
 		expr = Identifier{id: p.curr}
 	default:
 		return nil, fmt.Errorf("pratt: unexpected token type %s (%s)", TokenString(p.curr), p.curr.Pos())
@@ -109,6 +147,27 @@ func (p *pratt) parsePrefix() (Expression, error) {
 	return expr, nil
 }
 
+func (p *pratt) parseCall() (Expression, error) {
+	c := Call{id: p.curr, file: p.file}
+	p.nextToken()
+	for p.peek.Type != rparen {
+		p.nextToken()
+		arg, err := p.parseExpression(bindLowest)
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if p.peek.Type != comma && p.peek.Type != rparen {
+			return nil, fmt.Errorf("pratt: expected , or ), got %s (%s)", TokenString(p.peek), p.peek.Pos())
+		}
+		if p.peek.Type == comma {
+			p.nextToken()
+		}
+	}
+	p.nextToken()
+	return c, nil
+}
+
 func (p *pratt) parseInfix(left Expression) (Expression, error) {
 	expr := Binary{
 		Left:     left,
@@ -169,4 +228,8 @@ func (p *pratt) parseTernary(left Expression) (Expression, error) {
 func (p *pratt) nextToken() {
 	p.curr = p.peek
 	p.peek = p.scan.Scan()
+	if p.peek.pos.Line == 1 {
+		p.peek.pos.Column += p.at.Column
+	}
+	p.peek.pos.Line += p.at.Line - 1
 }
@@ -1,10 +1,7 @@
 package dissect
 
 import (
-	"bytes"
-	"io"
 	"strconv"
-	"strings"
 )
 
 var headersDebug = []string{
@@ -15,9 +12,13 @@ var headersDebug = []string{
 	"len",
 	"raw",
 	"eng",
+	"valid",
 }
 
-type printFunc func(io.Writer, []Field) error
+// printFunc appends the serialized record made of values to buf. The printers
+// never allocate by themselves: the caller reuses buf from one record to the
+// next.
+type printFunc func(buf []byte, values []Field) []byte
 
 var printers = map[struct{ Format, Method string }]printFunc{
 	{Format: fmtCSV, Method: methRaw}:     csvPrintRaw,
@@ -32,249 +33,325 @@ var printers = map[struct{ Format, Method string }]printFunc{
 	{Format: fmtSexp, Method: methEng}:    sexpPrintEng,
 	{Format: fmtTuple, Method: methBoth}:  sexpPrintBoth,
 	{Format: fmtSexp, Method: methBoth}:   sexpPrintBoth,
+	{Format: fmtJSON, Method: methRaw}:    jsonPrintRaw,
+	{Format: fmtJSON, Method: methEng}:    jsonPrintEng,
+	{Format: fmtJSON, Method: methBoth}:   jsonPrintBoth,
+	{Format: fmtJSON, Method: methDebug}:  jsonPrintDebug,
 }
 
-func sexpPrintDebug(w io.Writer, values []Field) error {
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 32)
-	)
-	buf.WriteRune(lparen)
+func sexpPrintDebug(buf []byte, values []Field) []byte {
+	buf = append(buf, lparen)
 	for _, v := range values {
-		buf.WriteRune(lparen)
+		buf = append(buf, lparen)
 
 		var (
 			offset = v.Offset()
 			index  = offset / 8
 		)
 
-		buf.WriteString(strconv.Itoa(index))
-		buf.WriteRune(colon)
-		buf.WriteString(strconv.Itoa(offset))
-		buf.WriteRune(colon)
-		buf.WriteString(v.String())
-		buf.WriteRune(colon)
-		buf.WriteString(strconv.Itoa(v.Len))
-		buf.WriteRune(colon)
-		buf.Write(appendRaw(dat, v.Raw(), false))
-		buf.WriteRune(colon)
-		buf.Write(appendEng(dat, v.Eng(), false))
+		buf = strconv.AppendInt(buf, int64(index), 10)
+		buf = append(buf, colon)
+		buf = strconv.AppendInt(buf, int64(offset), 10)
+		buf = append(buf, colon)
+		buf = append(buf, v.Block...)
+		buf = append(buf, colon)
+		buf = append(buf, v.Id...)
+		buf = append(buf, colon)
+		buf = strconv.AppendInt(buf, int64(v.Len), 10)
+		buf = append(buf, colon)
+		buf = appendRaw(buf, v.Raw(), encSexp)
+		buf = append(buf, colon)
+		buf = appendEng(buf, v.Eng(), encSexp)
+		buf = append(buf, colon)
+		buf = strconv.AppendBool(buf, v.Valid())
 
-		buf.WriteRune(rparen)
+		buf = append(buf, rparen)
 	}
-	buf.WriteRune(rparen)
-
-	_, err := io.Copy(w, &buf)
-	return err
+	return append(buf, rparen)
 }
 
-func sexpPrintRaw(w io.Writer, values []Field) error {
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 32)
-	)
-	buf.WriteRune(lparen)
+func sexpPrintRaw(buf []byte, values []Field) []byte {
+	buf = append(buf, lparen)
 	for i, v := range values {
 		if v.Skip() {
 			continue
 		}
 		if i > 0 {
-			buf.WriteRune(space)
+			buf = append(buf, space)
 		}
-		buf.Write(appendRaw(dat, v.Raw(), true))
+		buf = appendRaw(buf, v.Raw(), encSexp)
 	}
-	buf.WriteRune(rparen)
-
-	_, err := io.Copy(w, &buf)
-	return err
+	return append(buf, rparen)
 }
 
-func sexpPrintEng(w io.Writer, values []Field) error {
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 32)
-	)
-	buf.WriteRune(lparen)
+func sexpPrintEng(buf []byte, values []Field) []byte {
+	buf = append(buf, lparen)
 	for i, v := range values {
 		if v.Skip() {
 			continue
 		}
 		if i > 0 {
-			buf.WriteRune(space)
+			buf = append(buf, space)
 		}
-		buf.Write(appendEng(dat, v.Eng(), true))
+		buf = appendEng(buf, v.Eng(), encSexp)
 	}
-	buf.WriteRune(rparen)
-
-	_, err := io.Copy(w, &buf)
-	return err
+	return append(buf, rparen)
 }
 
-func sexpPrintBoth(w io.Writer, values []Field) error {
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 32)
-	)
-	buf.WriteRune(lparen)
+func sexpPrintBoth(buf []byte, values []Field) []byte {
+	buf = append(buf, lparen)
 	for i, v := range values {
 		if v.Skip() {
 			continue
 		}
 		if i > 0 {
-			buf.WriteRune(space)
+			buf = append(buf, space)
 		}
-		buf.WriteRune(lparen)
-		buf.Write(appendRaw(dat, v.Raw(), true))
-		buf.WriteRune(space)
-		buf.Write(appendEng(dat, v.Eng(), true))
-		buf.WriteRune(rparen)
+		buf = append(buf, lparen)
+		buf = appendRaw(buf, v.Raw(), encSexp)
+		buf = append(buf, space)
+		buf = appendEng(buf, v.Eng(), encSexp)
+		buf = append(buf, rparen)
 	}
-	buf.WriteRune(rparen)
+	return append(buf, rparen)
+}
 
-	_, err := io.Copy(w, &buf)
-	return err
+// columns are the suffixes added to the name of the fields for the raw and
+// engineering columns of the csv records printed with both.
+type columns struct {
+	raw string
+	eng string
 }
 
-func csvPrintHeaders(w io.Writer, meth string, values []Field) error {
+var defaultColumns = columns{eng: "_eng"}
+
+// explodeArrays replaces the array fields by a field per element, named after
+// its index, eg samples[0], samples[1],... so that each element has its own
+// column in the csv records.
+func explodeArrays(values []Field) []Field {
+	var xs []Field
+	for i, v := range values {
+		arr, ok := v.Raw().(*Array)
+		if !ok {
+			if xs != nil {
+				xs = append(xs, v)
+			}
+			continue
+		}
+		if xs == nil {
+			xs = append(make([]Field, 0, len(values)+len(arr.Raw)), values[:i]...)
+		}
+		xs = appendElements(xs, v, arr)
+	}
+	if xs == nil {
+		return values
+	}
+	return xs
+}
+
+func appendElements(xs []Field, f Field, arr *Array) []Field {
 	var (
-		buf     bytes.Buffer
-		headers []string
+		eng, _ = f.Eng().(*Array)
+		size   int
 	)
+	if n := len(arr.Raw); n > 0 && f.Len%n == 0 {
+		size = f.Len / n
+	}
+	for i, v := range arr.Raw {
+		e := f
+		e.Id = f.Id + "[" + strconv.Itoa(i) + "]"
+		e.Pos, e.Len = f.Pos+i*size, size
+		if size == 0 {
+			// elements not laid out in the packet, eg the counts of an
+			// histogram, all span the region of the field
+			e.Pos, e.Len = f.Pos, f.Len
+		}
+		e.raw, e.eng = v, nil
+		if eng != nil && i < len(eng.Raw) {
+			e.eng = eng.Raw[i]
+		}
+		if sub, ok := v.(*Array); ok {
+			xs = appendElements(xs, e, sub)
+			continue
+		}
+		xs = append(xs, e)
+	}
+	return xs
+}
+
+func csvPrintHeaders(buf []byte, meth string, values []Field, names columns) []byte {
+	var headers []string
 	if meth == methDebug {
 		headers = headersDebug
 	} else {
 		headers = make([]string, 0, len(values))
-		for i := 0; i < len(values); i++ {
-			if strings.HasPrefix(values[i].Id, "_") {
+		for _, v := range values {
+			if v.Skip() {
 				continue
 			}
-			headers = append(headers, values[i].Id)
+			if meth == methBoth {
+				headers = append(headers, v.Id+names.raw, v.Id+names.eng)
+				continue
+			}
+			headers = append(headers, v.Id)
 		}
 	}
 	for i := 0; i < len(headers); i++ {
 		if i > 0 {
-			buf.WriteRune(comma)
+			buf = append(buf, comma)
 		}
-		buf.WriteRune('"')
-		buf.WriteString(headers[i])
-		buf.WriteRune('"')
+		buf = append(buf, '"')
+		buf = appendString(buf, headers[i], encCSV)
+		buf = append(buf, '"')
 	}
-	buf.WriteString("\r\n")
-
-	_, err := io.Copy(w, &buf)
-	return err
+	return append(buf, "\r\n"...)
 }
 
-func csvPrintDebug(w io.Writer, values []Field) error {
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 64)
-	)
+func csvPrintDebug(buf []byte, values []Field) []byte {
 	for _, v := range values {
 		var (
 			offset = v.Offset()
 			index  = offset / numbit
 		)
 
-		buf.WriteRune('"')
-		buf.WriteString(strconv.Itoa(index))
-		buf.WriteRune('"')
-		buf.WriteRune(comma)
-		buf.WriteRune('"')
-		buf.WriteString(strconv.Itoa(offset))
-		buf.WriteRune('"')
-		buf.WriteRune(comma)
-		buf.WriteRune('"')
-		buf.WriteString(v.Block)
-		buf.WriteRune('"')
-		buf.WriteRune(comma)
-		buf.WriteRune('"')
-		buf.WriteString(v.Id)
-		buf.WriteRune('"')
-		buf.WriteRune(comma)
-		buf.WriteRune('"')
-		buf.WriteString(strconv.Itoa(v.Len))
-		buf.WriteRune('"')
-		buf.WriteRune(comma)
-		buf.WriteRune('"')
-		buf.Write(appendRaw(dat, v.Raw(), true))
-		buf.WriteRune('"')
-		buf.WriteRune(comma)
-		buf.WriteRune('"')
-		buf.Write(appendEng(dat, v.Eng(), true))
-		buf.WriteRune('"')
-		buf.WriteString("\r\n")
+		buf = append(buf, '"')
+		buf = strconv.AppendInt(buf, int64(index), 10)
+		buf = append(buf, '"', comma, '"')
+		buf = strconv.AppendInt(buf, int64(offset), 10)
+		buf = append(buf, '"', comma, '"')
+		buf = appendString(buf, v.Block, encCSV)
+		buf = append(buf, '"', comma, '"')
+		buf = appendString(buf, v.Id, encCSV)
+		buf = append(buf, '"', comma, '"')
+		buf = strconv.AppendInt(buf, int64(v.Len), 10)
+		buf = append(buf, '"', comma, '"')
+		buf = appendRaw(buf, v.Raw(), encCSV)
+		buf = append(buf, '"', comma, '"')
+		buf = appendEng(buf, v.Eng(), encCSV)
+		buf = append(buf, '"', comma, '"')
+		buf = strconv.AppendBool(buf, v.Valid())
+		buf = append(buf, '"')
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
 
-		if _, err := io.Copy(w, &buf); err != nil {
-			return err
+func csvPrintRaw(buf []byte, values []Field) []byte {
+	for i, v := range values {
+		if v.Skip() {
+			continue
+		}
+		if i > 0 {
+			buf = append(buf, comma)
 		}
+		buf = append(buf, '"')
+		buf = appendRaw(buf, v.Raw(), encCSV)
+		buf = append(buf, '"')
 	}
-	return nil
+	return append(buf, "\r\n"...)
 }
 
-func csvPrintRaw(w io.Writer, values []Field) error {
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 64)
-	)
+func csvPrintEng(buf []byte, values []Field) []byte {
 	for i, v := range values {
 		if v.Skip() {
 			continue
 		}
 		if i > 0 {
-			buf.WriteRune(comma)
+			buf = append(buf, comma)
 		}
-		buf.WriteRune('"')
-		buf.Write(appendRaw(dat, v.Raw(), true))
-		buf.WriteRune('"')
+		buf = append(buf, '"')
+		buf = appendEng(buf, v.Eng(), encCSV)
+		buf = append(buf, '"')
 	}
-	buf.WriteString("\r\n")
-	_, err := io.Copy(w, &buf)
-	return err
+	return append(buf, "\r\n"...)
 }
 
-func csvPrintEng(w io.Writer, values []Field) error {
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 64)
-	)
+func csvPrintBoth(buf []byte, values []Field) []byte {
 	for i, v := range values {
 		if v.Skip() {
 			continue
 		}
 		if i > 0 {
-			buf.WriteRune(comma)
+			buf = append(buf, comma)
 		}
-		buf.WriteRune('"')
-		buf.Write(appendEng(dat, v.Eng(), true))
-		buf.WriteRune('"')
+		buf = append(buf, '"')
+		buf = appendRaw(buf, v.Raw(), encCSV)
+		buf = append(buf, '"', comma, '"')
+		buf = appendEng(buf, v.Eng(), encCSV)
+		buf = append(buf, '"')
 	}
-	buf.WriteString("\r\n")
-	_, err := io.Copy(w, &buf)
-	return err
+	return append(buf, "\r\n"...)
 }
 
-func csvPrintBoth(w io.Writer, values []Field) error {
-	var (
-		buf bytes.Buffer
-		dat = make([]byte, 0, 64)
-	)
+// jsonPrintDebug writes a record as an array with an object per field. The keys
+// of the objects are the columns of the debug csv records.
+func jsonPrintDebug(buf []byte, values []Field) []byte {
+	buf = append(buf, '[')
 	for i, v := range values {
+		if i > 0 {
+			buf = append(buf, comma)
+		}
+		offset := v.Offset()
+		buf = append(buf, `{"bytoff":`...)
+		buf = strconv.AppendInt(buf, int64(offset/numbit), 10)
+		buf = append(buf, `,"bitoff":`...)
+		buf = strconv.AppendInt(buf, int64(offset), 10)
+		buf = append(buf, `,"block":`...)
+		buf = appendJSONString(buf, v.Block)
+		buf = append(buf, `,"param":`...)
+		buf = appendJSONString(buf, v.Id)
+		buf = append(buf, `,"len":`...)
+		buf = strconv.AppendInt(buf, int64(v.Len), 10)
+		buf = append(buf, `,"raw":`...)
+		buf = appendRaw(buf, v.Raw(), encJSON)
+		buf = append(buf, `,"eng":`...)
+		buf = appendEng(buf, v.Eng(), encJSON)
+		buf = append(buf, `,"valid":`...)
+		buf = strconv.AppendBool(buf, v.Valid())
+		buf = append(buf, '}')
+	}
+	return append(buf, ']', '\n')
+}
+
+// jsonPrintRaw writes a record as an object keyed by the id of its fields.
+func jsonPrintRaw(buf []byte, values []Field) []byte {
+	return jsonPrintObject(buf, values, func(buf []byte, v Field) []byte {
+		return appendRaw(buf, v.Raw(), encJSON)
+	})
+}
+
+func jsonPrintEng(buf []byte, values []Field) []byte {
+	return jsonPrintObject(buf, values, func(buf []byte, v Field) []byte {
+		return appendEng(buf, v.Eng(), encJSON)
+	})
+}
+
+// jsonPrintBoth writes a record as an object keyed by the id of its fields.
+// Each field is an object with its raw and eng values.
+func jsonPrintBoth(buf []byte, values []Field) []byte {
+	return jsonPrintObject(buf, values, func(buf []byte, v Field) []byte {
+		buf = append(buf, `{"raw":`...)
+		buf = appendRaw(buf, v.Raw(), encJSON)
+		buf = append(buf, `,"eng":`...)
+		buf = appendEng(buf, v.Eng(), encJSON)
+		return append(buf, '}')
+	})
+}
+
+func jsonPrintObject(buf []byte, values []Field, value func([]byte, Field) []byte) []byte {
+	buf = append(buf, '{')
+	var n int
+	for _, v := range values {
 		if v.Skip() {
 			continue
 		}
-		if i > 0 {
-			buf.WriteRune(comma)
+		if n > 0 {
+			buf = append(buf, comma)
 		}
-		buf.WriteRune('"')
-		buf.Write(appendRaw(dat, v.Raw(), true))
-		buf.WriteRune('"')
-		buf.WriteRune(comma)
-		buf.WriteRune('"')
-		buf.Write(appendEng(dat, v.Eng(), true))
-		buf.WriteRune('"')
+		n++
+		buf = appendJSONString(buf, v.Id)
+		buf = append(buf, colon)
+		buf = value(buf, v)
 	}
-	buf.WriteString("\r\n")
-	_, err := io.Copy(w, &buf)
-	return err
+	return append(buf, '}', '\n')
 }
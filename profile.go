@@ -0,0 +1,105 @@
+package dissect
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	timeBlock = "block"
+	timePrint = "print"
+	timeStage = "stage"
+)
+
+const (
+	stageExtract = "extract"
+	stageEval    = "eval"
+)
+
+// Timing is the cumulative wall time spent in a block, in a printer or in one
+// of the stages of the decoding: the extraction of the fields from the packets
+// and the evaluation of the expressions. The time of a block includes the time
+// of the blocks it includes.
+type Timing struct {
+	Kind  string        `json:"kind"`
+	Name  string        `json:"name"`
+	Count int           `json:"count"`
+	Time  time.Duration `json:"time"`
+}
+
+// WithTiming makes the interpreter measure the time spent in each block,
+// printer and stage. The timings are given by the Profile field of the stats.
+func WithTiming(timing bool) Option {
+	return func(i *Interpreter) error {
+		i.timing = timing
+		return nil
+	}
+}
+
+func noTiming() {}
+
+// timed starts measuring the time spent in name. The returned function stops
+// the measure.
+func (root *state) timed(kind, name string) func() {
+	if !root.timing {
+		return noTiming
+	}
+	start := time.Now()
+	return func() {
+		root.addTiming(kind, name, time.Since(start))
+	}
+}
+
+func (root *state) addTiming(kind, name string, elapsed time.Duration) {
+	for i, t := range root.timings {
+		if t.Kind == kind && t.Name == name {
+			root.timings[i].Count++
+			root.timings[i].Time += elapsed
+			return
+		}
+	}
+	root.timings = append(root.timings, Timing{
+		Kind:  kind,
+		Name:  name,
+		Count: 1,
+		Time:  elapsed,
+	})
+}
+
+func (s *Stats) addTimings(ts []Timing) {
+	for _, t := range ts {
+		i := sort.Search(len(s.Profile), func(i int) bool {
+			x := s.Profile[i]
+			return x.Kind > t.Kind || (x.Kind == t.Kind && x.Name >= t.Name)
+		})
+		if i < len(s.Profile) && s.Profile[i].Kind == t.Kind && s.Profile[i].Name == t.Name {
+			s.Profile[i].Count += t.Count
+			s.Profile[i].Time += t.Time
+			continue
+		}
+		s.Profile = append(s.Profile, Timing{})
+		copy(s.Profile[i+1:], s.Profile[i:])
+		s.Profile[i] = t
+	}
+}
+
+func reportTimings(w io.Writer, ts []Timing) error {
+	ts = append([]Timing(nil), ts...)
+	sort.SliceStable(ts, func(i, j int) bool {
+		return ts[i].Time > ts[j].Time
+	})
+	fmt.Fprintf(w, "\n%-8s %-32s %10s %14s %12s\n", "kind", "name", "count", "time", "average")
+	for _, t := range ts {
+		var avg time.Duration
+		if t.Count > 0 {
+			avg = t.Time / time.Duration(t.Count)
+		}
+		if _, err := fmt.Fprintf(w, "%-8s %-32s %10d %14s %12s\n", t.Kind, t.Name, t.Count, t.Time, avg); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
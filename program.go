@@ -0,0 +1,278 @@
+package dissect
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// ErrStale is returned by LoadProgram when one of the files a compiled program
+// was made from has changed since it was compiled.
+var ErrStale = errors.New("source changed since compilation")
+
+const compiledMagic = "dissect-compiled 3\n"
+
+// Program is a parsed and merged script. It is never modified once created:
+// everything that changes while decoding lives in the state created by each
+// run of an Interpreter. A Program can then be shared by many interpreters
+// decoding concurrently, each one in its own goroutine.
+type Program struct {
+	data    Data
+	sources *sourceSet
+}
+
+// Compile parses and merges script into a Program.
+func Compile(script io.Reader) (*Program, error) {
+	return compile(script, nil)
+}
+
+// CompileFS parses and merges the script file found in fsys into a Program.
+// Files included by the script are also read from fsys.
+func CompileFS(fsys fs.FS, file string) (*Program, error) {
+	r, err := fsys.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return compile(r, fsys)
+}
+
+func compile(script io.Reader, fsys fs.FS) (*Program, error) {
+	p := newParser(fsys, nil)
+	p.sources = &sourceSet{}
+	node, err := mergeTree(p.parse(script))
+	if err != nil {
+		return nil, err
+	}
+	data, ok := node.(Data)
+	if !ok {
+		return nil, fmt.Errorf("missing data block")
+	}
+	return &Program{data: data, sources: p.sources}, nil
+}
+
+// compiledProgram is what is saved of a Program: the merged script and the
+// sha256 of the files it was made from.
+type compiledProgram struct {
+	Sources sourceSet
+	Data    flatValue
+}
+
+// Save writes prog in the compiled format read by LoadProgram. Only a program
+// created by Compile or CompileFS can be saved.
+func (p *Program) Save(w io.Writer) error {
+	if p.sources == nil {
+		return fmt.Errorf("program has no source to save")
+	}
+	data, err := flatten(reflect.ValueOf(&p.data).Elem())
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, compiledMagic); err != nil {
+		return err
+	}
+	c := compiledProgram{
+		Sources: *p.sources,
+		Data:    data,
+	}
+	return gob.NewEncoder(w).Encode(c)
+}
+
+// LoadProgram reads a program saved by Program.Save. The merged script is
+// read as it was saved and is not parsed again. However, the files of the host
+// and the schemas of the standard library the program was made from are read
+// again: ErrStale is returned when one of them has changed since the program
+// was compiled, and an error when one of them can no longer be read. The
+// remote files and the files read from a fs.FS are not checked.
+func LoadProgram(r io.Reader) (*Program, error) {
+	rs := bufio.NewReader(r)
+	magic := make([]byte, len(compiledMagic))
+	if _, err := io.ReadFull(rs, magic); err != nil || string(magic) != compiledMagic {
+		return nil, fmt.Errorf("not a compiled program")
+	}
+	var c compiledProgram
+	if err := gob.NewDecoder(rs).Decode(&c); err != nil {
+		return nil, err
+	}
+	if err := c.Sources.check(); err != nil {
+		return nil, err
+	}
+	var data Data
+	if err := unflatten(c.Data, reflect.ValueOf(&data).Elem()); err != nil {
+		return nil, fmt.Errorf("compiled program is corrupted: %w", err)
+	}
+	return &Program{data: data, sources: &c.Sources}, nil
+}
+
+// NewFromProgram creates an Interpreter running prog. Unlike New, the script is
+// not parsed again.
+func NewFromProgram(prog *Program, opts ...Option) (*Interpreter, error) {
+	i, err := newInterpreter(opts...)
+	if err != nil {
+		return nil, err
+	}
+	i.data, i.sources = i.prepare(prog.data), prog.sources
+	return i, nil
+}
+
+// ReloadProgram replaces the program run by the interpreter like Reload does
+// with a script.
+func (i *Interpreter) ReloadProgram(prog *Program) {
+	i.swap(i.prepare(prog.data), prog.sources)
+}
+
+// Program returns the program run by the interpreter.
+func (i *Interpreter) Program() *Program {
+	return &Program{data: i.script()}
+}
+
+// Sources returns the files of the host the script run by the interpreter was
+// read from, the script first, and the directories it includes. The schemas of
+// the standard library, the remote files and the files of a fs.FS are not
+// given.
+func (i *Interpreter) Sources() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.sources == nil {
+		return nil
+	}
+	return i.sources.names()
+}
+
+// sourceSet keeps the sha256 of the files read by the parser while compiling a
+// script and the content of the directories it includes.
+type sourceSet struct {
+	Files []sourceFile
+	Dirs  map[string][]string
+}
+
+type sourceFile struct {
+	Name string // absolute path or name of a schema of the standard library
+	Sum  [sha256.Size]byte
+}
+
+func (s sourceSet) names() []string {
+	var names []string
+	for _, f := range s.Files {
+		if !isStd(f.Name) {
+			names = append(names, f.Name)
+		}
+	}
+	dirs := make([]string, 0, len(s.Dirs))
+	for dir := range s.Dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return append(names, dirs...)
+}
+
+// check compares the sources with the files and the directories currently
+// found on the file system.
+func (s sourceSet) check() error {
+	for _, f := range s.Files {
+		buf, err := readSource(f.Name)
+		if err != nil {
+			return err
+		}
+		if sha256.Sum256(buf) != f.Sum {
+			return fmt.Errorf("%s: %w", f.Name, ErrStale)
+		}
+	}
+	for dir, names := range s.Dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(infos) != len(names) {
+			return fmt.Errorf("%s: %w", dir, ErrStale)
+		}
+		for i := range infos {
+			if infos[i].Name() != names[i] {
+				return fmt.Errorf("%s: %w", dir, ErrStale)
+			}
+		}
+	}
+	return nil
+}
+
+func readSource(file string) ([]byte, error) {
+	if !isStd(file) {
+		buf, err := ioutil.ReadFile(file)
+		return normalizeNewlines(buf), err
+	}
+	r, err := openStd(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	return normalizeNewlines(buf), err
+}
+
+// addSource records file among the sources of the script being compiled, if
+// it can be checked when the program is loaded: the script read from a reader
+// without name, the files of a fs.FS and the remote files are not recorded.
+func (p *Parser) addSource(file string, buf []byte) {
+	if p.sources == nil || p.fsys != nil || file == "<input>" || isURL(file) {
+		return
+	}
+	if !isStd(file) {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return
+		}
+		file = abs
+	}
+	for _, f := range p.sources.Files {
+		if f.Name == file {
+			return
+		}
+	}
+	p.sources.Files = append(p.sources.Files, sourceFile{
+		Name: file,
+		Sum:  sha256.Sum256(normalizeNewlines(buf)),
+	})
+}
+
+// addSourceDir records the names of the files of dir, a directory included by
+// the script being compiled.
+func (p *Parser) addSourceDir(dir string, names []string) {
+	if p.sources == nil || p.fsys != nil {
+		return
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	if p.sources.Dirs == nil {
+		p.sources.Dirs = make(map[string][]string)
+	}
+	base := make([]string, len(names))
+	for i := range names {
+		base[i] = filepath.Base(names[i])
+	}
+	p.sources.Dirs[abs] = base
+}
+
+// namedReader gives the content of a file read in memory.
+type namedReader struct {
+	*bytes.Reader
+	name string
+}
+
+func (r namedReader) Name() string {
+	return r.name
+}
+
+func (r namedReader) Close() error {
+	return nil
+}
@@ -0,0 +1,184 @@
+package dissect
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProgramSaveLoad(t *testing.T) {
+	var files []string
+	for _, pattern := range []string{"testdata/conformance/valid/*.lst", "testdata/golden/*.dsl"} {
+		fs, err := filepath.Glob(filepath.FromSlash(pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fs...)
+	}
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			r, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			prog, err := Compile(r)
+			if err != nil {
+				t.Fatalf("compiling: unexpected error: %s", err)
+			}
+			var buf bytes.Buffer
+			if err := prog.Save(&buf); err != nil {
+				t.Fatalf("saving: unexpected error: %s", err)
+			}
+			other, err := LoadProgram(&buf)
+			if err != nil {
+				t.Fatalf("loading: unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(prog.data, other.data) {
+				t.Errorf("loaded program differs from the compiled one")
+			}
+		})
+	}
+}
+
+func TestProgramStale(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.dsl":         "include (\n  \"defs/header.lst\"\n  \"types\"\n)\ndata (\n  include header\n)\n",
+		"defs/header.lst":  "block header (\n  apid: uint 8\n)\n",
+		"types/uint.lst":   "typedef (\n  word = uint 16 big\n)\n",
+		"types/unused.txt": "",
+		"defs/notused.lst": "",
+	}
+	write := func(name, body string) {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, body := range files {
+		write(name, body)
+	}
+	compile := func() []byte {
+		r, err := os.Open(filepath.Join(dir, "main.dsl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		prog, err := Compile(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := prog.Save(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		Name   string
+		Change func()
+		Err    error
+	}{
+		{
+			Name:   "unchanged",
+			Change: func() {},
+		},
+		{
+			Name:   "newlines",
+			Change: func() { write("defs/header.lst", "block header (\r\n  apid: uint 8\r\n)\r\n") },
+		},
+		{
+			Name:   "unrelated",
+			Change: func() { write("defs/notused.lst", "block other ()\n") },
+		},
+		{
+			Name:   "modified",
+			Change: func() { write("defs/header.lst", "block header (\n  apid: uint 16\n)\n") },
+			Err:    ErrStale,
+		},
+		{
+			Name:   "added",
+			Change: func() { write("types/int.lst", "") },
+			Err:    ErrStale,
+		},
+		{
+			Name:   "removed",
+			Change: func() { os.Remove(filepath.Join(dir, "defs", "header.lst")) },
+			Err:    os.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			for name, body := range files {
+				write(name, body)
+			}
+			os.Remove(filepath.Join(dir, "types", "int.lst"))
+
+			compiled := compile()
+			tt.Change()
+			_, err := LoadProgram(bytes.NewReader(compiled))
+			if tt.Err == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !errors.Is(err, tt.Err) {
+				t.Fatalf("want error %v, got %v", tt.Err, err)
+			}
+		})
+	}
+}
+
+func TestInterpreterSources(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.dsl":       "include (\n  \"header.lst\"\n  \"types\"\n)\ndata (\n  include header\n)\n",
+		"header.lst":     "block header (\n  apid: uint 8\n)\n",
+		"types/uint.lst": "typedef (\n  word = uint 16 big\n)\n",
+		"other.dsl":      "data (\n  id: uint 8\n)\n",
+	}
+	for name, body := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open := func(name string) *os.File {
+		r, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	}
+	i, err := New(open("main.dsl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "main.dsl"),
+		filepath.Join(dir, "header.lst"),
+		filepath.Join(dir, "types", "uint.lst"),
+		filepath.Join(dir, "types"),
+	}
+	if got := i.Sources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sources mismatched:\nwant: %q\ngot:  %q", want, got)
+	}
+	if err := i.Reload(open("other.dsl")); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{filepath.Join(dir, "other.dsl")}
+	if got := i.Sources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sources after reload mismatched:\nwant: %q\ngot:  %q", want, got)
+	}
+}
@@ -0,0 +1,333 @@
+package dissect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// protoField is a field of a message declared in a .proto file. typ is the name
+// of a scalar type, of a message or of an enum.
+type protoField struct {
+	name   string
+	typ    string
+	number int
+}
+
+type protoMessage struct {
+	name   string
+	fields map[int]protoField
+}
+
+// protoSchema holds the messages and the enums of a .proto file, keyed by their
+// name qualified by the names of the messages they are nested in.
+type protoSchema struct {
+	pkg      string
+	messages map[string]*protoMessage
+	enums    map[string]struct{}
+}
+
+func (s *protoSchema) message(name string) (*protoMessage, error) {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "."), s.pkg+".")
+	if m, ok := s.messages[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s: message not declared", name)
+}
+
+// resolve gives the qualified name of the type of f, looked up from the scope
+// of the message m as protoc does. The names of the scalar types and of the
+// types not declared are returned as is.
+func (s *protoSchema) resolve(m *protoMessage, f protoField) string {
+	typ := f.typ
+	if strings.HasPrefix(typ, ".") {
+		return strings.TrimPrefix(strings.TrimPrefix(typ, "."), s.pkg+".")
+	}
+	if _, ok := protoScalars[typ]; ok {
+		return typ
+	}
+	for scope := m.name; scope != ""; {
+		q := scope + "." + typ
+		if s.isDeclared(q) {
+			return q
+		}
+		i := strings.LastIndexByte(scope, '.')
+		if i < 0 {
+			break
+		}
+		scope = scope[:i]
+	}
+	return strings.TrimPrefix(typ, s.pkg+".")
+}
+
+func (s *protoSchema) isDeclared(name string) bool {
+	if _, ok := s.messages[name]; ok {
+		return true
+	}
+	_, ok := s.enums[name]
+	return ok
+}
+
+var protoScalars = map[string]struct{}{
+	"double":   {},
+	"float":    {},
+	"int32":    {},
+	"int64":    {},
+	"uint32":   {},
+	"uint64":   {},
+	"sint32":   {},
+	"sint64":   {},
+	"fixed32":  {},
+	"fixed64":  {},
+	"sfixed32": {},
+	"sfixed64": {},
+	"bool":     {},
+	"string":   {},
+	"bytes":    {},
+}
+
+// parseProto reads the messages declared in a .proto file. Only what is needed
+// to name the fields of the messages is kept: options, services, extensions
+// and reserved ranges are skipped.
+func parseProto(str string) (*protoSchema, error) {
+	s := protoSchema{
+		messages: make(map[string]*protoMessage),
+		enums:    make(map[string]struct{}),
+	}
+	p := protoParser{tokens: protoTokens(str)}
+	for !p.done() {
+		switch tok := p.next(); tok {
+		case "package":
+			s.pkg = p.next()
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "message":
+			if err := p.parseMessage(&s, ""); err != nil {
+				return nil, err
+			}
+		case "enum":
+			s.enums[p.next()] = struct{}{}
+			p.skipBody()
+		case "service", "extend":
+			p.next()
+			p.skipBody()
+		case ";":
+		default:
+			p.skipStatement()
+		}
+	}
+	return &s, nil
+}
+
+type protoParser struct {
+	tokens []string
+	index  int
+}
+
+func (p *protoParser) done() bool {
+	return p.index >= len(p.tokens)
+}
+
+func (p *protoParser) next() string {
+	if p.done() {
+		return ""
+	}
+	p.index++
+	return p.tokens[p.index-1]
+}
+
+func (p *protoParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.index]
+}
+
+func (p *protoParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("proto: expected %q, got %q", tok, got)
+	}
+	return nil
+}
+
+// skipStatement skips the tokens up to the end of the current statement, a
+// body included.
+func (p *protoParser) skipStatement() {
+	for !p.done() {
+		switch p.next() {
+		case ";":
+			return
+		case "{":
+			p.index--
+			p.skipBody()
+			return
+		}
+	}
+}
+
+// skipBody skips the tokens up to the end of the next body.
+func (p *protoParser) skipBody() {
+	var depth int
+	for !p.done() {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth <= 0 {
+				return
+			}
+		}
+	}
+}
+
+func (p *protoParser) parseMessage(s *protoSchema, scope string) error {
+	name := p.next()
+	if scope != "" {
+		name = scope + "." + name
+	}
+	m := protoMessage{
+		name:   name,
+		fields: make(map[int]protoField),
+	}
+	s.messages[name] = &m
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.done() {
+		switch tok := p.next(); tok {
+		case "}":
+			return nil
+		case "message":
+			if err := p.parseMessage(s, name); err != nil {
+				return err
+			}
+		case "enum":
+			s.enums[name+"."+p.next()] = struct{}{}
+			p.skipBody()
+		case "oneof":
+			p.next()
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			for p.peek() != "}" && !p.done() {
+				if p.peek() == "option" {
+					p.skipStatement()
+					continue
+				}
+				if err := p.parseField(&m, s, p.next()); err != nil {
+					return err
+				}
+			}
+			p.next()
+		case "option", "reserved", "extensions", "extend":
+			p.skipStatement()
+		case ";":
+		case "repeated", "optional", "required":
+			if err := p.parseField(&m, s, p.next()); err != nil {
+				return err
+			}
+		default:
+			if err := p.parseField(&m, s, tok); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("proto: %s: message not closed", name)
+}
+
+// parseField parses the declaration of a field of type typ. A map field is a
+// repeated message whose fields are the key, 1, and the value, 2.
+func (p *protoParser) parseField(m *protoMessage, s *protoSchema, typ string) error {
+	if typ == "map" {
+		if err := p.expect("<"); err != nil {
+			return err
+		}
+		key := p.next()
+		if err := p.expect(","); err != nil {
+			return err
+		}
+		val := p.next()
+		if err := p.expect(">"); err != nil {
+			return err
+		}
+		typ = m.name + ".$entry." + strconv.Itoa(len(s.messages))
+		s.messages[typ] = &protoMessage{
+			name: typ,
+			fields: map[int]protoField{
+				1: {name: "key", typ: key, number: 1},
+				2: {name: "value", typ: val, number: 2},
+			},
+		}
+		typ = "." + typ
+	}
+	f := protoField{
+		typ:  typ,
+		name: p.next(),
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(p.next())
+	if err != nil || n <= 0 {
+		return fmt.Errorf("proto: %s.%s: invalid field number", m.name, f.name)
+	}
+	f.number = n
+	if p.peek() == "[" {
+		for !p.done() && p.next() != "]" {
+		}
+	}
+	m.fields[n] = f
+	return p.expect(";")
+}
+
+// protoTokens splits a .proto file into identifiers, numbers, strings and
+// punctuations. Comments are dropped.
+func protoTokens(str string) []string {
+	var (
+		tokens []string
+		rs     = []rune(str)
+	)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '/':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i+1 < len(rs) && !(rs[i] == '*' && rs[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(rs) {
+				j++
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j
+		case r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(rs) && (rs[j] == '_' || rs[j] == '.' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,339 @@
+package dissect
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+const protobufDecl = "protobuf"
+
+const (
+	wireVarint = iota
+	wireFixed64
+	wireBytes
+	wireStartGroup
+	wireEndGroup
+	wireFixed32
+)
+
+var errWire = errors.New("invalid wire format")
+
+// decodeProtobuf walks the message in protobuf wire format made of the bytes
+// following the current position, their number given by the expression of n.
+// A field is given for each scalar value, named after the numbers of the
+// fields leading to it from the name of the statement, eg pb.1.3, or after
+// their names when the message is declared in a .proto file.
+//
+// Without .proto, varints and fixed values are unsigned integers and the
+// length delimited values are read as nested messages unless they are
+// printable text, given as strings, or are not valid messages, given as bytes.
+func (root *state) decodeProtobuf(n Protobuf) error {
+	buf, err := root.region(protobufDecl, n.size)
+	if err != nil {
+		return err
+	}
+	w := protoWalker{schema: n.schema}
+	if _, err := w.walk(buf, root.Pos, n.id.Literal, n.message, -1); err != nil {
+		return fmt.Errorf("%s: %w", protobufDecl, err)
+	}
+	for i := range w.fields {
+		w.fields[i].Block, w.fields[i].Ix = root.currentBlock(), root.Iter
+	}
+	root.Fields = append(root.Fields, w.fields...)
+	root.Pos += len(buf) * numbit
+	return nil
+}
+
+type protoWalker struct {
+	schema *protoSchema
+	fields []Field
+}
+
+// walk appends the fields of the message in buf, pos being the position of its
+// first byte in the packet. A group ends with the end group tag of its field,
+// group being -1 when walking a message. walk returns the number of bytes read.
+func (w *protoWalker) walk(buf []byte, pos int, path string, m *protoMessage, group int) (int, error) {
+	var offset int
+	for offset < len(buf) {
+		key, n := binary.Uvarint(buf[offset:])
+		if n <= 0 {
+			return offset, fmt.Errorf("%s: %w: key", path, errWire)
+		}
+		var (
+			num   = int(key >> 3)
+			wire  = int(key & 7)
+			start = offset + n
+		)
+		if num <= 0 || key>>3 > math.MaxInt32 {
+			return offset, fmt.Errorf("%s: %w: field number %d", path, errWire, key>>3)
+		}
+		if wire == wireEndGroup {
+			if num != group {
+				return offset, fmt.Errorf("%s: %w: end of group %d", path, errWire, num)
+			}
+			return start, nil
+		}
+		f, name := w.field(m, path, num)
+		switch wire {
+		case wireVarint:
+			x, n := binary.Uvarint(buf[start:])
+			if n <= 0 {
+				return offset, fmt.Errorf("%s: %w: varint", name, errWire)
+			}
+			w.scalar(name, f.typ, wire, x, pos+start*numbit, n*numbit)
+			offset = start + n
+		case wireFixed64, wireFixed32:
+			z := 8
+			if wire == wireFixed32 {
+				z = 4
+			}
+			if start+z > len(buf) {
+				return offset, fmt.Errorf("%s: %w: want %d bytes, only %d available", name, errTruncated, z, len(buf)-start)
+			}
+			var x uint64
+			if z == 8 {
+				x = binary.LittleEndian.Uint64(buf[start:])
+			} else {
+				x = uint64(binary.LittleEndian.Uint32(buf[start:]))
+			}
+			w.scalar(name, f.typ, wire, x, pos+start*numbit, z*numbit)
+			offset = start + z
+		case wireBytes:
+			size, n := binary.Uvarint(buf[start:])
+			if n <= 0 {
+				return offset, fmt.Errorf("%s: %w: length", name, errWire)
+			}
+			start += n
+			if size > uint64(len(buf)-start) {
+				return offset, fmt.Errorf("%s: %w: want %d bytes, only %d available", name, errTruncated, size, len(buf)-start)
+			}
+			end := start + int(size)
+			if err := w.delimited(buf[start:end], pos+start*numbit, name, m, f); err != nil {
+				return offset, err
+			}
+			offset = end
+		case wireStartGroup:
+			var sub *protoMessage
+			if m != nil && w.schema != nil {
+				sub, _ = w.schema.message(w.schema.resolve(m, f))
+			}
+			n, err := w.walk(buf[start:], pos+start*numbit, name, sub, num)
+			if err != nil {
+				return offset, err
+			}
+			offset = start + n
+		default:
+			return offset, fmt.Errorf("%s: %w: wire type %d", name, errWire, wire)
+		}
+	}
+	if group >= 0 {
+		return offset, fmt.Errorf("%s: %w: missing end of group", path, errTruncated)
+	}
+	return offset, nil
+}
+
+// field gives the declaration of the field num of m, if any, and its name in
+// the path of the fields.
+func (w *protoWalker) field(m *protoMessage, path string, num int) (protoField, string) {
+	if m != nil {
+		if f, ok := m.fields[num]; ok {
+			return f, path + "." + f.name
+		}
+	}
+	return protoField{number: num}, path + "." + strconv.Itoa(num)
+}
+
+// delimited handles a length delimited value: a string, bytes, a nested message
+// or packed scalars.
+func (w *protoWalker) delimited(buf []byte, pos int, name string, m *protoMessage, f protoField) error {
+	typ := f.typ
+	if m != nil && w.schema != nil && typ != "" {
+		typ = w.schema.resolve(m, f)
+	}
+	switch typ {
+	case "string":
+		w.append(name, pos, len(buf)*numbit, &String{Raw: string(buf)}, kindString)
+		return nil
+	case "bytes":
+		w.append(name, pos, len(buf)*numbit, &Bytes{Raw: buf}, kindBytes)
+		return nil
+	case "":
+	default:
+		if _, ok := protoScalars[typ]; ok {
+			return w.packed(buf, pos, name, typ)
+		}
+		if sub, err := w.schema.message(typ); err == nil {
+			_, err := w.walk(buf, pos, name, sub, -1)
+			return err
+		}
+	}
+	// text seldom starts with a control character while the key of the first
+	// fields of a message does
+	if len(buf) > 0 && (buf[0] < ' ' || !isPrintable(buf)) && isMessage(buf, -1) {
+		_, err := w.walk(buf, pos, name, nil, -1)
+		return err
+	}
+	if isPrintable(buf) {
+		w.append(name, pos, len(buf)*numbit, &String{Raw: string(buf)}, kindString)
+	} else {
+		w.append(name, pos, len(buf)*numbit, &Bytes{Raw: buf}, kindBytes)
+	}
+	return nil
+}
+
+// packed appends the elements of a packed repeated field of scalars, each one
+// as a field with the name of the repeated field.
+func (w *protoWalker) packed(buf []byte, pos int, name, typ string) error {
+	wire, z := wireVarint, 0
+	switch typ {
+	case "double", "fixed64", "sfixed64":
+		wire, z = wireFixed64, 8
+	case "float", "fixed32", "sfixed32":
+		wire, z = wireFixed32, 4
+	}
+	for offset := 0; offset < len(buf); {
+		var x uint64
+		switch {
+		case z == 8 && offset+z <= len(buf):
+			x = binary.LittleEndian.Uint64(buf[offset:])
+		case z == 4 && offset+z <= len(buf):
+			x = uint64(binary.LittleEndian.Uint32(buf[offset:]))
+		case z == 0:
+			var n int
+			if x, n = binary.Uvarint(buf[offset:]); n <= 0 {
+				return fmt.Errorf("%s: %w: packed varint", name, errWire)
+			}
+			w.scalar(name, typ, wire, x, pos+offset*numbit, n*numbit)
+			offset += n
+			continue
+		default:
+			return fmt.Errorf("%s: %w: packed %s", name, errTruncated, typ)
+		}
+		w.scalar(name, typ, wire, x, pos+offset*numbit, z*numbit)
+		offset += z
+	}
+	return nil
+}
+
+// scalar appends the value x of a varint or of a fixed value, converted
+// according to the type of its field.
+func (w *protoWalker) scalar(name, typ string, wire int, x uint64, pos, bits int) {
+	var (
+		val  Value = &Uint{Raw: x}
+		kind       = kindUint
+	)
+	switch typ {
+	case "int64", "sfixed64":
+		val, kind = &Int{Raw: int64(x)}, kindInt
+	case "int32", "sfixed32":
+		val, kind = &Int{Raw: int64(int32(x))}, kindInt
+	case "sint32", "sint64":
+		val, kind = &Int{Raw: int64(x>>1) ^ -int64(x&1)}, kindInt
+	case "bool":
+		val, kind = &Boolean{Raw: x != 0}, kindNull
+	case "double":
+		val, kind = &Real{Raw: math.Float64frombits(x)}, kindFloat
+	case "float":
+		val, kind = &Real{Raw: float64(math.Float32frombits(uint32(x)))}, kindFloat
+	default:
+		if w.schema != nil && typ != "" && wire == wireVarint {
+			if _, ok := protoScalars[typ]; !ok {
+				// enums
+				val, kind = &Int{Raw: int64(int32(x))}, kindInt
+			}
+		}
+	}
+	w.append(name, pos, bits, val, kind)
+}
+
+func (w *protoWalker) append(name string, pos, bits int, val Value, kind Kind) {
+	w.fields = append(w.fields, Field{
+		Id:   name,
+		Pos:  pos,
+		Len:  bits,
+		raw:  val,
+		kind: kind,
+	})
+}
+
+// isMessage reports whether buf can be read as a message in wire format.
+func isMessage(buf []byte, group int) bool {
+	_, ok := skipMessage(buf, group)
+	return ok
+}
+
+func skipMessage(buf []byte, group int) (int, bool) {
+	var offset int
+	for offset < len(buf) {
+		key, n := binary.Uvarint(buf[offset:])
+		if n <= 0 || key>>3 == 0 || key>>3 > math.MaxInt32 {
+			return offset, false
+		}
+		offset += n
+		switch num, wire := int(key>>3), int(key&7); wire {
+		case wireVarint:
+			if _, n = binary.Uvarint(buf[offset:]); n <= 0 {
+				return offset, false
+			}
+			offset += n
+		case wireFixed64:
+			offset += 8
+		case wireFixed32:
+			offset += 4
+		case wireBytes:
+			size, n := binary.Uvarint(buf[offset:])
+			if n <= 0 || size > uint64(len(buf)-offset-n) {
+				return offset, false
+			}
+			offset += n + int(size)
+		case wireStartGroup:
+			n, ok := skipMessage(buf[offset:], num)
+			if !ok {
+				return offset, false
+			}
+			offset += n
+		case wireEndGroup:
+			return offset, num == group
+		default:
+			return offset, false
+		}
+	}
+	return offset, offset == len(buf) && group < 0
+}
+
+// isPrintable reports whether buf is text in UTF-8 without control characters
+// other than spaces.
+func isPrintable(buf []byte) bool {
+	if !utf8.Valid(buf) {
+		return false
+	}
+	for _, r := range string(buf) {
+		if !unicode.IsPrint(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// loadProto reads the .proto file of a protobuf statement. The file is added
+// to the sources of the script, if any, as the files it includes.
+func (p *Parser) loadProto(file string) (*protoSchema, error) {
+	file = p.resolvePath(file)
+	r, err := p.openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p.addSource(file, buf)
+	return parseProto(string(buf))
+}
@@ -0,0 +1,95 @@
+package dissect
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	summaryEntropy   = "entropy"
+	summaryHistogram = "histogram"
+)
+
+func isSummary(str string) bool {
+	return str == summaryEntropy || str == summaryHistogram
+}
+
+// decodeSummary gives, as a field, statistics on the bytes following the
+// current position, their number given by the expression of n: the entropy,
+// in bits per byte, or the number of occurrences of each byte value. The bytes
+// are not consumed so that they can still be decoded.
+func (root *state) decodeSummary(n Summary) (Field, error) {
+	buf, err := root.region(n.kind.Literal, n.count)
+	if err != nil {
+		return Field{}, err
+	}
+	var (
+		size   = len(buf)
+		counts [256]int
+	)
+	for _, b := range buf {
+		counts[b]++
+	}
+	f := Field{
+		Id:    n.id.Literal,
+		Pos:   root.Pos,
+		Len:   size * numbit,
+		Block: root.currentBlock(),
+		Ix:    root.Iter,
+	}
+	switch n.kind.Literal {
+	case summaryEntropy:
+		f.kind, f.raw = kindFloat, &Real{Raw: entropy(counts[:], size)}
+	case summaryHistogram:
+		arr := Array{Raw: make([]Value, len(counts))}
+		for i, c := range counts {
+			arr.Raw[i] = &Uint{Raw: uint64(c)}
+		}
+		f.kind, f.raw = kindUint, &arr
+	default:
+		return Field{}, fmt.Errorf("%s: unknown summary", n.kind.Literal)
+	}
+	return f, nil
+}
+
+// region gives the bytes following the current position, their number given by
+// size. The position should be at the start of a byte and is not moved.
+func (root *state) region(what string, size Expression) ([]byte, error) {
+	v, err := eval(size, root)
+	if err != nil {
+		return nil, err
+	}
+	n := int(asInt(v))
+	if n < 0 {
+		return nil, fmt.Errorf("%s: negative size (%d)", what, n)
+	}
+	if root.Pos%numbit != 0 {
+		return nil, fmt.Errorf("%s: region should start at offset 0", what)
+	}
+	bits := n * numbit
+	if err := root.checkFrame(bits); err != nil {
+		return nil, err
+	}
+	if err := root.growBuffer(bits); err != nil {
+		return nil, err
+	}
+	if avail := root.Size() - root.Pos; avail < bits {
+		return nil, fmt.Errorf("%s %w: want %d bits, only %d available", what, ErrShort, bits, avail)
+	}
+	index := root.Pos / numbit
+	return root.buffer[index : index+n], nil
+}
+
+// entropy gives the Shannon entropy, in bits per byte, of size bytes whose
+// values occur counts times.
+func entropy(counts []int, size int) float64 {
+	var e float64
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(size)
+		e -= p * math.Log2(p)
+	}
+	return e
+}
@@ -0,0 +1,299 @@
+package dissect
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrOffline is returned when a script includes a URL not found in the cache
+// while the interpreter is offline.
+var ErrOffline = errors.New("not available offline")
+
+// ErrNetwork is returned when a script accesses a host not allowed with
+// WithAllowNetwork.
+var ErrNetwork = errors.New("network access not allowed")
+
+const (
+	pinSHA256     = "sha256="
+	remoteTimeout = 30 * time.Second
+	maxRedirects  = 10
+	etagExt       = ".etag"
+)
+
+// WithOffline makes the parser read the files included from URLs only from the
+// cache, without accessing the network.
+func WithOffline(offline bool) Option {
+	return func(i *Interpreter) error {
+		i.offline = offline
+		return nil
+	}
+}
+
+// WithIncludeCache sets the directory where the files included from URLs are
+// cached. By default, they are cached in the dissect directory of the user
+// cache directory.
+func WithIncludeCache(dir string) Option {
+	return func(i *Interpreter) error {
+		i.cache = dir
+		return nil
+	}
+}
+
+// WithAllowNetwork restricts the hosts the script can access, eg to include
+// files from URLs, to the given ones. A host is a name, optionally followed by
+// a port, or a pattern like *.example.com matching its sub domains. Without
+// hosts, the script can not access the network at all.
+func WithAllowNetwork(hosts ...string) Option {
+	return func(i *Interpreter) error {
+		i.hosts = hostPolicy{
+			restricted: true,
+			hosts:      append(i.hosts.hosts, hosts...),
+		}
+		return nil
+	}
+}
+
+type hostPolicy struct {
+	restricted bool
+	hosts      []string
+}
+
+func (h hostPolicy) allow(u *url.URL) bool {
+	if !h.restricted {
+		return true
+	}
+	name := strings.ToLower(u.Hostname())
+	for _, host := range h.hosts {
+		host = strings.ToLower(host)
+		switch {
+		case host == name || host == strings.ToLower(u.Host):
+		case strings.HasPrefix(host, "*.") && strings.HasSuffix(name, host[1:]):
+		default:
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// client gives an HTTP client following only the redirections to the hosts
+// allowed by the policy.
+func (h hostPolicy) client() *http.Client {
+	return &http.Client{
+		Timeout: remoteTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !h.allow(req.URL) {
+				return fmt.Errorf("redirected to %s: %w", req.URL.Host, ErrNetwork)
+			}
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// resolveURL resolves file relatively to the URL of the file including it.
+func resolveURL(base, file string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return file
+	}
+	f, err := url.Parse(filepath.ToSlash(file))
+	if err != nil {
+		return file
+	}
+	return b.ResolveReference(f).String()
+}
+
+// openURL returns the content of the file found at addr. The content can be
+// pinned by giving its SHA-256 in the fragment of addr, eg
+// https://host/schema.dsl#sha256=<hex>. The files downloaded are kept in the
+// cache. A pinned file found in the cache is read from it. The other ones are
+// downloaded again when they changed, which the server tells from the ETag of
+// the cached copy. When the download fails, the cached copy is used. Offline,
+// the files are only read from the cache.
+func (p *Parser) openURL(addr string) (io.ReadCloser, error) {
+	loc, pin, err := splitPin(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(loc); err != nil || !p.hosts.allow(u) {
+		return nil, fmt.Errorf("%s: %w", addr, ErrNetwork)
+	}
+	file, err := p.cacheFile(loc)
+	if err != nil {
+		return nil, err
+	}
+	cached, cerr := ioutil.ReadFile(file)
+	if p.offline {
+		if os.IsNotExist(cerr) {
+			cerr = ErrOffline
+		}
+		return openCached(addr, cached, pin, cerr)
+	}
+	if cerr == nil && pin != nil && checkPin(cached, pin) == nil {
+		return openCached(addr, cached, pin, nil)
+	}
+	var etag string
+	if cerr == nil {
+		etag = readETag(file)
+	}
+	buf, etag, err := download(p.hosts.client(), loc, etag)
+	if err == nil && buf != nil {
+		err = checkPin(buf, pin)
+	}
+	switch {
+	case err != nil && cerr == nil && !errors.Is(err, ErrNetwork):
+		// the download failed: the cached copy is used
+		return openCached(addr, cached, pin, nil)
+	case err != nil:
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	case buf == nil:
+		// not modified since it was cached
+		return openCached(addr, cached, pin, nil)
+	}
+	if err := writeCache(file, buf, etag); err != nil {
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	return namedReader{Reader: bytes.NewReader(buf), name: addr}, nil
+}
+
+// openCached gives the cached copy of addr. err is the error of reading it.
+func openCached(addr string, buf, pin []byte, err error) (io.ReadCloser, error) {
+	if err == nil {
+		err = checkPin(buf, pin)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	return namedReader{Reader: bytes.NewReader(buf), name: addr}, nil
+}
+
+func (p *Parser) cacheFile(loc string) (string, error) {
+	dir := p.cache
+	if dir == "" {
+		d, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(d, "dissect")
+	}
+	sum := sha256.Sum256([]byte(loc))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])), nil
+}
+
+func splitPin(addr string) (string, []byte, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", nil, err
+	}
+	frag := u.Fragment
+	u.Fragment = ""
+	if frag == "" {
+		return u.String(), nil, nil
+	}
+	if !strings.HasPrefix(frag, pinSHA256) {
+		return "", nil, fmt.Errorf("%s: unsupported checksum %s", addr, frag)
+	}
+	pin, err := hex.DecodeString(strings.TrimPrefix(frag, pinSHA256))
+	if err != nil || len(pin) != sha256.Size {
+		return "", nil, fmt.Errorf("%s: invalid sha256 checksum", addr)
+	}
+	return u.String(), pin, nil
+}
+
+func checkPin(buf, pin []byte) error {
+	if pin == nil {
+		return nil
+	}
+	if sum := sha256.Sum256(buf); !bytes.Equal(sum[:], pin) {
+		return fmt.Errorf("checksum mismatch (got %x)", sum)
+	}
+	return nil
+}
+
+// download gets the file at loc. With the ETag of a cached copy, the file is
+// only sent by the server if it changed: no content is returned otherwise. The
+// ETag of the content is returned with it.
+func download(c *http.Client, loc, etag string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotModified && etag != "":
+		return nil, etag, nil
+	case res.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("unexpected status %s", res.Status)
+	}
+	buf, err := ioutil.ReadAll(res.Body)
+	return buf, res.Header.Get("ETag"), err
+}
+
+// readETag gives the ETag saved with the cached copy in file, if any.
+func readETag(file string) string {
+	buf, _ := ioutil.ReadFile(file + etagExt)
+	return strings.TrimSpace(string(buf))
+}
+
+// writeCache saves buf in file and its ETag next to it.
+func writeCache(file string, buf []byte, etag string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// an ETag left with another content only makes the server send the file
+	// again: it is written after the content
+	if err := writeFile(file, buf); err != nil {
+		return err
+	}
+	if etag == "" {
+		if err := os.Remove(file + etagExt); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeFile(file+etagExt, []byte(etag))
+}
+
+// writeFile replaces the content of file, writing it first to a temporary file
+// so that a file read concurrently is never partially written.
+func writeFile(file string, buf []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".include-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,184 @@
+package dissect
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	var posted bytes.Buffer
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			io.Copy(&posted, r.Body)
+			return
+		}
+		io.WriteString(w, "block header (\n  apid: uint 8\n)\n")
+	}))
+	defer target.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer proxy.Close()
+
+	var (
+		proxyHost  = hostOf(t, proxy.URL)
+		targetHost = hostOf(t, target.URL)
+	)
+	if proxyHost == targetHost {
+		t.Fatalf("servers listening on the same address")
+	}
+	tests := []struct {
+		Name  string
+		Hosts []string
+		Err   error
+	}{
+		{Name: "allowed", Hosts: []string{proxyHost, targetHost}},
+		{Name: "denied", Hosts: []string{proxyHost}, Err: ErrNetwork},
+	}
+	for _, tt := range tests {
+		t.Run("include-"+tt.Name, func(t *testing.T) {
+			script := fmt.Sprintf("include (\n  %q\n)\ndata (\n  include header\n)\n", proxy.URL+"/header.lst")
+			_, err := New(strings.NewReader(script), WithAllowNetwork(tt.Hosts...), WithIncludeCache(t.TempDir()))
+			if !errors.Is(err, tt.Err) {
+				t.Fatalf("want error %v, got %v", tt.Err, err)
+			}
+		})
+		t.Run("post-"+tt.Name, func(t *testing.T) {
+			posted.Reset()
+			script := fmt.Sprintf("data (\n  apid: uint 8\n  print raw to %q as csv\n)\n", proxy.URL+"/ingest")
+			i, err := New(strings.NewReader(script), WithAllowNetwork(tt.Hosts...), WithPostRetry(0, 0), WithStderr(ioutil.Discard))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = i.Run(bytes.NewReader([]byte{1}))
+			if !errors.Is(err, tt.Err) {
+				t.Fatalf("want error %v, got %v", tt.Err, err)
+			}
+			if sent := posted.Len() > 0; sent != (tt.Err == nil) {
+				t.Errorf("records sent to the target: %t", sent)
+			}
+		})
+	}
+}
+
+func hostOf(t *testing.T, addr string) string {
+	t.Helper()
+	u, err := url.Parse(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func TestIncludeCache(t *testing.T) {
+	const (
+		v1 = "block header (\n  apid: uint 8\n)\n"
+		v2 = "block header (\n  kind: uint 8\n)\n"
+	)
+	var (
+		body     = v1
+		etag     = `"v1"`
+		fail     bool
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case fail:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case r.Header.Get("If-None-Match") == etag:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", etag)
+			io.WriteString(w, body)
+		}
+	}))
+	defer srv.Close()
+
+	var (
+		cache = t.TempDir()
+		addr  = srv.URL + "/header.lst"
+	)
+	pinned := func(str string) string {
+		return fmt.Sprintf("%s#sha256=%x", addr, sha256.Sum256([]byte(str)))
+	}
+	tests := []struct {
+		Name     string
+		Addr     string
+		Change   func()
+		Offline  bool
+		Cache    string
+		Want     string
+		Requests int
+		Err      error
+	}{
+		{Name: "miss", Addr: addr, Want: "apid", Requests: 1},
+		{Name: "not-modified", Addr: addr, Want: "apid", Requests: 1},
+		{
+			Name:     "modified",
+			Addr:     addr,
+			Change:   func() { body, etag = v2, `"v2"` },
+			Want:     "kind",
+			Requests: 1,
+		},
+		{Name: "failure", Addr: addr, Change: func() { fail = true }, Want: "kind", Requests: 1},
+		{Name: "failure-miss", Addr: addr, Cache: t.TempDir(), Requests: 1, Err: errors.New("503")},
+		{Name: "offline", Addr: addr, Offline: true, Want: "kind"},
+		{Name: "offline-miss", Addr: addr, Offline: true, Cache: t.TempDir(), Err: ErrOffline},
+		{
+			Name:     "pinned-miss",
+			Addr:     pinned(v2),
+			Cache:    t.TempDir(),
+			Change:   func() { fail = false },
+			Want:     "kind",
+			Requests: 1,
+		},
+		{Name: "pinned", Addr: pinned(v2), Change: func() { fail = true }, Want: "kind"},
+		{
+			Name:     "pinned-mismatch",
+			Addr:     pinned("other"),
+			Cache:    t.TempDir(),
+			Change:   func() { fail = false },
+			Requests: 1,
+			Err:      errors.New("checksum mismatch"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if tt.Change != nil {
+				tt.Change()
+			}
+			dir := cache
+			if tt.Cache != "" {
+				dir = tt.Cache
+			}
+			requests = 0
+			script := fmt.Sprintf("include (\n  %q\n)\ndata (\n  include header\n)\n", tt.Addr)
+			i, err := New(strings.NewReader(script), WithIncludeCache(dir), WithOffline(tt.Offline))
+			if requests != tt.Requests {
+				t.Errorf("want %d requests, got %d", tt.Requests, requests)
+			}
+			if tt.Err != nil {
+				if err == nil || (!errors.Is(err, tt.Err) && !strings.Contains(err.Error(), tt.Err.Error())) {
+					t.Fatalf("want error %v, got %v", tt.Err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if fs := i.Program().Fields(); len(fs) != 1 || fs[0].Id != tt.Want {
+				t.Errorf("want field %s, got %+v", tt.Want, fs)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package dissect
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Renderer formats the value of a field before it is printed. The returned
+// string replaces the engineering value of the field in all the printers. A
+// renderer returns false to leave the field unchanged.
+type Renderer func(Field) (string, bool)
+
+type renderer struct {
+	kind    string
+	pattern string
+	render  Renderer
+}
+
+func (r renderer) match(f Field) bool {
+	if r.kind != "" {
+		return f.kind.String() == r.kind
+	}
+	ok, _ := path.Match(r.pattern, f.Id)
+	return ok
+}
+
+// WithKindRenderer registers r for the fields of the given kind (int, uint,
+// float, string, bytes, time...).
+func WithKindRenderer(kind string, r Renderer) Option {
+	return func(i *Interpreter) error {
+		if !isKindName(kind) {
+			return fmt.Errorf("%s: unknown kind", kind)
+		}
+		i.renderers = append(i.renderers, renderer{kind: kind, render: r})
+		return nil
+	}
+}
+
+// WithFieldRenderer registers r for the fields whose name matches pattern. The
+// syntax of pattern is the one of path.Match.
+func WithFieldRenderer(pattern string, r Renderer) Option {
+	return func(i *Interpreter) error {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: %w", pattern, err)
+		}
+		i.renderers = append(i.renderers, renderer{pattern: pattern, render: r})
+		return nil
+	}
+}
+
+// HexRenderer renders bytes and unsigned integers as hexadecimal bytes joined
+// by sep, eg a MAC address with ":".
+func HexRenderer(sep string) Renderer {
+	return func(f Field) (string, bool) {
+		var buf []byte
+		switch v := f.Raw().(type) {
+		case *Bytes:
+			buf = v.Raw
+		case *Uint:
+			n := (f.Len + numbit - 1) / numbit
+			for i := n - 1; i >= 0; i-- {
+				buf = append(buf, byte(v.Raw>>(i*numbit)))
+			}
+		default:
+			return "", false
+		}
+		parts := make([]string, len(buf))
+		for i := range buf {
+			parts[i] = hex.EncodeToString(buf[i : i+1])
+		}
+		return strings.Join(parts, sep), true
+	}
+}
+
+func isKindName(kind string) bool {
+	for _, k := range []Kind{kindInt, kindUint, kindFloat, kindString, kindBytes, kindTime, kindGPS, kindUnix, kindCUC, kindCDS} {
+		if k.String() == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// render applies the renderers of the interpreter to values. The fields are
+// copied before being changed: the fields of the packet keep their values.
+// Renderers are tried in the order they were registered and the first one
+// accepting a field wins.
+func (root *state) render(values []Field) []Field {
+	if len(root.renderers) == 0 {
+		return values
+	}
+	var xs []Field
+	for i, v := range values {
+		for _, r := range root.renderers {
+			if !r.match(v) {
+				continue
+			}
+			str, ok := r.render(v)
+			if !ok {
+				continue
+			}
+			if xs == nil {
+				xs = append(xs, values...)
+			}
+			xs[i].eng = &String{Raw: str}
+			break
+		}
+	}
+	if xs == nil {
+		return values
+	}
+	return xs
+}
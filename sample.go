@@ -0,0 +1,177 @@
+package dissect
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var errNotDecoded = errors.New("field not decoded")
+
+// Divergence is the first difference found by CheckSample between the fields
+// decoded from a sample and the values expected for them.
+type Divergence struct {
+	Packet int    // packet of the sample, from 0
+	Field  string // block and id of the field, empty if the packet failed
+	Offset int    // offset of the field in the packet, in bits
+	Want   string
+	Got    string
+	Err    error // set when the field or the packet could not be decoded
+}
+
+func (d Divergence) String() string {
+	where := fmt.Sprintf("packet %d: %s at bit %d (byte %d)", d.Packet, d.Field, d.Offset, d.Offset/numbit)
+	if d.Field == "" {
+		where = fmt.Sprintf("packet %d: at bit %d (byte %d)", d.Packet, d.Offset, d.Offset/numbit)
+	}
+	switch {
+	case d.Err != nil && d.Want != "":
+		return fmt.Sprintf("%s: want %s, %s", where, d.Want, d.Err)
+	case d.Err != nil:
+		return fmt.Sprintf("%s: %s", where, d.Err)
+	}
+	return fmt.Sprintf("%s: want %s, got %s", where, d.Want, d.Got)
+}
+
+type samplePacket struct {
+	fields []Field
+	err    error
+}
+
+// CheckSample decodes sample with script and compares the fields of its packets
+// to the values read from expected, a csv file whose first row gives the names
+// of the fields and each of the next rows the values of one packet. Names are
+// either the id of the fields or their block and id, eg hdr.apid. A name given
+// more than once matches the fields with that name in the order they are
+// decoded. The elements of an array are checked all at once or one by one, eg
+// samples[3]. Empty cells are not checked and numbers are compared by value, eg
+// 0x10 and 16 are equal.
+//
+// CheckSample returns the first field, in the order of the sample, whose value
+// is not the expected one or nil if all the packets given in expected match.
+// The engineering values are compared instead of the raw ones when eng is set.
+func CheckSample(script, sample, expected io.Reader, eng bool, opts ...Option) (*Divergence, error) {
+	rows, err := csv.NewReader(expected).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("expected values: no header found")
+	}
+	var packets []samplePacket
+	opts = append(opts, WithDryRun(true), WithPacketHook(func(p PacketInfo) {
+		packets = append(packets, samplePacket{
+			fields: append([]Field(nil), p.Fields...),
+			err:    p.Err,
+		})
+	}))
+	i, err := New(script, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.Run(sample); err != nil {
+		return nil, err
+	}
+	cols := make(map[string][]int)
+	for j, name := range rows[0] {
+		name = strings.TrimSpace(name)
+		cols[name] = append(cols[name], j)
+	}
+	for k, row := range rows[1:] {
+		if k >= len(packets) {
+			return &Divergence{Packet: k, Err: fmt.Errorf("packet not decoded (%d in sample)", len(packets))}, nil
+		}
+		if d := checkPacket(k, packets[k], rows[0], cols, row, eng); d != nil {
+			return d, nil
+		}
+	}
+	return nil, nil
+}
+
+func checkPacket(k int, p samplePacket, header []string, cols map[string][]int, row []string, eng bool) *Divergence {
+	var (
+		seen = make(map[string]int)
+		used = make(map[int]struct{})
+		end  int
+	)
+	var fields []Field
+	for _, f := range p.fields {
+		fields = append(fields, f)
+		if _, ok := f.Raw().(*Array); ok {
+			fields = append(fields, explodeArrays([]Field{f})...)
+		}
+	}
+	for _, f := range fields {
+		if n := f.Pos + f.Len; n > end {
+			end = n
+		}
+		name := f.String()
+		if _, ok := cols[name]; !ok {
+			name = f.Id
+		}
+		ix, ok := cols[name]
+		if !ok || seen[name] >= len(ix) {
+			continue
+		}
+		j := ix[seen[name]]
+		seen[name]++
+		used[j] = struct{}{}
+
+		want := strings.TrimSpace(row[j])
+		if want == "" {
+			continue
+		}
+		v := f.Raw()
+		if eng {
+			v = f.Eng()
+		}
+		if got := string(appendRaw(nil, v, encText)); !sameValue(want, got) {
+			return &Divergence{
+				Packet: k,
+				Field:  f.String(),
+				Offset: f.Pos,
+				Want:   want,
+				Got:    got,
+			}
+		}
+	}
+	if p.err != nil {
+		return &Divergence{Packet: k, Offset: end, Err: p.err}
+	}
+	for j, want := range row {
+		if _, ok := used[j]; ok || strings.TrimSpace(want) == "" {
+			continue
+		}
+		return &Divergence{
+			Packet: k,
+			Field:  strings.TrimSpace(header[j]),
+			Offset: end,
+			Want:   strings.TrimSpace(want),
+			Err:    errNotDecoded,
+		}
+	}
+	return nil
+}
+
+// sameValue compares the text of an expected and a decoded value, by value if
+// both are numbers.
+func sameValue(want, got string) bool {
+	if want == got {
+		return true
+	}
+	x, okx := new(big.Int).SetString(want, 0)
+	y, oky := new(big.Int).SetString(got, 0)
+	if okx && oky {
+		return x.Cmp(y) == 0
+	}
+	f, err := strconv.ParseFloat(want, 64)
+	if err != nil {
+		return false
+	}
+	g, err := strconv.ParseFloat(got, 64)
+	return err == nil && f == g
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"io/ioutil"
 	"sort"
+	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -30,7 +32,7 @@ func Scan(r io.Reader) (*Scanner, error) {
 func (s *Scanner) Reset(r io.Reader) error {
 	buf, err := ioutil.ReadAll(r)
 	if err == nil {
-		s.buffer = bytes.ReplaceAll(buf, []byte("\r\n"), []byte("\n"))
+		s.buffer = normalizeNewlines(buf)
 		s.line = 1
 		s.column = 0
 		s.readRune()
@@ -38,6 +40,11 @@ func (s *Scanner) Reset(r io.Reader) error {
 	return err
 }
 
+func normalizeNewlines(buf []byte) []byte {
+	buf = bytes.ReplaceAll(buf, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(buf, []byte("\r"), []byte("\n"))
+}
+
 func (s *Scanner) Scan() Token {
 	var tok Token
 	if s.char == 0 {
@@ -55,7 +62,8 @@ func (s *Scanner) Scan() Token {
 	switch {
 	case s.char == dollar:
 		s.readRune()
-		if tok = s.Scan(); tok.Type != Ident {
+		// keywords are valid names after $, eg $bytes(...)
+		if tok = s.Scan(); tok.Type != Ident && tok.Type != Keyword {
 			tok.Type = Illegal
 		} else {
 			tok.Type = Internal
@@ -67,10 +75,17 @@ func (s *Scanner) Scan() Token {
 		s.scanNumber(&tok)
 	case isComment(s.char):
 		s.scanComment(&tok)
+	case s.char == langle && s.isHeredoc():
+		s.scanHeredoc(&tok)
+	case s.char == dot && s.peekRune() == dot:
+		s.readRune()
+		tok.Type = Range
 	case isOp(s.char):
 		s.scanOperator(&tok)
 	case s.char == quote:
 		s.scanText(&tok)
+	case s.char == backquote:
+		s.scanQuotedIdent(&tok)
 	case s.char == newline:
 		tok.Type = Newline
 	default:
@@ -167,6 +182,7 @@ func (s *Scanner) scanNumber(tok *Token) {
 		s.readRune()
 	}
 	switch {
+	case s.char == dot && s.peekRune() == dot:
 	case s.char == dot && !nodot:
 		s.readRune()
 		for accept(s.char) {
@@ -184,7 +200,7 @@ func (s *Scanner) scanNumber(tok *Token) {
 		return
 	default:
 	}
-	if s.pos == pos {
+	if s.pos == pos || s.char == EOF {
 		tok.Literal = string(s.buffer[pos : s.pos+1])
 	} else {
 		tok.Literal = string(s.buffer[pos:s.pos])
@@ -206,13 +222,102 @@ func (s *Scanner) scanText(tok *Token) {
 	s.readRune()
 
 	pos := s.pos
-	for s.char != quote {
+	for s.char != quote && s.char != EOF {
 		s.readRune()
 	}
 	tok.Type = Text
+	if s.char == EOF {
+		tok.Type = Illegal
+	}
 	tok.Literal = string(s.buffer[pos:s.pos])
 }
 
+// scanQuotedIdent scans an identifier enclosed in backquotes. It can contain
+// any character except newlines and backquotes.
+func (s *Scanner) scanQuotedIdent(tok *Token) {
+	s.readRune()
+
+	pos := s.pos
+	for s.char != backquote && s.char != newline && s.char != EOF {
+		s.readRune()
+	}
+	tok.Type = Ident
+	if s.char != backquote || s.pos == pos {
+		tok.Type = Illegal
+	}
+	tok.Literal = string(s.buffer[pos:s.pos])
+}
+
+// isHeredoc reports whether the scanner is at the start of a heredoc: << followed
+// by an upper case delimiter and the end of the line.
+func (s *Scanner) isHeredoc() bool {
+	_, ok := s.heredocDelimiter()
+	return ok
+}
+
+func (s *Scanner) heredocDelimiter() (string, bool) {
+	str := s.buffer[s.pos:]
+	if !bytes.HasPrefix(str, []byte("<<")) || len(str) < 3 || str[2] < 'A' || str[2] > 'Z' {
+		return "", false
+	}
+	i := 2
+	for i < len(str) && (isUpper(rune(str[i])) || isDigit(rune(str[i])) || str[i] == underscore) {
+		i++
+	}
+	word := string(str[2:i])
+	for i < len(str) && isBlank(rune(str[i])) {
+		i++
+	}
+	return word, i < len(str) && str[i] == newline
+}
+
+// scanHeredoc reads the lines up to the one starting with the delimiter. The
+// indentation of the closing delimiter is removed from each line of the text.
+func (s *Scanner) scanHeredoc(tok *Token) {
+	word, _ := s.heredocDelimiter()
+	for s.char != newline {
+		s.readRune()
+	}
+	var (
+		start = s.next
+		lines []string
+		end   = -1
+	)
+	for offset := start; offset < len(s.buffer); {
+		line := s.buffer[offset:]
+		if i := bytes.IndexByte(line, newline); i >= 0 {
+			line = line[:i]
+		}
+		trim := bytes.TrimLeft(line, " \t")
+		if bytes.HasPrefix(trim, []byte(word)) {
+			rest := trim[len(word):]
+			if len(rest) == 0 || isBlank(rune(rest[0])) {
+				indent := string(line[:len(line)-len(trim)])
+				for i := range lines {
+					lines[i] = strings.TrimPrefix(lines[i], indent)
+				}
+				end = offset + len(line) - len(rest)
+				break
+			}
+		}
+		lines = append(lines, string(line))
+		offset += len(line) + 1
+	}
+	if end < 0 {
+		tok.Type = Illegal
+		tok.Literal = word
+		for s.char != EOF {
+			s.readRune()
+		}
+		return
+	}
+	for s.next < end {
+		s.readRune()
+	}
+	tok.Type = Text
+	tok.Literal = strings.Join(lines, "\n")
+}
+
 func (s *Scanner) scanIdent(tok *Token) {
 	pos := s.pos
 	for isIdent(s.char) && s.char != 0 {
@@ -220,7 +325,7 @@ func (s *Scanner) scanIdent(tok *Token) {
 	}
 
 	if s.char == EOF {
-		tok.Literal = string(s.buffer[pos : s.pos+1])
+		tok.Literal = string(s.buffer[pos:s.next])
 	} else {
 		tok.Literal = string(s.buffer[pos:s.pos])
 	}
@@ -306,11 +411,15 @@ func (s *Scanner) scanComment(tok *Token) {
 	s.skipBlank()
 
 	pos := s.pos
-	for s.char != newline {
+	for s.char != newline && s.char != EOF {
 		s.readRune()
 	}
 
-	tok.Literal = string(s.buffer[pos:s.pos])
+	if s.char == EOF {
+		tok.Literal = string(s.buffer[pos:s.next])
+	} else {
+		tok.Literal = string(s.buffer[pos:s.pos])
+	}
 	tok.Type = Comment
 }
 
@@ -329,12 +438,25 @@ func (s *Scanner) skipBlank() {
 	}
 }
 
+// isIdent reports whether b can continue an identifier. Besides letters,
+// non ASCII marks, digits and connector punctuations are accepted (UAX#31).
 func isIdent(b rune) bool {
-	return isLetter(b) || isDigit(b) || b == underscore
+	if isLetter(b) || isDigit(b) || b == underscore {
+		return true
+	}
+	return b >= utf8.RuneSelf && unicode.In(b, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc)
 }
 
+// isLetter reports whether b can start an identifier.
 func isLetter(b rune) bool {
-	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+	if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') {
+		return true
+	}
+	return b >= utf8.RuneSelf && b != utf8.RuneError && unicode.In(b, unicode.L, unicode.Nl)
+}
+
+func isUpper(b rune) bool {
+	return b >= 'A' && b <= 'Z'
 }
 
 func isDigit(b rune) bool {
@@ -0,0 +1,78 @@
+package dissect
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanLineEndings(t *testing.T) {
+	want := []struct {
+		Token  string
+		Line   int
+		Column int
+	}{
+		{Token: "<keyword(data)>", Line: 1, Column: 1},
+		{Token: "<punct(()>", Line: 1, Column: 6},
+		{Token: "<newline>", Line: 2, Column: 0},
+		{Token: "<ident(a)>", Line: 2, Column: 3},
+		{Token: "<punct(:)>", Line: 2, Column: 4},
+		{Token: "<keyword(uint)>", Line: 2, Column: 6},
+		{Token: "<integer(8)>", Line: 2, Column: 11},
+		{Token: "<newline>", Line: 3, Column: 0},
+		{Token: "<punct())>", Line: 3, Column: 1},
+	}
+	for _, eol := range []string{"\n", "\r\n", "\r"} {
+		script := strings.Join([]string{"data (", "  a: uint 8", ")"}, eol)
+		s, err := Scan(strings.NewReader(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, w := range want {
+			var (
+				tok = s.Scan()
+				got = TokenString(tok)
+				pos = tok.Pos()
+			)
+			if got != w.Token || pos.Line != w.Line || pos.Column != w.Column {
+				t.Errorf("%q: token #%d: want %s at %d:%d, got %s at %d:%d", eol, i, w.Token, w.Line, w.Column, got, pos.Line, pos.Column)
+			}
+		}
+	}
+}
+
+func TestScanUnicode(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Token  string
+		Column int
+	}{
+		{Input: "température: uint 8", Token: "<ident(température)>", Column: 1},
+		{Input: "température: uint 8", Token: "<punct(:)>", Column: 12},
+		{Input: "日本: uint 8", Token: "<keyword(uint)>", Column: 5},
+		{Input: "e\u0301te\u0301: uint 8", Token: "<punct(:)>", Column: 6},
+		{Input: "ñ_2: uint 8", Token: "<ident(ñ_2)>", Column: 1},
+		{Input: "a: uint 8 \"côté\" 1", Token: "<text(côté)>", Column: 11},
+		{Input: "a: uint 8 \"côté\" 1", Token: "<integer(1)>", Column: 18},
+		{Input: "# côté 日本", Token: "<comment(côté 日本)>", Column: 1},
+	}
+	for _, tt := range tests {
+		s, err := Scan(strings.NewReader(tt.Input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for tok := s.Scan(); tok.Type != EOF; tok = s.Scan() {
+			if TokenString(tok) != tt.Token {
+				continue
+			}
+			found = true
+			if pos := tok.Pos(); pos.Line != 1 || pos.Column != tt.Column {
+				t.Errorf("%s: %s: want column %d, got %d:%d", tt.Input, tt.Token, tt.Column, pos.Line, pos.Column)
+			}
+			break
+		}
+		if !found {
+			t.Errorf("%s: %s not found", tt.Input, tt.Token)
+		}
+	}
+}
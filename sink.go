@@ -0,0 +1,345 @@
+package dissect
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultMaxFiles = 256
+
+const (
+	sinkFile      = "file"
+	sinkAppend    = "append"
+	sinkRotate    = "rotate"
+	sinkPartition = "partition"
+	sinkCompress  = "compress"
+	partitionBy   = "by"
+)
+
+type Sink struct {
+	pos       Position
+	id        Token
+	kind      Token
+	file      Token
+	format    Token
+	append    bool
+	rotate    time.Duration
+	partition []Token
+	compress  string
+}
+
+func (s Sink) Pos() Position {
+	return s.pos
+}
+
+func (s Sink) String() string {
+	return fmt.Sprintf("sink(%s)", s.id.Literal)
+}
+
+type sink struct {
+	Sink
+
+	path   string
+	files  map[string]struct{}
+	bucket time.Time
+}
+
+func openSinks(sinks []Sink) map[string]*sink {
+	set := make(map[string]*sink)
+	for _, s := range sinks {
+		file := s.file.Literal
+		if s.compress == "" {
+			s.compress = compressionOf(file)
+		} else if ext := compressExt[s.compress]; filepath.Ext(file) != ext {
+			file += ext
+		}
+		set[s.id.Literal] = &sink{
+			Sink:  s,
+			path:  partitionPath(file, s.partition),
+			files: make(map[string]struct{}),
+		}
+	}
+	return set
+}
+
+// partitionPath inserts in the directory of file one sub directory per
+// partition key, named after the key and its value (eg apid=101), as found in
+// Hive style partitioned datasets. The values are filled by expandPath.
+func partitionPath(file string, keys []Token) string {
+	if len(keys) == 0 {
+		return file
+	}
+	var (
+		dir, base = filepath.Split(file)
+		parts     = make([]string, 0, len(keys)+2)
+	)
+	parts = append(parts, dir)
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%%(%s)", k.Literal, k.Literal))
+	}
+	parts = append(parts, base)
+	return filepath.Join(parts...)
+}
+
+func (s *sink) Open(root *state) (io.Writer, bool, error) {
+	file, err := expandPath(root, s.path)
+	if err == nil {
+		file, err = root.outputFile(file)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if s.rotate > 0 {
+		now := time.Now().UTC().Truncate(s.rotate)
+		if !now.Equal(s.bucket) {
+			if err := s.Close(root.files); err != nil {
+				return nil, false, err
+			}
+			s.bucket = now
+		}
+		base, ext := splitExt(file)
+		file = fmt.Sprintf("%s-%s%s", base, s.bucket.Format("20060102T150405"), ext)
+	}
+	w, created, err := root.files.Open(file, s.append, s.compress)
+	if err == nil {
+		s.files[file] = struct{}{}
+	}
+	return w, created, err
+}
+
+func (s *sink) Close(c *fileCache) error {
+	var err error
+	for f := range s.files {
+		if e := c.Release(f); e != nil {
+			err = e
+		}
+		delete(s.files, f)
+	}
+	return err
+}
+
+// fileCache keeps at most limit files open. When the limit is reached, the
+// least recently used file is closed. A file closed this way is reopened in
+// append mode the next time it is needed.
+//
+// Writes to the files are buffered. The buffers are written when the files are
+// closed and each time Flush is called. The records written to HTTP endpoints
+// are sent by batches instead and do not count in the limit.
+type fileCache struct {
+	limit     int
+	files     map[string]*list.Element
+	queue     *list.List
+	endpoints map[string]*endpoint
+	post      postPolicy
+	seen      map[string]struct{}
+	outputs   map[string]*OutputStat
+	opens     int
+}
+
+func newFileCache(limit int, post postPolicy) *fileCache {
+	return &fileCache{
+		limit:   limit,
+		files:   make(map[string]*list.Element),
+		queue:   list.New(),
+		post:    post,
+		seen:    make(map[string]struct{}),
+		outputs: make(map[string]*OutputStat),
+	}
+}
+
+type cachedFile struct {
+	*bufio.Writer
+	comp compressor
+	file *os.File
+	out  *OutputStat
+}
+
+// Write writes one record: print, echo and copy write each record with one
+// call.
+func (f *cachedFile) Write(b []byte) (int, error) {
+	n, err := f.Writer.Write(b)
+	f.out.Records++
+	f.out.Bytes += int64(n)
+	return n, err
+}
+
+func (f *cachedFile) Flush() error {
+	err := f.Writer.Flush()
+	if err == nil && f.comp != nil {
+		err = f.comp.Flush()
+	}
+	return err
+}
+
+// Close writes the buffered data then closes the compressor, which writes the
+// end of the compressed stream, before closing the file.
+func (f *cachedFile) Close() error {
+	err := f.Writer.Flush()
+	if f.comp != nil {
+		if e := f.comp.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := f.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Open opens file for writing. When compress is set, the data written is
+// compressed. A compressed file opened in append mode gets a new compressed
+// stream after the existing ones: gzip and zstd readers decompress all of them.
+func (c *fileCache) Open(file string, append bool, compress string) (io.Writer, bool, error) {
+	if e, ok := c.files[file]; ok {
+		c.queue.MoveToFront(e)
+		return e.Value.(*cachedFile), false, nil
+	}
+	if _, ok := c.seen[file]; ok {
+		append = true
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, false, err
+	}
+	flag := os.O_CREATE | os.O_WRONLY
+	if append {
+		flag |= os.O_APPEND
+	} else {
+		flag |= os.O_TRUNC
+	}
+	w, err := os.OpenFile(file, flag, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	i, err := w.Stat()
+	if err != nil {
+		w.Close()
+		return nil, false, err
+	}
+	for c.limit > 0 && c.queue.Len() >= c.limit {
+		if err := c.evict(); err != nil {
+			w.Close()
+			return nil, false, err
+		}
+	}
+	c.opens++
+	out, ok := c.outputs[file]
+	if !ok {
+		out = new(OutputStat)
+		c.outputs[file] = out
+	}
+	f := &cachedFile{
+		Writer: bufio.NewWriter(w),
+		file:   w,
+		out:    out,
+	}
+	if compress != "" {
+		if f.comp, err = newCompressor(compress, w); err != nil {
+			w.Close()
+			return nil, false, err
+		}
+		f.Writer = bufio.NewWriter(f.comp)
+	}
+	c.files[file] = c.queue.PushFront(f)
+	c.seen[file] = struct{}{}
+	return f, i.Size() == 0, nil
+}
+
+func (c *fileCache) Release(file string) error {
+	e, ok := c.files[file]
+	if !ok {
+		return nil
+	}
+	delete(c.files, file)
+	c.queue.Remove(e)
+	return e.Value.(*cachedFile).Close()
+}
+
+// Flush writes the buffers of the files. Only the batches of the endpoints
+// waiting for too long are sent: the other ones are sent once full.
+func (c *fileCache) Flush() error {
+	var err error
+	for e := c.queue.Front(); e != nil; e = e.Next() {
+		if e := e.Value.(*cachedFile).Flush(); e != nil {
+			err = e
+		}
+	}
+	for _, p := range c.endpoints {
+		if !p.isStale() {
+			continue
+		}
+		if e := p.Flush(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (c *fileCache) Close() error {
+	var err error
+	for n := range c.files {
+		if e := c.Release(n); e != nil {
+			err = e
+		}
+	}
+	for n, p := range c.endpoints {
+		if e := p.Flush(); e != nil {
+			err = e
+		}
+		delete(c.endpoints, n)
+	}
+	return err
+}
+
+func (c *fileCache) evict() error {
+	e := c.queue.Back()
+	if e == nil {
+		return nil
+	}
+	return c.Release(e.Value.(*cachedFile).file.Name())
+}
+
+// expandPath replaces the %(name) placeholders found in str by the raw value
+// of the decoded field name (or of the internal value when name starts with $).
+// The values are sanitized so that they can not add directories to the path.
+func expandPath(root *state, str string) (string, error) {
+	var (
+		buf    strings.Builder
+		offset int
+	)
+	for {
+		i := strings.Index(str[offset:], "%(")
+		if i < 0 {
+			break
+		}
+		buf.WriteString(str[offset : offset+i])
+		offset += i + 2
+		j := strings.IndexByte(str[offset:], rparen)
+		if j < 0 {
+			return "", fmt.Errorf("%s: placeholder not closed", str)
+		}
+		var (
+			name = str[offset : offset+j]
+			f    Field
+			err  error
+		)
+		if strings.HasPrefix(name, "$") {
+			f, err = root.ResolveInternal(name[1:])
+		} else {
+			f, err = root.ResolveValue(name)
+		}
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(sanitizeName(asString(f.Raw())))
+		offset += j + 1
+	}
+	buf.WriteString(str[offset:])
+	return buf.String(), nil
+}
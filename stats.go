@@ -0,0 +1,374 @@
+package dissect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxAnomalies is the number of anomalies kept in the statistics. The ones
+// found after are only counted.
+const MaxAnomalies = 1000
+
+type Anomaly struct {
+	File   string `json:"file"`
+	Packet int    `json:"packet"`
+	Offset int    `json:"offset"`
+	Err    error  `json:"-"`
+}
+
+func (a Anomaly) MarshalJSON() ([]byte, error) {
+	type anomaly Anomaly
+	v := struct {
+		anomaly
+		Err string `json:"error"`
+	}{
+		anomaly: anomaly(a),
+	}
+	if a.Err != nil {
+		v.Err = a.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%s: packet #%d (offset %d): %s", a.File, a.Packet, a.Offset, a.Err)
+}
+
+type FileResult struct {
+	File    string
+	Packets int
+	Err     error
+}
+
+func (f FileResult) String() string {
+	if f.Err != nil {
+		return fmt.Sprintf("%s: %d packets: %s", f.File, f.Packets, f.Err)
+	}
+	return fmt.Sprintf("%s: %d packets", f.File, f.Packets)
+}
+
+// FilesError is returned by RunFiles when some of its files failed while the
+// interpreter was told to continue with the remaining files.
+type FilesError []FileResult
+
+func (e FilesError) Error() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d file(s) failed", len(e))
+	for _, f := range e {
+		buf.WriteString("\n")
+		buf.WriteString(f.String())
+	}
+	return buf.String()
+}
+
+func (e FilesError) Unwrap() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e[0].Err
+}
+
+const (
+	failExpect   = "expect"
+	failEnum     = "enum"
+	failParity   = "parity"
+	failChecksum = "checksum"
+)
+
+// Failure counts how many times a field failed one of the validations done
+// while decoding: expectation, value not found in an enum, parity or checksum,
+// or one of the thresholds of its limits.
+type Failure struct {
+	Field string `json:"field"`
+	Kind  string `json:"kind"`
+	Count int    `json:"count"`
+}
+
+type Stats struct {
+	Files   int `json:"files"`
+	Packets int `json:"packets"`
+	Bytes   int `json:"bytes"`
+	Fields  int `json:"fields"`
+
+	Short    int `json:"short"`
+	Expect   int `json:"expect"`
+	Checksum int `json:"checksum"`
+	Gaps     int `json:"gaps"`
+	Dups     int `json:"duplicates"`
+
+	Parity      int `json:"parity"`
+	Corrected   int `json:"corrected"`
+	Uncorrected int `json:"uncorrected"`
+
+	// Yellow and Red count the values of the fields out of the thresholds
+	// of the limits blocks.
+	Yellow int `json:"yellow"`
+	Red    int `json:"red"`
+
+	// BufferPeak is the largest size, in bytes, of the buffer holding the
+	// input being decoded and Opens the number of times output files were
+	// opened, including the files reopened after having been rotated or
+	// closed to stay below the maximum number of open files.
+	BufferPeak int `json:"buffer_peak"`
+	Opens      int `json:"opens"`
+
+	Failures []Failure `json:"failures"`
+	Profile  []Timing  `json:"profile,omitempty"`
+	// Anomalies are the packets that could not be decoded, at most
+	// MaxAnomalies of them.
+	Anomalies []Anomaly `json:"anomalies"`
+	// Omitted counts the anomalies found once MaxAnomalies were kept.
+	Omitted int                   `json:"omitted_anomalies"`
+	Outputs map[string]OutputStat `json:"outputs,omitempty"`
+}
+
+// OutputStat counts the records and the bytes, before compression, written to
+// an output file. Outputs are only updated when the files are closed at the
+// end of a run.
+type OutputStat struct {
+	Records int   `json:"records"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (s Stats) clone() Stats {
+	s.Failures = append([]Failure(nil), s.Failures...)
+	s.Profile = append([]Timing(nil), s.Profile...)
+	s.Anomalies = append([]Anomaly(nil), s.Anomalies...)
+	outputs := s.Outputs
+	s.Outputs = make(map[string]OutputStat, len(outputs))
+	for f, o := range outputs {
+		s.Outputs[f] = o
+	}
+	return s
+}
+
+// Add adds the statistics of other to s, eg to report the statistics of several
+// interpreters running at the same time. The largest buffer peak is kept.
+func (s *Stats) Add(other Stats) {
+	s.Files += other.Files
+	s.Packets += other.Packets
+	s.Bytes += other.Bytes
+	s.Fields += other.Fields
+	s.Short += other.Short
+	s.Expect += other.Expect
+	s.Checksum += other.Checksum
+	s.Gaps += other.Gaps
+	s.Dups += other.Dups
+	s.Parity += other.Parity
+	s.Corrected += other.Corrected
+	s.Uncorrected += other.Uncorrected
+	s.Yellow += other.Yellow
+	s.Red += other.Red
+	s.Opens += other.Opens
+	if other.BufferPeak > s.BufferPeak {
+		s.BufferPeak = other.BufferPeak
+	}
+	s.addFailures(other.Failures)
+	s.addTimings(other.Profile)
+	for _, a := range other.Anomalies {
+		if len(s.Anomalies) >= MaxAnomalies {
+			s.Omitted++
+			continue
+		}
+		s.Anomalies = append(s.Anomalies, a)
+	}
+	s.Omitted += other.Omitted
+	for f, o := range other.Outputs {
+		if s.Outputs == nil {
+			s.Outputs = make(map[string]OutputStat)
+		}
+		x := s.Outputs[f]
+		x.Records += o.Records
+		x.Bytes += o.Bytes
+		s.Outputs[f] = x
+	}
+}
+
+// addOutputs adds what was written in each output file.
+func (s *Stats) addOutputs(outputs map[string]*OutputStat) {
+	if s.Outputs == nil {
+		s.Outputs = make(map[string]OutputStat)
+	}
+	for f, o := range outputs {
+		x := s.Outputs[f]
+		x.Records += o.Records
+		x.Bytes += o.Bytes
+		s.Outputs[f] = x
+	}
+}
+
+// account updates the resources used by root.
+func (s *Stats) account(root *state) {
+	if root.peak > s.BufferPeak {
+		s.BufferPeak = root.peak
+	}
+	s.Opens += root.files.opens
+	root.files.opens = 0
+}
+
+func (s Stats) Report(w io.Writer) error {
+	return s.report(w, false)
+}
+
+// HumanReport writes the same report as Report but with the sizes written in
+// human units, eg 1.5MiB.
+func (s Stats) HumanReport(w io.Writer) error {
+	return s.report(w, true)
+}
+
+func (s Stats) report(w io.Writer, human bool) error {
+	lines := []struct {
+		Label string
+		Value int
+	}{
+		{Label: "files", Value: s.Files},
+		{Label: "packets", Value: s.Packets},
+		{Label: "bytes", Value: s.Bytes},
+		{Label: "fields", Value: s.Fields},
+		{Label: "short buffers", Value: s.Short},
+		{Label: "expectations", Value: s.Expect},
+		{Label: "checksums", Value: s.Checksum},
+		{Label: "coverage gaps", Value: s.Gaps},
+		{Label: "duplicates", Value: s.Dups},
+		{Label: "parity errors", Value: s.Parity},
+		{Label: "corrected", Value: s.Corrected},
+		{Label: "uncorrected", Value: s.Uncorrected},
+		{Label: "yellow limits", Value: s.Yellow},
+		{Label: "red limits", Value: s.Red},
+		{Label: "anomalies", Value: len(s.Anomalies) + s.Omitted},
+		{Label: "buffer peak", Value: s.BufferPeak},
+		{Label: "file opens", Value: s.Opens},
+	}
+	for _, i := range lines {
+		value := strconv.Itoa(i.Value)
+		if human && (i.Label == "bytes" || i.Label == "buffer peak") {
+			value = humanBytes(float64(i.Value))
+		}
+		if _, err := fmt.Fprintf(w, "%16s: %s\n", i.Label, value); err != nil {
+			return err
+		}
+	}
+	if len(s.Failures) > 0 {
+		cs := append([]Failure(nil), s.Failures...)
+		sort.SliceStable(cs, func(i, j int) bool {
+			return cs[i].Count > cs[j].Count
+		})
+		fmt.Fprintf(w, "\n%-32s %-8s %s\n", "field", "check", "failures")
+		for _, c := range cs {
+			if _, err := fmt.Fprintf(w, "%-32s %-8s %d\n", c.Field, c.Kind, c.Count); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	if len(s.Outputs) > 0 {
+		if err := reportOutputs(w, s.Outputs, human); err != nil {
+			return err
+		}
+	}
+	if len(s.Profile) > 0 {
+		if err := reportTimings(w, s.Profile); err != nil {
+			return err
+		}
+	}
+	for _, a := range s.Anomalies {
+		if _, err := fmt.Fprintln(w, a); err != nil {
+			return err
+		}
+	}
+	if s.Omitted > 0 {
+		if _, err := fmt.Fprintf(w, "... %d more anomalies\n", s.Omitted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func reportOutputs(w io.Writer, outputs map[string]OutputStat, human bool) error {
+	files := make([]string, 0, len(outputs))
+	for f := range outputs {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	fmt.Fprintf(w, "\n%-48s %-8s %s\n", "output", "records", "bytes")
+	for _, f := range files {
+		o := outputs[f]
+		size := strconv.FormatInt(o.Bytes, 10)
+		if human {
+			size = humanBytes(float64(o.Bytes))
+		}
+		if _, err := fmt.Fprintf(w, "%-48s %-8d %s\n", f, o.Records, size); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func (s *Stats) update(root *state) {
+	var covered int
+	for _, f := range root.Fields {
+		covered += f.Len
+	}
+	s.Packets++
+	s.Fields += len(root.Fields)
+	s.Bytes += root.Pos / numbit
+	if covered < root.Pos {
+		s.Gaps++
+	}
+	if root.drop {
+		s.Dups++
+	}
+	s.Parity += root.parity
+	s.Corrected += root.corrected
+	s.Uncorrected += root.uncorrected
+	s.Yellow += root.yellows
+	s.Red += root.reds
+	s.addFailures(root.failures)
+	s.addTimings(root.timings)
+}
+
+func (s *Stats) addFailures(cs []Failure) {
+	for _, c := range cs {
+		i := sort.Search(len(s.Failures), func(i int) bool {
+			x := s.Failures[i]
+			return x.Field > c.Field || (x.Field == c.Field && x.Kind >= c.Kind)
+		})
+		if i < len(s.Failures) && s.Failures[i].Field == c.Field && s.Failures[i].Kind == c.Kind {
+			s.Failures[i].Count += c.Count
+			continue
+		}
+		s.Failures = append(s.Failures, Failure{})
+		copy(s.Failures[i+1:], s.Failures[i:])
+		s.Failures[i] = c
+	}
+}
+
+func (s *Stats) record(root *state, err error) {
+	switch {
+	case errors.Is(err, ErrShort):
+		s.Short++
+	case errors.Is(err, ErrExpect):
+		s.Expect++
+	case errors.Is(err, ErrChecksum):
+		s.Checksum++
+	}
+	if len(s.Anomalies) < MaxAnomalies {
+		a := Anomaly{
+			File:   root.currentFile,
+			Packet: root.Loop,
+			Offset: root.Pos,
+			Err:    err,
+		}
+		s.Anomalies = append(s.Anomalies, a)
+	} else {
+		s.Omitted++
+	}
+	s.addFailures(root.failures)
+	s.addTimings(root.timings)
+}
@@ -0,0 +1,51 @@
+package dissect
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// stdlib holds the schemas shipped with dissect. They are included with
+// include (<std/name>).
+//
+//go:embed std/*.lst
+var stdlib embed.FS
+
+const stdExt = ".lst"
+
+func isStd(file string) bool {
+	return strings.HasPrefix(file, "<") && strings.HasSuffix(file, ">")
+}
+
+func openStd(file string) (io.ReadCloser, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(file, "<"), ">")
+	buf, err := stdlib.ReadFile(name + stdExt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: schema not found in standard library", file)
+	}
+	return namedReader{Reader: bytes.NewReader(buf), name: file}, nil
+}
+
+// parseStdName reads the name of a schema of the standard library given
+// between angle brackets, eg <std/units>.
+func (p *Parser) parseStdName() (string, error) {
+	var str strings.Builder
+	str.WriteRune(langle)
+	for p.nextToken(); p.curr.Type != Greater; p.nextToken() {
+		switch {
+		case p.curr.Type == Div:
+			str.WriteRune(div)
+		case p.curr.Type == Min:
+			str.WriteRune(minus)
+		case p.curr.isIdent() || p.curr.Type == Keyword:
+			str.WriteString(p.curr.Literal)
+		default:
+			return "", p.expectedError(">")
+		}
+	}
+	str.WriteRune(rangle)
+	return str.String(), nil
+}
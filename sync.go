@@ -0,0 +1,71 @@
+package dissect
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const syncDecl = "sync"
+
+// parseMarker gives the bytes of a sync marker. The width of an hexadecimal
+// marker is given by its digits, eg 0x00FF is two bytes long.
+func parseMarker(str string) ([]byte, error) {
+	if digits := strings.TrimPrefix(strings.TrimPrefix(str, "0x"), "0X"); digits != str {
+		if len(digits)%2 == 1 {
+			digits = "0" + digits
+		}
+		return hex.DecodeString(digits)
+	}
+	n, err := strconv.ParseUint(str, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid marker", str)
+	}
+	var marker []byte
+	for marker = []byte{byte(n)}; n > 0xFF; {
+		n >>= numbit
+		marker = append([]byte{byte(n)}, marker...)
+	}
+	return marker, nil
+}
+
+// decodeSync moves to the next occurrence of the marker of n in the input. At
+// the start of a packet, the bytes before the marker are dropped so that the
+// packet starts with the marker. The decoding stops if no marker is found.
+func (root *state) decodeSync(n Sync) error {
+	if root.Pos%numbit != 0 {
+		return fmt.Errorf("sync: marker should be searched at offset 0")
+	}
+	from := root.Pos / numbit
+	for {
+		if i := bytes.Index(root.buffer[from:], n.marker); i >= 0 {
+			from += i
+			break
+		}
+		if root.eof {
+			return ErrDone
+		}
+		// the end of the buffer could be the start of the marker
+		if k := len(root.buffer) - len(n.marker) + 1; k > from {
+			from = k
+		}
+		if root.Pos == 0 {
+			root.buffer = root.buffer[from:]
+			root.offset += int64(from)
+			from = 0
+		}
+		if err := root.readBuffer(0); err != nil {
+			return err
+		}
+	}
+	if root.Pos == 0 {
+		root.buffer = root.buffer[from:]
+		root.offset += int64(from)
+		from = 0
+	}
+	root.Pos = from * numbit
+	root.marker = root.Pos
+	return nil
+}
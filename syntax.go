@@ -0,0 +1,338 @@
+package dissect
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	SyntaxTextMate = "textmate"
+	SyntaxVim      = "vim"
+)
+
+// words are the identifiers having a meaning in some statements only, eg the
+// methods of print or the options of a sink. Unlike keywords, they can still
+// be used as names.
+var words = []string{
+	pragmaVersion,
+	lengthDecl,
+	syncDecl,
+	blockLimit,
+	summaryEntropy,
+	summaryHistogram,
+	asn1Decl,
+	protobufDecl,
+	textDecl,
+	textJSON,
+	textNMEA,
+	textKV,
+	bitorderDecl,
+	bitMSB,
+	bitLSB,
+	expectDecl,
+	expectFail,
+	expectWarn,
+	expectSkip,
+	matchPrefix,
+	seekEnd,
+	markDecl,
+	restoreDecl,
+	limitsDecl,
+	onDecl,
+	onTransition,
+	onFrom,
+	onAbove,
+	onBelow,
+	limitYellow,
+	limitRed,
+	repeatWithin,
+	repeatStep,
+	repeatDecimate,
+	destField,
+	destConst,
+	methRaw,
+	methEng,
+	methBoth,
+	methDebug,
+	fmtCSV,
+	fmtTuple,
+	fmtSexp,
+	fmtJSON,
+	fmtInflux,
+	influxTag,
+	sinkFile,
+	sinkAppend,
+	sinkRotate,
+	sinkPartition,
+	sinkCompress,
+	partitionBy,
+	compressGzip,
+	compressZstd,
+	dedupeWindow,
+	attrParity,
+	parityOdd,
+	parityEven,
+	checkHamming,
+	checkSecDed,
+	checkCRC16,
+	checkCRC32,
+	checkSum,
+	checkXor,
+	transGray,
+	transBitRev,
+	transNibSwap,
+	signTwos,
+	signOnes,
+	signMag,
+	timeCUC,
+	timeCDS,
+	timeCoarse,
+	timeFine,
+	timeDays,
+	timeSubms,
+	timeEpoch,
+	epochTAI,
+}
+
+// Syntax writes the definition of the syntax of the language for editors:
+// either a TextMate grammar, in JSON, or a Vim syntax file. The definitions are
+// generated from the keywords, the operators and the builtins known by the
+// parser.
+func Syntax(w io.Writer, format string) error {
+	switch format {
+	case SyntaxTextMate:
+		return syntaxTextMate(w)
+	case SyntaxVim:
+		return syntaxVim(w)
+	default:
+		return fmt.Errorf("%s: unsupported syntax format", format)
+	}
+}
+
+// syntaxTable splits the words of the language in the groups highlighted
+// differently by editors.
+type syntaxTable struct {
+	keywords  []string
+	types     []string
+	words     []string
+	builtins  []string
+	operators []string
+}
+
+func newSyntaxTable() syntaxTable {
+	var t syntaxTable
+	for _, k := range keywords {
+		switch k {
+		case kwInt, kwUint, kwFloat, kwString, kwBytes, kwTime:
+			t.types = append(t.types, k)
+		default:
+			t.keywords = append(t.keywords, k)
+		}
+	}
+	seen := make(map[string]struct{})
+	for _, w := range words {
+		if _, ok := seen[w]; ok {
+			continue
+		}
+		seen[w] = struct{}{}
+		t.words = append(t.words, w)
+	}
+	sort.Strings(t.words)
+	for b := range builtins {
+		t.builtins = append(t.builtins, b)
+	}
+	sort.Strings(t.builtins)
+
+	ops := []string{
+		Token{Type: Not}.String(),
+		Token{Type: BitAnd}.String(),
+		Token{Type: BitOr}.String(),
+		"..",
+	}
+	for r := range bindings {
+		if r == Cond {
+			ops = append(ops, "?", ":")
+			continue
+		}
+		ops = append(ops, Token{Type: r}.String())
+	}
+	// longest operators first so that they are matched before their prefixes
+	sort.Slice(ops, func(i, j int) bool {
+		if len(ops[i]) != len(ops[j]) {
+			return len(ops[i]) > len(ops[j])
+		}
+		return ops[i] < ops[j]
+	})
+	t.operators = ops
+	return t
+}
+
+func wordsPattern(ws []string) string {
+	return `\b(` + strings.Join(ws, "|") + `)\b`
+}
+
+func syntaxTextMate(w io.Writer) error {
+	type rule struct {
+		Name    string `json:"name,omitempty"`
+		Match   string `json:"match,omitempty"`
+		Begin   string `json:"begin,omitempty"`
+		End     string `json:"end,omitempty"`
+		Include string `json:"include,omitempty"`
+	}
+	var (
+		t   = newSyntaxTable()
+		ops = make([]string, len(t.operators))
+	)
+	for i, o := range t.operators {
+		ops[i] = regexp.QuoteMeta(o)
+	}
+	repo := map[string]rule{
+		"comment": {
+			Name:  "comment.line.number-sign.dissect",
+			Match: `#.*$`,
+		},
+		"heredoc": {
+			Name:  "string.unquoted.heredoc.dissect",
+			Begin: `<<([A-Z][A-Z0-9_]*)\s*$`,
+			End:   `^\s*\1\b`,
+		},
+		"string": {
+			Name:  "string.quoted.double.dissect",
+			Begin: `"`,
+			End:   `"`,
+		},
+		"ident": {
+			Name:  "variable.other.quoted.dissect",
+			Match: "`[^`\\n]*`",
+		},
+		"number": {
+			Name:  "constant.numeric.dissect",
+			Match: `\b(0[xX][0-9a-fA-F]+|[0-9]+(\.[0-9]*)?([eE]-?[0-9]+)?)\b`,
+		},
+		"boolean": {
+			Name:  "constant.language.dissect",
+			Match: wordsPattern([]string{kwTrue, kwFalse}),
+		},
+		"builtin": {
+			Name:  "support.function.dissect",
+			Match: `\$` + wordsPattern(t.builtins) + `(?=\s*\()`,
+		},
+		"internal": {
+			Name:  "variable.language.dissect",
+			Match: `\$[A-Za-z_][A-Za-z0-9_]*`,
+		},
+		"keyword": {
+			Name:  "keyword.control.dissect",
+			Match: wordsPattern(t.keywords),
+		},
+		"type": {
+			Name:  "storage.type.dissect",
+			Match: wordsPattern(t.types),
+		},
+		"word": {
+			Name:  "keyword.other.dissect",
+			Match: wordsPattern(t.words),
+		},
+		"operator": {
+			Name:  "keyword.operator.dissect",
+			Match: strings.Join(ops, "|"),
+		},
+	}
+	// order matters: comments and strings hide what they contain and builtins
+	// are more specific than internals.
+	order := []string{
+		"comment",
+		"heredoc",
+		"string",
+		"ident",
+		"builtin",
+		"internal",
+		"number",
+		"boolean",
+		"type",
+		"keyword",
+		"word",
+		"operator",
+	}
+	var patterns []rule
+	for _, o := range order {
+		patterns = append(patterns, rule{Include: "#" + o})
+	}
+	grammar := struct {
+		Name       string          `json:"name"`
+		ScopeName  string          `json:"scopeName"`
+		FileTypes  []string        `json:"fileTypes"`
+		Patterns   []rule          `json:"patterns"`
+		Repository map[string]rule `json:"repository"`
+	}{
+		Name:       "dissect",
+		ScopeName:  "source.dissect",
+		FileTypes:  []string{"lst", "dsl"},
+		Patterns:   patterns,
+		Repository: repo,
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.SetEscapeHTML(false)
+	return e.Encode(grammar)
+}
+
+func syntaxVim(w io.Writer) error {
+	var (
+		t   = newSyntaxTable()
+		ws  = bufio.NewWriter(w)
+		ops = make([]string, len(t.operators))
+	)
+	for i, o := range t.operators {
+		ops[i] = strings.ReplaceAll(o, `\`, `\\`)
+	}
+	fmt.Fprintln(ws, `" Vim syntax file`)
+	fmt.Fprintln(ws, `" Language: dissect`)
+	fmt.Fprintln(ws, `" Generated with: dissect syntax -f vim`)
+	fmt.Fprintln(ws, `if exists("b:current_syntax")`)
+	fmt.Fprintln(ws, `  finish`)
+	fmt.Fprintln(ws, `endif`)
+	fmt.Fprintln(ws)
+	fmt.Fprintf(ws, "syn keyword dissectKeyword %s\n", strings.Join(t.keywords, " "))
+	fmt.Fprintf(ws, "syn keyword dissectType %s\n", strings.Join(t.types, " "))
+	fmt.Fprintf(ws, "syn keyword dissectWord %s\n", strings.Join(t.words, " "))
+	fmt.Fprintf(ws, "syn keyword dissectBoolean %s %s\n", kwTrue, kwFalse)
+	fmt.Fprintf(ws, "syn match dissectOperator \"\\V%s\"\n", strings.Join(ops, `\|`))
+	fmt.Fprintln(ws, `syn match dissectNumber "\<0[xX]\x\+\>"`)
+	fmt.Fprintln(ws, `syn match dissectNumber "\<\d\+\(\.\d*\)\=\([eE]-\=\d\+\)\=\>"`)
+	fmt.Fprintln(ws, `syn match dissectInternal "\$\h\w*"`)
+	fmt.Fprintf(ws, "syn match dissectBuiltin \"\\$\\(%s\\)\\ze\\s*(\"\n", strings.Join(t.builtins, `\|`))
+	fmt.Fprintln(ws, "syn match dissectQuoted \"`[^`]*`\"")
+	fmt.Fprintln(ws, `syn region dissectString start=+"+ end=+"+`)
+	fmt.Fprintln(ws, `syn region dissectHeredoc start=+<<\z([A-Z][A-Z0-9_]*\)\s*$+ end=+^\s*\z1\>+`)
+	fmt.Fprintln(ws, `syn match dissectComment "#.*$"`)
+	fmt.Fprintln(ws)
+	links := []struct {
+		Group string
+		Link  string
+	}{
+		{Group: "dissectKeyword", Link: "Keyword"},
+		{Group: "dissectType", Link: "Type"},
+		{Group: "dissectWord", Link: "Special"},
+		{Group: "dissectBoolean", Link: "Boolean"},
+		{Group: "dissectOperator", Link: "Operator"},
+		{Group: "dissectNumber", Link: "Number"},
+		{Group: "dissectInternal", Link: "Identifier"},
+		{Group: "dissectBuiltin", Link: "Function"},
+		{Group: "dissectQuoted", Link: "Identifier"},
+		{Group: "dissectString", Link: "String"},
+		{Group: "dissectHeredoc", Link: "String"},
+		{Group: "dissectComment", Link: "Comment"},
+	}
+	for _, k := range links {
+		fmt.Fprintf(ws, "hi def link %s %s\n", k.Group, k.Link)
+	}
+	fmt.Fprintln(ws)
+	fmt.Fprintln(ws, `let b:current_syntax = "dissect"`)
+	return ws.Flush()
+}
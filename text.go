@@ -0,0 +1,282 @@
+package dissect
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// textSep joins the keys and the indices naming the nested values so that
+	// the names are valid identifiers
+	textSep = "_"
+
+	textDecl = "text"
+	textJSON = "json"
+	textNMEA = "nmea"
+	textKV   = "kv"
+)
+
+func isTextFormat(str string) bool {
+	return str == textJSON || str == textNMEA || str == textKV
+}
+
+// decodeText parses the text made of the bytes following the current position,
+// their number given by the expression of n, and gives a field for each of its
+// values. The NUL bytes and the spaces padding the text are ignored.
+//
+// With json, the fields are named after the keys leading to the values, the
+// elements of arrays after their index, eg pos_lat or sats_0_prn. With nmea,
+// each sentence gives a field for each of its fields, as strings, named after
+// the sentence and the index of the field, eg GPGGA_2. The checksum of the
+// sentences, when given, is checked. With kv, the fields are the key=value
+// pairs separated by spaces, commas, semicolons or ampersands.
+//
+// Numbers of json and kv are given as integers or reals, the other values as
+// strings.
+func (root *state) decodeText(n Extract) error {
+	buf, err := root.region(textDecl, n.size)
+	if err != nil {
+		return err
+	}
+	var (
+		text = textParser{pos: root.Pos}
+		data = bytes.TrimRight(buf, "\x00 \t\r\n")
+	)
+	switch n.format.Literal {
+	case textJSON:
+		err = text.parseJSON(data)
+	case textNMEA:
+		err = text.parseNMEA(data, root)
+	case textKV:
+		text.parseKV(data)
+	default:
+		err = fmt.Errorf("%s: unknown format", n.format.Literal)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", textDecl, n.format.Literal, err)
+	}
+	for i := range text.fields {
+		text.fields[i].Block, text.fields[i].Ix = root.currentBlock(), root.Iter
+	}
+	root.Fields = append(root.Fields, text.fields...)
+	root.Pos += len(buf) * numbit
+	return nil
+}
+
+type textParser struct {
+	pos    int
+	fields []Field
+}
+
+// append adds the value in buf[start:end] as the field name.
+func (t *textParser) append(name string, start, end int, val Value, kind Kind) {
+	t.fields = append(t.fields, Field{
+		Id:   name,
+		Pos:  t.pos + start*numbit,
+		Len:  (end - start) * numbit,
+		raw:  val,
+		kind: kind,
+	})
+}
+
+type jsonFrame struct {
+	object  bool
+	key     string
+	index   int
+	wantKey bool
+}
+
+func (t *textParser) parseJSON(buf []byte) error {
+	var (
+		dec   = json.NewDecoder(bytes.NewReader(buf))
+		stack []jsonFrame
+	)
+	dec.UseNumber()
+	next := func() {
+		if n := len(stack); n > 0 {
+			if stack[n-1].object {
+				stack[n-1].wantKey = true
+			} else {
+				stack[n-1].index++
+			}
+		}
+	}
+	name := func() string {
+		if len(stack) == 0 {
+			return "value"
+		}
+		parts := make([]string, len(stack))
+		for i, f := range stack {
+			parts[i] = f.key
+			if !f.object {
+				parts[i] = strconv.Itoa(f.index)
+			}
+		}
+		return strings.Join(parts, textSep)
+	}
+	for {
+		start := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// the offset of the token is the one of its first byte after the
+		// separators of the previous one.
+		for start < len(buf) && strings.IndexByte(" \t\r\n:,", buf[start]) >= 0 {
+			start++
+		}
+		end := int(dec.InputOffset())
+		if n := len(stack); n > 0 && stack[n-1].object && stack[n-1].wantKey {
+			if d, ok := tok.(json.Delim); ok && d == '}' {
+				stack = stack[:n-1]
+				next()
+				continue
+			}
+			stack[n-1].key, stack[n-1].wantKey = tok.(string), false
+			continue
+		}
+		switch tok := tok.(type) {
+		case json.Delim:
+			switch tok {
+			case '{':
+				stack = append(stack, jsonFrame{object: true, wantKey: true})
+			case '[':
+				stack = append(stack, jsonFrame{})
+			default:
+				stack = stack[:len(stack)-1]
+				next()
+			}
+			continue
+		case json.Number:
+			if i, err := tok.Int64(); err == nil {
+				t.append(name(), start, end, &Int{Raw: i}, kindInt)
+			} else if f, err := tok.Float64(); err == nil {
+				t.append(name(), start, end, &Real{Raw: f}, kindFloat)
+			} else {
+				t.append(name(), start, end, &String{Raw: tok.String()}, kindString)
+			}
+		case string:
+			t.append(name(), start, end, &String{Raw: tok}, kindString)
+		case bool:
+			t.append(name(), start, end, &Boolean{Raw: tok}, kindNull)
+		case nil:
+			t.append(name(), start, end, &Null{}, kindNull)
+		}
+		next()
+	}
+	return nil
+}
+
+// parseNMEA splits the sentences of buf, one per line. A sentence starts with $
+// or ! and ends with the checksum of its bytes after the first character, in
+// hexadecimal after a *, if any.
+func (t *textParser) parseNMEA(buf []byte, root *state) error {
+	var offset int
+	for _, line := range bytes.SplitAfter(buf, []byte("\n")) {
+		start := offset
+		offset += len(line)
+		line = bytes.TrimRight(line, "\r\n")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if line[0] != '$' && line[0] != '!' {
+			return fmt.Errorf("byte %d: sentence should start with $ or !", start)
+		}
+		body := line[1:]
+		if i := bytes.LastIndexByte(body, '*'); i >= 0 {
+			sum, err := strconv.ParseUint(string(body[i+1:]), 16, 8)
+			if err != nil {
+				return fmt.Errorf("byte %d: invalid checksum %q", start, body[i+1:])
+			}
+			body = body[:i]
+			var got byte
+			for _, b := range body {
+				got ^= b
+			}
+			if want := byte(sum); got != want {
+				id, _, _ := strings.Cut(string(body), ",")
+				root.fail(id, failChecksum)
+				return fmt.Errorf("%s %w: want %#02x, got %#02x", id, ErrChecksum, want, got)
+			}
+		}
+		var (
+			parts = bytes.Split(body, []byte(","))
+			pos   = start + 1 + len(parts[0]) + 1
+			id    = string(parts[0])
+		)
+		for i, p := range parts[1:] {
+			t.append(id+textSep+strconv.Itoa(i+1), pos, pos+len(p), &String{Raw: string(p)}, kindString)
+			pos += len(p) + 1
+		}
+	}
+	return nil
+}
+
+// parseKV gives the key=value pairs of buf. Values can be quoted with double
+// quotes to contain separators. The words without = are ignored.
+func (t *textParser) parseKV(buf []byte) {
+	isSep := func(b byte) bool {
+		return b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == ',' || b == ';' || b == '&'
+	}
+	for i := 0; i < len(buf); {
+		if isSep(buf[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(buf) && !isSep(buf[i]) && buf[i] != '=' {
+			i++
+		}
+		if i >= len(buf) || buf[i] != '=' {
+			continue
+		}
+		key := string(buf[start:i])
+		i++
+		vstart := i
+		var val string
+		if i < len(buf) && buf[i] == '"' {
+			end := bytes.IndexByte(buf[i+1:], '"')
+			if end < 0 {
+				end = len(buf) - i - 1
+			}
+			val = string(buf[i+1 : i+1+end])
+			i += end + 2
+			if i > len(buf) {
+				i = len(buf)
+			}
+		} else {
+			for i < len(buf) && !isSep(buf[i]) {
+				i++
+			}
+			val = string(buf[vstart:i])
+		}
+		if key == "" {
+			continue
+		}
+		v, kind := textValue(val)
+		t.append(key, vstart, i, v, kind)
+	}
+}
+
+// textValue gives str as an integer or a real when it is a number and as a
+// string otherwise.
+func textValue(str string) (Value, Kind) {
+	if str == "" || strings.IndexByte("+-.0123456789", str[0]) < 0 {
+		return &String{Raw: str}, kindString
+	}
+	if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return &Int{Raw: i}, kindInt
+	}
+	if f, err := strconv.ParseFloat(str, 64); err == nil {
+		return &Real{Raw: f}, kindFloat
+	}
+	return &String{Raw: str}, kindString
+}
@@ -0,0 +1,73 @@
+package dissect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	nmea := func(body string) string {
+		var sum byte
+		for i := 0; i < len(body); i++ {
+			sum ^= body[i]
+		}
+		return fmt.Sprintf("$%s*%02X\r\n", body, sum)
+	}
+	tests := []struct {
+		Name   string
+		Format string
+		Text   string
+		Print  string
+		Want   string
+		Err    error
+	}{
+		{
+			Name:   "json",
+			Format: "json",
+			Text:   `{"pos": {"lat": 1.5, "lon": -2}, "sats": [{"prn": 7}, {"prn": 12}], "ok": true}`,
+			Print:  "let lat = pos_lat * 2\n  print raw as csv with pos_lon sats_1_prn ok lat",
+			Want:   csvRecords("pos_lon,sats_1_prn,ok,lat", "-2,12,true,3"),
+		},
+		{
+			Name:   "nmea",
+			Format: "nmea",
+			Text:   nmea("GPGGA,123519,4807.038,N") + nmea("GPVTG,054.7,T"),
+			Print:  "print raw as csv with GPGGA_1 GPGGA_3 GPVTG_2",
+			Want:   csvRecords("GPGGA_1,GPGGA_3,GPVTG_2", "123519,N,T"),
+		},
+		{
+			Name:   "nmea-checksum",
+			Format: "nmea",
+			Text:   "$GPGGA,123519*00\r\n",
+			Print:  "print raw",
+			Err:    ErrChecksum,
+		},
+		{
+			Name:   "kv",
+			Format: "kv",
+			Text:   `mode=on; temp=21.5 label="a b"&count=3` + "\x00\x00",
+			Print:  "print raw as csv with mode temp label count",
+			Want:   csvRecords("mode,temp,label,count", "on,21.5,a b,3"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			script := fmt.Sprintf("data (\n  size: uint 8\n  text [size] as %s\n  %s\n)\n", tt.Format, tt.Print)
+			data := append([]byte{byte(len(tt.Text))}, tt.Text...)
+			if tt.Err != nil {
+				var buf bytes.Buffer
+				err := DissectToWriter(strings.NewReader(script), bytes.NewReader(data), &buf)
+				if !errors.Is(err, tt.Err) {
+					t.Fatalf("want error %v, got %v", tt.Err, err)
+				}
+				return
+			}
+			if got := dissectString(t, script, data); got != tt.Want {
+				t.Errorf("output mismatched:\nwant: %q\ngot:  %q", tt.Want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package dissect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var ErrTarget = errors.New("unmarshal target must be a non nil pointer to a struct or to a slice of structs")
+
+const (
+	tagName = "dissect"
+	tagEng  = "eng"
+)
+
+var (
+	fieldType = reflect.TypeOf(Field{})
+	valueType = reflect.TypeOf((*Value)(nil)).Elem()
+	timeType  = reflect.TypeOf(time.Time{})
+	bigType   = reflect.TypeOf((*big.Int)(nil))
+)
+
+// Unmarshal decodes data with script and stores the fields of the packets in v.
+// v is a pointer to a struct, filled with the first packet (the following ones
+// are not decoded), or a pointer to a slice of structs to which one element is
+// appended for each packet.
+//
+// The fields of the struct are matched with the id of the decoded fields given
+// by their dissect tag or, without tag, by their name regardless of the case,
+// eg Temp matches the field temp. A field tagged with "-" is ignored. The raw
+// value is used unless the tag has the eng option, eg `dissect:"temp,eng"`. A
+// struct field of slice type (other than []byte) receives all the values of the
+// fields having the same id, eg the fields decoded in a repeat, or the elements
+// of an array field.
+//
+// Values are converted to the type of the struct field: integers, floats,
+// booleans, strings, []byte, time.Time, *big.Int, Value and Field are
+// supported. The output of the print, echo and copy statements of the script is
+// discarded.
+func Unmarshal(script io.Reader, data []byte, v interface{}, opts ...Option) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return ErrTarget
+	}
+	target = target.Elem()
+
+	var (
+		many = target.Kind() == reflect.Slice
+		elem = target.Type()
+		done bool
+		err  error
+	)
+	if many {
+		elem = elem.Elem()
+	}
+	if structType(elem) == nil {
+		return ErrTarget
+	}
+	hook := func(p PacketInfo) {
+		if p.Err != nil || err != nil || done {
+			return
+		}
+		if !many {
+			err, done = unmarshalFields(p.Fields, target), true
+			return
+		}
+		x := reflect.New(elem).Elem()
+		if err = unmarshalFields(p.Fields, x); err == nil {
+			target.Set(reflect.Append(target, x))
+		}
+	}
+	opts = append(opts, WithPacketHook(hook))
+	i, err := New(script, opts...)
+	if err != nil {
+		return err
+	}
+	i.capture = ioutil.Discard
+	// a struct is filled with the first packet: the next ones, possibly cut
+	// by the end of data, are not decoded
+	i.once = !many
+	if e := i.Run(bytes.NewReader(data)); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+func unmarshalFields(fields []Field, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name, eng, tagged := parseTag(sf)
+		if name == "-" {
+			continue
+		}
+		f := v.Field(i)
+		for _, x := range fields {
+			if x.Id != name && (tagged || !strings.EqualFold(x.Id, name)) {
+				continue
+			}
+			if err := unmarshalField(x, eng, f); err != nil {
+				return fmt.Errorf("%s: %w", x.Id, err)
+			}
+			if !isSliceTarget(f.Type()) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseTag gives the id of the decoded fields stored in sf, if it uses the eng
+// value and whether the id is given by the tag.
+func parseTag(sf reflect.StructField) (string, bool, bool) {
+	tag, ok := sf.Tag.Lookup(tagName)
+	if !ok {
+		return sf.Name, false, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = sf.Name
+	}
+	var eng bool
+	for _, p := range parts[1:] {
+		if p == tagEng {
+			eng = true
+		}
+	}
+	return name, eng, parts[0] != ""
+}
+
+func isSliceTarget(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func unmarshalField(f Field, eng bool, v reflect.Value) error {
+	val := f.Raw()
+	if eng {
+		val = f.Eng()
+	}
+	if isSliceTarget(v.Type()) {
+		if arr, ok := val.(*Array); ok && v.Type().Elem() != fieldType {
+			for _, val := range arr.Raw {
+				x := reflect.New(v.Type().Elem()).Elem()
+				if err := unmarshalValue(val, x); err != nil {
+					return err
+				}
+				v.Set(reflect.Append(v, x))
+			}
+			return nil
+		}
+		x := reflect.New(v.Type().Elem()).Elem()
+		if err := unmarshalField(f, eng, x); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, x))
+		return nil
+	}
+	if v.Type() == fieldType {
+		v.Set(reflect.ValueOf(f))
+		return nil
+	}
+	return unmarshalValue(val, v)
+}
+
+func unmarshalValue(val Value, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr && v.Type() != bigType {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshalValue(val, v.Elem())
+	}
+	switch t := v.Type(); {
+	case t == valueType:
+		v.Set(reflect.ValueOf(val))
+		return nil
+	case t == timeType:
+		x, ok := val.(*Time)
+		if !ok {
+			return incompatibleTarget(val, t)
+		}
+		v.Set(reflect.ValueOf(x.Raw))
+		return nil
+	case t == bigType:
+		if !isInteger(val) {
+			if _, ok := val.(*BigInt); !ok {
+				return incompatibleTarget(val, t)
+			}
+		}
+		v.Set(reflect.ValueOf(new(big.Int).Set(asBig(val))))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !isNumber(val) {
+			return incompatibleTarget(val, v.Type())
+		}
+		if x, ok := val.(*Uint); ok && int64(x.Raw) < 0 {
+			return fmt.Errorf("%d overflows %s", x.Raw, v.Type())
+		}
+		n := asInt(val)
+		if v.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !isNumber(val) {
+			return incompatibleTarget(val, v.Type())
+		}
+		if asReal(val) < 0 {
+			return fmt.Errorf("%s: negative value for %s", asString(val), v.Type())
+		}
+		n := asUint(val)
+		if v.OverflowUint(n) {
+			return fmt.Errorf("%d overflows %s", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if !isNumber(val) {
+			return incompatibleTarget(val, v.Type())
+		}
+		v.SetFloat(asReal(val))
+	case reflect.Bool:
+		v.SetBool(asBool(val))
+	case reflect.String:
+		v.SetString(asString(val))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return incompatibleTarget(val, v.Type())
+		}
+		switch x := val.(type) {
+		case *Bytes:
+			v.SetBytes(append([]byte(nil), x.Raw...))
+		case *String:
+			v.SetBytes([]byte(x.Raw))
+		default:
+			return incompatibleTarget(val, v.Type())
+		}
+	default:
+		return incompatibleTarget(val, v.Type())
+	}
+	return nil
+}
+
+func incompatibleTarget(val Value, t reflect.Type) error {
+	return fmt.Errorf("%w: can not store %T in %s", ErrIncompatible, val, t)
+}
@@ -0,0 +1,149 @@
+package dissect
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	const script = `enum modes (
+  1 = "on"
+  2 = "off"
+)
+data (
+  id: uint 8
+  mode: uint 8, modes
+  repeat [2] (
+    temp: int 8
+  )
+  size: uint 16
+)
+`
+	type untagged struct {
+		Id   uint8
+		Mode string
+		Temp []int
+	}
+	type tagged struct {
+		Ident uint16  `dissect:"id"`
+		Mode  string  `dissect:",eng"`
+		Size  big.Int `dissect:"-"`
+		Other int     `dissect:"ID"`
+		Temp  []Value
+		Field Field    `dissect:"size"`
+		Big   *big.Int `dissect:"size"`
+	}
+	type overflow struct {
+		Size uint8
+	}
+	type incompatible struct {
+		Id []byte
+	}
+	packet := []byte{1, 2, 0xfe, 3, 1, 0}
+
+	tests := []struct {
+		Name   string
+		Data   []byte
+		Target interface{}
+		Want   interface{}
+		Err    error
+	}{
+		{
+			Name:   "untagged",
+			Data:   packet,
+			Target: new(untagged),
+			Want:   &untagged{Id: 1, Mode: "2", Temp: []int{-2, 3}},
+		},
+		{
+			// the packet cut after the first one is not decoded
+			Name:   "first",
+			Data:   append(append([]byte{}, packet...), 7, 1),
+			Target: new(untagged),
+			Want:   &untagged{Id: 1, Mode: "2", Temp: []int{-2, 3}},
+		},
+		{
+			Name:   "pointer",
+			Data:   packet,
+			Target: new(*untagged),
+			Want: func() **untagged {
+				p := &untagged{Id: 1, Mode: "2", Temp: []int{-2, 3}}
+				return &p
+			}(),
+		},
+		{
+			Name:   "slice",
+			Data:   append(append([]byte{}, packet...), 7, 1, 1, 2, 0, 0),
+			Target: new([]untagged),
+			Want: &[]untagged{
+				{Id: 1, Mode: "2", Temp: []int{-2, 3}},
+				{Id: 7, Mode: "1", Temp: []int{1, 2}},
+			},
+		},
+		{
+			Name:   "overflow",
+			Data:   packet,
+			Target: new(overflow),
+			Err:    errors.New("256 overflows uint8"),
+		},
+		{
+			Name:   "incompatible",
+			Data:   packet,
+			Target: new(incompatible),
+			Err:    ErrIncompatible,
+		},
+		{
+			Name:   "struct",
+			Data:   packet,
+			Target: untagged{},
+			Err:    ErrTarget,
+		},
+		{
+			Name:   "int",
+			Data:   packet,
+			Target: new(int),
+			Err:    ErrTarget,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := Unmarshal(strings.NewReader(script), tt.Data, tt.Target)
+			if tt.Err != nil {
+				if err == nil {
+					t.Fatalf("want error %v, got %+v", tt.Err, tt.Target)
+				}
+				if !errors.Is(err, tt.Err) && !strings.Contains(err.Error(), tt.Err.Error()) {
+					t.Fatalf("want error %v, got %v", tt.Err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(tt.Target, tt.Want) {
+				t.Errorf("target mismatched:\nwant: %+v\ngot:  %+v", tt.Want, tt.Target)
+			}
+		})
+	}
+
+	t.Run("tagged", func(t *testing.T) {
+		var p tagged
+		if err := Unmarshal(strings.NewReader(script), packet, &p); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if p.Ident != 1 || p.Mode != "off" || p.Other != 0 || p.Size.Sign() != 0 {
+			t.Errorf("unexpected values %+v", p)
+		}
+		if len(p.Temp) != 2 || asInt(p.Temp[0]) != -2 || asInt(p.Temp[1]) != 3 {
+			t.Errorf("unexpected temp values %v", p.Temp)
+		}
+		if p.Field.Id != "size" || asUint(p.Field.Raw()) != 256 {
+			t.Errorf("unexpected field %+v", p.Field)
+		}
+		if p.Big == nil || p.Big.Int64() != 256 {
+			t.Errorf("unexpected big value %v", p.Big)
+		}
+	})
+}
@@ -4,16 +4,16 @@ import (
 	"bytes"
 	"encoding/hex"
 	"errors"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
-	"unicode/utf8"
 )
 
 var (
 	ErrIncompatible = errors.New("incompatible type")
 	ErrUnsupported  = errors.New("unsupported operation")
+	ErrZero         = errors.New("division by zero")
 )
 
 type Value interface {
@@ -359,6 +359,103 @@ func (i *Uint) or(v Value) (Value, error) {
 	return &x, nil
 }
 
+// BigInt holds the value of integer fields wider than 64 bits.
+type BigInt struct {
+	Raw *big.Int
+}
+
+func (i *BigInt) Cmp(v Value) int {
+	return i.Raw.Cmp(asBig(v))
+}
+
+func (i *BigInt) add(v Value) (Value, error) {
+	if v, ok := v.(*String); ok {
+		return concatValues(i, v)
+	}
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	x := new(big.Int).Add(i.Raw, asBig(v))
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) subtract(v Value) (Value, error) {
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	x := new(big.Int).Sub(i.Raw, asBig(v))
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) multiply(v Value) (Value, error) {
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	x := new(big.Int).Mul(i.Raw, asBig(v))
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) divide(v Value) (Value, error) {
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	y := asBig(v)
+	if y.Sign() == 0 {
+		return nil, ErrZero
+	}
+	x := new(big.Int).Quo(i.Raw, y)
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) modulo(v Value) (Value, error) {
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	y := asBig(v)
+	if y.Sign() == 0 {
+		return nil, ErrZero
+	}
+	x := new(big.Int).Rem(i.Raw, y)
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) reverse() (Value, error) {
+	x := new(big.Int).Neg(i.Raw)
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) leftshift(v Value) (Value, error) {
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	x := new(big.Int).Lsh(i.Raw, uint(asUint(v)))
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) rightshift(v Value) (Value, error) {
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	x := new(big.Int).Rsh(i.Raw, uint(asUint(v)))
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) and(v Value) (Value, error) {
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	x := new(big.Int).And(i.Raw, asBig(v))
+	return &BigInt{Raw: x}, nil
+}
+
+func (i *BigInt) or(v Value) (Value, error) {
+	if !isCompatible(i, v) {
+		return nil, ErrIncompatible
+	}
+	x := new(big.Int).Or(i.Raw, asBig(v))
+	return &BigInt{Raw: x}, nil
+}
+
 type Real struct {
 	Raw float64
 }
@@ -471,56 +568,46 @@ func (s *String) rightshift(_ Value) (Value, error) { return nil, ErrUnsupported
 func (s *String) and(_ Value) (Value, error)        { return nil, ErrUnsupported }
 func (s *String) or(_ Value) (Value, error)         { return nil, ErrUnsupported }
 
-func concatValues(left, right Value) (Value, error) {
-	ls, rs := asString(left), asString(right)
-	s := String{Raw: ls + rs}
-	return &s, nil
+// Array holds the elements of an array field, eg samples: uint 12 * 64.
+type Array struct {
+	Raw []Value
 }
 
-func appendRaw(buf []byte, v Value, escape bool) []byte {
-	switch v := v.(type) {
-	case *Int:
-		buf = strconv.AppendInt(buf, v.Raw, 10)
-	case *Uint:
-		buf = strconv.AppendUint(buf, v.Raw, 10)
-	case *Real:
-		buf = strconv.AppendFloat(buf, v.Raw, 'g', -1, 64)
-	case *Boolean:
-		buf = strconv.AppendBool(buf, v.Raw)
-	case *String:
-		strmap := func(r rune) rune {
-			if r == utf8.RuneError || !unicode.IsPrint(r) {
-				r = '*'
-			}
-			return r
-		}
-		buf = bytes.Map(strmap, []byte(v.Raw))
-		if escape {
-			buf = escapeQuotes(buf)
+func (a *Array) Cmp(v Value) int {
+	x, ok := v.(*Array)
+	if !ok {
+		return -1
+	}
+	for i := 0; i < len(a.Raw) && i < len(x.Raw); i++ {
+		if c := a.Raw[i].Cmp(x.Raw[i]); c != 0 {
+			return c
 		}
-		buf = bytes.TrimSpace(buf)
-	case *Bytes:
-		x := hex.EncodeToString(v.Raw)
-		buf = []byte(x)
-	case *Time:
-		buf = strconv.AppendInt(buf, v.Raw.Unix(), 10)
-	default:
 	}
-	return buf
-}
-
-func appendEng(buf []byte, v Value, escape bool) []byte {
-	switch v := v.(type) {
-	case *Time:
-		buf = v.Raw.AppendFormat(buf, time.RFC3339)
+	switch {
+	case len(a.Raw) < len(x.Raw):
+		return -1
+	case len(a.Raw) > len(x.Raw):
+		return 1
 	default:
-		buf = appendRaw(buf, v, escape)
+		return 0
 	}
-	return buf
 }
 
-func escapeQuotes(buf []byte) []byte {
-	return bytes.ReplaceAll(buf, []byte("\""), []byte("\"\""))
+func (a *Array) add(_ Value) (Value, error)        { return nil, ErrUnsupported }
+func (a *Array) subtract(_ Value) (Value, error)   { return nil, ErrUnsupported }
+func (a *Array) multiply(_ Value) (Value, error)   { return nil, ErrUnsupported }
+func (a *Array) divide(_ Value) (Value, error)     { return nil, ErrUnsupported }
+func (a *Array) modulo(_ Value) (Value, error)     { return nil, ErrUnsupported }
+func (a *Array) reverse() (Value, error)           { return nil, ErrUnsupported }
+func (a *Array) leftshift(_ Value) (Value, error)  { return nil, ErrUnsupported }
+func (a *Array) rightshift(_ Value) (Value, error) { return nil, ErrUnsupported }
+func (a *Array) and(_ Value) (Value, error)        { return nil, ErrUnsupported }
+func (a *Array) or(_ Value) (Value, error)         { return nil, ErrUnsupported }
+
+func concatValues(left, right Value) (Value, error) {
+	ls, rs := asString(left), asString(right)
+	s := String{Raw: ls + rs}
+	return &s, nil
 }
 
 func asString(v Value) string {
@@ -529,6 +616,8 @@ func asString(v Value) string {
 		return strconv.FormatInt(v.Raw, 10)
 	case *Uint:
 		return strconv.FormatUint(v.Raw, 10)
+	case *BigInt:
+		return v.Raw.String()
 	case *Real:
 		return strconv.FormatFloat(v.Raw, 'g', -1, 64)
 	case *Boolean:
@@ -537,6 +626,14 @@ func asString(v Value) string {
 		return hex.EncodeToString(v.Raw)
 	case *String:
 		return v.Raw
+	case *Time:
+		return v.Raw.Format(time.RFC3339Nano)
+	case *Array:
+		parts := make([]string, len(v.Raw))
+		for i := range v.Raw {
+			parts[i] = asString(v.Raw[i])
+		}
+		return strings.Join(parts, " ")
 	default:
 		return ""
 	}
@@ -550,6 +647,9 @@ func asReal(v Value) float64 {
 		return float64(v.Raw)
 	case *Int:
 		return float64(v.Raw)
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(v.Raw).Float64()
+		return f
 	default:
 		return 0
 	}
@@ -563,6 +663,8 @@ func asUint(v Value) uint64 {
 		return uint64(v.Raw)
 	case *Real:
 		return uint64(v.Raw)
+	case *BigInt:
+		return v.Raw.Uint64()
 	default:
 		return 0
 	}
@@ -576,11 +678,55 @@ func asInt(v Value) int64 {
 		return int64(v.Raw)
 	case *Real:
 		return int64(v.Raw)
+	case *BigInt:
+		return v.Raw.Int64()
 	default:
 		return 0
 	}
 }
 
+func asBig(v Value) *big.Int {
+	switch v := v.(type) {
+	case *BigInt:
+		return v.Raw
+	case *Int:
+		return big.NewInt(v.Raw)
+	case *Uint:
+		return new(big.Int).SetUint64(v.Raw)
+	case *Real:
+		x, _ := big.NewFloat(v.Raw).Int(nil)
+		return x
+	default:
+		return new(big.Int)
+	}
+}
+
+// promote converts integer operands to BigInt when the other operand is a
+// BigInt so that operations are not truncated to 64 bits.
+func promote(left, right Value) (Value, Value) {
+	_, lb := left.(*BigInt)
+	_, rb := right.(*BigInt)
+	if lb == rb {
+		return left, right
+	}
+	switch {
+	case lb && isInteger(right):
+		right = &BigInt{Raw: asBig(right)}
+	case rb && isInteger(left):
+		left = &BigInt{Raw: asBig(left)}
+	}
+	return left, right
+}
+
+func isInteger(v Value) bool {
+	switch v.(type) {
+	case *Int, *Uint:
+		return true
+	default:
+		return false
+	}
+}
+
 func asBool(v Value) bool {
 	switch v := v.(type) {
 	case *Boolean:
@@ -589,10 +735,14 @@ func asBool(v Value) bool {
 		return v.Raw != 0
 	case *Uint:
 		return v.Raw != 0
+	case *BigInt:
+		return v.Raw.Sign() != 0
 	case *String:
 		return len(v.Raw) > 0
 	case *Bytes:
 		return len(v.Raw) > 0
+	case *Array:
+		return len(v.Raw) > 0
 	default:
 		return false
 	}
@@ -608,7 +758,7 @@ func null2null(v Value) (Value, error) {
 func isCompatible(left, right Value) bool {
 	for _, v := range []Value{left, right} {
 		switch v.(type) {
-		case *Int, *Uint, *Real:
+		case *Int, *Uint, *Real, *BigInt:
 		default:
 			return false
 		}
@@ -621,6 +771,7 @@ func isNumber(v Value) bool {
 	case *Int:
 	case *Uint:
 	case *Real:
+	case *BigInt:
 	default:
 		return false
 	}